@@ -1,4 +1,4 @@
-import { commands, workspace, ExtensionContext, window, EventEmitter, TextDocumentContentProvider, Uri, ViewColumn, WorkspaceConfiguration } from 'vscode';
+import { commands, debug, workspace, DebugAdapterExecutable, ExtensionContext, window, EventEmitter, TextDocumentContentProvider, Uri, ViewColumn, WorkspaceConfiguration } from 'vscode';
 
 import {
 	DidChangeConfigurationNotification,
@@ -6,6 +6,8 @@ import {
 	ExecuteCommandRequest,
 	LanguageClient,
 	LanguageClientOptions,
+	Location,
+	Position,
 	ServerOptions,
 } from 'vscode-languageclient/node';
 
@@ -13,22 +15,24 @@ let client: LanguageClient;
 
 
 // previewProvider is a virtual content provider which displays ephemeral preview output
-// for jsonnet evaluation results. There is one preview pane per workspace, and it will
-// update on every evaluation.
+// for jsonnet evaluation results. There is one preview pane per workspace and output
+// format, and it will update on every evaluation.
 const previewProvider = new class implements TextDocumentContentProvider {
-	data: string | undefined;
+	data = new Map<string, string>();
 	uriScheme = 'jsonnetpreview';
 	previewPaneURI = Uri.parse(`${this.uriScheme}:preview.json`);
+	yamlPreviewPaneURI = Uri.parse(`${this.uriScheme}:preview.yaml`);
+	profilePaneURI = Uri.parse(`${this.uriScheme}:profile.txt`);
 	onDidChangeEmitter = new EventEmitter<Uri>();
 	onDidChange = this.onDidChangeEmitter.event;
 
-	previewDidChange(data: string) {
-		this.data = data;
-		this.onDidChangeEmitter.fire(this.previewPaneURI);
+	previewDidChange(uri: Uri, data: string) {
+		this.data.set(uri.toString(), data);
+		this.onDidChangeEmitter.fire(uri);
 	}
 
 	provideTextDocumentContent(uri: Uri): string {
-		return this.data ?? "No jsonnet evaluation results";
+		return this.data.get(uri.toString()) ?? "No jsonnet evaluation results";
 	}
 };
 
@@ -48,6 +52,7 @@ async function startClient(binaryPath: string, cfg: WorkspaceConfiguration): Pro
 
 	const clientOptions: LanguageClientOptions = {
 		documentSelector: [{ scheme: 'file', language: 'jsonnet' }],
+		initializationOptions: cfg,
 	};
 
 	client = new LanguageClient(
@@ -59,6 +64,15 @@ async function startClient(binaryPath: string, cfg: WorkspaceConfiguration): Pro
 
 	await client.start();
 	await client.sendNotification(DidChangeConfigurationNotification.type, {settings: cfg});
+
+	// the server pushes the output of previewed files when they change
+	client.onNotification("jsonnet/previewDidChange", (params: PreviewDidChangeParams) => {
+		const preview = previews.get(params.format);
+		if (preview?.document === params.textDocument.uri && preview?.field === params.field) {
+			previewProvider.previewDidChange(preview.uri, params.output);
+		}
+	});
+	previews.clear();
 }
 
 function builtinBinaryPath(): string {
@@ -71,6 +85,72 @@ type EvaluateResult = {
 	output: string;
 };
 
+// the arguments of the code lenses counting the references of a definition
+type ShowReferencesArgs = {
+	textDocument: { uri: string };
+	position: Position;
+	locations: Location[];
+};
+
+type PreviewDidChangeParams = {
+	textDocument: { uri: string };
+	format: string;
+	field?: string;
+	output: string;
+};
+
+// previews is the document shown in the preview pane of each format, or one of its top level
+// fields, which is kept up to date by the server as the document changes.
+const previews = new Map<string, { document: string; field?: string; uri: Uri }>();
+
+// evaluate opens a live preview of the output of a document in the preview pane of the
+// format.
+async function evaluate(format: string, previewURI: Uri, args?: string): Promise<void> {
+	if (!client.isRunning()) {
+		window.showErrorMessage("jsonnet: cannot evaluate file, language server not running");
+		return;
+	}
+
+	// the code lens passes the document to evaluate, otherwise use the active editor
+	let document: string;
+	let field: string | undefined;
+	if (args === undefined) {
+		const editor = window.activeTextEditor;
+		if (editor === undefined) {
+			window.showErrorMessage("jsonnet: cannot evaluate file, no active editor");
+			return;
+		}
+
+		// do nothing if it's not a jsonnet file
+		if (editor.document.languageId !== "jsonnet") {
+			return;
+		}
+		document = editor.document.uri.toString();
+	} else {
+		const params = JSON.parse(args);
+		document = params.textDocument.uri;
+		field = params.field;
+	}
+
+	// the pane shows one document at a time, stop updating the previous one
+	const previous = previews.get(format);
+	if (previous !== undefined && (previous.document !== document || previous.field !== field)) {
+		await client.sendRequest("jsonnet/closePreview", { textDocument: { uri: previous.document }, format: format, field: previous.field });
+	}
+	previews.set(format, { document: document, field: field, uri: previewURI });
+
+	const result: EvaluateResult = await client.sendRequest("jsonnet/preview", {
+		textDocument: { uri: document },
+		format: format,
+		field: field
+	}).catch(err => window.showErrorMessage(`jsonnet: failed to evaluate file ${err}`));
+
+	previewProvider.previewDidChange(previewURI, result.output);
+
+	const doc = { ...(await workspace.openTextDocument(previewURI)), languageId: format };
+	await window.showTextDocument(doc, ViewColumn.Beside, true);
+}
+
 export async function activate(context: ExtensionContext) {
 	let cfg = workspace.getConfiguration('jsonnet.lsp');
 
@@ -94,34 +174,37 @@ export async function activate(context: ExtensionContext) {
 			await startClient(binaryPath, cfg);
 		}),
 		workspace.registerTextDocumentContentProvider(previewProvider.uriScheme, previewProvider),
-		commands.registerCommand('jsonnet.lsp.evaluate', async function (): Promise<void> {
+		// the debug adapter is run by the same binary as the language server
+		debug.registerDebugAdapterDescriptorFactory('jsonnet', {
+			createDebugAdapterDescriptor: () => new DebugAdapterExecutable(binaryPath, ["dap"]),
+		}),
+		commands.registerCommand('jsonnet.lsp.evaluate', async function (args?: string): Promise<void> {
+			await evaluate("json", previewProvider.previewPaneURI, args);
+		}),
+		commands.registerCommand('jsonnet.lsp.evaluateYaml', async function (args?: string): Promise<void> {
+			await evaluate("yaml", previewProvider.yamlPreviewPaneURI, args);
+		}),
+		commands.registerCommand('jsonnet.lsp.showReferences', async function (args: string): Promise<void> {
+			const refs: ShowReferencesArgs = JSON.parse(args);
+			await commands.executeCommand('editor.action.showReferences',
+				client.protocol2CodeConverter.asUri(refs.textDocument.uri),
+				client.protocol2CodeConverter.asPosition(refs.position),
+				await client.protocol2CodeConverter.asLocations(refs.locations));
+		}),
+		commands.registerCommand('jsonnet.lsp.profile', async function (): Promise<void> {
 			const editor = window.activeTextEditor;
-			if (editor === undefined) {
-				window.showErrorMessage("jsonnet: cannot evaluate file, no active editor");
-				return;
-			}
-
-			// do nothing if it's not a jsonnet file
-			if (editor.document.languageId !== "jsonnet") {
-				return;
-			}
-
-			if (!client.isRunning()) {
-				window.showErrorMessage("jsonnet: cannot evaluate file, language server not running");
+			if (!client.isRunning() || editor === undefined || editor.document.languageId !== "jsonnet") {
+				window.showErrorMessage("jsonnet: cannot profile file, no active jsonnet editor");
 				return;
 			}
 
 			const result: EvaluateResult = await client.sendRequest(ExecuteCommandRequest.type, {
-				command: "jsonnet.lsp.evaluate",
-				arguments: [JSON.stringify({
-					textDocument: { uri: editor.document.uri.toString() }
-				})]
-			}).catch(err => window.showErrorMessage(`jsonnet: failed to evaluate file ${err}`));
-
-			previewProvider.previewDidChange(result.output);
-			
-			const doc = { ...(await workspace.openTextDocument(previewProvider.previewPaneURI)), languageId: "json" };
-			await window.showTextDocument(doc, ViewColumn.Beside, true);
+				command: "jsonnet.lsp.profile",
+				arguments: [JSON.stringify({ textDocument: { uri: editor.document.uri.toString() } })]
+			}).catch(err => window.showErrorMessage(`jsonnet: failed to profile file ${err}`));
+
+			previewProvider.previewDidChange(previewProvider.profilePaneURI, result.output);
+			await window.showTextDocument(await workspace.openTextDocument(previewProvider.profilePaneURI), ViewColumn.Beside, true);
 		})
 	);
 
@@ -3,9 +3,11 @@ module github.com/carlverge/jsonnet-lsp
 go 1.19
 
 require (
+	github.com/gorilla/websocket v1.5.0
 	github.com/stretchr/testify v1.7.0
 	go.lsp.dev/jsonrpc2 v0.10.0
 	go.lsp.dev/uri v0.3.0
+	sigs.k8s.io/yaml v1.3.0
 )
 
 require (
@@ -19,7 +21,6 @@ require (
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
-	sigs.k8s.io/yaml v1.3.0 // indirect
 )
 
 require (
@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"sort"
 	"strings"
 
+	"github.com/carlverge/jsonnet-lsp/pkg/dap"
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
 	"github.com/carlverge/jsonnet-lsp/pkg/lsp"
+	"go.lsp.dev/protocol"
 )
 
 type cmd struct {
@@ -16,7 +20,10 @@ type cmd struct {
 }
 
 var subcommands = map[string]cmd{
-	"lsp": {Fn: doLSP, Help: "Run the jsonnet language server. Uses stdin/stdout for communication."},
+	"lsp":  {Fn: doLSP, Help: "Run the jsonnet language server. Uses stdin/stdout for communication, TCP with --listen <addr>, or WebSocket with --websocket <addr>."},
+	"dap":  {Fn: doDAP, Help: "Run the jsonnet debug adapter. Uses stdin/stdout for communication."},
+	"fmt":  {Fn: doFmt, Help: "Format jsonnet files or directories (the whole workspace by default) with the formatter settings of the language server, and print the result. -w writes it to the files instead, -l lists the files that are not formatted and exits with an error if there are any."},
+	"lint": {Fn: doLint, Help: "Check jsonnet files or directories (the whole workspace by default) like the language server does, and print the diagnostics as file:line:col. Exits with an error if any is at least as severe as --fail-on."},
 }
 
 func fmtUsage(cmds map[string]cmd) string {
@@ -48,17 +55,154 @@ func dispatch(args []string, cmds map[string]cmd) error {
 }
 
 func doLSP(args []string) error {
+	flags := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	listen := flags.String("listen", "", "accept connections over TCP on this address (f.ex localhost:7777) instead of using stdin/stdout")
+	ws := flags.String("websocket", "", "accept WebSocket connections on this address (f.ex localhost:7777), for web editors like Monaco")
+	origins := flags.String("allowed-origins", "", "comma separated origins of the web editors allowed to connect with --websocket (f.ex http://localhost:3000), besides the address of the server")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if *listen != "" {
+		return lsp.ListenServer(ctx, *listen)
+	}
+	if *ws != "" {
+		var allowed []string
+		for _, o := range strings.Split(*origins, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				allowed = append(allowed, o)
+			}
+		}
+		return lsp.WebSocketServer(ctx, *ws, allowed)
+	}
+
 	// swap out process-level stdout right away to ensure that nothing else writes to it
 	// otherwise it will desync the jsonrpc stream
 	oldout := os.Stdout
 	os.Stdout = os.Stderr
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	return lsp.RunServer(ctx, oldout)
 }
 
+func doDAP(args []string) error {
+	flags := flag.NewFlagSet("dap", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// nothing else may write to stdout, it would desync the protocol stream
+	oldout := os.Stdout
+	os.Stdout = os.Stderr
+
+	return dap.NewSession(os.Stdin, oldout).Run()
+}
+
+var severityNames = map[protocol.DiagnosticSeverity]string{
+	protocol.DiagnosticSeverityError:       "error",
+	protocol.DiagnosticSeverityWarning:     "warning",
+	protocol.DiagnosticSeverityInformation: "info",
+	protocol.DiagnosticSeverityHint:        "hint",
+}
+
+func doLint(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	root := flags.String("root", ".", "root of the workspace, its project configuration (.jsonnet-lsp.yaml) and search paths are used")
+	failOn := flags.String("fail-on", "error", "exit with an error if a diagnostic is at least this severe: error, warning, information, hint, or off")
+	format := flags.String("format", "text", "output format: text, or sarif for a SARIF 2.1.0 log that CI systems like GitHub code scanning annotate pull requests with")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "sarif" {
+		return fmt.Errorf("unknown format %s", *format)
+	}
+	threshold, failing := linter.ParseSeverity(*failOn, protocol.DiagnosticSeverityError)
+
+	files, err := lsp.Lint(context.Background(), *root, flags.Args())
+	if err != nil {
+		return err
+	}
+	if *format == "sarif" {
+		if err := lsp.WriteSARIF(os.Stdout, files); err != nil {
+			return err
+		}
+	}
+	failed := 0
+	for _, f := range files {
+		for _, d := range f.Diagnostics {
+			sev := d.Severity
+			if sev == 0 {
+				sev = protocol.DiagnosticSeverityError
+			}
+			if *format == "text" {
+				msg := strings.Join(strings.Fields(d.Message), " ")
+				if d.Code != nil {
+					msg += fmt.Sprintf(" [%v]", d.Code)
+				}
+				fmt.Printf("%s:%d:%d: %s: %s\n", f.Path, d.Range.Start.Line+1, d.Range.Start.Character+1, severityNames[sev], msg)
+			}
+			if failing && sev <= threshold {
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("diagnostics at or above the --fail-on severity: %d", failed)
+	}
+	return nil
+}
+
+func doFmt(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	root := flags.String("root", ".", "root of the workspace, its project configuration (.jsonnet-lsp.yaml) is used")
+	write := flags.Bool("w", false, "write the result to the files instead of printing it")
+	list := flags.Bool("l", false, "list the files whose formatting differs instead of printing them, and exit with an error if there are any")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	files, err := lsp.Format(context.Background(), *root, flags.Args())
+	if err != nil {
+		return err
+	}
+	failed, unformatted := 0, 0
+	for _, f := range files {
+		switch {
+		case f.Err != nil:
+			// the error has the position in the file
+			fmt.Fprintln(os.Stderr, f.Err)
+			failed++
+		case *list:
+			if f.Formatted != f.Contents {
+				fmt.Println(f.Path)
+				unformatted++
+			}
+		case *write:
+			if f.Formatted == f.Contents {
+				continue
+			}
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(f.Path, []byte(f.Formatted), info.Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+			os.Stdout.WriteString(f.Formatted)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("files that do not parse: %d", failed)
+	}
+	if unformatted > 0 {
+		return fmt.Errorf("files that are not formatted: %d", unformatted)
+	}
+	return nil
+}
+
 func main() {
 	if err := dispatch(os.Args[1:], subcommands); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
@@ -140,17 +140,7 @@ func walkStack(node ast.Node, stk []ast.Node, fn func(n ast.Node, stk []ast.Node
 }
 
 func locInNode(n ast.Node, pos ast.Location) bool {
-	start, end := n.Loc().Begin, n.Loc().End
-	if pos.Line < start.Line || pos.Line > end.Line {
-		return false
-	}
-	if pos.Line == start.Line && pos.Column < start.Column {
-		return false
-	}
-	if pos.Line == end.Line && pos.Column > end.Column {
-		return false
-	}
-	return true
+	return LocInRange(*n.Loc(), pos)
 }
 
 func unwindLocals(root ast.Node, locs []ast.Node) ([]ast.Node, ast.Node) {
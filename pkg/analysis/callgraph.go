@@ -0,0 +1,171 @@
+package analysis
+
+import (
+	"github.com/google/go-jsonnet/ast"
+)
+
+// FunctionDef is a named function: either a local bound to a function, or an object
+// field with a function value.
+type FunctionDef struct {
+	Name   string
+	Field  bool
+	Detail string
+	// Range covers the whole definition, and NameRange only the name.
+	Range     ast.LocationRange
+	NameRange ast.LocationRange
+	Func      *ast.Function
+}
+
+// spanRange returns the smallest range covering both `a` and `b`.
+func spanRange(a, b ast.LocationRange) ast.LocationRange {
+	if !b.IsSet() {
+		return a
+	}
+	res := a
+	if !locBeforeEq(res.Begin, b.Begin) {
+		res.Begin = b.Begin
+	}
+	if locBeforeEq(res.End, b.End) {
+		res.End = b.End
+	}
+	return res
+}
+
+func newFunctionDef(name string, field bool, nameRange ast.LocationRange, node ast.Node) *FunctionDef {
+	fn, ok := node.(*ast.Function)
+	if !ok || !nameRange.IsSet() {
+		return nil
+	}
+	rng := spanRange(nameRange, fn.LocRange)
+	// functions defined in objects have no range, only their body does
+	if fn.Body != nil && fn.Body.Loc() != nil {
+		rng = spanRange(rng, *fn.Body.Loc())
+	}
+	_, detail := symbolDetail(fn)
+	return &FunctionDef{Name: name, Field: field, Detail: detail, Range: rng, NameRange: nameRange, Func: fn}
+}
+
+func bindingFunctionDef(b *Binding) *FunctionDef {
+	if b == nil {
+		return nil
+	}
+	decl := b.Decl()
+	if decl == nil {
+		return nil
+	}
+	return newFunctionDef(decl.Name, false, decl.Range, decl.Body)
+}
+
+func fieldFunctionDef(fld *Field) *FunctionDef {
+	if fld == nil {
+		return nil
+	}
+	return newFunctionDef(fld.Name, true, fld.NameRange, fld.Node)
+}
+
+// CallName returns the name of the function being called, and the range of the name at
+// the call site. Only calls of variables and indexes with a constant name are named.
+func CallName(apply *ast.Apply) (string, ast.LocationRange, bool) {
+	switch t := apply.Target.(type) {
+	case *ast.Var:
+		return string(t.Id), t.LocRange, t.LocRange.IsSet()
+	case *ast.Index:
+		return IndexNameRange(t)
+	}
+	return "", ast.LocationRange{}, false
+}
+
+// CallTarget resolves the named function called by `apply`. `stack` is the stack from
+// the root of the file to the call.
+func CallTarget(apply *ast.Apply, stack []ast.Node, resolver Resolver) *FunctionDef {
+	switch t := apply.Target.(type) {
+	case *ast.Var:
+		return bindingFunctionDef(FindBinding(string(t.Id), stack))
+	case *ast.Index:
+		return fieldFunctionDef(FieldDefinition(t, resolver))
+	}
+	return nil
+}
+
+// enclosingFunction returns the innermost named function in the stack.
+func enclosingFunction(stack []ast.Node) *FunctionDef {
+	for i := len(stack) - 1; i > 0; i-- {
+		fn, ok := stack[i].(*ast.Function)
+		if !ok {
+			continue
+		}
+		parent := stack[i-1]
+		for _, b := range bindsOf(parent) {
+			if b.Body == fn || (b.Fun != nil && b.Fun == fn) {
+				return bindingFunctionDef(&Binding{Def: parent, Name: string(b.Variable)})
+			}
+		}
+		if obj, ok := parent.(*ast.DesugaredObject); ok {
+			for j := range obj.Fields {
+				if obj.Fields[j].Body != fn {
+					continue
+				}
+				if name, rng, ok := FieldNameRange(&obj.Fields[j]); ok {
+					return newFunctionDef(name, true, rng, fn)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// FunctionDefAt returns the named function that is defined, referenced, or called at `pos`.
+func FunctionDefAt(root ast.Node, pos ast.Location, resolver Resolver) *FunctionDef {
+	if def := bindingFunctionDef(BindingAtLoc(root, pos)); def != nil {
+		return def
+	}
+	stack := StackAtLoc(root, pos)
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch n := stack[i].(type) {
+		case *ast.Index:
+			if _, rng, ok := IndexNameRange(n); ok && LocInRange(rng, pos) {
+				return fieldFunctionDef(FieldDefinition(n, resolver))
+			}
+		case *ast.DesugaredObject:
+			for j := range n.Fields {
+				if name, rng, ok := FieldNameRange(&n.Fields[j]); ok && LocInRange(rng, pos) {
+					return newFunctionDef(name, true, rng, n.Fields[j].Body)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Call is a function call to a named function.
+type Call struct {
+	Apply *ast.Apply
+	// The range of the function name at the call site
+	NameRange ast.LocationRange
+	// The named function being called
+	Target *FunctionDef
+	// The named function the call is made from, nil if the call is not in one
+	Caller *FunctionDef
+}
+
+// Calls returns the calls in the file `root` that resolve to a named function. Resolving
+// calls can be expensive, so only calls where `keep` returns true for the name of the
+// called function and its range at the call site are resolved.
+func Calls(root ast.Node, resolver Resolver, keep func(name string, rng ast.LocationRange) bool) []Call {
+	res := []Call{}
+	walkStack(root, nil, func(n ast.Node, stack []ast.Node) bool {
+		apply, ok := n.(*ast.Apply)
+		if !ok {
+			return true
+		}
+		name, rng, ok := CallName(apply)
+		if !ok || !keep(name, rng) {
+			return true
+		}
+		if target := CallTarget(apply, stack, resolver); target != nil {
+			res = append(res, Call{Apply: apply, NameRange: rng, Target: target, Caller: enclosingFunction(stack)})
+		}
+		return true
+	})
+	return res
+}
@@ -0,0 +1,50 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFunctionDefAt(t *testing.T) {
+	source := "local helper(x) = x + 1;\nlocal obj = {\n  add(a, b):: helper(a) + helper(b),\n};\nobj.add(1, 2)"
+	resolver, _ := newAnonMockResolver(t, source)
+
+	def := FunctionDefAt(resolver.root, ast.Location{Line: 3, Column: 16}, resolver)
+	require.NotNil(t, def)
+	assert.Equal(t, "helper", def.Name)
+	assert.False(t, def.Field)
+	assert.Equal(t, valueRange{1, 7, 1, 13}, rangeToTestRange(def.NameRange))
+
+	def = FunctionDefAt(resolver.root, ast.Location{Line: 5, Column: 6}, resolver)
+	require.NotNil(t, def)
+	assert.Equal(t, "add", def.Name)
+	assert.True(t, def.Field)
+	assert.Equal(t, valueRange{3, 3, 3, 6}, rangeToTestRange(def.NameRange))
+	assert.Equal(t, valueRange{3, 3, 3, 36}, rangeToTestRange(def.Range))
+
+	// not a function
+	assert.Nil(t, FunctionDefAt(resolver.root, ast.Location{Line: 2, Column: 7}, resolver))
+}
+
+func TestCalls(t *testing.T) {
+	source := "local helper(x) = x + 1;\nlocal obj = {\n  add(a, b):: helper(a) + helper(b),\n};\nobj.add(1, 2)"
+	resolver, _ := newAnonMockResolver(t, source)
+
+	calls := Calls(resolver.root, resolver, func(string, ast.LocationRange) bool { return true })
+	require.Len(t, calls, 3)
+
+	assert.Equal(t, "helper", calls[0].Target.Name)
+	assert.Equal(t, valueRange{3, 15, 3, 21}, rangeToTestRange(calls[0].NameRange))
+	require.NotNil(t, calls[0].Caller)
+	assert.Equal(t, "add", calls[0].Caller.Name)
+
+	assert.Equal(t, "add", calls[2].Target.Name)
+	assert.Equal(t, valueRange{5, 5, 5, 8}, rangeToTestRange(calls[2].NameRange))
+	assert.Nil(t, calls[2].Caller)
+
+	calls = Calls(resolver.root, resolver, func(name string, _ ast.LocationRange) bool { return name == "add" })
+	require.Len(t, calls, 1)
+}
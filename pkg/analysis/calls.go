@@ -0,0 +1,77 @@
+package analysis
+
+import (
+	"github.com/google/go-jsonnet/ast"
+)
+
+// PositionalParams returns the parameters that the positional arguments of a call
+// are bound to, in order. Arguments past the end of the parameter list are ignored.
+// Returns nil if the called function could not be resolved.
+func PositionalParams(apply *ast.Apply, resolver Resolver) []Param {
+	targ := NodeToValue(apply.Target, resolver)
+	if targ.Function == nil {
+		return nil
+	}
+	n := len(apply.Arguments.Positional)
+	if n > len(targ.Function.Params) {
+		n = len(targ.Function.Params)
+	}
+	return targ.Function.Params[:n]
+}
+
+// EnclosingCall returns the innermost function call in the stack where `pos` is inside
+// of the argument list, and not on the function being called.
+func EnclosingCall(stack []ast.Node, pos ast.Location) *ast.Apply {
+	for i := len(stack) - 1; i >= 0; i-- {
+		apply, ok := stack[i].(*ast.Apply)
+		if !ok {
+			continue
+		}
+		targ := apply.Target.Loc()
+		if targ == nil || !targ.IsSet() || LocInRange(*targ, pos) {
+			continue
+		}
+		return apply
+	}
+	return nil
+}
+
+// ActiveParam returns the index of the parameter of `fn` that the argument at `pos`
+// is bound to. If `pos` is not inside of an argument, then it is the first parameter
+// that has not been passed yet.
+func ActiveParam(apply *ast.Apply, fn *Function, pos ast.Location) int {
+	for i, arg := range apply.Arguments.Positional {
+		if loc := arg.Expr.Loc(); loc != nil && LocInRange(*loc, pos) {
+			return i
+		}
+	}
+	for _, arg := range apply.Arguments.Named {
+		if loc := arg.Arg.Loc(); loc == nil || !LocInRange(*loc, pos) {
+			continue
+		}
+		for i := range fn.Params {
+			if fn.Params[i].Name == string(arg.Name) {
+				return i
+			}
+		}
+	}
+
+	// The AST doesn't parse with partial named params, so we can't fully
+	// properly highlight the active named (without gnarly string parsing)
+	if len(apply.Arguments.Positional) >= len(fn.Params) {
+		return 0
+	}
+	seenNamed := map[string]bool{}
+	for i := range apply.Arguments.Positional {
+		seenNamed[fn.Params[i].Name] = true
+	}
+	for _, p := range apply.Arguments.Named {
+		seenNamed[string(p.Name)] = true
+	}
+	for i, p := range fn.Params {
+		if !seenNamed[p.Name] {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPositionalParams(t *testing.T) {
+	source := "local f(a, b=1) = a + b;\nlocal g = {};\n[f(1, 2, 3), std.join(',', []), g.unknown(1)]"
+	resolver, out := newAnonMockResolver(t, source)
+	arr, ok := out.(*ast.Array)
+	require.True(t, ok)
+
+	names := func(idx int) []string {
+		apply := arr.Elements[idx].Expr.(*ast.Apply)
+		params := PositionalParams(apply, resolver)
+		if params == nil {
+			return nil
+		}
+		res := []string{}
+		for _, p := range params {
+			res = append(res, p.Name)
+		}
+		return res
+	}
+
+	assert.Equal(t, []string{"a", "b"}, names(0))
+	assert.Equal(t, []string{"sep", "arr"}, names(1))
+	assert.Nil(t, names(2))
+}
+
+func TestActiveParam(t *testing.T) {
+	source := "local f(a, b=1, c=2) = a + b + c;\nf(10, c=f(1, 2))"
+	cases := []struct {
+		Name   string
+		Column int
+		// column of the expected call target
+		Call   int
+		Active int
+	}{
+		{Name: "OnTarget", Column: 1, Call: -1},
+		{Name: "FirstPositional", Column: 3, Call: 1, Active: 0},
+		{Name: "Whitespace", Column: 6, Call: 1, Active: 1},
+		{Name: "Named", Column: 9, Call: 1, Active: 2},
+		{Name: "Nested", Column: 14, Call: 9, Active: 1},
+	}
+	resolver, _ := newAnonMockResolver(t, source)
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pos := ast.Location{Line: 2, Column: tc.Column}
+			_, stack := resolver.NodeAt(pos)
+			apply := EnclosingCall(stack, pos)
+			if tc.Call < 0 {
+				assert.Nil(t, apply)
+				return
+			}
+			require.NotNil(t, apply)
+			assert.Equal(t, tc.Call, apply.Target.Loc().Begin.Column)
+			fn := NodeToValue(apply.Target, resolver).Function
+			require.NotNil(t, fn)
+			assert.Equal(t, tc.Active, ActiveParam(apply, fn, pos))
+		})
+	}
+}
+
+func TestDefaultString(t *testing.T) {
+	source := "local f(a, b='x', c={ long: 'a very long default value that is truncated' }) = a;\nf"
+	resolver, out := newAnonMockResolver(t, source)
+	fn := NodeToValue(out, resolver).Function
+	require.NotNil(t, fn)
+	assert.Equal(t, "(a, b='x', c={ long: 'a very long default val...)", fn.String())
+	assert.Equal(t, "(arr: array, keyF: function=null) -> array", StdLibFunctions["sort"].String())
+}
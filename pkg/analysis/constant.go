@@ -0,0 +1,438 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// maxConstantBinds limits the number of locals a constant expression can depend on.
+const maxConstantBinds = 50
+
+// maxConstantSize limits the length of the strings and arrays of constant values, larger
+// values are not folded.
+const maxConstantSize = 1000
+
+// ConstantValue folds the expression at the end of the stack over the AST, and returns its
+// value as JSON. Expressions are constant if they only use literals, arrays, arithmetic,
+// comparisons, string concatenation, `std.join`, `std.toString`, `std.length`, and locals
+// of the file that are constant too. Nothing is evaluated, so folding always terminates.
+func ConstantValue(stack []ast.Node) (string, bool) {
+	if len(stack) == 0 {
+		return "", false
+	}
+	f := &constantFolder{values: map[constantBind]constant{}, folding: map[constantBind]bool{}}
+	val, ok := f.fold(stack)
+	if !ok {
+		return "", false
+	}
+	return manifestConstant(val), true
+}
+
+// constant is a folded value: nil, a bool, a float64, a string, or a []constant.
+type constant interface{}
+
+// constantBind is a local, variables of different scopes can have the same name.
+type constantBind struct {
+	def  ast.Node
+	name string
+}
+
+type constantFolder struct {
+	// the values of the locals folded so far
+	values map[constantBind]constant
+	// the locals being folded, recursive locals are not constant
+	folding map[constantBind]bool
+}
+
+// fold returns the value of the node at the end of the stack.
+func (f *constantFolder) fold(stk []ast.Node) (constant, bool) {
+	child := func(n ast.Node) (constant, bool) {
+		return f.fold(append(stk[:len(stk):len(stk)], n))
+	}
+	switch n := stk[len(stk)-1].(type) {
+	case *ast.LiteralNull:
+		return nil, true
+	case *ast.LiteralBoolean:
+		return n.Value, true
+	case *ast.LiteralNumber:
+		v, err := strconv.ParseFloat(n.OriginalString, 64)
+		return v, err == nil
+	case *ast.LiteralString:
+		return n.Value, len(n.Value) <= maxConstantSize
+	case *ast.Parens:
+		return child(n.Inner)
+	case *ast.Local:
+		return child(n.Body)
+	case *ast.Var:
+		return f.variable(string(n.Id), stk)
+	case *ast.Array:
+		if len(n.Elements) > maxConstantSize {
+			return nil, false
+		}
+		res := make([]constant, 0, len(n.Elements))
+		for _, elem := range n.Elements {
+			v, ok := child(elem.Expr)
+			if !ok {
+				return nil, false
+			}
+			res = append(res, v)
+		}
+		return res, true
+	case *ast.Conditional:
+		cond, ok := child(n.Cond)
+		if b, isBool := cond.(bool); !ok || !isBool {
+			return nil, false
+		} else if b {
+			return child(n.BranchTrue)
+		}
+		return child(n.BranchFalse)
+	case *ast.Unary:
+		v, ok := child(n.Expr)
+		if !ok {
+			return nil, false
+		}
+		return foldUnary(n.Op, v)
+	case *ast.Binary:
+		left, ok := child(n.Left)
+		if !ok {
+			return nil, false
+		}
+		// the right side of `&&` and `||` is only needed when the left does not decide
+		if b, isBool := left.(bool); isBool && (n.Op == ast.BopAnd && !b || n.Op == ast.BopOr && b) {
+			return b, true
+		}
+		right, ok := child(n.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldBinary(n.Op, left, right)
+	case *ast.Index:
+		target, ok := child(n.Target)
+		if !ok {
+			return nil, false
+		}
+		idx, ok := child(n.Index)
+		if !ok {
+			return nil, false
+		}
+		i, isInt := constantInt(idx)
+		switch t := target.(type) {
+		case []constant:
+			if isInt && i >= 0 && i < len(t) {
+				return t[i], true
+			}
+		case string:
+			if r := []rune(t); isInt && i >= 0 && i < len(r) {
+				return string(r[i]), true
+			}
+		}
+		return nil, false
+	case *ast.Apply:
+		name, ok := stdMember(n.Target)
+		if !ok || len(n.Arguments.Named) > 0 {
+			return nil, false
+		}
+		args := make([]constant, 0, len(n.Arguments.Positional))
+		for _, arg := range n.Arguments.Positional {
+			v, ok := child(arg.Expr)
+			if !ok {
+				return nil, false
+			}
+			args = append(args, v)
+		}
+		return foldStd(name, args)
+	}
+	return nil, false
+}
+
+// variable returns the value of the local `name` used at the end of the stack. Function
+// parameters, object fields and the variables of comprehensions are not constant.
+func (f *constantFolder) variable(name string, stk []ast.Node) (constant, bool) {
+	b := FindBinding(name, stk)
+	if b == nil {
+		return nil, false
+	}
+	key := constantBind{def: b.Def, name: name}
+	if v, ok := f.values[key]; ok {
+		return v, true
+	}
+	if f.folding[key] || len(f.folding) >= maxConstantBinds {
+		return nil, false
+	}
+	var body ast.Node
+	for _, lb := range bindsOf(b.Def) {
+		if string(lb.Variable) == name {
+			body = lb.Body
+		}
+	}
+	if body == nil {
+		return nil, false
+	}
+	// the body of the bind is in the scope of its definition
+	outer := stk
+	for i := len(stk) - 1; i >= 0; i-- {
+		if stk[i] == b.Def {
+			outer = stk[: i+1 : i+1]
+			break
+		}
+	}
+	f.folding[key] = true
+	v, ok := f.fold(append(outer, body))
+	if ok {
+		f.values[key] = v
+	}
+	return v, ok
+}
+
+// stdMember returns the name of the stdlib member `node` is, f.ex `join` for `std.join`.
+func stdMember(node ast.Node) (string, bool) {
+	idx, ok := node.(*ast.Index)
+	if !ok {
+		return "", false
+	}
+	v, ok := idx.Target.(*ast.Var)
+	if !ok || (v.Id != "std" && v.Id != "$std") {
+		return "", false
+	}
+	lit, ok := idx.Index.(*ast.LiteralString)
+	if !ok {
+		return "", false
+	}
+	return lit.Value, true
+}
+
+func foldUnary(op ast.UnaryOp, v constant) (constant, bool) {
+	switch x := v.(type) {
+	case bool:
+		return !x, op == ast.UopNot
+	case float64:
+		switch op {
+		case ast.UopPlus:
+			return x, true
+		case ast.UopMinus:
+			return -x, true
+		case ast.UopBitwiseNot:
+			return float64(^int64(x)), true
+		}
+	}
+	return nil, false
+}
+
+func foldBinary(op ast.BinaryOp, left, right constant) (constant, bool) {
+	switch op {
+	case ast.BopManifestEqual:
+		return constantEqual(left, right), true
+	case ast.BopManifestUnequal:
+		return !constantEqual(left, right), true
+	case ast.BopAnd, ast.BopOr:
+		b, ok := right.(bool)
+		_, leftBool := left.(bool)
+		return b, ok && leftBool
+	}
+
+	ls, lstr := left.(string)
+	rs, rstr := right.(string)
+	if op == ast.BopPlus && (lstr || rstr) {
+		// the other side is converted like std.toString
+		if !lstr {
+			ls = manifestConstant(left)
+		}
+		if !rstr {
+			rs = manifestConstant(right)
+		}
+		return ls + rs, len(ls)+len(rs) <= maxConstantSize
+	}
+	if lstr && rstr {
+		switch op {
+		case ast.BopLess:
+			return ls < rs, true
+		case ast.BopLessEq:
+			return ls <= rs, true
+		case ast.BopGreater:
+			return ls > rs, true
+		case ast.BopGreaterEq:
+			return ls >= rs, true
+		}
+		return nil, false
+	}
+	if la, ok := left.([]constant); ok {
+		ra, ok := right.([]constant)
+		if !ok || op != ast.BopPlus || len(la)+len(ra) > maxConstantSize {
+			return nil, false
+		}
+		return append(append([]constant{}, la...), ra...), true
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, false
+	}
+	var res float64
+	switch op {
+	case ast.BopPlus:
+		res = l + r
+	case ast.BopMinus:
+		res = l - r
+	case ast.BopMult:
+		res = l * r
+	case ast.BopDiv:
+		if r == 0 {
+			return nil, false
+		}
+		res = l / r
+	case ast.BopLess:
+		return l < r, true
+	case ast.BopLessEq:
+		return l <= r, true
+	case ast.BopGreater:
+		return l > r, true
+	case ast.BopGreaterEq:
+		return l >= r, true
+	case ast.BopShiftL, ast.BopShiftR, ast.BopBitwiseAnd, ast.BopBitwiseOr, ast.BopBitwiseXor:
+		a, b := int64(l), int64(r)
+		if b < 0 && (op == ast.BopShiftL || op == ast.BopShiftR) {
+			return nil, false
+		}
+		switch op {
+		case ast.BopShiftL:
+			res = float64(a << (b % 64))
+		case ast.BopShiftR:
+			res = float64(a >> (b % 64))
+		case ast.BopBitwiseAnd:
+			res = float64(a & b)
+		case ast.BopBitwiseOr:
+			res = float64(a | b)
+		case ast.BopBitwiseXor:
+			res = float64(a ^ b)
+		}
+	default:
+		return nil, false
+	}
+	// overflows are errors in jsonnet
+	return res, !math.IsInf(res, 0) && !math.IsNaN(res)
+}
+
+// foldStd returns the result of the stdlib function `name`.
+func foldStd(name string, args []constant) (constant, bool) {
+	switch {
+	case name == "toString" && len(args) == 1:
+		if s, ok := args[0].(string); ok {
+			return s, true
+		}
+		s := manifestConstant(args[0])
+		return s, len(s) <= maxConstantSize
+	case name == "length" && len(args) == 1:
+		switch x := args[0].(type) {
+		case string:
+			return float64(len([]rune(x))), true
+		case []constant:
+			return float64(len(x)), true
+		}
+	case name == "equals" && len(args) == 2:
+		return constantEqual(args[0], args[1]), true
+	case name == "mod" && len(args) == 2:
+		l, lok := args[0].(float64)
+		r, rok := args[1].(float64)
+		if lok && rok && r != 0 {
+			return math.Mod(l, r), true
+		}
+	case name == "join" && len(args) == 2:
+		arr, ok := args[1].([]constant)
+		if !ok {
+			return nil, false
+		}
+		switch sep := args[0].(type) {
+		case string:
+			// nulls are skipped
+			parts := []string{}
+			for _, elem := range arr {
+				if elem == nil {
+					continue
+				}
+				s, ok := elem.(string)
+				if !ok {
+					return nil, false
+				}
+				parts = append(parts, s)
+			}
+			res := strings.Join(parts, sep)
+			return res, len(res) <= maxConstantSize
+		case []constant:
+			res := []constant{}
+			first := true
+			for _, elem := range arr {
+				if elem == nil {
+					continue
+				}
+				a, ok := elem.([]constant)
+				if !ok {
+					return nil, false
+				}
+				if !first {
+					res = append(res, sep...)
+				}
+				first = false
+				res = append(res, a...)
+			}
+			return res, len(res) <= maxConstantSize
+		}
+	}
+	return nil, false
+}
+
+// constantInt returns the value if it is an integer.
+func constantInt(v constant) (int, bool) {
+	x, ok := v.(float64)
+	if !ok || x != math.Floor(x) || math.Abs(x) > maxConstantSize {
+		return 0, false
+	}
+	return int(x), true
+}
+
+func constantEqual(a, b constant) bool {
+	aa, aok := a.([]constant)
+	ba, bok := b.([]constant)
+	if !aok || !bok {
+		return !aok && !bok && a == b
+	}
+	if len(aa) != len(ba) {
+		return false
+	}
+	for i := range aa {
+		if !constantEqual(aa[i], ba[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// manifestConstant returns the value as JSON, with numbers formatted like jsonnet does.
+func manifestConstant(v constant) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(x)
+	case float64:
+		if x == math.Floor(x) {
+			return fmt.Sprintf("%.0f", x)
+		}
+		return fmt.Sprintf("%.17g", x)
+	case string:
+		out, _ := json.Marshal(x)
+		return string(out)
+	case []constant:
+		parts := make([]string, len(x))
+		for i, elem := range x {
+			parts[i] = manifestConstant(elem)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstantValue(t *testing.T) {
+	for _, tc := range []struct {
+		Name   string
+		Source string
+		// the cursor, on the last line
+		Column int
+		// the value of the expression, empty if it is not constant
+		Expect string
+	}{
+		{"arithmetic", "1 + 2 * 3", 3, "7"},
+		{"division", "1 / 4", 3, "0.25"},
+		{"modulo", "7 % 3", 3, "1"},
+		{"division by zero", "1 / 0", 3, ""},
+		{"string concatenation", "local name = 'web';\nname + '-' + std.toString(8080)", 12, "\"web-8080\""},
+		{"number concatenation", "'port: ' + 80", 10, "\"port: 80\""},
+		{"join", "local parts = ['a', null, 'b'];\nstd.join(',', parts)", 14, "\"a,b\""},
+		{"conditional", "local n = 3;\nif n > 2 then 'many' else 'few'", 1, "\"many\""},
+		{"index", "local arr = [1, 2];\narr[1]", 7, "2"},
+		{"null local", "local n = null;\n[n, n]", 1, "[null, null]"},
+		{"transitive locals", "local a = 2, b = a * 3;\na + b", 3, "8"},
+		{"shadowed", "local x = 1;\nlocal g = x;\nlocal x = 2;\nx + g", 3, "3"},
+		{"recursive", "local a = a + 1;\na", 1, ""},
+		{"function call", "local f(x) = x + 1;\nf( 1 )", 6, ""},
+		{"object", "local o = { a: 1 };\no.a", 2, ""},
+		{"import", "local lib = import 'lib.libsonnet';\nlib.a", 2, ""},
+		{"ext var", "std.extVar('env') + '-x'", 20, ""},
+		{"parameter", "function(p)\n  p + 1", 5, ""},
+		{"large", "local a = '0123456789', b = a + a + a + a + a + a + a + a + a + a;\nlocal c = b + b + b + b + b + b + b + b + b + b;\nc + c", 3, ""},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			root, err := jsonnet.SnippetToAST("anon", tc.Source)
+			require.NoError(t, err)
+			lines := 1
+			for _, c := range tc.Source {
+				if c == '\n' {
+					lines++
+				}
+			}
+			stack := StackAtLoc(root, ast.Location{Line: lines, Column: tc.Column})
+			val, ok := ConstantValue(stack)
+			if tc.Expect == "" {
+				assert.False(t, ok, val)
+				return
+			}
+			require.True(t, ok)
+			assert.Equal(t, tc.Expect, val)
+		})
+	}
+}
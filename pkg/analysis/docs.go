@@ -0,0 +1,384 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// leadingComment returns the comment on the lines right above a definition, f.ex
+//
+//	// The name of the service.
+//	name: 'api',
+//
+// Consecutive `//` and `#` lines, or a single `/* */` block, are part of the comment. The
+// comment is kept with its markers, like the comments of the fodder.
+func leadingComment(loc ast.LocationRange) []string {
+	if !loc.IsSet() || loc.File == nil || loc.Begin.Line > len(loc.File.Lines) {
+		return nil
+	}
+	lines := loc.File.Lines
+	// the definition starts its line, only `local` can come before the name of a bind
+	prefix := lines[loc.Begin.Line-1]
+	if loc.Begin.Column-1 <= len(prefix) {
+		prefix = prefix[:loc.Begin.Column-1]
+	}
+	if p := strings.TrimSpace(prefix); p != "" && p != "local" {
+		return nil
+	}
+
+	var res []string
+	inBlock := false
+	for i := loc.Begin.Line - 2; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		switch {
+		case inBlock:
+			res = append(res, line)
+			inBlock = !strings.HasPrefix(line, "/*")
+			if !inBlock {
+				return reverseLines(res)
+			}
+		case strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#"):
+			res = append(res, line)
+		case strings.HasSuffix(line, "*/") && len(res) == 0:
+			res = append(res, line)
+			if inBlock = !strings.Contains(line, "/*"); !inBlock {
+				return res
+			}
+		default:
+			return reverseLines(res)
+		}
+	}
+	if inBlock {
+		// the start of the block was not found
+		return nil
+	}
+	return reverseLines(res)
+}
+
+// FileComment returns the comments at the top of a file, before its first line of code,
+// f.ex the documentation of a library. The comments are kept with their markers, without
+// the blank lines between them.
+func FileComment(contents string) []string {
+	var res []string
+	inBlock := false
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case inBlock:
+			res = append(res, line)
+			inBlock = !strings.Contains(line, "*/")
+		case line == "":
+		case strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#"):
+			res = append(res, line)
+		case strings.HasPrefix(line, "/*"):
+			res = append(res, line)
+			inBlock = !strings.Contains(line[2:], "*/")
+		default:
+			return res
+		}
+	}
+	return res
+}
+
+func reverseLines(lines []string) []string {
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+// withComment returns a copy of the value with the comment of its definition, or the
+// value itself if the definition has no comment. The `@param` tags of the comment of a
+// function document its parameters.
+func withComment(val *Value, comment []string) *Value {
+	if len(comment) == 0 {
+		return val
+	}
+	res := *val
+	res.Comment = comment
+	res.Function = withParamDocs(val.Function, comment)
+	return &res
+}
+
+// IsDocField returns true for the fields that document other fields with docsonnet, f.ex
+// `'#name':: d.fn('Returns the name.')` documents the field `name`, and `'#'` the object.
+func IsDocField(fld Field) bool {
+	return strings.HasPrefix(fld.Name, "#")
+}
+
+// docsonnetKinds maps the constructors of the newer docsonnet API, f.ex `d.func.new`, to
+// the functions of the older one.
+var docsonnetKinds = map[string]string{"func": "fn", "object": "obj", "value": "val", "package": "pkg"}
+
+// docsonnetComment returns the documentation of a docsonnet doc field, which is a call of
+// `d.fn`, `d.obj`, `d.val` or `d.pkg` (or `d.func.new`, ...), or the object these return
+// as generated libraries like Grafonnet write it. The type of the documented field is
+// returned when the doc field declares it.
+func docsonnetComment(node ast.Node) ([]string, ValueType, bool) {
+	if obj, ok := node.(*ast.DesugaredObject); ok {
+		return docsonnetObjectComment(obj)
+	}
+	apply, ok := node.(*ast.Apply)
+	if !ok {
+		return nil, AnyType, false
+	}
+	name, ok := docsonnetKind(apply)
+	if !ok {
+		return nil, AnyType, false
+	}
+
+	var help string
+	typ := AnyType
+	var res []string
+	switch name {
+	case "fn":
+		// fn(help, args=[])
+		help, _ = docString(docArg(apply, 0, "help"))
+		typ = FunctionType
+		if args, ok := docArg(apply, 1, "args").(*ast.Array); ok && len(args.Elements) > 0 {
+			res = append(res, "", "Arguments:")
+			for _, elem := range args.Elements {
+				if arg, ok := docsonnetArg(elem.Expr); ok {
+					res = append(res, arg)
+				}
+			}
+		}
+	case "obj":
+		// obj(help, fields={})
+		help, _ = docString(docArg(apply, 0, "help"))
+		typ = ObjectType
+	case "val":
+		// val(type, help='', default=null)
+		typ = docType(docArg(apply, 0, "type"))
+		help, _ = docString(docArg(apply, 1, "help"))
+		res = append(res, docDefault(docArg(apply, 2, "default"))...)
+	case "pkg":
+		// pkg(name, url, help, filename='', version='master')
+		help, _ = docString(docArg(apply, 2, "help"))
+		typ = ObjectType
+		res = append(res, docImport(docArg(apply, 1, "url"))...)
+	default:
+		return nil, AnyType, false
+	}
+	return withHelp(help, res), typ, true
+}
+
+// docsonnetKind returns the docsonnet function called, f.ex `fn` for `d.fn(...)` and
+// `d.func.new(...)`.
+func docsonnetKind(apply *ast.Apply) (string, bool) {
+	idx, ok := apply.Target.(*ast.Index)
+	if !ok {
+		return "", false
+	}
+	kind, ok := idx.Index.(*ast.LiteralString)
+	if !ok {
+		return "", false
+	}
+	name := kind.Value
+	if parent, ok := idx.Target.(*ast.Index); ok && name == "new" {
+		if lit, ok := parent.Index.(*ast.LiteralString); ok {
+			name = docsonnetKinds[lit.Value]
+		}
+	}
+	return name, true
+}
+
+// DocsonnetArgNames returns the names of the arguments a `d.fn` doc field declares, f.ex
+// `env` of `d.fn('...', [d.arg('env', d.T.string)])`.
+func DocsonnetArgNames(node ast.Node) []*ast.LiteralString {
+	apply, ok := node.(*ast.Apply)
+	if !ok {
+		return nil
+	}
+	if kind, ok := docsonnetKind(apply); !ok || kind != "fn" {
+		return nil
+	}
+	args, ok := docArg(apply, 1, "args").(*ast.Array)
+	if !ok {
+		return nil
+	}
+	res := []*ast.LiteralString{}
+	for _, elem := range args.Elements {
+		if arg, ok := elem.Expr.(*ast.Apply); ok {
+			if name, ok := docArg(arg, 0, "name").(*ast.LiteralString); ok {
+				res = append(res, name)
+			}
+		}
+	}
+	return res
+}
+
+// docsonnetObjectComment returns the documentation of a doc field written as the object the
+// docsonnet functions return, f.ex `{ 'function': { help: '...', args: [...] } }`.
+func docsonnetObjectComment(obj *ast.DesugaredObject) ([]string, ValueType, bool) {
+	var res []string
+	if fn, ok := objectField(obj, "function").(*ast.DesugaredObject); ok {
+		help, _ := docString(objectField(fn, "help"))
+		if args, ok := objectField(fn, "args").(*ast.Array); ok && len(args.Elements) > 0 {
+			res = append(res, "", "Arguments:")
+			for _, elem := range args.Elements {
+				arg, ok := elem.Expr.(*ast.DesugaredObject)
+				if !ok {
+					continue
+				}
+				if name, ok := docString(objectField(arg, "name")); ok {
+					res = append(res, formatDocArg(name, docType(objectField(arg, "type")), objectField(arg, "default"), objectField(arg, "enums")))
+				}
+			}
+		}
+		return withHelp(help, res), FunctionType, true
+	}
+	if o, ok := objectField(obj, "object").(*ast.DesugaredObject); ok {
+		help, _ := docString(objectField(o, "help"))
+		return withHelp(help, nil), ObjectType, true
+	}
+	if v, ok := objectField(obj, "value").(*ast.DesugaredObject); ok {
+		help, _ := docString(objectField(v, "help"))
+		return withHelp(help, docDefault(objectField(v, "default"))), docType(objectField(v, "type")), true
+	}
+	if _, ok := docString(objectField(obj, "name")); ok {
+		// a package, the `import` of generated libraries is the `url` of d.pkg
+		help, _ := docString(objectField(obj, "help"))
+		imp := objectField(obj, "import")
+		if imp == nil {
+			imp = objectField(obj, "url")
+		}
+		return withHelp(help, docImport(imp)), ObjectType, true
+	}
+	return nil, AnyType, false
+}
+
+// objectField returns the value of a field of an object literal by its constant name, or nil.
+func objectField(obj *ast.DesugaredObject, name string) ast.Node {
+	for _, fld := range obj.Fields {
+		if lit, ok := fld.Name.(*ast.LiteralString); ok && lit.Value == name {
+			return fld.Body
+		}
+	}
+	return nil
+}
+
+func withHelp(help string, res []string) []string {
+	help = strings.TrimRight(help, "\n")
+	if help == "" {
+		// the blank line only separates the details from the help
+		if len(res) > 0 && res[0] == "" {
+			res = res[1:]
+		}
+		return res
+	}
+	return append(strings.Split(help, "\n"), res...)
+}
+
+func docDefault(def ast.Node) []string {
+	if def == nil {
+		return nil
+	}
+	if _, isNull := def.(*ast.LiteralNull); isNull {
+		return nil
+	}
+	return []string{"", "Default: " + nodeSource(def)}
+}
+
+func docImport(url ast.Node) []string {
+	if url, ok := docString(url); ok && url != "" {
+		return []string{"", fmt.Sprintf("import %q", url)}
+	}
+	return nil
+}
+
+// docsonnetArg formats an argument of `d.fn`, declared with `d.arg(name, type, default=null)`
+// or `d.argument.new(name, type, default=null, enums=null)`.
+func docsonnetArg(node ast.Node) (string, bool) {
+	apply, ok := node.(*ast.Apply)
+	if !ok {
+		return "", false
+	}
+	name, ok := docString(docArg(apply, 0, "name"))
+	if !ok {
+		return "", false
+	}
+	return formatDocArg(name, docType(docArg(apply, 1, "type")), docArg(apply, 2, "default"), docArg(apply, 3, "enums")), true
+}
+
+// formatDocArg formats an argument for the documentation of a function, f.ex
+// `- port (number, default: 80)`.
+func formatDocArg(name string, typ ValueType, def, enums ast.Node) string {
+	details := []string{}
+	if typ != AnyType {
+		details = append(details, typ.String())
+	}
+	if _, isNull := def.(*ast.LiteralNull); def != nil && !isNull {
+		details = append(details, "default: "+nodeSource(def))
+	}
+	if arr, ok := enums.(*ast.Array); ok && len(arr.Elements) > 0 {
+		values := make([]string, len(arr.Elements))
+		for i, elem := range arr.Elements {
+			values[i] = nodeSource(elem.Expr)
+		}
+		details = append(details, "one of: "+strings.Join(values, ", "))
+	}
+	if len(details) == 0 {
+		return "- " + name
+	}
+	return fmt.Sprintf("- %s (%s)", name, strings.Join(details, ", "))
+}
+
+// docArg returns the argument of a docsonnet call by position or name, or nil.
+func docArg(apply *ast.Apply, pos int, name string) ast.Node {
+	if pos < len(apply.Arguments.Positional) {
+		return apply.Arguments.Positional[pos].Expr
+	}
+	for _, arg := range apply.Arguments.Named {
+		if string(arg.Name) == name {
+			return arg.Arg
+		}
+	}
+	return nil
+}
+
+// docString returns the value of a string literal, or of a concatenation of literals.
+func docString(node ast.Node) (string, bool) {
+	switch node := node.(type) {
+	case *ast.LiteralString:
+		return node.Value, true
+	case *ast.Binary:
+		if node.Op != ast.BopPlus {
+			return "", false
+		}
+		lhs, lok := docString(node.Left)
+		rhs, rok := docString(node.Right)
+		return lhs + rhs, lok && rok
+	}
+	return "", false
+}
+
+// docType returns the type of a docsonnet declaration, written as `d.T.string` or 'string',
+// or `['string']` in generated libraries.
+func docType(node ast.Node) ValueType {
+	if arr, ok := node.(*ast.Array); ok {
+		if len(arr.Elements) != 1 {
+			return AnyType
+		}
+		node = arr.Elements[0].Expr
+	}
+	name, ok := docString(node)
+	if idx, isIndex := node.(*ast.Index); isIndex {
+		name, ok = docString(idx.Index)
+	}
+	if !ok {
+		return AnyType
+	}
+	switch name {
+	case "bool":
+		name = "boolean"
+	case "func":
+		name = "function"
+	}
+	if typ, ok := NewValueType(name); ok {
+		return typ
+	}
+	return AnyType
+}
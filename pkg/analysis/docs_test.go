@@ -0,0 +1,179 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeadingComments(t *testing.T) {
+	source := `// the number of replicas
+local replicas = 3;
+{
+  // the name of the service
+  // and of its deployment
+  name: 'api',
+  /* the ports */
+  ports: [80],
+  /*
+   * the image
+   */
+  image: 'nginx',
+
+  # unrelated
+
+  replicas: replicas, other: 1,
+}`
+	resolver, out := newAnonMockResolver(t, source)
+	val := NodeToValue(out, resolver)
+	require.NotNil(t, val.Object)
+
+	fields := val.Object.FieldMap
+	assert.Equal(t, []string{"// the name of the service", "// and of its deployment"}, fields["name"].Comment)
+	assert.Equal(t, []string{"/* the ports */"}, fields["ports"].Comment)
+	assert.Equal(t, []string{"/*", "* the image", "*/"}, fields["image"].Comment)
+	assert.Empty(t, fields["replicas"].Comment)
+	// the field does not start its line
+	assert.Empty(t, fields["other"].Comment)
+
+	// the comment of a local is the comment of its variable
+	replicas := NodeToValue(val.Object.FieldMap["replicas"].Node, resolver)
+	assert.Equal(t, []string{"// the number of replicas"}, replicas.Comment)
+}
+
+func TestFileComment(t *testing.T) {
+	source := `
+// Helpers for services.
+// Import as ` + "`svc`" + `.
+
+/*
+ * Maintained by the platform team.
+ */
+local name = 'api'; // not part of it
+{}`
+	assert.Equal(t, []string{
+		"// Helpers for services.",
+		"// Import as `svc`.",
+		"/*",
+		"* Maintained by the platform team.",
+		"*/",
+	}, FileComment(source))
+	assert.Empty(t, FileComment("{ a: 1 }\n// trailing\n"))
+}
+
+func TestDocsonnetComments(t *testing.T) {
+	source := `local d = import 'doc-util/main.libsonnet';
+{
+  '#': d.pkg(name='lib', url='github.com/example/lib/main.libsonnet', help='A library.'),
+  '#new':: d.fn(|||
+    Creates a service.
+  |||, args=[d.arg('name', d.T.string), d.arg('port', d.T.number, 80), d.arg('labels')]),
+  new(name, port=80, labels={}):: { name: name },
+  '#replicas':: d.val(type=d.T.number, help='The number of ' + 'replicas.', default=1),
+  replicas: $.config.replicas,
+  '#config':: d.obj('The configuration.'),
+  config:: {},
+}`
+	resolver, out := newAnonMockResolver(t, source)
+	val := NodeToValue(out, resolver)
+	require.NotNil(t, val.Object)
+
+	assert.Equal(t, []string{"A library.", "", `import "github.com/example/lib/main.libsonnet"`}, val.Comment)
+
+	fields := val.Object.FieldMap
+	assert.Equal(t, []string{
+		"Creates a service.",
+		"",
+		"Arguments:",
+		"- name (string)",
+		"- port (number, default: 80)",
+		"- labels",
+	}, fields["new"].Comment)
+	assert.Equal(t, []string{"The number of replicas.", "", "Default: 1"}, fields["replicas"].Comment)
+	assert.Equal(t, NumberType, fields["replicas"].Type)
+	assert.Equal(t, []string{"The configuration."}, fields["config"].Comment)
+
+	// the doc fields are not documented themselves
+	assert.True(t, IsDocField(*fields["#new"]))
+	assert.False(t, IsDocField(*fields["new"]))
+}
+
+func TestDocsonnetGeneratedComments(t *testing.T) {
+	// generated libraries like Grafonnet write the objects the docsonnet functions return
+	source := `local d = import 'doc-util/main.libsonnet';
+{
+  '#': { filename: 'main.libsonnet', help: 'Grafonnet.', 'import': 'github.com/grafana/grafonnet/gen/grafonnet-latest/main.libsonnet', name: 'grafonnet' },
+  '#withTitle': { 'function': { args: [{ default: null, enums: null, name: 'value', type: ['string'] }], help: 'Title of dashboard.' } },
+  withTitle(value): { title: value },
+  '#withStyle': { 'function': { args: [{ default: 'dark', enums: ['dark', 'light'], name: 'value', type: ['string'] }], help: '' } },
+  withStyle(value='dark'): { style: value },
+  '#new':: d.func.new('Creates a new dashboard.', args=[d.argument.new('title', d.T.string)]),
+  new(title): self.withTitle(title),
+  '#refresh': { value: { help: 'Refresh rate.', type: 'string', default: '1m' } },
+  refresh: '1m',
+}`
+	resolver, out := newAnonMockResolver(t, source)
+	val := NodeToValue(out, resolver)
+	require.NotNil(t, val.Object)
+
+	assert.Equal(t, []string{"Grafonnet.", "", `import "github.com/grafana/grafonnet/gen/grafonnet-latest/main.libsonnet"`}, val.Comment)
+
+	fields := val.Object.FieldMap
+	assert.Equal(t, []string{"Title of dashboard.", "", "Arguments:", "- value (string)"}, fields["withTitle"].Comment)
+	assert.Equal(t, []string{"Arguments:", "- value (string, default: 'dark', one of: 'dark', 'light')"}, fields["withStyle"].Comment)
+	assert.Equal(t, []string{"Creates a new dashboard.", "", "Arguments:", "- title (string)"}, fields["new"].Comment)
+	assert.Equal(t, []string{"Refresh rate.", "", "Default: '1m'"}, fields["refresh"].Comment)
+	assert.Equal(t, StringType, fields["refresh"].Type)
+}
+
+func TestDocTags(t *testing.T) {
+	source := `{
+  // Returns the address of the service.
+  // @param env the environment it runs in
+  // @param port
+  // @return the host and port
+  address(env, port=80):: env + ':' + port,
+}`
+	resolver, out := newAnonMockResolver(t, source)
+	defs := FunctionDefinitions(out)
+	require.Len(t, defs, 1)
+	assert.Equal(t, "address", defs[0].Name)
+
+	tags := DocTags(defs[0].Loc)
+	require.Len(t, tags, 3)
+	assert.Equal(t, DocTag{Kind: ParamTag, Name: "env", Text: "the environment it runs in"}, DocTag{Kind: tags[0].Kind, Name: tags[0].Name, Text: tags[0].Text})
+	assert.Equal(t, "anon:3:13-16", tags[0].Range.String())
+	assert.Equal(t, "port", tags[1].Name)
+	assert.Equal(t, DocTag{Kind: ReturnTag, Text: "the host and port"}, DocTag{Kind: tags[2].Kind, Name: tags[2].Name, Text: tags[2].Text})
+
+	def, ok := DocumentedFunction(out, 4)
+	require.True(t, ok)
+	assert.Equal(t, "address", def.Name)
+	_, ok = DocumentedFunction(out, 6)
+	assert.False(t, ok)
+
+	// the text of the tags documents the parameters
+	resolver, out = newAnonMockResolver(t, "local lib = "+source+";\nlib.address")
+	val := NodeToValue(out, resolver)
+	require.NotNil(t, val.Function)
+	assert.Equal(t, []string{"the environment it runs in"}, val.Function.Params[0].Comment)
+	assert.Empty(t, val.Function.Params[1].Comment)
+}
+
+func TestCommentAt(t *testing.T) {
+	source := "local x = '// no';\n// a comment\n{ /* block */ a: x }"
+	start, ok := CommentAt(source, 22)
+	assert.True(t, ok)
+	assert.Equal(t, 19, start)
+	// the end of a line comment is in it
+	_, ok = CommentAt(source, 31)
+	assert.True(t, ok)
+	_, ok = CommentAt(source, 13)
+	assert.False(t, ok)
+	start, ok = CommentAt(source, 37)
+	assert.True(t, ok)
+	assert.Equal(t, 34, start)
+	_, ok = CommentAt(source, 46)
+	assert.False(t, ok)
+}
@@ -0,0 +1,199 @@
+package analysis
+
+import (
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// The comment documenting a function can describe its parameters and result with tags,
+// f.ex
+//
+//	// Returns the address of the service.
+//	// @param env the environment it runs in
+//	// @return the host and port
+//	address(env):: ...
+const (
+	ParamTag  = "param"
+	ReturnTag = "return"
+)
+
+// DocTag is a tag of the comment documenting a function.
+type DocTag struct {
+	Kind string
+	// the parameter documented by a `@param` tag
+	Name string
+	Text string
+	// the name of the parameter of a `@param` tag, or the tag itself
+	Range ast.LocationRange
+}
+
+// commentText returns the text of a line of a comment, without its markers.
+func commentText(line string) string {
+	line = strings.TrimSpace(line)
+	for _, marker := range []string{"//", "#", "/*", "*"} {
+		if strings.HasPrefix(line, marker) {
+			line = line[len(marker):]
+			break
+		}
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), "*/"))
+}
+
+// parseDocTag parses the tag a line of a comment starts with. Returns the kind of the tag,
+// and the text after it.
+func parseDocTag(line string) (string, string, bool) {
+	text := commentText(line)
+	for _, kind := range []string{ParamTag, ReturnTag} {
+		rest := strings.TrimPrefix(text, "@"+kind)
+		if rest != text && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			return kind, strings.TrimSpace(rest), true
+		}
+	}
+	return "", "", false
+}
+
+// DocTags returns the tags of the comment right above the definition at `loc`, f.ex the
+// name of a field or the bind of a local.
+func DocTags(loc ast.LocationRange) []DocTag {
+	comment := leadingComment(loc)
+	if len(comment) == 0 {
+		return nil
+	}
+	res := []DocTag{}
+	// the comment is on the lines right above the definition
+	first := loc.Begin.Line - len(comment)
+	for i, line := range comment {
+		kind, text, ok := parseDocTag(line)
+		if !ok {
+			continue
+		}
+		lineNum := first + i
+		raw := strings.TrimRight(loc.File.Lines[lineNum-1], "\r\n")
+		col := strings.Index(raw, "@"+kind)
+		tag := DocTag{Kind: kind, Text: text, Range: ast.LocationRange{
+			FileName: loc.FileName,
+			File:     loc.File,
+			Begin:    ast.Location{Line: lineNum, Column: col + 1},
+			End:      ast.Location{Line: lineNum, Column: col + 1 + len(kind) + 1},
+		}}
+		if kind == ParamTag {
+			fields := strings.Fields(text)
+			if len(fields) == 0 {
+				continue
+			}
+			tag.Name = fields[0]
+			tag.Text = strings.TrimSpace(strings.TrimPrefix(text, tag.Name))
+			nameCol := col + len(kind) + 1 + strings.Index(raw[col+len(kind)+1:], tag.Name)
+			tag.Range.Begin.Column = nameCol + 1
+			tag.Range.End.Column = nameCol + 1 + len(tag.Name)
+		}
+		res = append(res, tag)
+	}
+	return res
+}
+
+// paramDocs returns the text of the `@param` tags of a comment by parameter.
+func paramDocs(comment []string) map[string]string {
+	var res map[string]string
+	for _, line := range comment {
+		kind, text, ok := parseDocTag(line)
+		if !ok || kind != ParamTag {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if res == nil {
+			res = map[string]string{}
+		}
+		res[fields[0]] = strings.TrimSpace(strings.TrimPrefix(text, fields[0]))
+	}
+	return res
+}
+
+// withParamDocs returns a copy of the function with the text of the `@param` tags of its
+// comment as the documentation of its parameters.
+func withParamDocs(fn *Function, comment []string) *Function {
+	docs := paramDocs(comment)
+	if fn == nil || len(docs) == 0 {
+		return fn
+	}
+	res := *fn
+	res.Params = make([]Param, len(fn.Params))
+	for i, p := range fn.Params {
+		if doc, ok := docs[p.Name]; ok && doc != "" {
+			p.Comment = append(append([]string{}, p.Comment...), doc)
+		}
+		res.Params[i] = p
+	}
+	return &res
+}
+
+// FunctionDefinition is a field or local whose value is a function, which its comment
+// documents.
+type FunctionDefinition struct {
+	Name string
+	// the name of the field, or the bind of the local
+	Loc      ast.LocationRange
+	Function *ast.Function
+}
+
+// FunctionDefinitions returns the fields and locals of a file whose value is a function.
+func FunctionDefinitions(root ast.Node) []FunctionDefinition {
+	res := []FunctionDefinition{}
+	addBinds := func(binds ast.LocalBinds) {
+		for _, b := range binds {
+			fn, ok := b.Body.(*ast.Function)
+			if b.Fun != nil {
+				fn, ok = b.Fun, true
+			}
+			if !ok {
+				continue
+			}
+			// binds with the function syntax have no range, the function starts at the name
+			rng := b.LocRange
+			if !rng.IsSet() && fn.Loc() != nil {
+				rng = *fn.Loc()
+			}
+			if rng.IsSet() {
+				res = append(res, FunctionDefinition{Name: string(b.Variable), Loc: rng, Function: fn})
+			}
+		}
+	}
+	WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Local:
+			addBinds(n.Binds)
+		case *ast.DesugaredObject:
+			addBinds(n.Locals)
+			for i := range n.Fields {
+				fn, ok := n.Fields[i].Body.(*ast.Function)
+				if !ok {
+					continue
+				}
+				name, rng, ok := FieldNameRange(&n.Fields[i])
+				if !rng.IsSet() {
+					rng = n.Fields[i].LocRange
+				}
+				if ok && rng.IsSet() {
+					res = append(res, FunctionDefinition{Name: name, Loc: rng, Function: fn})
+				}
+			}
+		}
+		return true
+	})
+	return res
+}
+
+// DocumentedFunction returns the function documented by the comment on `line`, which is
+// right above its definition.
+func DocumentedFunction(root ast.Node, line int) (FunctionDefinition, bool) {
+	for _, def := range FunctionDefinitions(root) {
+		if n := len(leadingComment(def.Loc)); n > 0 && def.Loc.Begin.Line-n <= line && line < def.Loc.Begin.Line {
+			return def, true
+		}
+	}
+	return FunctionDefinition{}, false
+}
@@ -0,0 +1,131 @@
+package analysis
+
+import (
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+type FoldingKind int
+
+const (
+	FoldRegion  FoldingKind = 0
+	FoldComment FoldingKind = 1
+	FoldImports FoldingKind = 2
+)
+
+// FoldingRange is a range of lines that can be collapsed. Lines are 1-indexed, the same
+// as ast.Location.
+type FoldingRange struct {
+	StartLine int
+	EndLine   int
+	Kind      FoldingKind
+}
+
+// FoldingRanges returns the foldable ranges of a document: multi-line objects, arrays,
+// functions, text blocks, comments, and groups of imports.
+func FoldingRanges(root ast.Node, contents string) []FoldingRange {
+	res := []FoldingRange{}
+	seen := map[int]bool{}
+	WalkStack(root, func(n ast.Node, stack []ast.Node) bool {
+		switch n.(type) {
+		case *ast.DesugaredObject, *ast.Array, *ast.Function, *ast.LiteralString:
+		default:
+			return true
+		}
+		loc := n.Loc()
+		if loc == nil || !loc.IsSet() {
+			return true
+		}
+		// keep the line with the closing bracket visible when folded
+		fr := FoldingRange{StartLine: loc.Begin.Line, EndLine: loc.End.Line - 1, Kind: FoldRegion}
+		// nested nodes starting on the same line (f.ex `function(x) {`) fold the same lines
+		if fr.EndLine > fr.StartLine && !seen[fr.StartLine] {
+			seen[fr.StartLine] = true
+			res = append(res, fr)
+		}
+		return true
+	})
+	res = append(res, importRanges(root)...)
+	res = append(res, commentRanges(contents)...)
+	return res
+}
+
+// importRanges groups consecutive top level import locals.
+func importRanges(root ast.Node) []FoldingRange {
+	res := []FoldingRange{}
+	cur := FoldingRange{Kind: FoldImports}
+	flush := func() {
+		if cur.EndLine > cur.StartLine {
+			res = append(res, cur)
+		}
+		cur = FoldingRange{Kind: FoldImports}
+	}
+	for loc, ok := root.(*ast.Local); ok; loc, ok = loc.Body.(*ast.Local) {
+		for _, b := range loc.Binds {
+			switch b.Body.(type) {
+			case *ast.Import, *ast.ImportStr, *ast.ImportBin:
+			default:
+				flush()
+				continue
+			}
+			if !b.LocRange.IsSet() {
+				continue
+			}
+			if cur.StartLine == 0 || b.LocRange.Begin.Line > cur.EndLine+1 {
+				flush()
+				cur.StartLine = b.LocRange.Begin.Line
+			}
+			cur.EndLine = b.LocRange.End.Line
+		}
+	}
+	flush()
+	return res
+}
+
+// commentRanges scans the source for block comments, and runs of line comments that
+// are on their own line. Strings are skipped so that comment characters inside of them
+// are not mistaken for comments.
+func commentRanges(contents string) []FoldingRange {
+	res := []FoldingRange{}
+	line := 1
+	lineHasCode := false
+	// the current run of consecutive line comments
+	run := FoldingRange{Kind: FoldComment}
+	flushRun := func() {
+		if run.EndLine > run.StartLine {
+			res = append(res, run)
+		}
+		run = FoldingRange{Kind: FoldComment}
+	}
+
+	for i := 0; i < len(contents); {
+		kind, end := scanToken(contents, i)
+		start := line
+		line += strings.Count(contents[i:end], "\n")
+		switch kind {
+		case spaceToken:
+			if contents[i] == '\n' {
+				lineHasCode = false
+			}
+		case lineCommentToken:
+			if !lineHasCode {
+				if run.StartLine == 0 || start > run.EndLine+1 {
+					flushRun()
+					run.StartLine = start
+				}
+				run.EndLine = start
+			}
+		case blockCommentToken:
+			if line > start {
+				res = append(res, FoldingRange{StartLine: start, EndLine: line, Kind: FoldComment})
+			}
+			lineHasCode = true
+		default:
+			lineHasCode = true
+		}
+		i = end
+	}
+	flushRun()
+	return res
+}
@@ -0,0 +1,45 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFoldingRanges(t *testing.T) {
+	source := `local a = import 'a.libsonnet';
+local b = import 'b.libsonnet';
+// A long comment
+// over multiple lines
+local url = 'http://example.com # not a comment';
+/*
+ block comment
+*/
+{
+  fn(x):: {
+    y: x,
+  },
+  arr: [
+    1, // trailing comment
+    2, // trailing comment
+  ],
+  text: |||
+    // not a comment
+  |||,
+}
+`
+	root, err := jsonnet.SnippetToAST("anon", source)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []FoldingRange{
+		{StartLine: 1, EndLine: 2, Kind: FoldImports},
+		{StartLine: 3, EndLine: 4, Kind: FoldComment},
+		{StartLine: 6, EndLine: 8, Kind: FoldComment},
+		{StartLine: 9, EndLine: 19, Kind: FoldRegion},
+		{StartLine: 10, EndLine: 11, Kind: FoldRegion},
+		{StartLine: 13, EndLine: 15, Kind: FoldRegion},
+		{StartLine: 17, EndLine: 18, Kind: FoldRegion},
+	}, FoldingRanges(root, source))
+}
@@ -0,0 +1,18 @@
+package analysis
+
+import "fmt"
+
+// forceSnippet evaluates every visible value of an expression, like manifesting it does,
+// without formatting the output. Functions are not called.
+const forceSnippet = `local force(v) =
+  if std.isObject(v) then std.foldl(function(ok, k) ok && force(v[k]), std.objectFields(v), true)
+  else if std.isArray(v) then std.foldl(function(ok, e) ok && force(e), v, true)
+  else true;
+force(%s)
+`
+
+// ForceSnippet returns the snippet that evaluates every visible value of the expression
+// `expr`, f.ex to time or step through its evaluation. It evaluates to true.
+func ForceSnippet(expr string) string {
+	return fmt.Sprintf(forceSnippet, expr)
+}
@@ -0,0 +1,22 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForceSnippet(t *testing.T) {
+	vm := jsonnet.MakeVM()
+	// like in the output, hidden fields and functions are not evaluated
+	out, err := vm.EvaluateAnonymousSnippet("force.jsonnet", ForceSnippet("{ a: [1, { b:: error 'hidden' }], f(x):: error 'not called' }"))
+	require.NoError(t, err)
+	assert.Equal(t, "true\n", out)
+
+	// the visible values are
+	_, err = vm.EvaluateAnonymousSnippet("force.jsonnet", ForceSnippet("{ a: { b: error 'forced' } }"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "forced")
+}
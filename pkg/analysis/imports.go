@@ -0,0 +1,142 @@
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// importLine matches a local bound to an import on a line of its own, f.ex
+// `local k = import 'k.libsonnet';`
+var importLine = regexp.MustCompile(`^local\s+([_a-zA-Z][_a-zA-Z0-9]*)\s*=\s*(import|importstr|importbin)\s*(['"])(.*)['"]\s*([;,])\s*$`)
+
+// importEntry is a line in the import block at the top of a file.
+type importEntry struct {
+	// 1-indexed line and column of the name
+	line, col int
+	name      string
+	kind      string
+	quote     byte
+	path      string
+	// `;` for a local expression, or `,` for an object local
+	term string
+}
+
+// importBlock returns the imports at the top of a file, before any other code. Blank
+// lines and comments before the first import are skipped, and blank lines between
+// imports. Also returns the line of the first code after the imports (which may be a
+// comment), or 0 if there is none.
+func importBlock(contents string) ([]importEntry, int) {
+	res := []importEntry{}
+	for i, line := range strings.Split(contents, "\n") {
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		line = strings.TrimSpace(line)
+		if m := importLine.FindStringSubmatchIndex(line); m != nil && !strings.Contains(line[m[8]:m[9]], line[m[6]:m[7]]) {
+			res = append(res, importEntry{
+				line:  i + 1,
+				col:   indent + m[2] + 1,
+				name:  line[m[2]:m[3]],
+				kind:  line[m[4]:m[5]],
+				quote: line[m[6]],
+				path:  line[m[8]:m[9]],
+				term:  line[m[10]:m[11]],
+			})
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		if len(res) == 0 && (strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#")) {
+			continue
+		}
+		return res, i + 1
+	}
+	return res, 0
+}
+
+// ImportInsertLine returns the line (1-indexed) a new import should be inserted at:
+// after the imports at the top of the file, or before the first line of code if there
+// are none. Also returns the quote character used by the existing imports.
+func ImportInsertLine(contents string) (int, byte) {
+	imports, firstCode := importBlock(contents)
+	if len(imports) > 0 {
+		last := imports[len(imports)-1]
+		return last.line + 1, last.quote
+	}
+	if firstCode == 0 {
+		return 1, '\''
+	}
+	return firstCode, '\''
+}
+
+// OrganizeImports rewrites the imports at the top of the file as a single block sorted
+// by name, without the imports that are never used. Paths are quoted with `quote`, or
+// keep their quotes if it is 0. Returns false if the imports are already organized.
+func OrganizeImports(root ast.Node, contents string, quote byte) (TextEdit, bool) {
+	imports, _ := importBlock(contents)
+	if root == nil || root.Loc() == nil || len(imports) == 0 {
+		return TextEdit{}, false
+	}
+	lines := strings.Split(contents, "\n")
+	first, last := imports[0].line, imports[len(imports)-1].line
+	// only local expressions are organized, the block ends at the first object local
+	for i, imp := range imports {
+		if imp.term != ";" {
+			if i == 0 {
+				return TextEdit{}, false
+			}
+			imports, last = imports[:i], imports[i-1].line
+			break
+		}
+	}
+
+	kept := []importEntry{}
+	for _, imp := range imports {
+		bind := BindingAtLoc(root, ast.Location{Line: imp.line, Column: imp.col})
+		if bind != nil && len(BindingReferences(bind)) == 0 {
+			continue
+		}
+		kept = append(kept, imp)
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].name < kept[j].name })
+
+	block := []string{}
+	for _, imp := range kept {
+		q := quote
+		if q == 0 || strings.ContainsRune(imp.path, rune(q)) {
+			q = imp.quote
+		}
+		block = append(block, fmt.Sprintf("local %s = %s %c%s%c;", imp.name, imp.kind, q, imp.path, q))
+	}
+	newText := strings.Join(block, "\n")
+
+	rng := *root.Loc()
+	rng.Begin = ast.Location{Line: first, Column: 1}
+	rng.End = ast.Location{Line: last, Column: len(lines[last-1]) + 1}
+	if len(kept) == 0 && last < len(lines) {
+		// remove the line breaks of the removed imports too
+		rng.End = ast.Location{Line: last + 1, Column: 1}
+	}
+	if newText == strings.Join(lines[first-1:last], "\n") {
+		return TextEdit{}, false
+	}
+	return TextEdit{Range: rng, NewText: newText}, true
+}
+
+// ImportedNames returns the import paths that each local bound directly to an import
+// is bound to, f.ex `local k = import 'k.libsonnet'` maps `k` to `k.libsonnet`.
+func ImportedNames(root ast.Node) map[string][]string {
+	res := map[string][]string{}
+	walkStack(root, nil, func(n ast.Node, _ []ast.Node) bool {
+		for _, b := range bindsOf(n) {
+			if imp, ok := b.Body.(*ast.Import); ok && imp.File != nil {
+				res[string(b.Variable)] = append(res[string(b.Variable)], imp.File.Value)
+			}
+		}
+		return true
+	})
+	return res
+}
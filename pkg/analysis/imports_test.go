@@ -0,0 +1,81 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportInsertLine(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Source string
+		Line   int
+		Quote  byte
+	}{
+		{Name: "NoImports", Source: "{}", Line: 1, Quote: '\''},
+		{Name: "AfterHeader", Source: "// header\n\n{}", Line: 3, Quote: '\''},
+		{Name: "AfterImports", Source: "// header\nlocal a = import \"a.libsonnet\";\nlocal b = import \"b.libsonnet\";\n\n{}", Line: 4, Quote: '"'},
+		{Name: "StopsAtCode", Source: "local a = import 'a.libsonnet';\nlocal x = 1;\nlocal b = import 'b.libsonnet';\nx", Line: 2, Quote: '\''},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			line, quote := ImportInsertLine(tc.Source)
+			assert.Equal(t, tc.Line, line)
+			assert.Equal(t, string(tc.Quote), string(quote))
+		})
+	}
+}
+
+func TestImportedNames(t *testing.T) {
+	resolver, _ := newAnonMockResolver(t, "local a = import 'a.libsonnet';\n{\n  local b = import 'lib/b.libsonnet',\n  c: importstr 'c.txt',\n}")
+	assert.Equal(t, map[string][]string{"a": {"a.libsonnet"}, "b": {"lib/b.libsonnet"}}, ImportedNames(resolver.root))
+}
+
+func TestOrganizeImports(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Source string
+		Quote  byte
+		Result string
+	}{
+		{
+			Name:   "SortAndRemoveUnused",
+			Source: "// header\nlocal c = import 'c.libsonnet';\n\nlocal a = import \"a.libsonnet\";\nlocal unused = import 'u.libsonnet';\nlocal b = importstr 'b.txt';\n\n[a, b, c]",
+			Quote:  '\'',
+			Result: "// header\nlocal a = import 'a.libsonnet';\nlocal b = importstr 'b.txt';\nlocal c = import 'c.libsonnet';\n\n[a, b, c]",
+		},
+		{
+			Name:   "KeepQuotes",
+			Source: "local b = import \"b.libsonnet\";\nlocal a = import 'a.libsonnet';\n[a, b]",
+			Quote:  0,
+			Result: "local a = import 'a.libsonnet';\nlocal b = import \"b.libsonnet\";\n[a, b]",
+		},
+		{
+			Name:   "AllUnused",
+			Source: "local a = import 'a.libsonnet';\nlocal b = import 'b.libsonnet';\n{}",
+			Quote:  '\'',
+			Result: "{}",
+		},
+		{
+			Name:   "StopsAtComment",
+			Source: "local b = import 'b.libsonnet';\n// a is special\nlocal a = import 'a.libsonnet';\n[a, b]",
+			Quote:  '"',
+			Result: "local b = import \"b.libsonnet\";\n// a is special\nlocal a = import 'a.libsonnet';\n[a, b]",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, _ := newAnonMockResolver(t, tc.Source)
+			edit, ok := OrganizeImports(resolver.root, tc.Source, tc.Quote)
+			require.True(t, ok)
+			assert.Equal(t, tc.Result, applyTestEdits(tc.Source, []TextEdit{edit}))
+		})
+	}
+
+	source := "local a = import 'a.libsonnet';\nlocal b = import 'b.libsonnet';\n[a, b]"
+	resolver, _ := newAnonMockResolver(t, source)
+	_, ok := OrganizeImports(resolver.root, source, '\'')
+	assert.False(t, ok, "imports are already organized")
+}
@@ -0,0 +1,62 @@
+package analysis
+
+import "strings"
+
+// leadingSpace returns the indentation at the start of `s`.
+func leadingSpace(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+// LineIndent returns the indentation jsonnetfmt gives `line` (1-indexed), based on the
+// brackets that are still open at the start of the line. Lines are indented one `unit`
+// deeper than the line with the innermost open bracket, and a line that starts by closing
+// the bracket is level with it. A line continuing a definition that ends with `=` or `:`
+// is indented one more `unit`. Returns false if the line starts inside a string or comment.
+func LineIndent(contents string, line int, unit string) (string, bool) {
+	// indentation of the lines with an unclosed bracket
+	open := []string{}
+	cur, lineStart := 1, 0
+	var lastCode byte
+	i := 0
+	for i < len(contents) && cur < line {
+		kind, end := scanToken(contents, i)
+		if kind == codeToken {
+			switch contents[i] {
+			case '{', '[', '(':
+				open = append(open, leadingSpace(contents[lineStart:]))
+			case '}', ']', ')':
+				if len(open) > 0 {
+					open = open[:len(open)-1]
+				}
+			}
+			lastCode = contents[i]
+		}
+		if kind == stringToken {
+			lastCode = 0
+		}
+		if nl := strings.LastIndexByte(contents[i:end], '\n'); nl >= 0 {
+			cur += strings.Count(contents[i:end], "\n")
+			lineStart = i + nl + 1
+			// the token continues past the start of the line
+			if kind != spaceToken && cur >= line {
+				return "", false
+			}
+		}
+		i = end
+	}
+	if cur != line {
+		return "", false
+	}
+
+	indent := ""
+	if len(open) > 0 {
+		indent = open[len(open)-1] + unit
+	}
+	text := strings.TrimLeft(contents[i:], " \t")
+	if len(open) > 0 && text != "" && strings.ContainsRune("}])", rune(text[0])) {
+		indent = open[len(open)-1]
+	} else if lastCode == '=' || lastCode == ':' {
+		indent += unit
+	}
+	return indent, true
+}
@@ -0,0 +1,39 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type lineIndentCase struct {
+	Name   string
+	Source string
+	Line   int
+	Indent string
+	Ok     bool
+}
+
+var lineIndentCases = []lineIndentCase{
+	{Name: "TopLevel", Source: "local x = 1;\n    x", Line: 2, Indent: "", Ok: true},
+	{Name: "InObject", Source: "{\na: 1,\n}", Line: 2, Indent: "  ", Ok: true},
+	{Name: "ClosingBrace", Source: "{\n  a: {\n    b: 1,\n      },\n}", Line: 4, Indent: "  ", Ok: true},
+	{Name: "Nested", Source: "{\n  a: [\n1,\n  ],\n}", Line: 3, Indent: "    ", Ok: true},
+	{Name: "SameLineBrackets", Source: "{\n  a: [{\nb: 1,\n  }],\n}", Line: 3, Indent: "    ", Ok: true},
+	{Name: "Continuation", Source: "{\n  local x =\n1,\n}", Line: 3, Indent: "    ", Ok: true},
+	{Name: "BracketInString", Source: "{\n  a: '{',\nb: 1,\n}", Line: 3, Indent: "  ", Ok: true},
+	{Name: "BracketInComment", Source: "{\n  // {\nb: 1,\n}", Line: 3, Indent: "  ", Ok: true},
+	{Name: "InTextBlock", Source: "{\n  a: |||\n    text\n  |||,\n}", Line: 3, Ok: false},
+	{Name: "InBlockComment", Source: "/*\n comment\n*/\n1", Line: 2, Ok: false},
+	{Name: "PastEnd", Source: "1", Line: 3, Ok: false},
+}
+
+func TestLineIndent(t *testing.T) {
+	for _, tc := range lineIndentCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			indent, ok := LineIndent(tc.Source, tc.Line, "  ")
+			assert.Equal(t, tc.Ok, ok)
+			assert.Equal(t, tc.Indent, indent)
+		})
+	}
+}
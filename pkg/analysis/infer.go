@@ -0,0 +1,521 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// Shape is the inferred type of an expression, with the structure that is known about
+// it: the fields of an object, the elements of an array, or the signature of a function.
+type Shape struct {
+	Type ValueType
+	// Fields of an object in declaration order. Open objects may have more fields, f.ex
+	// fields with computed names or mixed in from unknown objects.
+	Fields []ShapeField
+	Open   bool
+	// Elem is the shape of every element of an array, nil if it is not known.
+	Elem *Shape
+	// Function is the signature of a function, and Return the shape it returns.
+	Function *Function
+	Return   *Shape
+	// Union are the shapes an expression can have, f.ex the branches of a conditional that
+	// have different types. The type of a union is any.
+	Union []*Shape
+}
+
+type ShapeField struct {
+	Name   string
+	Hidden bool
+	// Optional fields are only in some of the objects of the shape, f.ex in one branch of
+	// a conditional.
+	Optional bool
+	Shape    *Shape
+}
+
+// maxShapeDepth is how deep the shapes of fields and return values are inferred. Values
+// at this depth only have their type.
+const maxShapeDepth = 2
+
+// maxShapeFields limits the number of fields of an object, and elements of an array, that
+// are inferred. Objects of libraries can have hundreds of fields.
+const maxShapeFields = 30
+
+// maxInferSteps limits the work done inferring a single shape.
+const maxInferSteps = 2000
+
+// TypeCache keeps the shapes inferred for the nodes of a file. The shapes depend on the
+// files it imports, the cache must be dropped when they change.
+type TypeCache struct {
+	lock   sync.Mutex
+	shapes map[ast.Node]*Shape
+}
+
+func NewTypeCache() *TypeCache {
+	return &TypeCache{shapes: map[ast.Node]*Shape{}}
+}
+
+func (c *TypeCache) get(node ast.Node) *Shape {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.shapes[node]
+}
+
+func (c *TypeCache) put(node ast.Node, shape *Shape) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.shapes[node] = shape
+}
+
+// TypeCacheResolver is a resolver that keeps the inferred shapes between calls, f.ex for a
+// version of a file. It returns nil once the shapes it infers are incomplete, f.ex when
+// imports are no longer made.
+type TypeCacheResolver interface {
+	Resolver
+	TypeCache() *TypeCache
+}
+
+func typeCacheOf(resolver Resolver) *TypeCache {
+	if r, ok := resolver.(TypeCacheResolver); ok {
+		return r.TypeCache()
+	}
+	return nil
+}
+
+type inferrer struct {
+	resolver Resolver
+	cache    *TypeCache
+	steps    int
+}
+
+// InferShape infers the type of an expression, and the structure known about it. Shapes
+// are shared through the cache of the resolver, they must not be modified.
+func InferShape(node ast.Node, resolver Resolver) *Shape {
+	in := &inferrer{resolver: resolver, cache: typeCacheOf(resolver)}
+	return in.shape(node, 0)
+}
+
+func (in *inferrer) shape(node ast.Node, depth int) *Shape {
+	if node == nil {
+		return &Shape{Type: AnyType}
+	}
+	// only complete shapes are cached, deeper ones are cut short
+	if depth > 0 || in.cache == nil {
+		return in.infer(node, depth)
+	}
+	if res := in.cache.get(node); res != nil {
+		return res
+	}
+	res := in.infer(node, depth)
+	if in.steps <= maxInferSteps && typeCacheOf(in.resolver) != nil {
+		in.cache.put(node, res)
+	}
+	return res
+}
+
+func (in *inferrer) infer(node ast.Node, depth int) *Shape {
+	in.steps++
+	if in.steps > maxInferSteps {
+		typ, _ := simpleToValueType(node)
+		return &Shape{Type: typ}
+	}
+
+	switch node := node.(type) {
+	case *ast.LiteralNull:
+		return &Shape{Type: NullType}
+	case *ast.LiteralBoolean:
+		return &Shape{Type: BooleanType}
+	case *ast.LiteralNumber:
+		return &Shape{Type: NumberType}
+	case *ast.LiteralString, *ast.ImportStr:
+		return &Shape{Type: StringType}
+	case *ast.ImportBin:
+		return &Shape{Type: ArrayType, Elem: &Shape{Type: NumberType}}
+	case *ast.Error:
+		return &Shape{Type: AnyType}
+	case *ast.Local:
+		return in.shape(node.Body, depth)
+	case *ast.Conditional:
+		// a branch that fails has no value, f.ex `if x then y else error 'z'`
+		if _, ok := node.BranchFalse.(*ast.Error); ok {
+			return in.shape(node.BranchTrue, depth)
+		}
+		if _, ok := node.BranchTrue.(*ast.Error); ok {
+			return in.shape(node.BranchFalse, depth)
+		}
+		return unifyShapes(in.shape(node.BranchTrue, depth), in.shape(node.BranchFalse, depth))
+	case *ast.Unary:
+		if node.Op == ast.UopNot {
+			return &Shape{Type: BooleanType}
+		}
+		return &Shape{Type: NumberType}
+	case *ast.Array:
+		// the elements are at the same depth as the array, the array itself has no fields
+		res := &Shape{Type: ArrayType}
+		for i, elem := range node.Elements {
+			if i == maxShapeFields {
+				break
+			}
+			if s := in.shape(elem.Expr, depth); i == 0 {
+				res.Elem = s
+			} else {
+				res.Elem = unifyShapes(res.Elem, s)
+			}
+		}
+		return res
+	case *ast.Binary:
+		if s := in.binaryShape(node, depth); s != nil {
+			return s
+		}
+	case *ast.Apply:
+		if s := in.applyShape(node, depth); s != nil {
+			return s
+		}
+	}
+
+	val := NodeToValue(node, in.resolver)
+	// the expression refers to another one (f.ex a variable or a field), infer that one
+	if val.Object == nil && val.Function == nil && val.Node != nil && val.Node != node {
+		return in.shape(val.Node, depth)
+	}
+	if val.Type == AnyType {
+		if s := in.derivedShape(node, depth); s != nil {
+			return s
+		}
+	}
+	return in.valueShape(val, depth)
+}
+
+// derivedShape is the shape of a field or of the result of a call, from the inferred
+// shape of the object or function when their value is not known.
+func (in *inferrer) derivedShape(node ast.Node, depth int) *Shape {
+	switch node := node.(type) {
+	case *ast.Index:
+		if name, ok := node.Index.(*ast.LiteralString); ok {
+			return in.shape(node.Target, depth).field(name.Value)
+		}
+	case *ast.Apply:
+		if target := in.shape(node.Target, depth); target.Type == FunctionType {
+			return target.Return
+		}
+	}
+	return nil
+}
+
+// field returns the shape of a field of an object, or of the objects of a union. It is
+// nil if the field is not known.
+func (s *Shape) field(name string) *Shape {
+	var res *Shape
+	for _, m := range s.members() {
+		for _, fld := range m.Fields {
+			if fld.Name != name {
+				continue
+			}
+			if res == nil {
+				res = fld.Shape
+			} else {
+				res = unifyShapes(res, fld.Shape)
+			}
+		}
+	}
+	return res
+}
+
+// valueShape is the shape of a resolved value.
+func (in *inferrer) valueShape(val *Value, depth int) *Shape {
+	res := &Shape{Type: val.Type, Function: val.Function}
+	if val.Object != nil {
+		res.Type = ObjectType
+		res.Open = !val.Object.AllFieldsKnown
+		for i, fld := range val.Object.Fields {
+			if i == maxShapeFields {
+				res.Open = true
+				break
+			}
+			sf := ShapeField{Name: fld.Name, Hidden: fld.Hidden, Shape: &Shape{Type: fld.Type}}
+			if depth < maxShapeDepth {
+				sf.Shape = in.shape(fld.Node, depth+1)
+			}
+			res.Fields = append(res.Fields, sf)
+		}
+	}
+	if val.Function != nil {
+		res.Type = FunctionType
+		res.Return = &Shape{Type: val.Function.ReturnType}
+		if val.Function.Return != nil && depth < maxShapeDepth {
+			res.Return = in.shape(val.Function.Return, depth+1)
+		}
+	}
+	return res
+}
+
+// numberOps are the binary operators that only apply to numbers.
+var numberOps = map[ast.BinaryOp]bool{
+	ast.BopMult: true, ast.BopDiv: true, ast.BopMinus: true, ast.BopShiftL: true,
+	ast.BopShiftR: true, ast.BopBitwiseAnd: true, ast.BopBitwiseXor: true, ast.BopBitwiseOr: true,
+}
+
+func (in *inferrer) binaryShape(node *ast.Binary, depth int) *Shape {
+	if numberOps[node.Op] {
+		return &Shape{Type: NumberType}
+	}
+	if typ, ok := binopKnownTypes[node.Op]; ok {
+		return &Shape{Type: typ}
+	}
+	switch node.Op {
+	case ast.BopOr:
+		return &Shape{Type: BooleanType}
+	case ast.BopPlus:
+		lhs, rhs := in.shape(node.Left, depth), in.shape(node.Right, depth)
+		switch {
+		case lhs.Type == StringType || rhs.Type == StringType:
+			return &Shape{Type: StringType}
+		case lhs.Type == NumberType && rhs.Type == NumberType:
+			return &Shape{Type: NumberType}
+		case lhs.Type == ArrayType && rhs.Type == ArrayType:
+			res := &Shape{Type: ArrayType}
+			if lhs.Elem != nil && rhs.Elem != nil {
+				res.Elem = unifyShapes(lhs.Elem, rhs.Elem)
+			}
+			return res
+		case lhs.Type == ObjectType || rhs.Type == ObjectType:
+			// the fields of mixins are merged when resolving the value
+			res := in.valueShape(NodeToValue(node, in.resolver), depth)
+			if res.Type != ObjectType {
+				res = &Shape{Type: ObjectType, Open: true}
+			}
+			return res
+		}
+	}
+	return nil
+}
+
+// applyShape is the shape returned by calls of the stdlib, or nil for other calls.
+func (in *inferrer) applyShape(node *ast.Apply, depth int) *Shape {
+	idx, ok := node.Target.(*ast.Index)
+	if !ok {
+		return nil
+	}
+	std, _ := idx.Target.(*ast.Var)
+	name, _ := idx.Index.(*ast.LiteralString)
+	if std == nil || name == nil || (std.Id != "std" && std.Id != "$std") {
+		return nil
+	}
+	args := node.Arguments.Positional
+	switch name.Value {
+	case "flatMap":
+		// array comprehensions: the function returns the elements for each item
+		res := &Shape{Type: ArrayType}
+		if len(args) > 0 {
+			if fn, ok := args[0].Expr.(*ast.Function); ok {
+				if body := in.shape(fn.Body, depth); body.Type == ArrayType {
+					res.Elem = body.Elem
+				}
+			}
+		}
+		return res
+	case "mod":
+		// `%` formats strings, or is the modulo of numbers
+		if len(args) > 0 && in.shape(args[0].Expr, depth).Type == NumberType {
+			return &Shape{Type: NumberType}
+		}
+		// an unknown value modulo a number may be either
+		if len(args) > 1 && in.shape(args[0].Expr, depth).IsAny() && in.shape(args[1].Expr, depth).Type == NumberType {
+			return &Shape{Type: AnyType}
+		}
+		return &Shape{Type: StringType}
+	case "$objectFlatMerge":
+		return &Shape{Type: ObjectType, Open: true}
+	}
+	if fn := StdLibFunctions[name.Value]; fn != nil && fn.ReturnType != AnyType {
+		return &Shape{Type: fn.ReturnType}
+	}
+	return nil
+}
+
+// unifyShapes returns the shape that describes both `a` and `b`: the fields that are not
+// in both objects are optional, and values of different types are a union.
+func unifyShapes(a, b *Shape) *Shape {
+	if a == nil || b == nil {
+		return nil
+	}
+	if a.Type != b.Type || a.Union != nil || b.Union != nil {
+		return unionShapes(a, b)
+	}
+	switch a.Type {
+	case ObjectType:
+		res := &Shape{Type: ObjectType, Open: a.Open || b.Open}
+		inA := map[string]bool{}
+		for _, fa := range a.Fields {
+			inA[fa.Name] = true
+			fld := ShapeField{Name: fa.Name, Hidden: fa.Hidden, Optional: true, Shape: fa.Shape}
+			for _, fb := range b.Fields {
+				if fa.Name == fb.Name {
+					fld.Optional = fa.Optional || fb.Optional
+					fld.Shape = unifyShapes(fa.Shape, fb.Shape)
+					break
+				}
+			}
+			res.Fields = append(res.Fields, fld)
+		}
+		for _, fb := range b.Fields {
+			if !inA[fb.Name] {
+				fb.Optional = true
+				res.Fields = append(res.Fields, fb)
+			}
+		}
+		return res
+	case ArrayType:
+		return &Shape{Type: ArrayType, Elem: unifyShapes(a.Elem, b.Elem)}
+	case FunctionType:
+		// functions of different signatures are only known to be functions
+		if a.Function.String() != b.Function.String() {
+			return &Shape{Type: FunctionType}
+		}
+	}
+	return a
+}
+
+// unionShapes returns the union of `a` and `b`, members of the same type are unified. A
+// union with any value is any.
+func unionShapes(a, b *Shape) *Shape {
+	members := []*Shape{}
+	for _, s := range [][]*Shape{a.members(), b.members()} {
+		for _, m := range s {
+			if m.Type == AnyType {
+				return &Shape{Type: AnyType}
+			}
+			merged := false
+			for i := range members {
+				if members[i].Type == m.Type {
+					members[i] = unifyShapes(members[i], m)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				members = append(members, m)
+			}
+		}
+	}
+	if len(members) == 1 {
+		return members[0]
+	}
+	return &Shape{Type: AnyType, Union: members}
+}
+
+func (s *Shape) members() []*Shape {
+	if s.Union != nil {
+		return s.Union
+	}
+	return []*Shape{s}
+}
+
+// IsAny is true if nothing is known about the shape.
+func (s *Shape) IsAny() bool {
+	return s == nil || (s.Type == AnyType && s.Union == nil)
+}
+
+// HasField returns whether an object of the shape has a field. `known` is false if it is
+// not known, f.ex if the object is open. A union has the field if one of its members has.
+func (s *Shape) HasField(name string) (has, known bool) {
+	if s == nil {
+		return false, false
+	}
+	if s.Union != nil {
+		known = true
+		for _, m := range s.Union {
+			mhas, mknown := m.HasField(name)
+			has, known = has || mhas, known && mknown
+		}
+		return has, known || has
+	}
+	if s.Type != ObjectType {
+		return false, false
+	}
+	for _, fld := range s.Fields {
+		if fld.Name == name {
+			return true, true
+		}
+	}
+	return false, !s.Open
+}
+
+// String formats the shape like a jsonnet value, f.ex `{ name: string, ports: array[number] }`.
+func (s *Shape) String() string {
+	return s.format("")
+}
+
+// Inline formats the shape on a single line.
+func (s *Shape) Inline() string {
+	return s.format("  ")
+}
+
+// format formats the shape, objects are broken on multiple lines at the top level.
+func (s *Shape) format(indent string) string {
+	if s == nil {
+		return AnyType.String()
+	}
+	if s.Union != nil {
+		members := make([]string, len(s.Union))
+		for i, m := range s.Union {
+			members[i] = m.format(indent)
+		}
+		return strings.Join(members, " | ")
+	}
+	switch s.Type {
+	case ObjectType:
+		fields := make([]string, 0, len(s.Fields)+1)
+		for _, fld := range s.Fields {
+			sep := ": "
+			if fld.Hidden {
+				sep = ":: "
+			}
+			if fld.Optional {
+				sep = "?" + sep
+			}
+			fields = append(fields, SafeIdent(fld.Name)+sep+fld.Shape.format(indent+"  "))
+		}
+		if s.Open {
+			fields = append(fields, "...")
+		}
+		if len(fields) == 0 {
+			return "{}"
+		}
+		if indent == "" {
+			return "{\n  " + strings.Join(fields, ",\n  ") + ",\n}"
+		}
+		return "{ " + strings.Join(fields, ", ") + " }"
+	case ArrayType:
+		if s.Elem.IsAny() {
+			return "array"
+		}
+		return fmt.Sprintf("array[%s]", s.Elem.format(indent+"  "))
+	case FunctionType:
+		if s.Function == nil {
+			return "function"
+		}
+		params := make([]string, len(s.Function.Params))
+		for i := range s.Function.Params {
+			params[i] = s.Function.Params[i].String()
+		}
+		res := fmt.Sprintf("function(%s)", strings.Join(params, ", "))
+		ret := s.Return
+		if ret == nil {
+			ret = &Shape{Type: s.Function.ReturnType}
+		}
+		if !ret.IsAny() {
+			res += " -> " + ret.format(indent+"  ")
+		}
+		return res
+	}
+	return s.Type.String()
+}
@@ -0,0 +1,98 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInferShape(t *testing.T) {
+	for _, tc := range []struct {
+		Name   string
+		Source string
+		Expect string
+	}{
+		{"null", "null", "null"},
+		{"arithmetic", "local a = 1; a * 2 + 1", "number"},
+		{"string concatenation", "local a = 1; 'x' + a", "string"},
+		{"format", "'%d' % 1", "string"},
+		{"modulo", "5 % 2", "number"},
+		{"unknown modulo", "local f(x) = x % 2; f", "function(x)"},
+		{"comparison", "1 < 2 || false", "boolean"},
+		{"not", "!true", "boolean"},
+		{"stdlib", "std.length([])", "number"},
+		{"array of numbers", "[1, 2, 3]", "array[number]"},
+		{"mixed array", "[1, 'a']", "array[number | string]"},
+		{"array concatenation", "['a'] + ['b']", "array[string]"},
+		{"comprehension", "[{ n: x } for x in [1, 2]]", "array[{ n: any }]"},
+		{"conditional", "local c = true; if c then 'a' else 'b'", "string"},
+		{"object", "{ a: 1, b:: 'x', c: { d: [true] } }", "{\n  a: number,\n  b:: string,\n  c: { d: array[boolean] },\n}"},
+		{"mixin", "{ a: 1 } + { b: 'x' }", "{\n  a: number,\n  b: string,\n}"},
+		{"computed field", "local k = 'a'; { [k + 'b']: 1 }", "{\n  ab: number,\n}"},
+		{"open object", "local f(k) = { [k]: 1, a: 2 }; f('x')", "{\n  a: number,\n  ...,\n}"},
+		{"common fields", "[{ a: 1, b: 2 }, { a: 3 }]", "array[{ a: number, b?: number }]"},
+		{"function", "function(a, b=1) { sum: a + b }", "function(a, b=1) -> { sum: any }"},
+		{"call", "local f(x) = [x]; f(1)", "array"},
+		{"variable", "local o = { a: 'x' }; local p = o; p.a", "string"},
+		{"union", "local c = true; if c then 'a' else 1", "string | number"},
+		{"optional", "local c = true; if c then { a: 1 } else null", "{\n  a: number,\n} | null"},
+		{"optional fields", "local c = true; if c then { a: 1, b: 'x' } else { a: 2, c: true }", "{\n  a: number,\n  b?: string,\n  c?: boolean,\n}"},
+		{"error branch", "local c = true; if c then 'a' else error 'b'", "string"},
+		{"nested union", "local c = true; [if c then 1 else 'a', null, 2]", "array[number | string | null]"},
+		{"field of a conditional", "local c = true; local o = if c then { a: 1 } else { a: 2, b: 3 }; o.a", "number"},
+		{"call of a conditional", "local c = true; local f = if c then function(x) [x] else function(x) []; f(1)", "array"},
+		{"union with any", "local f(x) = if x then x else 1; f(true)", "any"},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, out := newAnonMockResolver(t, tc.Source)
+			assert.Equal(t, tc.Expect, InferShape(out, resolver).String())
+		})
+	}
+}
+
+type cachingMockResolver struct {
+	*mockResolver
+	cache *TypeCache
+}
+
+func (r *cachingMockResolver) TypeCache() *TypeCache {
+	return r.cache
+}
+
+func TestInferShapeCache(t *testing.T) {
+	mock, out := newAnonMockResolver(t, "local o = { a: 1 }; o")
+	resolver := &cachingMockResolver{mockResolver: mock, cache: NewTypeCache()}
+	shape := InferShape(out, resolver)
+	assert.Equal(t, "{\n  a: number,\n}", shape.String())
+	// the shape is inferred once for the version of the file
+	assert.Same(t, shape, InferShape(out, resolver))
+
+	// shapes are not cached once the resolver stops caching, f.ex when a request is canceled
+	resolver.cache = nil
+	assert.NotSame(t, shape, InferShape(out, resolver))
+}
+
+func TestShapeHasField(t *testing.T) {
+	for _, tc := range []struct {
+		Name   string
+		Source string
+		Field  string
+		Has    bool
+		Known  bool
+	}{
+		{"field", "{ a: 1 }", "a", true, true},
+		{"missing field", "{ a: 1 }", "b", false, true},
+		{"open object", "local f(k) = { [k]: 1 }; f('x')", "b", false, false},
+		{"optional field", "local c = true; if c then { a: 1 } else { b: 1 }", "b", true, true},
+		{"union", "local c = true; if c then { a: 1 } else 'x'", "a", true, true},
+		{"union without field", "local c = true; if c then { a: 1 } else null", "b", false, false},
+		{"not an object", "1", "a", false, false},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, out := newAnonMockResolver(t, tc.Source)
+			has, known := InferShape(out, resolver).HasField(tc.Field)
+			assert.Equal(t, tc.Has, has, "has")
+			assert.Equal(t, tc.Known, known, "known")
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package analysis
+
+import (
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// MovableDefinition is a top level local of a file that can be moved into the object
+// exported by another file.
+type MovableDefinition struct {
+	Name string
+	// Field is the definition as a field of an object, f.ex `f(a):: a + 1` for
+	// `local f(a) = a + 1`.
+	Field string
+	// Range is the bind, from its name to the end of its body.
+	Range ast.LocationRange
+	// Imports is true if the definition imports files, with paths that can be relative
+	// to the file it is defined in.
+	Imports bool
+}
+
+// indentContinuation indents every line of `text` after the first.
+func indentContinuation(text, indent string) string {
+	return strings.ReplaceAll(text, "\n", "\n"+indent)
+}
+
+// MovableDefinitionAt returns the top level local function or object named at `pos`. Returns
+// false if there is none, or if it uses other variables of the file, which would not be
+// in scope in another file.
+func MovableDefinitionAt(root ast.Node, pos ast.Location) (MovableDefinition, bool) {
+	node := root
+	for {
+		local, ok := node.(*ast.Local)
+		if !ok {
+			return MovableDefinition{}, false
+		}
+		for _, bind := range local.Binds {
+			decl := bindDecl(bind)
+			if !decl.Range.IsSet() || !LocInRange(decl.Range, pos) {
+				continue
+			}
+			return movableBind(bind, decl)
+		}
+		node = local.Body
+	}
+}
+
+func movableBind(bind ast.LocalBind, decl Decl) (MovableDefinition, bool) {
+	res := MovableDefinition{Name: decl.Name}
+	body := decl.Body
+	switch body.(type) {
+	case *ast.Function, *ast.DesugaredObject:
+	default:
+		return res, false
+	}
+
+	// binds with the function syntax have no range, the function starts at the name
+	res.Range = bind.LocRange
+	if !res.Range.IsSet() && body.Loc() != nil {
+		res.Range = *body.Loc()
+	}
+	text := rangeSource(res.Range)
+	if !strings.HasPrefix(text, decl.Name) {
+		return res, false
+	}
+
+	movable := true
+	walkStack(body, nil, func(n ast.Node, stk []ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Var:
+			if FindBinding(string(n.Id), stk) == nil && n.Id != "std" {
+				movable = false
+			}
+		case *ast.Import, *ast.ImportStr, *ast.ImportBin:
+			res.Imports = true
+		}
+		return movable
+	})
+	if !movable {
+		return res, false
+	}
+
+	fn, isFn := body.(*ast.Function)
+	if rest := strings.TrimLeft(text[len(decl.Name):], " \t\r\n"); isFn && strings.HasPrefix(rest, "(") {
+		// `f(a) = body` becomes `f(a):: body`
+		if fn.Body.Loc() == nil || !fn.Body.Loc().IsSet() {
+			return res, false
+		}
+		header := res.Range
+		header.End = fn.Body.Loc().Begin
+		signature := strings.TrimSuffix(strings.TrimSpace(rangeSource(header)), "=")
+		res.Field = strings.TrimSpace(signature) + ":: " + rangeSource(*fn.Body.Loc())
+	} else if isFn {
+		res.Field = decl.Name + ":: " + rangeSource(*body.Loc())
+	} else {
+		res.Field = decl.Name + ": " + rangeSource(*body.Loc())
+	}
+	res.Field = indentContinuation(res.Field, "  ")
+	return res, true
+}
+
+// exportedObject returns the object at the end of the top level locals of a file.
+func exportedObject(root ast.Node) (*ast.DesugaredObject, bool) {
+	node := root
+	for {
+		switch n := node.(type) {
+		case *ast.Local:
+			node = n.Body
+		case *ast.DesugaredObject:
+			return n, n.LocRange.IsSet()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// NewExportFile returns the contents of a new file that exports the definition.
+func NewExportFile(def MovableDefinition) string {
+	return "{\n  " + def.Field + ",\n}\n"
+}
+
+// AddExportField returns the edit that adds the definition as the last field of the
+// object exported by a file. Returns false if the file does not export an object, or if
+// the object already has a field with the name of the definition.
+func AddExportField(root ast.Node, def MovableDefinition) (TextEdit, bool) {
+	obj, ok := exportedObject(root)
+	if !ok {
+		return TextEdit{}, false
+	}
+	for _, fld := range obj.Fields {
+		if name, ok := fld.Name.(*ast.LiteralString); ok && name.Value == def.Name {
+			return TextEdit{}, false
+		}
+	}
+	file := obj.LocRange.File
+	text := strings.Join(file.Lines, "")
+	field := "\n  " + def.Field + ","
+
+	// the field is inserted after the comma of the last member of the object
+	last := ast.Location{}
+	members := []ast.LocationRange{}
+	for _, fld := range obj.Fields {
+		members = append(members, fld.LocRange)
+	}
+	for _, bind := range obj.Locals {
+		members = append(members, bindSpan(bind))
+	}
+	for _, assert := range obj.Asserts {
+		if assert.Loc() != nil {
+			members = append(members, *assert.Loc())
+		}
+	}
+	for _, m := range members {
+		if m.IsSet() && locBeforeEq(last, m.End) {
+			last = m.End
+		}
+	}
+	if !last.IsSet() {
+		return TextEdit{Range: obj.LocRange, NewText: "{" + field + "\n}"}, true
+	}
+	offset := sourceOffset(file, last)
+	rest := strings.TrimLeft(text[offset:], " \t\r\n")
+	if !strings.HasPrefix(rest, ",") {
+		return insertEdit(obj.LocRange, last, ","+field), true
+	}
+	offset = len(text) - len(rest) + 1
+	// a comment after the comma stays on the line of the last member
+	line := text[offset:]
+	if nl := strings.IndexByte(line, '\n'); nl >= 0 {
+		line = line[:nl]
+	}
+	if comment := strings.TrimSpace(line); comment == "" || strings.HasPrefix(comment, "//") || strings.HasPrefix(comment, "#") {
+		offset += len(strings.TrimRight(line, "\r"))
+	}
+	return insertEdit(obj.LocRange, sourceLoc(file, offset), field), true
+}
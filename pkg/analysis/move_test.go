@@ -0,0 +1,94 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMovableDefinitionAt(t *testing.T) {
+	source := `local lib = import 'lib.libsonnet';
+local add(a, b=1) =
+  local sum = a + b;
+  std.max(sum, 0);
+local defaults = { replicas: 1, name: $.replicas };
+local uses = lib.x;
+local scale = function(x) x * 2;
+local n = 1;
+add(n, 2)`
+	root, err := jsonnet.SnippetToAST("anon", source)
+	require.NoError(t, err)
+
+	def, ok := MovableDefinitionAt(root, ast.Location{Line: 2, Column: 8})
+	require.True(t, ok)
+	assert.Equal(t, "add", def.Name)
+	assert.Equal(t, "add(a, b=1):: local sum = a + b;\n    std.max(sum, 0)", def.Field)
+	assert.Equal(t, "add(a, b=1) =\n  local sum = a + b;\n  std.max(sum, 0)", rangeSource(def.Range))
+
+	def, ok = MovableDefinitionAt(root, ast.Location{Line: 5, Column: 7})
+	require.True(t, ok)
+	assert.Equal(t, "defaults: { replicas: 1, name: $.replicas }", def.Field)
+
+	def, ok = MovableDefinitionAt(root, ast.Location{Line: 7, Column: 7})
+	require.True(t, ok)
+	assert.Equal(t, "scale:: function(x) x * 2", def.Field)
+
+	// uses another local of the file
+	_, ok = MovableDefinitionAt(root, ast.Location{Line: 6, Column: 7})
+	assert.False(t, ok)
+	// not a function or an object
+	_, ok = MovableDefinitionAt(root, ast.Location{Line: 8, Column: 7})
+	assert.False(t, ok)
+	// not on the name of a local
+	_, ok = MovableDefinitionAt(root, ast.Location{Line: 9, Column: 2})
+	assert.False(t, ok)
+}
+
+func TestAddExportField(t *testing.T) {
+	def := MovableDefinition{Name: "f", Field: "f(a):: a"}
+	cases := []struct {
+		Name, Source, Result string
+	}{
+		{
+			Name:   "TrailingComma",
+			Source: "{\n  g: 1,\n}\n",
+			Result: "{\n  g: 1,\n  f(a):: a,\n}\n",
+		},
+		{
+			Name:   "NoTrailingComma",
+			Source: "local x = 1;\n{\n  g: x\n}\n",
+			Result: "local x = 1;\n{\n  g: x,\n  f(a):: a,\n}\n",
+		},
+		{
+			Name:   "Comment",
+			Source: "{\n  g: 1, // one\n  local h = 2,\n}\n",
+			Result: "{\n  g: 1, // one\n  local h = 2,\n  f(a):: a,\n}\n",
+		},
+		{
+			Name:   "Empty",
+			Source: "{}\n",
+			Result: "{\n  f(a):: a,\n}\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			root, err := jsonnet.SnippetToAST("anon", tc.Source)
+			require.NoError(t, err)
+			edit, ok := AddExportField(root, def)
+			require.True(t, ok)
+			assert.Equal(t, tc.Result, applyTestEdits(tc.Source, []TextEdit{edit}))
+		})
+	}
+
+	root, err := jsonnet.SnippetToAST("anon", "{ f: 1 }")
+	require.NoError(t, err)
+	_, ok := AddExportField(root, def)
+	assert.False(t, ok)
+	root, err = jsonnet.SnippetToAST("anon", "[]")
+	require.NoError(t, err)
+	_, ok = AddExportField(root, def)
+	assert.False(t, ok)
+}
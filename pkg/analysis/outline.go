@@ -0,0 +1,303 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// maxOutlineBlanks limits the number of bodies replaced by a placeholder in a file.
+const maxOutlineBlanks = 32
+
+// outlineSection is the body of a local bind or of an object field, by its byte offsets
+// [begin, end) in the source.
+type outlineSection struct {
+	begin, end int
+}
+
+func (s outlineSection) contains(o outlineSection) bool {
+	return s.begin <= o.begin && o.end <= s.end
+}
+
+// outlineFrame is a bracket of the source, or the whole source.
+type outlineFrame struct {
+	// the bracket that closes the frame, 0 for the whole source
+	closer byte
+	object bool
+	// the number of `local` whose `;` was not reached yet
+	locals int
+	// the beginning of the body being scanned, -1 if there is none, and the number of
+	// locals when it began: the body ends at a separator of the same local
+	open       int
+	openLocals int
+	// a `local` was seen, its `=` begins a body
+	wantBind bool
+}
+
+// outlineSections returns the bodies of the local binds and object fields of a source
+// that may not parse, by scanning its brackets, strings and comments. Bodies end at the
+// `,` or `;` that separates them from the next bind or field, or at the end of their
+// bracket. Unterminated strings and comments, and unclosed brackets, end at the end of
+// the source.
+func outlineSections(src string) []outlineSection {
+	res := []outlineSection{}
+	frames := []*outlineFrame{{open: -1}}
+	closeBody := func(f *outlineFrame, at int) {
+		if f.open >= 0 {
+			res = append(res, outlineSection{begin: f.open, end: at})
+			f.open = -1
+		}
+	}
+	skipTo := func(from int, end string) int {
+		if i := strings.Index(src[from:], end); i >= 0 {
+			return from + i + len(end)
+		}
+		return len(src)
+	}
+
+	for i := 0; i < len(src); {
+		f := frames[len(frames)-1]
+		c := src[i]
+		switch {
+		case c == '#' || strings.HasPrefix(src[i:], "//"):
+			i = skipTo(i, "\n")
+		case strings.HasPrefix(src[i:], "/*"):
+			i = skipTo(i+2, "*/")
+		case strings.HasPrefix(src[i:], "|||"):
+			// the text block ends at the next line starting with `|||`
+			i = skipTo(i+3, "\n")
+			for i < len(src) {
+				line := src[i:skipTo(i, "\n")]
+				if strings.HasPrefix(strings.TrimLeft(line, " \t"), "|||") {
+					i = strings.Index(src[i:], "|||") + i + 3
+					break
+				}
+				i += len(line)
+			}
+		case c == '@' && i+1 < len(src) && (src[i+1] == '\'' || src[i+1] == '"'):
+			// verbatim strings escape their quote by doubling it
+			q := src[i+1]
+			i += 2
+			for i < len(src) {
+				if src[i] == q && (i+1 >= len(src) || src[i+1] != q) {
+					break
+				}
+				if src[i] == q {
+					i++
+				}
+				i++
+			}
+			i++
+		case c == '\'' || c == '"':
+			for i++; i < len(src) && src[i] != c; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		case c == '{' || c == '(' || c == '[':
+			closer := "})]"[strings.IndexByte("{([", c)]
+			frames = append(frames, &outlineFrame{closer: closer, object: c == '{', open: -1})
+			i++
+		case c == '}' || c == ')' || c == ']':
+			// the brackets left unclosed in the frame are closed with it, a bracket that
+			// closes no frame is ignored
+			for j := len(frames) - 1; j > 0; j-- {
+				if frames[j].closer != c {
+					continue
+				}
+				for _, inner := range frames[j:] {
+					closeBody(inner, i)
+				}
+				frames = frames[:j]
+				break
+			}
+			i++
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+			j := i + 1
+			for j < len(src) && isIdentByte(src[j]) {
+				j++
+			}
+			if src[i:j] == "local" {
+				f.locals++
+				f.wantBind = f.open < 0
+			}
+			i = j
+		case c == '=':
+			if strings.HasPrefix(src[i:], "==") {
+				i += 2
+				continue
+			}
+			if i > 0 && strings.IndexByte("!<>", src[i-1]) >= 0 {
+				i++
+				continue
+			}
+			i++
+			if f.wantBind && f.open < 0 {
+				f.open, f.openLocals, f.wantBind = i, f.locals, false
+			}
+		case c == ':':
+			for i < len(src) && src[i] == ':' {
+				i++
+			}
+			if f.object && f.locals == 0 && f.open < 0 {
+				f.open, f.openLocals = i, 0
+			}
+		case c == ',':
+			if f.open >= 0 && f.locals == f.openLocals {
+				closeBody(f, i)
+				if f.object {
+					// the locals of objects end at their comma
+					f.locals = 0
+				} else {
+					// the next bind of the same local
+					f.wantBind = f.locals > 0
+				}
+			}
+			i++
+		case c == ';':
+			if f.locals > 0 {
+				f.locals--
+			}
+			if f.open >= 0 && f.locals < f.openLocals {
+				closeBody(f, i)
+			}
+			f.wantBind = false
+			i++
+		default:
+			i++
+		}
+	}
+	for _, f := range frames {
+		closeBody(f, len(src))
+	}
+	return res
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// sourceOffsets converts between byte offsets and locations of a source.
+type sourceOffsets []int
+
+func newSourceOffsets(src string) sourceOffsets {
+	res := sourceOffsets{0}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			res = append(res, i+1)
+		}
+	}
+	return res
+}
+
+func (so sourceOffsets) offset(loc ast.Location) int {
+	if loc.Line < 1 {
+		return 0
+	}
+	if loc.Line > len(so) {
+		loc.Line = len(so)
+	}
+	return so[loc.Line-1] + loc.Column - 1
+}
+
+func (so sourceOffsets) location(offset int) ast.Location {
+	line := sort.Search(len(so), func(i int) bool { return so[i] > offset })
+	return ast.Location{Line: line, Column: offset - so[line-1] + 1}
+}
+
+// blankRepairs returns the repairs that replace the sections by a placeholder expression.
+// They are made from the end of the source, so the locations of each section are those
+// of the original source.
+func blankRepairs(lines []string, so sourceOffsets, sections []outlineSection) repairs {
+	sorted := append([]outlineSection{}, sections...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].begin > sorted[j].begin })
+	res := repairs{}
+	for _, s := range sorted {
+		begin, end := so.location(s.begin), so.location(s.end)
+		if begin.Line == end.Line {
+			res = append(res, repair{line: begin.Line, begin: begin.Column, end: end.Column, text: placeholderExpr})
+			continue
+		}
+		if end.Column > 1 {
+			res = append(res, repair{line: end.Line, begin: 1, end: end.Column})
+		}
+		for line := end.Line - 1; line > begin.Line; line-- {
+			res = append(res, repair{line: line, begin: 1, end: len(strings.TrimSuffix(lines[line-1], "\n")) + 1})
+		}
+		res = append(res, repair{line: begin.Line, begin: begin.Column, end: len(strings.TrimSuffix(lines[begin.Line-1], "\n")) + 1, text: placeholderExpr})
+	}
+	return res
+}
+
+// outlineAST is the fallback of RecoverAST for the sources whose errors cannot be repaired
+// token by token, f.ex when half of a file is typed. The source is scanned for the bodies
+// of its locals and fields, and the bodies with a syntax error are replaced by a
+// placeholder expression until the rest parses, so the scopes and object fields of the
+// file are still known. The errors outside of the bodies, f.ex unclosed brackets, are
+// then repaired. Returns nil if the source still does not parse.
+func outlineAST(filename, contents string, err error) ast.Node {
+	sections := outlineSections(contents)
+	so := newSourceOffsets(contents)
+	lines := strings.SplitAfter(contents, "\n")
+	var blanked []outlineSection
+	var done repairs
+	src := contents
+	for {
+		at, ok := errorLoc(err)
+		if !ok {
+			return nil
+		}
+		sec, ok := innermostSection(sections, blanked, so.offset(done.unapply(at)))
+		if !ok || len(blanked) >= maxOutlineBlanks {
+			root := repairAST(filename, src, err, ast.Location{})
+			if root != nil {
+				remapLocations(root, ast.BuildSource(ast.DiagnosticFileName(filename), contents), done.unapply)
+			}
+			return root
+		}
+		// the sections in the blanked one are replaced with it
+		kept := blanked[:0]
+		for _, b := range blanked {
+			if !sec.contains(b) {
+				kept = append(kept, b)
+			}
+		}
+		blanked = append(kept, sec)
+
+		done = blankRepairs(lines, so, blanked)
+		repaired := lines
+		for _, r := range done {
+			repaired = r.apply(repaired)
+		}
+		src = strings.Join(repaired, "")
+		root, parseErr := jsonnet.SnippetToAST(filename, src)
+		if parseErr == nil {
+			remapLocations(root, ast.BuildSource(ast.DiagnosticFileName(filename), contents), done.unapply)
+			return root
+		}
+		err = parseErr
+	}
+}
+
+// innermostSection returns the smallest section around the offset that is not blanked
+// yet.
+func innermostSection(sections, blanked []outlineSection, offset int) (outlineSection, bool) {
+	var res outlineSection
+	found := false
+	for _, s := range sections {
+		if offset < s.begin || s.end < offset || (found && s.end-s.begin >= res.end-res.begin) {
+			continue
+		}
+		covered := false
+		for _, b := range blanked {
+			covered = covered || b.contains(s)
+		}
+		if !covered {
+			res, found = s, true
+		}
+	}
+	return res, found
+}
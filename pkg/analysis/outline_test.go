@@ -0,0 +1,76 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutlineSections(t *testing.T) {
+	src := "local a = 1 + , b = f(x, y);\n" +
+		"{\n" +
+		"  local c = [1, 2],\n" +
+		"  d: local e = 'x, y'; e,\n" +
+		"  f:: a == b, // g: 1,\n" +
+		"  h: |||\n    i: j, k\n  |||,\n" +
+		"  l: { m: 1 2 },\n" +
+		"  n: o(\n" +
+		"}"
+	res := []string{}
+	for _, s := range outlineSections(src) {
+		res = append(res, src[s.begin:s.end])
+	}
+	assert.Equal(t, []string{
+		" 1 + ",
+		" f(x, y)",
+		" [1, 2]",
+		" local e = 'x, y'; e",
+		" a == b",
+		" |||\n    i: j, k\n  |||",
+		" 1 2 ",
+		" { m: 1 2 }",
+		" o(\n",
+	}, res)
+}
+
+func TestOutlineAST(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Source string
+		// the source of the innermost node at `At` in the recovered AST
+		At   ast.Location
+		Node string
+	}{
+		{
+			"StrayBrackets",
+			"local a = 1 + * 2 ) ];\nlocal b = { x: 1 };\n{\n  c: b.x,\n  d: [1 2 3 4 5 6 7 8 9 10 11],\n  e: a,\n}",
+			ast.Location{Line: 4, Column: 6}, "b",
+		},
+		{
+			"HalfTypedFields",
+			"local lib = { f(x):: x };\n{\n  a: lib.f(1 2 3 4 5 6 7 8 9 10),\n  b: if then else if then else,\n  c: lib.\n}",
+			ast.Location{Line: 3, Column: 6}, " lib.f(1 2 3 4 5 6 7 8 9 10)",
+		},
+		{
+			"TextBlock",
+			"{\n  a: 1 2 3 4 5 6 7 8 9 10 11,\n  b: |||\n    text\n  |||,\n  c: 'x' 'y' 'z' 'w' 'a' 'b' 'c' 'd' 'e' 'f',\n  d: self.b,\n}",
+			ast.Location{Line: 7, Column: 6}, "self",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := jsonnet.SnippetToAST("anon", tc.Source)
+			require.Error(t, err)
+			// too many errors for the repairs alone
+			require.Nil(t, repairAST("anon", tc.Source, err, ast.Location{}))
+			root := RecoverAST("anon", tc.Source, err, ast.Location{})
+			require.NotNil(t, root)
+			stack := StackAtLoc(root, tc.At)
+			require.NotEmpty(t, stack)
+			assert.Equal(t, tc.Node, rangeSource(*stack[len(stack)-1].Loc()))
+		})
+	}
+}
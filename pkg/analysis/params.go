@@ -0,0 +1,170 @@
+package analysis
+
+import (
+	"github.com/google/go-jsonnet/ast"
+)
+
+// typeChecks are the stdlib functions that check the type of a value.
+var typeChecks = map[string]ValueType{
+	"isString": StringType, "isNumber": NumberType, "isBoolean": BooleanType,
+	"isObject": ObjectType, "isArray": ArrayType, "isFunction": FunctionType,
+	"type": AnyType,
+}
+
+// impreciseParams are the stdlib functions with parameters that accept more types than
+// their signature, f.ex `%` is desugared to `std.mod` and formats strings.
+var impreciseParams = map[string]bool{"mod": true, "join": true}
+
+// StdCallName returns the name of the stdlib function called, or "" if the call is not
+// of the stdlib.
+func StdCallName(apply *ast.Apply) string {
+	idx, ok := apply.Target.(*ast.Index)
+	if !ok {
+		return ""
+	}
+	std, _ := idx.Target.(*ast.Var)
+	name, _ := idx.Index.(*ast.LiteralString)
+	if std == nil || name == nil || (std.Id != "std" && std.Id != "$std") {
+		return ""
+	}
+	return name.Value
+}
+
+// paramTypes infers the types of the parameters of a function from how its body uses
+// them, f.ex `p * 2` needs a number, `p.name` an object, and `assert std.isString(p)` a
+// string. Parameters are left out if their uses do not agree, or if the body checks
+// their type (or compares them to null) to accept several types.
+func paramTypes(fn *ast.Function) map[string]ValueType {
+	params := map[string]bool{}
+	for _, p := range fn.Parameters {
+		params[string(p.Name)] = true
+	}
+	uses := map[string]ValueType{}
+	asserted := map[string]ValueType{}
+	poly := map[string]bool{}
+
+	walkStack(fn, nil, func(n ast.Node, stk []ast.Node) bool {
+		v, ok := n.(*ast.Var)
+		if !ok || !params[string(v.Id)] || len(stk) < 2 {
+			return true
+		}
+		name := string(v.Id)
+		if b := FindBinding(name, stk); b == nil || b.Def != fn {
+			return true
+		}
+		if typ, ok := typeCheck(stk); ok {
+			if typ, ok := assertedType(typ, stk); ok {
+				asserted[name] = typ
+			} else {
+				poly[name] = true
+			}
+			return true
+		}
+		typ, ok := useType(v, stk[len(stk)-2])
+		if !ok {
+			return true
+		}
+		if typ == NullType {
+			poly[name] = true
+		} else if prev, ok := uses[name]; ok && prev != typ {
+			poly[name] = true
+		}
+		uses[name] = typ
+		return true
+	})
+
+	res := map[string]ValueType{}
+	for name := range params {
+		if typ, ok := asserted[name]; ok {
+			res[name] = typ
+		} else if typ, ok := uses[name]; ok && !poly[name] {
+			res[name] = typ
+		}
+	}
+	return res
+}
+
+// typeCheck returns the type checked by a call like `std.isString(p)` of the variable at
+// the end of the stack.
+func typeCheck(stk []ast.Node) (ValueType, bool) {
+	apply, ok := stk[len(stk)-2].(*ast.Apply)
+	if !ok {
+		return AnyType, false
+	}
+	typ, ok := typeChecks[StdCallName(apply)]
+	return typ, ok
+}
+
+// assertedType returns the type of a check if the code fails when the check is false:
+// `assert std.isString(p)`, or `if !std.isString(p) then error ...`.
+func assertedType(typ ValueType, stk []ast.Node) (ValueType, bool) {
+	if typ == AnyType || len(stk) < 3 {
+		return AnyType, false
+	}
+	check := stk[len(stk)-2]
+	if cond, ok := stk[len(stk)-3].(*ast.Conditional); ok && cond.Cond == check {
+		_, fails := cond.BranchFalse.(*ast.Error)
+		return typ, fails
+	}
+	if not, ok := stk[len(stk)-3].(*ast.Unary); ok && not.Op == ast.UopNot && len(stk) >= 4 {
+		if cond, ok := stk[len(stk)-4].(*ast.Conditional); ok && cond.Cond == not {
+			_, fails := cond.BranchTrue.(*ast.Error)
+			return typ, fails
+		}
+	}
+	return AnyType, false
+}
+
+// useType returns the type a variable must have to be used by its parent node. It is
+// null for comparisons with null.
+func useType(v *ast.Var, parent ast.Node) (ValueType, bool) {
+	switch p := parent.(type) {
+	case *ast.Binary:
+		if numberOps[p.Op] {
+			return NumberType, true
+		}
+		switch p.Op {
+		case ast.BopAnd, ast.BopOr:
+			return BooleanType, true
+		case ast.BopManifestEqual, ast.BopManifestUnequal:
+			_, lnull := p.Left.(*ast.LiteralNull)
+			_, rnull := p.Right.(*ast.LiteralNull)
+			if lnull || rnull {
+				return NullType, true
+			}
+		}
+	case *ast.Unary:
+		if p.Op == ast.UopNot {
+			return BooleanType, true
+		}
+		return NumberType, true
+	case *ast.Conditional:
+		if p.Cond == v {
+			return BooleanType, true
+		}
+	case *ast.Index:
+		if _, ok := p.Index.(*ast.LiteralString); ok && p.Target == v {
+			return ObjectType, true
+		}
+	case *ast.Apply:
+		if p.Target == v {
+			return FunctionType, true
+		}
+		name := StdCallName(p)
+		fn := StdLibFunctions[name]
+		if fn == nil || impreciseParams[name] {
+			return AnyType, false
+		}
+		for i, arg := range p.Arguments.Positional {
+			if arg.Expr != v || i >= len(fn.Params) {
+				continue
+			}
+			// arrays are not used, the functions that take arrays often take strings too
+			switch typ := fn.Params[i].Type; typ {
+			case StringType, NumberType, BooleanType, ObjectType, FunctionType:
+				return typ, true
+			}
+		}
+	}
+	return AnyType, false
+}
@@ -0,0 +1,37 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParamTypes(t *testing.T) {
+	for _, tc := range []struct {
+		Name   string
+		Source string
+		Expect string
+	}{
+		{"arithmetic", "function(a, b) a * 2 - b", "(a: number, b: number)"},
+		{"object", "function(o) o.name", "(o: object)"},
+		{"called", "function(f, x) f(x)", "(f: function, x)"},
+		{"condition", "function(c) if c then 1 else 2", "(c: boolean)"},
+		{"stdlib", "function(s, o) std.startsWith(s, 'x') && std.objectHas(o, 'y')", "(s: string, o: object) -> boolean"},
+		{"in", "function(k, o) k in o", "(k: string, o: object)"},
+		{"assert", "function(s) assert std.isString(s) : 'must be a string'; s + 1", "(s: string)"},
+		{"negated check", "function(s) if !std.isArray(s) then error 'array' else s", "(s: array)"},
+		{"type check", "function(s) if std.isString(s) then s else s.name", "(s)"},
+		{"null check", "function(o=null) if o == null then {} else o.name", "(o=null)"},
+		{"conflicting uses", "function(x) [x.a, x * 2]", "(x) -> array"},
+		{"format", "function(f) f % [1]", "(f) -> string"},
+		{"default", "function(port=80) port * 2", "(port: number=80)"},
+		{"type hint", "function(a/*:string*/) a.b", "(a: string)"},
+		{"shadowed", "function(a) local a = { b: 1 }; a.b", "(a)"},
+		{"nested function", "function(a) function(b) a * b", "(a: number) -> function"},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, out := newAnonMockResolver(t, tc.Source)
+			assert.Equal(t, tc.Expect, NodeToValue(out, resolver).Function.String())
+		})
+	}
+}
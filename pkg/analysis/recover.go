@@ -0,0 +1,312 @@
+package analysis
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// maxRepairs limits the number of syntax errors repaired in a file.
+const maxRepairs = 8
+
+// placeholderExpr is inserted where an expression is missing, it is an error node in the
+// recovered AST.
+const placeholderExpr = "error 'syntax error'"
+
+// placeholderIdent is inserted where an identifier is missing, f.ex after `obj.`.
+const placeholderIdent = "__error__"
+
+// expectedToken is the token a parse error expected, f.ex `")"` or `IDENTIFIER`.
+var expectedToken = regexp.MustCompile(`Expected token (\S+) but got`)
+
+// repair replaces the columns [begin, end) of a line of the source. Repairs never add or
+// remove lines, so only the columns of the line after them move.
+type repair struct {
+	line, begin, end int
+	text             string
+	// cost is higher for the repairs that are less likely what the user meant, f.ex
+	// removing a token
+	cost int
+}
+
+func (r repair) apply(lines []string) []string {
+	res := append([]string{}, lines...)
+	line := res[r.line-1]
+	res[r.line-1] = line[:r.begin-1] + r.text + line[r.end-1:]
+	return res
+}
+
+// unapply maps a location in the repaired source back to the source before the repair.
+// Locations in the repaired text are moved to its end, or to its beginning if it only
+// inserted text.
+func (r repair) unapply(loc ast.Location) ast.Location {
+	if loc.Line != r.line || loc.Column <= r.begin {
+		return loc
+	}
+	if end := r.begin + len(r.text); loc.Column < end {
+		loc.Column = r.end
+	} else {
+		loc.Column -= len(r.text) - (r.end - r.begin)
+	}
+	return loc
+}
+
+// repairs are the repairs of a source, in the order they were made.
+type repairs []repair
+
+func (rs repairs) cost() int {
+	res := 0
+	for _, r := range rs {
+		res += r.cost
+	}
+	return res
+}
+
+func (rs repairs) unapply(loc ast.Location) ast.Location {
+	for i := len(rs) - 1; i >= 0; i-- {
+		loc = rs[i].unapply(loc)
+	}
+	return loc
+}
+
+// repairCandidates returns the repairs that may fix the parse error `err` of a source,
+// the most likely first.
+func repairCandidates(lines []string, err error) []repair {
+	locErr, ok := err.(interface{ Loc() ast.LocationRange })
+	if !ok {
+		return nil
+	}
+	rng := locErr.Loc()
+	begin, end := rng.Begin, rng.End
+	if !begin.IsSet() || begin.Line > len(lines) {
+		return nil
+	}
+	line := lines[begin.Line-1]
+	content := strings.TrimRight(line, "\r\n")
+	if begin.Column < 1 || begin.Column > len(content)+1 {
+		return nil
+	}
+	if end.Line != begin.Line || end.Column < begin.Column || end.Column > len(content)+1 {
+		end = begin
+	}
+	insert := func(text string) repair {
+		return repair{line: begin.Line, begin: begin.Column, end: begin.Column, text: text, cost: 1}
+	}
+	replace := func(text string) repair {
+		return repair{line: begin.Line, begin: begin.Column, end: end.Column, text: text, cost: 2}
+	}
+	token := content[begin.Column-1 : end.Column-1]
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "Unterminated String"):
+		// close the string at the end of its line
+		quote := content[begin.Column-1:]
+		quote = strings.TrimPrefix(quote, "@")
+		if quote == "" || (quote[0] != '\'' && quote[0] != '"') {
+			return nil
+		}
+		at := len(content) + 1
+		return []repair{{line: begin.Line, begin: at, end: at, text: quote[:1], cost: 1}}
+	case strings.Contains(msg, "Duplicate field"):
+		// computed field names are not checked for duplicates
+		if SafeIdent(token) == token {
+			return []repair{replace(`["` + token + `"]`)}
+		}
+		return []repair{replace("[" + token + "]")}
+	case strings.Contains(msg, "Unknown variable"):
+		return []repair{replace("(" + placeholderExpr + ")")}
+	}
+
+	res := []repair{}
+	if m := expectedToken.FindStringSubmatch(msg); m != nil {
+		switch expected := strings.Trim(m[1], `"`); expected {
+		case "IDENTIFIER":
+			res = append(res, insert(" "+placeholderIdent+" "))
+		case "OPERATOR":
+			res = append(res, insert(":"))
+		default:
+			res = append(res, insert(" "+expected+" "))
+		}
+	}
+	res = append(res,
+		insert(";"),
+		insert(","),
+		insert(" "+placeholderExpr+" "),
+		insert(")"),
+		insert("]"),
+		insert("}"),
+		insert(" "+placeholderIdent+" "),
+	)
+	if end != begin {
+		// a stray token
+		blank := replace(strings.Repeat(" ", len(token)))
+		blank.cost = 3
+		res = append(res, replace("("+placeholderExpr+")"), blank)
+	}
+	return res
+}
+
+// errorLoc returns the location of a parse error, and false if it has none.
+func errorLoc(err error) (ast.Location, bool) {
+	locErr, ok := err.(interface{ Loc() ast.LocationRange })
+	if !ok {
+		return ast.Location{}, false
+	}
+	at := locErr.Loc().Begin
+	return at, at.IsSet()
+}
+
+// maxRecoverParses limits the number of times a source is parsed to recover its AST, and
+// maxRecoverBytes the size of the sources parsed, so large files are parsed fewer times.
+const (
+	maxRecoverParses = 64
+	maxRecoverBytes  = 1 << 20
+)
+
+// recovery is a source with some of its syntax errors repaired.
+type recovery struct {
+	lines []string
+	done  repairs
+	err   error
+	// the location of the error in the original source
+	at ast.Location
+}
+
+// RecoverAST returns a best-effort AST of a source that does not parse, so completion,
+// hover, and navigation keep working while the user is typing. The syntax errors are
+// repaired by inserting the tokens the parser expected (f.ex the `;` after `local x =
+// std`), closing brackets and strings, or inserting placeholder expressions (`error
+// 'syntax error'`) and identifiers where they are missing. The repairs that let the
+// parser get the furthest are tried first. `edit` is where the user last typed, if it is
+// known, which is where a missing `;` or `,` most likely belongs. When the errors cannot
+// be repaired, the bodies of the locals and fields that do not parse are replaced by a
+// placeholder, see outlineAST. The locations of the AST are those of the original
+// source. Returns nil if the source cannot be recovered.
+//
+// The fallback scans the outline of the source instead of parsing it with
+// tree-sitter-jsonnet: tree-sitter needs cgo, which the releases are built without
+// (CGO_ENABLED=0), and its concrete tree would need its own desugaring to match the AST
+// the rest of the analysis uses. The parts of the outline that parse go through the
+// parser of go-jsonnet like the rest of the file.
+func RecoverAST(filename, contents string, err error, edit ast.Location) ast.Node {
+	if root := repairAST(filename, contents, err, edit); root != nil {
+		return root
+	}
+	return outlineAST(filename, contents, err)
+}
+
+// repairAST repairs the syntax errors of a source token by token, see RecoverAST.
+func repairAST(filename, contents string, err error, edit ast.Location) ast.Node {
+	at, ok := errorLoc(err)
+	if !ok {
+		return nil
+	}
+	queue := []recovery{{lines: strings.SplitAfter(contents, "\n"), err: err, at: at}}
+	// the errors that were already reached, by their location in the original source
+	seen := map[ast.Location]map[string]bool{at: {err.Error(): true}}
+	budget := maxRecoverBytes / (len(contents) + 1)
+	if budget > maxRecoverParses {
+		budget = maxRecoverParses
+	}
+	for parses := 0; len(queue) > 0 && parses < budget; {
+		// the cheapest recovery, with the error furthest in the source
+		next := 0
+		for i, r := range queue {
+			best := queue[next]
+			if r.done.cost() < best.done.cost() || (r.done.cost() == best.done.cost() && ast.LocationBefore(best.at, r.at)) {
+				next = i
+			}
+		}
+		cur := queue[next]
+		queue = append(queue[:next], queue[next+1:]...)
+		if len(cur.done) >= maxRepairs {
+			continue
+		}
+
+		candidates := repairCandidates(cur.lines, cur.err)
+		if len(cur.done) == 0 && edit.IsSet() && edit.Line <= len(cur.lines) && edit.Column <= len(cur.lines[edit.Line-1]) {
+			candidates = append([]repair{
+				{line: edit.Line, begin: edit.Column, end: edit.Column, text: ";", cost: 1},
+				{line: edit.Line, begin: edit.Column, end: edit.Column, text: ",", cost: 1},
+			}, candidates...)
+		}
+		for _, r := range candidates {
+			if parses >= budget {
+				break
+			}
+			parses++
+			lines := r.apply(cur.lines)
+			done := append(cur.done[:len(cur.done):len(cur.done)], r)
+			root, err := jsonnet.SnippetToAST(filename, strings.Join(lines, ""))
+			if err == nil {
+				remapLocations(root, ast.BuildSource(ast.DiagnosticFileName(filename), contents), done.unapply)
+				return root
+			}
+			at, ok := errorLoc(err)
+			if !ok {
+				continue
+			}
+			// the repair must get the parser further, or at least to another error
+			at = done.unapply(at)
+			if ast.LocationBefore(at, cur.at) || seen[at][err.Error()] {
+				continue
+			}
+			if seen[at] == nil {
+				seen[at] = map[string]bool{}
+			}
+			seen[at][err.Error()] = true
+			queue = append(queue, recovery{lines: lines, done: done, err: err, at: at})
+		}
+	}
+	return nil
+}
+
+var (
+	locationRangeType = reflect.TypeOf(ast.LocationRange{})
+	sourceType        = reflect.TypeOf(&ast.Source{})
+)
+
+// remapLocations maps every location range of an AST with `mapLoc`, and sets their source
+// to `src`.
+func remapLocations(root ast.Node, src *ast.Source, mapLoc func(ast.Location) ast.Location) {
+	seen := map[uintptr]bool{}
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Ptr:
+			if v.IsNil() || v.Type() == sourceType || seen[v.Pointer()] {
+				return
+			}
+			seen[v.Pointer()] = true
+			walk(v.Elem())
+		case reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Struct:
+			if v.Type() == locationRangeType {
+				rng := v.Interface().(ast.LocationRange)
+				if rng.IsSet() && v.CanSet() {
+					rng.Begin, rng.End = mapLoc(rng.Begin), mapLoc(rng.End)
+					rng.File = src
+					v.Set(reflect.ValueOf(rng))
+				}
+				return
+			}
+			for i := 0; i < v.NumField(); i++ {
+				if v.Type().Field(i).IsExported() {
+					walk(v.Field(i))
+				}
+			}
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(root))
+}
@@ -0,0 +1,55 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverAST(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Source string
+		// the source of the innermost node at `At` in the recovered AST
+		At   ast.Location
+		Node string
+	}{
+		{"MissingSemicolon", "local a = 1\nlocal b = a;\nb", ast.Location{Line: 2, Column: 11}, "a"},
+		{"MissingField", "local a = { x: 1 };\n{ y: a.x, z: a. }", ast.Location{Line: 2, Column: 7}, "a"},
+		{"UnclosedArray", "local a = [1, 2\nlocal b = 3;\n{ c: a, d: b }", ast.Location{Line: 3, Column: 12}, "b"},
+		{"UnclosedCall", "local f(x) = x;\nf(1", ast.Location{Line: 2, Column: 3}, "1"},
+		{"UnterminatedString", "local s = 'abc\nlocal t = 1;\nt", ast.Location{Line: 3, Column: 1}, "t"},
+		{"MissingValue", "{ a: 1, b: , c: 'x' }", ast.Location{Line: 1, Column: 17}, "'x'"},
+		{"MissingComma", "{\n  a: 1\n  b: [a],\n}", ast.Location{Line: 3, Column: 7}, "[a]"},
+		{"UnknownVariable", "{ a: foo, b: 1 + 2 }", ast.Location{Line: 1, Column: 14}, "1"},
+		{"DuplicateField", "{ a: 1, a: 2 }", ast.Location{Line: 1, Column: 12}, "2"},
+		{"EndOfFile", "local o = { a: { b: 1 } };\n{ c: o.a.", ast.Location{Line: 2, Column: 8}, "o.a"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := jsonnet.SnippetToAST("anon", tc.Source)
+			require.Error(t, err)
+			root := RecoverAST("anon", tc.Source, err, ast.Location{})
+			require.NotNil(t, root)
+			stack := StackAtLoc(root, tc.At)
+			require.NotEmpty(t, stack)
+			assert.Equal(t, tc.Node, rangeSource(*stack[len(stack)-1].Loc()))
+		})
+	}
+}
+
+func TestRecoverASTEdit(t *testing.T) {
+	// without the edit, the object is applied to `std`
+	source := "local x = std\n{ a: x }"
+	_, err := jsonnet.SnippetToAST("anon", source)
+	require.Error(t, err)
+	root := RecoverAST("anon", source, err, ast.Location{Line: 1, Column: 14})
+	require.NotNil(t, root)
+	local, ok := root.(*ast.Local)
+	require.True(t, ok)
+	_, ok = local.Body.(*ast.DesugaredObject)
+	assert.True(t, ok)
+}
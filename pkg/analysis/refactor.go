@@ -0,0 +1,583 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// TextEdit replaces the text in Range with NewText. Insertions have an empty range.
+type TextEdit struct {
+	Range   ast.LocationRange
+	NewText string
+}
+
+func insertEdit(loc ast.LocationRange, at ast.Location, text string) TextEdit {
+	loc.Begin, loc.End = at, at
+	return TextEdit{Range: loc, NewText: text}
+}
+
+// advanceLoc returns the location after `text`, starting from `loc`.
+func advanceLoc(loc ast.Location, text string) ast.Location {
+	if nl := strings.LastIndexByte(text, '\n'); nl >= 0 {
+		return ast.Location{Line: loc.Line + strings.Count(text, "\n"), Column: len(text) - nl}
+	}
+	return ast.Location{Line: loc.Line, Column: loc.Column + len(text)}
+}
+
+// trimRange shrinks the range to exclude surrounding whitespace.
+func trimRange(rng ast.LocationRange) ast.LocationRange {
+	text := rangeSource(rng)
+	trimmed := strings.TrimLeft(text, " \t\r\n")
+	rng.Begin = advanceLoc(rng.Begin, text[:len(text)-len(trimmed)])
+	rng.End = advanceLoc(rng.Begin, strings.TrimRight(trimmed, " \t\r\n"))
+	return rng
+}
+
+// statementSeparator returns the text that separates a new statement inserted at `loc`
+// from the code that follows it. Code starting its own line stays on its own line.
+func statementSeparator(file *ast.Source, loc ast.Location) string {
+	if file == nil || loc.Line > len(file.Lines) {
+		return " "
+	}
+	line := file.Lines[loc.Line-1]
+	indent := leadingSpace(line)
+	if len(indent) != loc.Column-1 {
+		return " "
+	}
+	return "\n" + indent
+}
+
+// exprStack returns the stack to the expression that covers exactly `rng`.
+func exprStack(root ast.Node, rng ast.LocationRange) []ast.Node {
+	stack := StackAtLoc(root, rng.Begin)
+	for i := len(stack) - 1; i >= 0; i-- {
+		loc := stack[i].Loc()
+		if loc != nil && loc.Begin == rng.Begin && loc.End == rng.End {
+			return stack[:i+1]
+		}
+	}
+	return nil
+}
+
+// usesVar returns true if `name` is referenced anywhere in `node`.
+func usesVar(node ast.Node, name string) bool {
+	found := false
+	walkStack(node, nil, func(n ast.Node, _ []ast.Node) bool {
+		if v, ok := n.(*ast.Var); ok && string(v.Id) == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// scopeDepth returns how deep in the stack the expression at the end of `stack` can be
+// moved without changing the meaning of its variables: every variable (and `self` or
+// `super`) it uses is bound above the returned index.
+func scopeDepth(stack []ast.Node) int {
+	expr := len(stack) - 1
+	depth := -1
+	walkStack(stack[expr], stack[:expr:expr], func(n ast.Node, stk []ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Var:
+			bind := FindBinding(string(n.Id), stk)
+			if bind == nil {
+				return true
+			}
+			for i := 0; i < expr; i++ {
+				if stack[i] == bind.Def && i > depth {
+					depth = i
+				}
+			}
+		case *ast.Self, *ast.SuperIndex, *ast.InSuper:
+			for i := expr - 1; i > depth; i-- {
+				if _, ok := stack[i].(*ast.DesugaredObject); ok {
+					depth = i
+					break
+				}
+			}
+		}
+		return true
+	})
+	return depth
+}
+
+// localInsertion returns where a local can be declared so that it is in scope for
+// `stack[k]`, and whether it is declared as an object local (`local x = ...,`) instead
+// of a local expression (`local x = ...;`).
+func localInsertion(stack []ast.Node, k int) (ast.Location, bool, bool) {
+	child := stack[k]
+	switch p := stack[k-1].(type) {
+	case *ast.Local:
+		if p.Body == child && p.LocRange.IsSet() {
+			return child.Loc().Begin, false, true
+		}
+	case *ast.Function:
+		// methods have no range, but are the body of a field or local
+		var def ast.LocationRange
+		if k > 1 {
+			def = definitionRange(stack[k-2], p)
+		}
+		if p.Body == child && (p.LocRange.IsSet() || def.IsSet()) {
+			return child.Loc().Begin, false, true
+		}
+	case *ast.DesugaredObject:
+		if !p.LocRange.IsSet() {
+			break
+		}
+		for _, fld := range p.Fields {
+			if fld.Body == child && fld.LocRange.IsSet() {
+				return fld.LocRange.Begin, true, true
+			}
+		}
+	}
+	return ast.Location{}, false, false
+}
+
+// extractionStack returns the selection trimmed of whitespace, and the stack to the
+// expression it covers. Returns nil if the selection is not an expression.
+func extractionStack(root ast.Node, sel ast.LocationRange) (ast.LocationRange, []ast.Node) {
+	if root == nil || root.Loc() == nil {
+		return sel, nil
+	}
+	sel.File, sel.FileName = root.Loc().File, root.Loc().FileName
+	sel = trimRange(sel)
+	stack := exprStack(root, sel)
+	if len(stack) == 0 {
+		return sel, nil
+	}
+	expr := stack[len(stack)-1]
+	// field names are part of the object syntax, not expressions
+	if len(stack) > 1 {
+		if obj, ok := stack[len(stack)-2].(*ast.DesugaredObject); ok {
+			for _, fld := range obj.Fields {
+				if fld.Name == expr {
+					return sel, nil
+				}
+			}
+		}
+	}
+	return sel, stack
+}
+
+// unusedName returns `extracted`, or `extracted` with the first number that makes it a
+// name that is not bound in the stack nor used in `scope`.
+func unusedName(stack []ast.Node, scope ast.Node) string {
+	name := "extracted"
+	for i := 1; FindBinding(name, stack) != nil || usesVar(scope, name); i++ {
+		name = fmt.Sprintf("extracted%d", i)
+	}
+	return name
+}
+
+// extractionEdits declares `decl` at `at`, and replaces the selection with `use`.
+func extractionEdits(sel ast.LocationRange, at ast.Location, decl, use string) []TextEdit {
+	decl += statementSeparator(sel.File, at)
+	if at == sel.Begin {
+		return []TextEdit{{Range: sel, NewText: decl + use}}
+	}
+	return []TextEdit{insertEdit(sel, at, decl), {Range: sel, NewText: use}}
+}
+
+// ExtractLocal moves the expression covering `sel` into a new local, declared in the
+// innermost scope that contains the expression and every variable it uses. The
+// expression is replaced by the name of the local. Returns the new name and the edits,
+// or false if the selection is not an expression that can be extracted.
+func ExtractLocal(root ast.Node, sel ast.LocationRange) (string, []TextEdit, bool) {
+	sel, stack := extractionStack(root, sel)
+	if len(stack) == 0 {
+		return "", nil, false
+	}
+
+	depth := scopeDepth(stack)
+	for k := len(stack) - 1; k > depth; k-- {
+		// the new local is visible everywhere in `scope`
+		scope, at, objectLocal, ok := root, root.Loc().Begin, false, k == 0
+		if k > 0 {
+			scope = stack[k-1]
+			at, objectLocal, ok = localInsertion(stack, k)
+		}
+		if !ok {
+			continue
+		}
+		name := unusedName(stack, scope)
+		decl := fmt.Sprintf("local %s = %s;", name, rangeSource(sel))
+		if objectLocal {
+			decl = fmt.Sprintf("local %s = %s,", name, rangeSource(sel))
+		}
+		return name, extractionEdits(sel, at, decl, name), true
+	}
+	return "", nil, false
+}
+
+// functionParam is a variable used by an extracted expression that is a parameter of a
+// function around it, and the index in the stack of the function.
+type functionParam struct {
+	name  string
+	depth int
+}
+
+// functionScope is like scopeDepth, but only the locals (and `self` or `super`) used by
+// the expression at the end of `stack` limit how far it can be moved. The parameters of
+// the functions around it can be passed as arguments instead, they are returned in the
+// order they are first used.
+func functionScope(stack []ast.Node) (int, []functionParam) {
+	expr := len(stack) - 1
+	depth := -1
+	params := []functionParam{}
+	seen := map[string]bool{}
+	walkStack(stack[expr], stack[:expr:expr], func(n ast.Node, stk []ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Var:
+			bind := FindBinding(string(n.Id), stk)
+			if bind == nil {
+				return true
+			}
+			for i := 0; i < expr; i++ {
+				if stack[i] != bind.Def {
+					continue
+				}
+				if _, ok := bind.Def.(*ast.Function); !ok {
+					if i > depth {
+						depth = i
+					}
+				} else if !seen[bind.Name] {
+					seen[bind.Name] = true
+					params = append(params, functionParam{bind.Name, i})
+				}
+			}
+		case *ast.Self, *ast.SuperIndex, *ast.InSuper:
+			for i := expr - 1; i > depth; i-- {
+				if _, ok := stack[i].(*ast.DesugaredObject); ok {
+					depth = i
+					break
+				}
+			}
+		}
+		return true
+	})
+	return depth, params
+}
+
+// ExtractFunction moves the expression covering `sel` into a new local function,
+// declared in the outermost scope that contains every local it uses. The variables it
+// uses that are not in scope there (the parameters of the functions around it) become
+// the parameters of the function, and the expression is replaced by a call. Returns the name of the function and the edits, or false if the selection
+// is not an expression that can be extracted.
+func ExtractFunction(root ast.Node, sel ast.LocationRange) (string, []TextEdit, bool) {
+	sel, stack := extractionStack(root, sel)
+	if len(stack) == 0 {
+		return "", nil, false
+	}
+
+	depth, params := functionScope(stack)
+	for k := depth + 1; k < len(stack); k++ {
+		scope, at, objectLocal, ok := root, root.Loc().Begin, false, k == 0
+		if k > 0 {
+			scope = stack[k-1]
+			at, objectLocal, ok = localInsertion(stack, k)
+		}
+		if !ok {
+			continue
+		}
+		// the parameters of the functions in scope at the declaration are not passed
+		args := []string{}
+		for _, p := range params {
+			if p.depth >= k {
+				args = append(args, p.name)
+			}
+		}
+		name := unusedName(stack, scope)
+		signature := fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+		decl := fmt.Sprintf("local %s = %s;", signature, rangeSource(sel))
+		if objectLocal {
+			decl = fmt.Sprintf("local %s = %s,", signature, rangeSource(sel))
+		}
+		return name, extractionEdits(sel, at, decl, signature), true
+	}
+	return "", nil, false
+}
+
+// sourceOffset converts a location into a byte offset into the text of `file`.
+func sourceOffset(file *ast.Source, loc ast.Location) int {
+	offset := 0
+	for i := 0; i < loc.Line-1 && i < len(file.Lines); i++ {
+		offset += len(file.Lines[i])
+	}
+	return offset + loc.Column - 1
+}
+
+// sourceLoc converts a byte offset into the text of `file` into a location.
+func sourceLoc(file *ast.Source, offset int) ast.Location {
+	for i, line := range file.Lines {
+		if offset < len(line) {
+			return ast.Location{Line: i + 1, Column: offset + 1}
+		}
+		offset -= len(line)
+	}
+	return ast.Location{Line: len(file.Lines), Column: offset + 1}
+}
+
+// isAtom returns true if the expression can be used anywhere without parentheses.
+func isAtom(node ast.Node) bool {
+	switch n := node.(type) {
+	case *ast.Var, *ast.Self, *ast.SuperIndex, *ast.LiteralNull, *ast.LiteralBoolean,
+		*ast.LiteralNumber, *ast.LiteralString, *ast.Array, *ast.DesugaredObject:
+		return true
+	case *ast.Index:
+		return n.Target.Loc() != nil && n.Target.Loc().IsSet()
+	case *ast.Apply:
+		// desugared operators like `%` are calls to std functions without a range
+		return n.Target.Loc() != nil && n.Target.Loc().IsSet()
+	}
+	return false
+}
+
+// isStandalone returns true if `child` is in a position where it is not part of a
+// larger expression, so it never needs parentheses.
+func isStandalone(parent, child ast.Node) bool {
+	switch p := parent.(type) {
+	case *ast.Array, *ast.DesugaredObject, *ast.Local:
+		return true
+	case *ast.Function:
+		return p.Body == child
+	case *ast.Apply:
+		return p.Target != child
+	}
+	return false
+}
+
+// hasSideEffects returns true if evaluating `node` can raise an error or print a trace.
+// Assertions are desugared to errors.
+func hasSideEffects(node ast.Node) bool {
+	found := false
+	walkStack(node, nil, func(n ast.Node, _ []ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Error:
+			found = true
+		case *ast.Index:
+			if v, ok := n.Target.(*ast.Var); ok && v.Id == "std" {
+				if lit, ok := n.Index.(*ast.LiteralString); ok && lit.Value == "trace" {
+					found = true
+				}
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// innerObject returns the innermost object in the stack, which is what `self` refers to.
+func innerObject(stack []ast.Node) ast.Node {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if _, ok := stack[i].(*ast.DesugaredObject); ok {
+			return stack[i]
+		}
+	}
+	return nil
+}
+
+// sameMeaning returns true if every variable and `self` used in `body` (which is at the
+// end of `from`) refers to the same thing when the body is moved to the end of `to`.
+func sameMeaning(body ast.Node, from, to []ast.Node) bool {
+	outer := from[: len(from)-1 : len(from)-1]
+	same := true
+	walkStack(body, outer, func(n ast.Node, stk []ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Var:
+			bind := FindBinding(string(n.Id), stk)
+			// only variables bound outside of the body can change meaning
+			if bind == nil || !containsNode(outer, bind.Def) {
+				return true
+			}
+			moved := FindBinding(string(n.Id), to)
+			same = moved != nil && moved.Def == bind.Def
+		case *ast.Self, *ast.SuperIndex, *ast.InSuper:
+			// objects in the body have their own `self`
+			if obj := innerObject(outer); innerObject(stk) == obj {
+				same = innerObject(to) == obj
+			}
+		}
+		return same
+	})
+	return same
+}
+
+func containsNode(stack []ast.Node, node ast.Node) bool {
+	for _, n := range stack {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeStack returns the stack from `root` to `node`, or nil if it is not in the tree.
+func nodeStack(root, node ast.Node) []ast.Node {
+	var res []ast.Node
+	walkStack(root, nil, func(n ast.Node, stk []ast.Node) bool {
+		if n == node {
+			res = append([]ast.Node{}, stk...)
+		}
+		return res == nil
+	})
+	return res
+}
+
+// bindRemoval returns the edit that removes the bind `name` from `def`.
+func bindRemoval(def ast.Node, name string) (TextEdit, bool) {
+	binds := bindsOf(def)
+	idx := -1
+	for i, b := range binds {
+		if string(b.Variable) == name {
+			idx = i
+		}
+	}
+	if idx < 0 || !binds[idx].LocRange.IsSet() {
+		return TextEdit{}, false
+	}
+	bind := binds[idx].LocRange
+	file := bind.File
+
+	switch def := def.(type) {
+	case *ast.Local:
+		rng := bind
+		switch {
+		case len(binds) == 1:
+			// `local x = ...;` up to the body
+			rng.Begin, rng.End = def.LocRange.Begin, def.Body.Loc().Begin
+		case idx < len(binds)-1:
+			// `x = ..., ` up to the next bind
+			rng.End = bindSpan(binds[idx+1]).Begin
+		default:
+			// `, x = ...` from the previous bind
+			rng.Begin = bindSpan(binds[idx-1]).End
+		}
+		if !rng.Begin.IsSet() || !rng.End.IsSet() {
+			return TextEdit{}, false
+		}
+		return TextEdit{Range: rng}, true
+	case *ast.DesugaredObject:
+		// object locals are `local x = ...,` with a single bind
+		text := strings.Join(file.Lines, "")
+		begin := strings.LastIndex(text[:sourceOffset(file, bind.Begin)], "local")
+		if begin < 0 {
+			return TextEdit{}, false
+		}
+		end := sourceOffset(file, bind.End)
+		end += len(text[end:]) - len(strings.TrimLeft(text[end:], " \t\r\n"))
+		if strings.HasPrefix(text[end:], ",") {
+			end++
+			end += len(text[end:]) - len(strings.TrimLeft(text[end:], " \t\r\n"))
+		}
+		rng := bind
+		rng.Begin, rng.End = sourceLoc(file, begin), sourceLoc(file, end)
+		return TextEdit{Range: rng}, true
+	}
+	return TextEdit{}, false
+}
+
+// bindSpan returns the range of a bind from its name to the end of its body.
+func bindSpan(b ast.LocalBind) ast.LocationRange {
+	if b.LocRange.IsSet() {
+		return b.LocRange
+	}
+	if b.Body != nil && b.Body.Loc() != nil {
+		return *b.Body.Loc()
+	}
+	return ast.LocationRange{}
+}
+
+// InlineLocal replaces every reference to the local at `pos` with its definition, and
+// removes the local. Returns the name of the local and the edits, or false if the local
+// cannot be inlined: its definition can raise an error, refers to itself, or would
+// refer to different variables (or a different `self`) where it is used.
+func InlineLocal(root ast.Node, pos ast.Location) (string, []TextEdit, bool) {
+	bind := BindingAtLoc(root, pos)
+	if bind == nil {
+		return "", nil, false
+	}
+	if _, ok := bind.Def.(*ast.Function); ok {
+		// function parameters have no definition to inline
+		return "", nil, false
+	}
+	decl := bind.Decl()
+	if decl == nil || !decl.Range.IsSet() || decl.Body == nil || decl.Body.Loc() == nil {
+		return "", nil, false
+	}
+	body := decl.Body
+	bodyRange := *body.Loc()
+	text := rangeSource(bodyRange)
+	if text == "" || hasSideEffects(body) {
+		return "", nil, false
+	}
+	bodyStack := nodeStack(root, body)
+	if len(bodyStack) == 0 {
+		return "", nil, false
+	}
+
+	removal, ok := bindRemoval(bind.Def, bind.Name)
+	if !ok {
+		return "", nil, false
+	}
+	edits := []TextEdit{}
+	for _, ref := range BindingReferences(bind) {
+		if rangeContains(bodyRange, ref.LocRange) {
+			// recursive definition
+			return "", nil, false
+		}
+		stack := nodeStack(root, ref)
+		if len(stack) < 2 || !sameMeaning(body, bodyStack, stack) {
+			return "", nil, false
+		}
+		newText := text
+		if !isAtom(body) && !isStandalone(stack[len(stack)-2], ref) {
+			newText = "(" + text + ")"
+		}
+		edits = append(edits, TextEdit{Range: ref.LocRange, NewText: newText})
+	}
+	return bind.Name, append(edits, removal), true
+}
+
+// RemoveUnusedLocal returns the edit that removes the local at `pos`. Returns the name
+// of the local, or false if it is a function parameter or is referenced.
+func RemoveUnusedLocal(root ast.Node, pos ast.Location) (string, TextEdit, bool) {
+	bind := BindingAtLoc(root, pos)
+	if bind == nil || len(BindingReferences(bind)) > 0 {
+		return "", TextEdit{}, false
+	}
+	if _, ok := bind.Def.(*ast.Function); ok {
+		return "", TextEdit{}, false
+	}
+	removal, ok := bindRemoval(bind.Def, bind.Name)
+	if !ok {
+		return "", TextEdit{}, false
+	}
+	return bind.Name, removal, true
+}
+
+// UnderscoreBinding returns the edit that prefixes the name of the local or parameter at
+// `pos` with an underscore, marking it as intentionally unused. Returns the new name, or
+// false if the binding is referenced or the new name is already used in its scope.
+func UnderscoreBinding(root ast.Node, pos ast.Location) (string, TextEdit, bool) {
+	bind := BindingAtLoc(root, pos)
+	if bind == nil || strings.HasPrefix(bind.Name, "_") || len(BindingReferences(bind)) > 0 {
+		return "", TextEdit{}, false
+	}
+	decl := bind.Decl()
+	if decl == nil || !decl.Range.IsSet() {
+		return "", TextEdit{}, false
+	}
+	name := "_" + bind.Name
+	if usesVar(bind.Def, name) {
+		return "", TextEdit{}, false
+	}
+	for _, d := range Decls(bind.Def) {
+		if d.Name == name {
+			return "", TextEdit{}, false
+		}
+	}
+	return name, insertEdit(decl.Range, decl.Range.Begin, "_"), true
+}
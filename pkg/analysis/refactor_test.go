@@ -0,0 +1,302 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func locOffset(source string, loc ast.Location) int {
+	offset := 0
+	for line := 1; line < loc.Line; line++ {
+		offset += strings.IndexByte(source[offset:], '\n') + 1
+	}
+	return offset + loc.Column - 1
+}
+
+// applyTestEdits applies non-overlapping edits to the source.
+func applyTestEdits(source string, edits []TextEdit) string {
+	sorted := append([]TextEdit{}, edits...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return locBeforeEq(sorted[j].Range.Begin, sorted[i].Range.Begin)
+	})
+	for _, e := range sorted {
+		begin, end := locOffset(source, e.Range.Begin), locOffset(source, e.Range.End)
+		source = source[:begin] + e.NewText + source[end:]
+	}
+	return source
+}
+
+type extractLocalCase struct {
+	Name   string
+	Source string
+	Sel    valueRange
+	Result string
+}
+
+var extractLocalCases = []extractLocalCase{
+	{
+		Name:   "TopLevel",
+		Source: "[1 + 2, 3]",
+		Sel:    valueRange{1, 2, 1, 7},
+		Result: "local extracted = 1 + 2;\n[extracted, 3]",
+	},
+	{
+		Name:   "LocalBody",
+		Source: "local x = 1;\nx * (x + 2)",
+		Sel:    valueRange{2, 6, 2, 11},
+		Result: "local x = 1;\nlocal extracted = x + 2;\nx * (extracted)",
+	},
+	{
+		Name:   "FunctionParameter",
+		Source: "local f(a) =\n  a + 1;\nf(1)",
+		Sel:    valueRange{2, 3, 2, 8},
+		Result: "local f(a) =\n  local extracted = a + 1;\n  extracted;\nf(1)",
+	},
+	{
+		Name:   "ObjectField",
+		Source: "{\n  a: [self.b, 1],\n  b: 2,\n}",
+		Sel:    valueRange{2, 7, 2, 13},
+		Result: "{\n  local extracted = self.b,\n  a: [extracted, 1],\n  b: 2,\n}",
+	},
+	{
+		Name:   "MethodParameter",
+		Source: "{\n  f(a):: [a + 1],\n}",
+		Sel:    valueRange{2, 11, 2, 16},
+		Result: "{\n  f(a):: local extracted = a + 1; [extracted],\n}",
+	},
+	{
+		Name:   "NameInUse",
+		Source: "local extracted = 1;\n[extracted + 1]",
+		Sel:    valueRange{2, 2, 2, 15},
+		Result: "local extracted = 1;\nlocal extracted1 = extracted + 1;\n[extracted1]",
+	},
+	{
+		Name:   "TrimsWhitespace",
+		Source: "[ 1 + 2 ]",
+		Sel:    valueRange{1, 2, 1, 9},
+		Result: "local extracted = 1 + 2;\n[ extracted ]",
+	},
+}
+
+func TestExtractLocal(t *testing.T) {
+	for _, tc := range extractLocalCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, _ := newAnonMockResolver(t, tc.Source)
+			sel := ast.LocationRange{
+				Begin: ast.Location{Line: tc.Sel.BeginLine, Column: tc.Sel.BeginCol},
+				End:   ast.Location{Line: tc.Sel.EndLine, Column: tc.Sel.EndCol},
+			}
+			_, edits, ok := ExtractLocal(resolver.root, sel)
+			require.True(t, ok)
+			assert.Equal(t, tc.Result, applyTestEdits(tc.Source, edits))
+		})
+	}
+}
+
+func TestExtractLocalInvalid(t *testing.T) {
+	source := "{\n  a: 1 + 2,\n}"
+	resolver, _ := newAnonMockResolver(t, source)
+	// not a whole expression
+	_, _, ok := ExtractLocal(resolver.root, ast.LocationRange{Begin: ast.Location{Line: 2, Column: 6}, End: ast.Location{Line: 2, Column: 9}})
+	assert.False(t, ok)
+	// field name
+	_, _, ok = ExtractLocal(resolver.root, ast.LocationRange{Begin: ast.Location{Line: 2, Column: 3}, End: ast.Location{Line: 2, Column: 4}})
+	assert.False(t, ok)
+
+	// comprehension variables are not bound anywhere a local can be declared
+	resolver, _ = newAnonMockResolver(t, "[x * 2 for x in [1, 2]]")
+	_, _, ok = ExtractLocal(resolver.root, ast.LocationRange{Begin: ast.Location{Line: 1, Column: 2}, End: ast.Location{Line: 1, Column: 7}})
+	assert.False(t, ok)
+}
+
+var extractFunctionCases = []extractLocalCase{
+	{
+		Name:   "NoParameters",
+		Source: "[1 + 2, 3]",
+		Sel:    valueRange{1, 2, 1, 7},
+		Result: "local extracted() = 1 + 2;\n[extracted(), 3]",
+	},
+	{
+		Name:   "FunctionParameters",
+		Source: "local f(a, b) =\n  a * b + a;\nf(1, 2)",
+		Sel:    valueRange{2, 3, 2, 12},
+		Result: "local extracted(a, b) = a * b + a;\nlocal f(a, b) =\n  extracted(a, b);\nf(1, 2)",
+	},
+	{
+		Name:   "NestedFunctions",
+		Source: "local f(a) =\n  local g(b) = a + b;\n  g(1);\nf(1)",
+		Sel:    valueRange{2, 16, 2, 21},
+		Result: "local extracted(a, b) = a + b;\nlocal f(a) =\n  local g(b) = extracted(a, b);\n  g(1);\nf(1)",
+	},
+	{
+		Name:   "LocalOfFunction",
+		Source: "local f(a) =\n  local b = a + 1;\n  [a, b * 2];\nf(1)",
+		Sel:    valueRange{3, 7, 3, 12},
+		Result: "local f(a) =\n  local b = a + 1;\n  local extracted() = b * 2;\n  [a, extracted()];\nf(1)",
+	},
+	{
+		Name:   "Self",
+		Source: "{\n  f(a):: self.b + a,\n  b: 2,\n}",
+		Sel:    valueRange{2, 10, 2, 20},
+		Result: "{\n  local extracted(a) = self.b + a,\n  f(a):: extracted(a),\n  b: 2,\n}",
+	},
+}
+
+func TestExtractFunction(t *testing.T) {
+	for _, tc := range extractFunctionCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, _ := newAnonMockResolver(t, tc.Source)
+			sel := ast.LocationRange{
+				Begin: ast.Location{Line: tc.Sel.BeginLine, Column: tc.Sel.BeginCol},
+				End:   ast.Location{Line: tc.Sel.EndLine, Column: tc.Sel.EndCol},
+			}
+			_, edits, ok := ExtractFunction(resolver.root, sel)
+			require.True(t, ok)
+			assert.Equal(t, tc.Result, applyTestEdits(tc.Source, edits))
+		})
+	}
+}
+
+type inlineLocalCase struct {
+	Name   string
+	Source string
+	// Position of the cursor (1-indexed)
+	Line, Column int
+	Result       string
+}
+
+var inlineLocalCases = []inlineLocalCase{
+	{
+		Name:   "SingleBind",
+		Source: "local x = 1;\n[x, x]",
+		Line:   1, Column: 7,
+		Result: "[1, 1]",
+	},
+	{
+		Name:   "FromReference",
+		Source: "local x = 1 + 2;\nx * 3",
+		Line:   2, Column: 1,
+		Result: "(1 + 2) * 3",
+	},
+	{
+		Name:   "FirstOfMany",
+		Source: "local x = 1, y = x + 1;\ny",
+		Line:   1, Column: 7,
+		Result: "local y = 1 + 1;\ny",
+	},
+	{
+		Name:   "LastOfMany",
+		Source: "local x = 1, y = x + 1;\n[y]",
+		Line:   1, Column: 14,
+		Result: "local x = 1;\n[x + 1]",
+	},
+	{
+		Name:   "ObjectLocal",
+		Source: "{\n  local x = self.b,\n  a: x,\n  b: 1,\n}",
+		Line:   2, Column: 9,
+		Result: "{\n  a: self.b,\n  b: 1,\n}",
+	},
+}
+
+func TestInlineLocal(t *testing.T) {
+	for _, tc := range inlineLocalCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, _ := newAnonMockResolver(t, tc.Source)
+			_, edits, ok := InlineLocal(resolver.root, ast.Location{Line: tc.Line, Column: tc.Column})
+			require.True(t, ok)
+			assert.Equal(t, tc.Result, applyTestEdits(tc.Source, edits))
+		})
+	}
+}
+
+var inlineLocalInvalidCases = []inlineLocalCase{
+	{Name: "Error", Source: "local x = error 'no';\n[x]", Line: 1, Column: 7},
+	{Name: "Assert", Source: "local x = assert true; 1;\n[x]", Line: 1, Column: 7},
+	{Name: "Trace", Source: "local x = std.trace('t', 1);\n[x]", Line: 1, Column: 7},
+	{Name: "Recursive", Source: "local f = function(n) if n == 0 then 0 else f(n - 1);\nf(1)", Line: 1, Column: 7},
+	{Name: "Shadowed", Source: "local a = 1;\nlocal x = a;\nlocal a = 2;\n[x]", Line: 2, Column: 7},
+	{Name: "OtherSelf", Source: "{\n  local x = self.b,\n  a: { c: x },\n  b: 1,\n}", Line: 2, Column: 9},
+	{Name: "Parameter", Source: "local f(p) = p;\nf(1)", Line: 1, Column: 9},
+}
+
+func TestInlineLocalInvalid(t *testing.T) {
+	for _, tc := range inlineLocalInvalidCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, _ := newAnonMockResolver(t, tc.Source)
+			_, _, ok := InlineLocal(resolver.root, ast.Location{Line: tc.Line, Column: tc.Column})
+			assert.False(t, ok)
+		})
+	}
+}
+
+var removeUnusedLocalCases = []inlineLocalCase{
+	{Name: "Single", Source: "local x = 1;\n{}", Line: 1, Column: 7, Result: "{}"},
+	{Name: "First", Source: "local x = 1, y = 2;\ny", Line: 1, Column: 7, Result: "local y = 2;\ny"},
+	{Name: "Last", Source: "local y = 2, x = 1;\ny", Line: 1, Column: 14, Result: "local y = 2;\ny"},
+	{Name: "ObjectLocal", Source: "{\n  local x = 1,\n  a: 2,\n}", Line: 2, Column: 9, Result: "{\n  a: 2,\n}"},
+}
+
+func TestRemoveUnusedLocal(t *testing.T) {
+	for _, tc := range removeUnusedLocalCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, _ := newAnonMockResolver(t, tc.Source)
+			_, edit, ok := RemoveUnusedLocal(resolver.root, ast.Location{Line: tc.Line, Column: tc.Column})
+			require.True(t, ok)
+			assert.Equal(t, tc.Result, applyTestEdits(tc.Source, []TextEdit{edit}))
+		})
+	}
+}
+
+var removeUnusedLocalInvalidCases = []inlineLocalCase{
+	{Name: "Used", Source: "local x = 1;\nx", Line: 1, Column: 7},
+	{Name: "Parameter", Source: "local f(p) = 1;\nf(1)", Line: 1, Column: 9},
+}
+
+func TestRemoveUnusedLocalInvalid(t *testing.T) {
+	for _, tc := range removeUnusedLocalInvalidCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, _ := newAnonMockResolver(t, tc.Source)
+			_, _, ok := RemoveUnusedLocal(resolver.root, ast.Location{Line: tc.Line, Column: tc.Column})
+			assert.False(t, ok)
+		})
+	}
+}
+
+var underscoreBindingCases = []inlineLocalCase{
+	{Name: "Local", Source: "local x = 1;\n{}", Line: 1, Column: 7, Result: "local _x = 1;\n{}"},
+	{Name: "Parameter", Source: "local f(p) = 1;\nf(1)", Line: 1, Column: 9, Result: "local f(_p) = 1;\nf(1)"},
+	{Name: "ObjectLocal", Source: "{\n  local x = 1,\n  a: 2,\n}", Line: 2, Column: 9, Result: "{\n  local _x = 1,\n  a: 2,\n}"},
+}
+
+func TestUnderscoreBinding(t *testing.T) {
+	for _, tc := range underscoreBindingCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, _ := newAnonMockResolver(t, tc.Source)
+			_, edit, ok := UnderscoreBinding(resolver.root, ast.Location{Line: tc.Line, Column: tc.Column})
+			require.True(t, ok)
+			assert.Equal(t, tc.Result, applyTestEdits(tc.Source, []TextEdit{edit}))
+		})
+	}
+}
+
+var underscoreBindingInvalidCases = []inlineLocalCase{
+	{Name: "Used", Source: "local x = 1;\nx", Line: 1, Column: 7},
+	{Name: "Underscored", Source: "local _x = 1;\n{}", Line: 1, Column: 7},
+	{Name: "Captures", Source: "local _x = 1;\nlocal x = 2;\n_x", Line: 2, Column: 7},
+	{Name: "Duplicate", Source: "local f(x, _x) = _x;\nf(1, 2)", Line: 1, Column: 9},
+}
+
+func TestUnderscoreBindingInvalid(t *testing.T) {
+	for _, tc := range underscoreBindingInvalidCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, _ := newAnonMockResolver(t, tc.Source)
+			_, _, ok := UnderscoreBinding(resolver.root, ast.Location{Line: tc.Line, Column: tc.Column})
+			assert.False(t, ok)
+		})
+	}
+}
@@ -0,0 +1,380 @@
+package analysis
+
+import (
+	"github.com/google/go-jsonnet/ast"
+)
+
+// LocInRange returns true if pos is within the range r (inclusive on both ends).
+func LocInRange(r ast.LocationRange, pos ast.Location) bool {
+	start, end := r.Begin, r.End
+	if pos.Line < start.Line || pos.Line > end.Line {
+		return false
+	}
+	if pos.Line == start.Line && pos.Column < start.Column {
+		return false
+	}
+	if pos.Line == end.Line && pos.Column > end.Column {
+		return false
+	}
+	return true
+}
+
+// SameRange compares two ranges by file and position, ignoring the file pointer.
+// Nodes from different parses of the same file will have equal ranges.
+func SameRange(a, b ast.LocationRange) bool {
+	return a.FileName == b.FileName && a.Begin == b.Begin && a.End == b.End
+}
+
+// identRange returns the range of an identifier starting at `begin`.
+// Identifiers cannot span lines, so the end is on the same line.
+func identRange(file *ast.Source, fname string, begin ast.Location, name string) ast.LocationRange {
+	return ast.LocationRange{
+		File:     file,
+		FileName: fname,
+		Begin:    begin,
+		End:      ast.Location{Line: begin.Line, Column: begin.Column + len(name)},
+	}
+}
+
+// Decl is a variable declared by a node.
+type Decl struct {
+	Name string
+	// The range of the identifier in the declaration. This is unset for
+	// variables that are introduced by desugaring (like `$` or comprehension variables).
+	Range ast.LocationRange
+	Body  ast.Node
+	Param bool
+}
+
+func bindDecl(b ast.LocalBind) Decl {
+	d := Decl{Name: string(b.Variable), Body: b.Body}
+	if b.Fun != nil {
+		d.Body = b.Fun
+	}
+	switch {
+	case b.LocRange.IsSet():
+		d.Range = identRange(b.LocRange.File, b.LocRange.FileName, b.LocRange.Begin, d.Name)
+	case d.Body != nil && d.Body.Loc() != nil && d.Body.Loc().IsSet():
+		// binds using function syntax `local f(x) = ...` have no range, but the function
+		// node starts at the name of the bind.
+		if _, isFn := d.Body.(*ast.Function); isFn {
+			loc := d.Body.Loc()
+			d.Range = identRange(loc.File, loc.FileName, loc.Begin, d.Name)
+		}
+	}
+	return d
+}
+
+// Decls returns the variables declared by a node. Only *ast.Local, *ast.DesugaredObject,
+// and *ast.Function nodes declare variables.
+func Decls(n ast.Node) []Decl {
+	res := []Decl{}
+	switch n := n.(type) {
+	case *ast.Local:
+		for _, b := range n.Binds {
+			res = append(res, bindDecl(b))
+		}
+	case *ast.DesugaredObject:
+		for _, b := range n.Locals {
+			res = append(res, bindDecl(b))
+		}
+	case *ast.Function:
+		for _, p := range n.Parameters {
+			d := Decl{Name: string(p.Name), Body: p.DefaultArg, Param: true}
+			if p.LocRange.IsSet() {
+				d.Range = identRange(p.LocRange.File, p.LocRange.FileName, p.LocRange.Begin, d.Name)
+			}
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// TopLevelDecls returns the top level locals of a file, and the fields with a constant name
+// of the object it exports, in order. Fields are declarations with the range of their name.
+func TopLevelDecls(root ast.Node) []Decl {
+	res := []Decl{}
+	local, ok := root.(*ast.Local)
+	for ok {
+		res = append(res, Decls(local)...)
+		local, ok = local.Body.(*ast.Local)
+	}
+	return append(res, ExportedFields(root)...)
+}
+
+// ExportedFields returns the fields with a constant name of the object a file exports after
+// its top level locals, as declarations with the range of their name.
+func ExportedFields(root ast.Node) []Decl {
+	res := []Decl{}
+	obj, ok := exportedObject(root)
+	if !ok {
+		return res
+	}
+	for i := range obj.Fields {
+		if name, rng, ok := FieldNameRange(&obj.Fields[i]); ok {
+			res = append(res, Decl{Name: name, Range: rng, Body: obj.Fields[i].Body})
+		}
+	}
+	return res
+}
+
+// Binding identifies a single variable declaration. Def is the node that introduces
+// the variable into scope (an *ast.Local, *ast.DesugaredObject, or *ast.Function).
+type Binding struct {
+	Def  ast.Node
+	Name string
+}
+
+// Decl returns the declaration of the binding.
+func (b *Binding) Decl() *Decl {
+	for _, d := range Decls(b.Def) {
+		if d.Name == b.Name {
+			return &d
+		}
+	}
+	return nil
+}
+
+// FindBinding walks the stack from the innermost node outwards and returns the binding
+// that `name` refers to. Returns nil if the name is not bound in the stack (f.ex `std`).
+func FindBinding(name string, stack []ast.Node) *Binding {
+	for i := len(stack) - 1; i >= 0; i-- {
+		for _, d := range Decls(stack[i]) {
+			if d.Name == name {
+				return &Binding{Def: stack[i], Name: name}
+			}
+		}
+	}
+	return nil
+}
+
+// BindingAtLoc returns the binding either declared or referenced at `loc`.
+func BindingAtLoc(root ast.Node, loc ast.Location) *Binding {
+	stack := StackAtLoc(root, loc)
+	if len(stack) > 0 {
+		if v, ok := stack[len(stack)-1].(*ast.Var); ok {
+			return FindBinding(string(v.Id), stack)
+		}
+	}
+
+	// Not a reference, check if the location is on a declaration. This does a
+	// full walk as function parameters of object methods are not in the stack.
+	var res *Binding
+	walkStack(root, nil, func(n ast.Node, _ []ast.Node) bool {
+		if res != nil {
+			return false
+		}
+		for _, d := range Decls(n) {
+			if d.Range.IsSet() && LocInRange(d.Range, loc) {
+				res = &Binding{Def: n, Name: d.Name}
+				return false
+			}
+		}
+		return true
+	})
+	return res
+}
+
+// BindingReferences returns every variable that refers to the binding `b`.
+func BindingReferences(b *Binding) []*ast.Var {
+	res := []*ast.Var{}
+	// References can only occur under the node defining the binding
+	walkStack(b.Def, nil, func(n ast.Node, stk []ast.Node) bool {
+		v, ok := n.(*ast.Var)
+		if !ok || string(v.Id) != b.Name || !v.LocRange.IsSet() {
+			return true
+		}
+		if found := FindBinding(b.Name, stk); found != nil && found.Def == b.Def {
+			res = append(res, v)
+		}
+		return true
+	})
+	return res
+}
+
+// IndexNameRange returns the range of the field name in an index expression. For
+// dotted access (`x.foo`) this is the identifier, and for string index (`x['foo']`)
+// this is the string literal, including quotes.
+func IndexNameRange(idx *ast.Index) (string, ast.LocationRange, bool) {
+	lit, ok := idx.Index.(*ast.LiteralString)
+	if !ok {
+		return "", ast.LocationRange{}, false
+	}
+	if lit.LocRange.IsSet() {
+		return lit.Value, lit.LocRange, true
+	}
+	if !idx.LocRange.IsSet() {
+		return "", ast.LocationRange{}, false
+	}
+	// dotted access has no location for the index, but the index always ends the expression
+	end := idx.LocRange.End
+	begin := ast.Location{Line: end.Line, Column: end.Column - len(lit.Value)}
+	return lit.Value, identRange(idx.LocRange.File, idx.LocRange.FileName, begin, lit.Value), true
+}
+
+// DottedIndexAt returns the innermost dotted index expression (`x.foo`) with its field
+// name at `pos`, and the range of the name. A cursor right after the name is in it.
+func DottedIndexAt(stack []ast.Node, pos ast.Location) (*ast.Index, ast.LocationRange, bool) {
+	for i := len(stack) - 1; i >= 0; i-- {
+		idx, ok := stack[i].(*ast.Index)
+		if !ok {
+			continue
+		}
+		// string indexes have a location for the literal
+		if lit, ok := idx.Index.(*ast.LiteralString); !ok || lit.LocRange.IsSet() {
+			continue
+		}
+		_, rng, ok := IndexNameRange(idx)
+		if !ok {
+			continue
+		}
+		if locBeforeEq(rng.Begin, pos) && locBeforeEq(pos, rng.End) {
+			return idx, rng, true
+		}
+	}
+	return nil, ast.LocationRange{}, false
+}
+
+// FieldNameRange returns the name and range of the name of an object field. Only
+// fields with constant names have a name range.
+func FieldNameRange(fld *ast.DesugaredObjectField) (string, ast.LocationRange, bool) {
+	lit, ok := fld.Name.(*ast.LiteralString)
+	if !ok {
+		return "", ast.LocationRange{}, false
+	}
+	if lit.LocRange.IsSet() {
+		return lit.Value, lit.LocRange, true
+	}
+	if !fld.LocRange.IsSet() {
+		return "", ast.LocationRange{}, false
+	}
+	return lit.Value, identRange(fld.LocRange.File, fld.LocRange.FileName, fld.LocRange.Begin, lit.Value), true
+}
+
+// RefIndex is a summary of the field accesses and imports in a single file. It is
+// used to quickly narrow down the files and nodes that could refer to a symbol before
+// doing the more expensive value resolution.
+type RefIndex struct {
+	// Fields maps a field name to every index expression accessing it by a constant name.
+	Fields map[string][]*ast.Index
+	// Imports is every *ast.Import, *ast.ImportStr, and *ast.ImportBin in the file.
+	Imports []ast.Node
+	// Calls maps a function name to every call of a variable or index with that name.
+	Calls map[string][]*ast.Apply
+	// ExtVars maps the name of an external variable to every `std.extVar` call reading it.
+	ExtVars map[string][]*ast.Apply
+}
+
+func BuildRefIndex(root ast.Node) *RefIndex {
+	res := &RefIndex{Fields: map[string][]*ast.Index{}, Calls: map[string][]*ast.Apply{}, ExtVars: map[string][]*ast.Apply{}}
+	walkStack(root, nil, func(n ast.Node, _ []ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Apply:
+			if name, _, ok := CallName(n); ok {
+				res.Calls[name] = append(res.Calls[name], n)
+			}
+			if name, ok := ExtVarName(n); ok {
+				res.ExtVars[name.Value] = append(res.ExtVars[name.Value], n)
+			}
+		case *ast.Index:
+			if name, _, ok := IndexNameRange(n); ok {
+				res.Fields[name] = append(res.Fields[name], n)
+			}
+		case *ast.Import, *ast.ImportStr, *ast.ImportBin:
+			res.Imports = append(res.Imports, n)
+		}
+		return true
+	})
+	return res
+}
+
+// ExtVarName returns the name literal of a call to `std.extVar` with a constant name.
+func ExtVarName(apply *ast.Apply) (*ast.LiteralString, bool) {
+	return stdNameArg(apply, "extVar")
+}
+
+// NativeName returns the name literal of a call to `std.native` with a constant name.
+func NativeName(apply *ast.Apply) (*ast.LiteralString, bool) {
+	return stdNameArg(apply, "native")
+}
+
+// stdNameArg returns the only argument of a call to the std function `fn`, if it is a
+// string literal.
+func stdNameArg(apply *ast.Apply, fn string) (*ast.LiteralString, bool) {
+	idx, ok := apply.Target.(*ast.Index)
+	if !ok {
+		return nil, false
+	}
+	if std, ok := idx.Target.(*ast.Var); !ok || string(std.Id) != "std" {
+		return nil, false
+	}
+	if name, ok := idx.Index.(*ast.LiteralString); !ok || name.Value != fn {
+		return nil, false
+	}
+	if len(apply.Arguments.Positional) != 1 {
+		return nil, false
+	}
+	name, ok := apply.Arguments.Positional[0].Expr.(*ast.LiteralString)
+	return name, ok
+}
+
+// IndexField resolves the object field accessed by an index expression. Returns nil
+// if the target could not be resolved to an object, or the field does not exist.
+func IndexField(idx *ast.Index, resolver Resolver) *Field {
+	name, _, ok := IndexNameRange(idx)
+	if !ok {
+		return nil
+	}
+	target := NodeToValue(idx.Target, resolver)
+	if target.Object == nil {
+		return nil
+	}
+	return target.Object.FieldMap[name]
+}
+
+// FieldDefinition resolves the object field accessed by an index expression to where it
+// was originally defined. Fields that re-export another field, like `{ foo: lib.foo }` or
+// `local foo = lib.foo; { foo: foo }`, are followed to the field they export.
+func FieldDefinition(idx *ast.Index, resolver Resolver) *Field {
+	var res *Field
+	for depth := 0; depth < maxStackDepth; depth++ {
+		fld := IndexField(idx, resolver)
+		if fld == nil {
+			return res
+		}
+		res = fld
+
+		next := fld.Node
+		for i := 0; i < maxStackDepth; i++ {
+			v, ok := next.(*ast.Var)
+			if !ok {
+				break
+			}
+			bound := resolver.Vars(v).Get(string(v.Id))
+			if bound == nil || bound.Node == nil {
+				return res
+			}
+			next = bound.Node
+		}
+		nextIdx, ok := next.(*ast.Index)
+		if !ok {
+			return res
+		}
+		idx = nextIdx
+	}
+	return res
+}
+
+// ImportFile returns the path literal of an import, importstr, or importbin node.
+// Returns nil if the node is not an import.
+func ImportFile(node ast.Node) *ast.LiteralString {
+	switch n := node.(type) {
+	case *ast.Import:
+		return n.File
+	case *ast.ImportStr:
+		return n.File
+	case *ast.ImportBin:
+		return n.File
+	}
+	return nil
+}
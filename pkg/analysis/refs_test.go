@@ -0,0 +1,209 @@
+package analysis
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type bindingRefsCase struct {
+	Name   string
+	Source string
+	// Position of the cursor (1-indexed)
+	Line, Column int
+	// Expected ranges as [beginLine, beginCol, endLine, endCol]
+	Decl valueRange
+	Refs []valueRange
+}
+
+var bindingRefsCases = []bindingRefsCase{
+	{
+		Name:   "LocalFromReference",
+		Source: "local x = 1;\n[x, x + 1]",
+		Line:   2, Column: 2,
+		Decl: valueRange{1, 7, 1, 8},
+		Refs: []valueRange{{2, 2, 2, 3}, {2, 5, 2, 6}},
+	},
+	{
+		Name:   "LocalFromDeclaration",
+		Source: "local x = 1;\n[x, x + 1]",
+		Line:   1, Column: 7,
+		Decl: valueRange{1, 7, 1, 8},
+		Refs: []valueRange{{2, 2, 2, 3}, {2, 5, 2, 6}},
+	},
+	{
+		Name:   "Shadowed",
+		Source: "local x = 1;\n[x, local x = 2; x]",
+		Line:   1, Column: 7,
+		Decl: valueRange{1, 7, 1, 8},
+		Refs: []valueRange{{2, 2, 2, 3}},
+	},
+	{
+		Name:   "FunctionSyntax",
+		Source: "local fn(a) = a;\nfn(1)",
+		Line:   2, Column: 1,
+		Decl: valueRange{1, 7, 1, 9},
+		Refs: []valueRange{{2, 1, 2, 3}},
+	},
+	{
+		Name:   "MethodParameter",
+		Source: "{\n  fn(arg): arg + 1,\n}",
+		Line:   2, Column: 6,
+		Decl: valueRange{2, 6, 2, 9},
+		Refs: []valueRange{{2, 12, 2, 15}},
+	},
+	{
+		Name:   "ObjectLocal",
+		Source: "{\n  local y = 2,\n  a: y,\n  b: { c: y },\n}",
+		Line:   4, Column: 11,
+		Decl: valueRange{2, 9, 2, 10},
+		Refs: []valueRange{{3, 6, 3, 7}, {4, 11, 4, 12}},
+	},
+}
+
+func rangeToTestRange(r ast.LocationRange) valueRange {
+	return valueRange{r.Begin.Line, r.Begin.Column, r.End.Line, r.End.Column}
+}
+
+func TestBindingReferences(t *testing.T) {
+	for _, tc := range bindingRefsCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolver, _ := newAnonMockResolver(t, tc.Source)
+			bind := BindingAtLoc(resolver.root, ast.Location{Line: tc.Line, Column: tc.Column})
+			require.NotNil(t, bind, "expected binding at cursor")
+			decl := bind.Decl()
+			require.NotNil(t, decl)
+			assert.Equal(t, tc.Decl, rangeToTestRange(decl.Range))
+
+			refs := []valueRange{}
+			for _, v := range BindingReferences(bind) {
+				refs = append(refs, rangeToTestRange(v.LocRange))
+			}
+			assert.Equal(t, tc.Refs, refs)
+		})
+	}
+}
+
+func TestTopLevelDecls(t *testing.T) {
+	resolver, _ := newAnonMockResolver(t, "local a = 1;\nlocal f(x) = x, b = { c: 1 };\n{\n  d: a,\n  'e': f(b),\n  [a + '']: 2,\n}")
+	res := []string{}
+	for _, d := range TopLevelDecls(resolver.root) {
+		res = append(res, fmt.Sprintf("%s %v", d.Name, rangeToTestRange(d.Range)))
+	}
+	assert.Equal(t, []string{"a {1 7 1 8}", "f {2 7 2 8}", "b {2 17 2 18}", "d {4 3 4 4}", "e {5 3 5 6}"}, res)
+
+	fields := []string{}
+	for _, d := range ExportedFields(resolver.root) {
+		fields = append(fields, d.Name)
+	}
+	assert.Equal(t, []string{"d", "e"}, fields)
+}
+
+func TestIndexField(t *testing.T) {
+	source := "local obj = {\n  foo: 1,\n  'bar': 2,\n};\n[obj.foo, obj['bar'], obj.missing]"
+	resolver, _ := newAnonMockResolver(t, source)
+	idx := BuildRefIndex(resolver.root)
+
+	require.Len(t, idx.Fields["foo"], 1)
+	name, rng, ok := IndexNameRange(idx.Fields["foo"][0])
+	require.True(t, ok)
+	assert.Equal(t, "foo", name)
+	assert.Equal(t, valueRange{5, 6, 5, 9}, rangeToTestRange(rng))
+	fld := IndexField(idx.Fields["foo"][0], resolver)
+	require.NotNil(t, fld)
+	assert.Equal(t, valueRange{2, 3, 2, 6}, rangeToTestRange(fld.NameRange))
+
+	require.Len(t, idx.Fields["bar"], 1)
+	_, rng, _ = IndexNameRange(idx.Fields["bar"][0])
+	assert.Equal(t, valueRange{5, 15, 5, 20}, rangeToTestRange(rng))
+	fld = IndexField(idx.Fields["bar"][0], resolver)
+	require.NotNil(t, fld)
+	assert.Equal(t, valueRange{3, 3, 3, 8}, rangeToTestRange(fld.NameRange))
+
+	require.Len(t, idx.Fields["missing"], 1)
+	assert.Nil(t, IndexField(idx.Fields["missing"][0], resolver))
+}
+
+func TestExtVarName(t *testing.T) {
+	source := "local name = 'x', ext = { extVar(n): n };\n[std.extVar('env'), std.extVar(\"env\"), std.extVar('cluster'), std.extVar(name), ext.extVar('other')]"
+	resolver, _ := newAnonMockResolver(t, source)
+	idx := BuildRefIndex(resolver.root)
+
+	assert.Len(t, idx.ExtVars, 2)
+	require.Len(t, idx.ExtVars["env"], 2)
+	require.Len(t, idx.ExtVars["cluster"], 1)
+	name, ok := ExtVarName(idx.ExtVars["cluster"][0])
+	require.True(t, ok)
+	assert.Equal(t, valueRange{2, 51, 2, 60}, rangeToTestRange(name.LocRange))
+}
+
+func TestDottedIndexAt(t *testing.T) {
+	source := "local k = { a: { b: 1 } };\n[k.a.b, k['a']]"
+	resolver, _ := newAnonMockResolver(t, source)
+
+	k, ka := &valueRange{2, 2, 2, 3}, &valueRange{2, 2, 2, 5}
+	for _, c := range []struct {
+		Column int
+		Target *valueRange
+	}{
+		// in the name, and right after the name
+		{4, k}, {5, k},
+		{6, ka}, {7, ka},
+		// the target itself, the dot, and string indexes
+		{2, nil}, {3, nil}, {12, nil},
+	} {
+		pos := ast.Location{Line: 2, Column: c.Column}
+		_, stack := resolver.NodeAt(pos)
+		idx, _, ok := DottedIndexAt(stack, pos)
+		if c.Target == nil {
+			assert.False(t, ok, "column %d", c.Column)
+			continue
+		}
+		require.True(t, ok, "column %d", c.Column)
+		assert.Equal(t, *c.Target, rangeToTestRange(*idx.Target.Loc()), "column %d", c.Column)
+	}
+}
+
+func TestFieldDefinition(t *testing.T) {
+	source := `local base = { foo: { bar: 1 }, baz: 2 };
+local baz = base.baz;
+local mid = { foo: base.foo, baz: baz };
+local mixin(p) = mid + p;
+[mid.foo.bar, mid.baz, mixin({}).foo]`
+	resolver, _ := newAnonMockResolver(t, source)
+	idx := BuildRefIndex(resolver.root)
+
+	find := func(name string, line int) *ast.Index {
+		for _, n := range idx.Fields[name] {
+			if n.LocRange.Begin.Line == line {
+				return n
+			}
+		}
+		require.Failf(t, "index not found", "%s on line %d", name, line)
+		return nil
+	}
+
+	fld := FieldDefinition(find("bar", 5), resolver)
+	require.NotNil(t, fld)
+	assert.Equal(t, valueRange{1, 23, 1, 26}, rangeToTestRange(fld.NameRange))
+
+	// re-exported through a local
+	fld = FieldDefinition(find("baz", 5), resolver)
+	require.NotNil(t, fld)
+	assert.Equal(t, valueRange{1, 33, 1, 36}, rangeToTestRange(fld.NameRange))
+
+	// mixed in with an unknown parameter
+	var mixed *ast.Index
+	for _, n := range idx.Fields["foo"] {
+		if _, ok := n.Target.(*ast.Apply); ok {
+			mixed = n
+		}
+	}
+	require.NotNil(t, mixed)
+	fld = FieldDefinition(mixed, resolver)
+	require.NotNil(t, fld)
+	assert.Equal(t, valueRange{1, 16, 1, 19}, rangeToTestRange(fld.NameRange))
+}
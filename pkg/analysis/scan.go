@@ -0,0 +1,89 @@
+package analysis
+
+import "strings"
+
+type tokenKind int
+
+const (
+	codeToken tokenKind = iota
+	spaceToken
+	lineCommentToken
+	blockCommentToken
+	// strings, verbatim strings, and text blocks
+	stringToken
+)
+
+// scanToken returns the kind of the token starting at `contents[i]`, and the index
+// it ends at. This is not a full lexer: whitespace and code are returned one byte at a
+// time, and only comments and strings are scanned as a whole so that their contents
+// are not mistaken for code. Line comments end before the newline.
+func scanToken(contents string, i int) (tokenKind, int) {
+	c := contents[i]
+	switch {
+	case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		return spaceToken, i + 1
+	case c == '#' || strings.HasPrefix(contents[i:], "//"):
+		end := strings.IndexByte(contents[i:], '\n')
+		if end < 0 {
+			return lineCommentToken, len(contents)
+		}
+		return lineCommentToken, i + end
+	case strings.HasPrefix(contents[i:], "/*"):
+		end := strings.Index(contents[i+2:], "*/")
+		if end < 0 {
+			return blockCommentToken, len(contents)
+		}
+		return blockCommentToken, i + end + 4
+	case strings.HasPrefix(contents[i:], "|||"):
+		// text blocks end at the first following line that starts with |||
+		for pos := i + 3; ; {
+			nl := strings.IndexByte(contents[pos:], '\n')
+			if nl < 0 {
+				return stringToken, len(contents)
+			}
+			pos += nl + 1
+			if rest := strings.TrimLeft(contents[pos:], " \t"); strings.HasPrefix(rest, "|||") {
+				return stringToken, len(contents) - len(rest) + 3
+			}
+		}
+	case c == '\'' || c == '"' || (c == '@' && i+1 < len(contents) && (contents[i+1] == '\'' || contents[i+1] == '"')):
+		verbatim := c == '@'
+		if verbatim {
+			i++
+		}
+		quote := contents[i]
+		j := i + 1
+		for ; j < len(contents); j++ {
+			if !verbatim && contents[j] == '\\' {
+				j++
+				continue
+			}
+			if contents[j] == quote {
+				// verbatim strings escape quotes by doubling them
+				if verbatim && j+1 < len(contents) && contents[j+1] == quote {
+					j++
+					continue
+				}
+				break
+			}
+		}
+		if j+1 > len(contents) {
+			return stringToken, len(contents)
+		}
+		return stringToken, j + 1
+	}
+	return codeToken, i + 1
+}
+
+// CommentAt returns the start of the comment `offset` is in. The end of a line comment is
+// in it, as text is typed there.
+func CommentAt(contents string, offset int) (int, bool) {
+	for i := 0; i < len(contents) && i <= offset; {
+		kind, end := scanToken(contents, i)
+		if (kind == lineCommentToken || kind == blockCommentToken) && offset > i && (offset < end || (kind == lineCommentToken && offset == end)) {
+			return i, true
+		}
+		i = end
+	}
+	return 0, false
+}
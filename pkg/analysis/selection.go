@@ -0,0 +1,114 @@
+package analysis
+
+import (
+	"github.com/google/go-jsonnet/ast"
+)
+
+func locBeforeEq(a, b ast.Location) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column <= b.Column)
+}
+
+// rangeContains returns true if `inner` is entirely within `outer`.
+func rangeContains(outer, inner ast.LocationRange) bool {
+	return locBeforeEq(outer.Begin, inner.Begin) && locBeforeEq(inner.End, outer.End)
+}
+
+// bindsOf returns the local binds of a node, if it has any.
+func bindsOf(node ast.Node) ast.LocalBinds {
+	switch n := node.(type) {
+	case *ast.Local:
+		return n.Binds
+	case *ast.DesugaredObject:
+		return n.Locals
+	}
+	return nil
+}
+
+// definitionRange returns the range of the local bind or object field of `parent` that
+// `child` is the body of. Binds and fields are not nodes, so they are not in the stack.
+func definitionRange(parent, child ast.Node) ast.LocationRange {
+	for _, b := range bindsOf(parent) {
+		if b.Body == child || (b.Fun != nil && b.Fun == child) {
+			return b.LocRange
+		}
+	}
+	if obj, ok := parent.(*ast.DesugaredObject); ok {
+		for _, fld := range obj.Fields {
+			if fld.Body == child || fld.Name == child {
+				return fld.LocRange
+			}
+		}
+	}
+	return ast.LocationRange{}
+}
+
+// fileRange returns the range of the whole source file of `node`.
+func fileRange(node ast.Node) ast.LocationRange {
+	loc := node.Loc()
+	if loc == nil || loc.File == nil || len(loc.File.Lines) == 0 {
+		return ast.LocationRange{}
+	}
+	lines := loc.File.Lines
+	last := lines[len(lines)-1]
+	return ast.LocationRange{
+		File:     loc.File,
+		FileName: loc.FileName,
+		Begin:    ast.Location{Line: 1, Column: 1},
+		End:      ast.Location{Line: len(lines), Column: len(last)},
+	}
+}
+
+// SelectionRanges returns the ranges around `pos` from the innermost to the outermost,
+// where each range contains the previous one. This follows the nesting of the AST, with
+// the names and bodies of locals and fields in between their parent nodes, and ends
+// with the whole file.
+func SelectionRanges(root ast.Node, pos ast.Location) []ast.LocationRange {
+	res := []ast.LocationRange{}
+	add := func(r ast.LocationRange) {
+		if !r.IsSet() || !LocInRange(r, pos) {
+			return
+		}
+		if len(res) > 0 {
+			last := res[len(res)-1]
+			if SameRange(last, r) || !rangeContains(r, last) {
+				return
+			}
+		}
+		res = append(res, r)
+	}
+
+	stack := StackAtLoc(root, pos)
+	for i := len(stack) - 1; i >= 0; i-- {
+		node := stack[i]
+		if i == len(stack)-1 {
+			// the cursor may be on the name of a declaration, which is not a node
+			for _, b := range bindsOf(node) {
+				if d := bindDecl(b); LocInRange(d.Range, pos) {
+					add(d.Range)
+					add(b.LocRange)
+				}
+			}
+			if fn, ok := node.(*ast.Function); ok {
+				for _, p := range fn.Parameters {
+					add(p.LocRange)
+				}
+			}
+			if obj, ok := node.(*ast.DesugaredObject); ok {
+				for i := range obj.Fields {
+					if _, rng, ok := FieldNameRange(&obj.Fields[i]); ok && LocInRange(rng, pos) {
+						add(rng)
+						add(obj.Fields[i].LocRange)
+					}
+				}
+			}
+		}
+		if loc := node.Loc(); loc != nil {
+			add(*loc)
+		}
+		if i > 0 {
+			add(definitionRange(stack[i-1], node))
+		}
+	}
+	add(fileRange(root))
+	return res
+}
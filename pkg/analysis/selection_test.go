@@ -0,0 +1,66 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+type selectionCase struct {
+	Name         string
+	Line, Column int
+	Expect       []valueRange
+}
+
+func TestSelectionRanges(t *testing.T) {
+	source := "local a = 1;\n{\n  foo: {\n    bar: a + 1,\n  },\n}\n"
+	cases := []selectionCase{
+		{
+			Name: "Identifier",
+			Line: 4, Column: 10,
+			Expect: []valueRange{
+				{4, 10, 4, 11}, // a
+				{4, 10, 4, 15}, // a + 1
+				{4, 5, 4, 15},  // bar: a + 1
+				{3, 8, 5, 4},   // { bar }
+				{3, 3, 5, 4},   // foo: { bar }
+				{2, 1, 6, 2},   // { foo }
+				{1, 1, 6, 2},   // local a = 1; { foo }
+				{1, 1, 7, 1},   // file
+			},
+		},
+		{
+			Name: "FieldName",
+			Line: 3, Column: 4,
+			Expect: []valueRange{
+				{3, 3, 3, 6},
+				{3, 3, 5, 4},
+				{2, 1, 6, 2},
+				{1, 1, 6, 2},
+				{1, 1, 7, 1},
+			},
+		},
+		{
+			Name: "LocalName",
+			Line: 1, Column: 7,
+			Expect: []valueRange{
+				{1, 7, 1, 8},
+				{1, 7, 1, 12},
+				{1, 1, 6, 2},
+				{1, 1, 7, 1},
+			},
+		},
+	}
+
+	resolver, _ := newAnonMockResolver(t, source)
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			got := []valueRange{}
+			for _, r := range SelectionRanges(resolver.root, ast.Location{Line: tc.Line, Column: tc.Column}) {
+				got = append(got, rangeToTestRange(r))
+			}
+			assert.Equal(t, tc.Expect, got)
+		})
+	}
+}
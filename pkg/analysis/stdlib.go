@@ -117,6 +117,20 @@ var StdLibFunctions = map[string]*Function{
 			{Name: "o", Type: ObjectType},
 		},
 	},
+	"objectKeysValues": {
+		Comment:    []string{"Returns an array of objects from the given object, each object having two fields:\n`key` (string) and `value` (object). Does not include hidden fields."},
+		ReturnType: ArrayType,
+		Params: []Param{
+			{Name: "o", Type: ObjectType},
+		},
+	},
+	"objectKeysValuesAll": {
+		Comment:    []string{"As `std.objectKeysValues` but also includes hidden fields."},
+		ReturnType: ArrayType,
+		Params: []Param{
+			{Name: "o", Type: ObjectType},
+		},
+	},
 	"prune": {
 		Comment:    []string{"Recursively remove all \"empty\" members of `a`. \"Empty\" is defined as zero\nlength \\`arrays\\`, zero length \\`objects\\`, or \\`null\\` values.\nThe argument `a` may have any type."},
 		ReturnType: AnyType,
@@ -283,6 +297,13 @@ var StdLibFunctions = map[string]*Function{
 			{Name: "str", Type: StringType},
 		},
 	},
+	"isEmpty": {
+		Comment:    []string{"Returns true if the given string is of zero length."},
+		ReturnType: BooleanType,
+		Params: []Param{
+			{Name: "str", Type: StringType},
+		},
+	},
 	"format": {
 		Comment:    []string{"Format the string `str` using the values in `vals`. The values can be\nan array, an object, or in other cases are treated as if they were provided in a singleton\narray. The string formatting follows the [same rules](https://docs.python.org/2/library/stdtypes.html#string-formatting) as\nPython. The `%` operator can be used as a shorthand for this function.\n\nExamples:\n\n  Input: `std.format(\"Hello %03d\", 12)`\n  Output: `\"Hello 012\"`\n\n  Input: `\"Hello %03d\" % 12`\n  Output: `\"Hello 012\"`\n\n  Input: `\"Hello %s, age %d\" % [\"Foo\", 25]`\n  Output: `\"Hello Foo, age 25\"`\n\n  Input: `\"Hello %(name)s, age %(age)d\" % {age: 25, name: \"Foo\"}`\n  Output: `\"Hello Foo, age 25\"`"},
 		ReturnType: StringType,
@@ -319,6 +340,13 @@ var StdLibFunctions = map[string]*Function{
 			{Name: "str", Type: StringType},
 		},
 	},
+	"escapeStringXML": {
+		Comment:    []string{"Convert `str` to allow it to be embedded in XML (or HTML). The following replacements are made:\n\n```\n{\n  \"<\": \"&lt;\",\n  \">\": \"&gt;\",\n  \"&\": \"&amp;\",\n  \"\\\"\": \"&quot;\",\n  \"'\": \"&apos;\",\n}\n```"},
+		ReturnType: StringType,
+		Params: []Param{
+			{Name: "str", Type: StringType},
+		},
+	},
 	"parseInt": {
 		Comment:    []string{"Parses a signed decimal integer from the input string.\n\nExamples:\n\n  Input: `std.parseInt(\"123\")`\n  Output: `123`\n\n  Input: `std.parseInt(\"-123\")`\n  Output: `-123`"},
 		ReturnType: NumberType,
@@ -399,6 +427,13 @@ var StdLibFunctions = map[string]*Function{
 			{Name: "key_val_sep", Type: StringType, Default: &ast.LiteralString{Value: ":"}},
 		},
 	},
+	"manifestJson": {
+		Comment:    []string{"Convert the given object to a JSON form, indented with four spaces. This is\n`std.manifestJsonEx(value, '    ')`."},
+		ReturnType: StringType,
+		Params: []Param{
+			{Name: "value", Type: AnyType},
+		},
+	},
 	"manifestJsonMinified": {
 		Comment:    []string{"Convert the given object to a minified JSON form. Under the covers,\nit calls `std.manifestJsonEx:')`:\n\nExamples:\n\n  Input: `std.manifestJsonMinified(\n{\n    x: [1, 2, 3, true, false, null,\n        \"string\\nstring\"],\n    y: { a: 1, b: 2, c: [1, 2] },\n})\n`\n  Output: `\"{\\\"x\\\":[1,2,3,true,false,null,\\\"string\\\\nstring\\\"],\\\"y\\\":{\\\"a\\\":1,\\\"b\\\":2,\\\"c\\\":[1,2]}}\"`"},
 		ReturnType: StringType,
@@ -440,6 +475,13 @@ var StdLibFunctions = map[string]*Function{
 			{Name: "indent", Type: StringType},
 		},
 	},
+	"manifestToml": {
+		Comment:    []string{"Convert the given object to a TOML form, indented with two spaces. This is\n`std.manifestTomlEx(toml, '  ')`."},
+		ReturnType: StringType,
+		Params: []Param{
+			{Name: "toml", Type: AnyType},
+		},
+	},
 	"makeArray": {
 		Comment:    []string{"Create a new array of `sz` elements by calling `func(i)` to initialize\neach element. Func is expected to be a function that takes a single parameter, the index of\nthe element it should initialize.\n\nExamples:\n\n  Input: `std.makeArray(3,function(x) x * x)`\n  Output: `[0,1,4]`"},
 		ReturnType: ArrayType,
@@ -565,6 +607,13 @@ var StdLibFunctions = map[string]*Function{
 			{Name: "arr", Type: ArrayType},
 		},
 	},
+	"deepJoin": {
+		Comment:    []string{"Concatenate an array containing strings and arrays to form a single string. If `arr` is a\nstring, it is returned unchanged. If it is an array, it is flattened and the string\nelements are concatenated together with no separator.\n\nExample:\n\n  Input: `std.deepJoin([\"one \", [\"two \", \"three \", [\"four \"], []], \"five \", [\"six\"]])`\n  Output: `\"one two three four five six\"`"},
+		ReturnType: StringType,
+		Params: []Param{
+			{Name: "arr", Type: ArrayType},
+		},
+	},
 	"lines": {
 		Comment:    []string{"Concatenate an array of strings into a text file with newline characters after each string.\nThis is suitable for constructing bash scripts and the like."},
 		ReturnType: StringType,
@@ -616,6 +665,13 @@ var StdLibFunctions = map[string]*Function{
 			{Name: "arr", Type: ArrayType},
 		},
 	},
+	"sum": {
+		Comment:    []string{"Return sum of all element in `arr`."},
+		ReturnType: NumberType,
+		Params: []Param{
+			{Name: "arr", Type: ArrayType},
+		},
+	},
 	"set": {
 		Comment:    []string{"Shortcut for std.uniq(std.sort(arr))."},
 		ReturnType: ArrayType,
@@ -696,6 +752,44 @@ var StdLibFunctions = map[string]*Function{
 			{Name: "patch", Type: AnyType},
 		},
 	},
+	"xor": {
+		Comment:    []string{"Returns the xor of the two given booleans."},
+		ReturnType: BooleanType,
+		Params: []Param{
+			{Name: "x", Type: BooleanType},
+			{Name: "y", Type: BooleanType},
+		},
+	},
+	"xnor": {
+		Comment:    []string{"Returns the xnor of the two given booleans."},
+		ReturnType: BooleanType,
+		Params: []Param{
+			{Name: "x", Type: BooleanType},
+			{Name: "y", Type: BooleanType},
+		},
+	},
+	"id": {
+		Comment:    []string{"The identity function, returns `x` unchanged."},
+		ReturnType: AnyType,
+		Params: []Param{
+			{Name: "x", Type: AnyType},
+		},
+	},
+	"native": {
+		Comment:    []string{"Returns the native function registered in the interpreter with the given name, or\n`null` if there is none."},
+		ReturnType: FunctionType,
+		Params: []Param{
+			{Name: "name", Type: StringType},
+		},
+	},
+	"resolvePath": {
+		Comment:    []string{"Replaces the last path component of `f` with `r`, f.ex to find a file next to\n`std.thisFile`.\n\nExample:\n\n  Input: `std.resolvePath(\"a/b/c.jsonnet\", \"d.libsonnet\")`\n  Output: `\"a/b/d.libsonnet\"`"},
+		ReturnType: StringType,
+		Params: []Param{
+			{Name: "f", Type: StringType},
+			{Name: "r", Type: StringType},
+		},
+	},
 	"trace": {
 		Comment:    []string{"Outputs the given string `str` to stderr and\nreturns `rest` as the result.\n\nExample:\n\n```\nlocal conditionalReturn(cond, in1, in2) =\n  if (cond) then\n      std.trace('cond is true returning '\n              + std.toString(in1), in1)\n  else\n      std.trace('cond is false returning '\n              + std.toString(in2), in2);\n\n{\n    a: conditionalReturn(true, { b: true }, { c: false }),\n}\n```\n\nPrints:\n\n```\nTRACE: test.jsonnet:3 cond is true returning {\"b\": true}\n{\n    \"a\": {\n        \"b\": true\n    }\n}\n```"},
 		ReturnType: AnyType,
@@ -706,26 +800,156 @@ var StdLibFunctions = map[string]*Function{
 	},
 
 	// Mathematical Utilities
-	"abs":      {ReturnType: NumberType, Params: []Param{{Name: "n", Type: NumberType}}},
-	"sign":     {ReturnType: NumberType, Params: []Param{{Name: "n", Type: NumberType}}},
-	"max":      {ReturnType: NumberType, Params: []Param{{Name: "a", Type: NumberType}, {Name: "b", Type: NumberType}}},
-	"min":      {ReturnType: NumberType, Params: []Param{{Name: "a", Type: NumberType}, {Name: "b", Type: NumberType}}},
-	"mod":      {ReturnType: NumberType, Params: []Param{{Name: "a", Type: NumberType}, {Name: "b", Type: NumberType}}},
-	"pow":      {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}, {Name: "n", Type: NumberType}}},
-	"exp":      {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"log":      {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"exponent": {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"mantissa": {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"floor":    {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"ceil":     {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"sqrt":     {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"sin":      {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"cos":      {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"tan":      {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"asin":     {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"acos":     {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"atan":     {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
-	"round":    {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"abs":      {Comment: []string{"Returns the absolute value of `n`."}, ReturnType: NumberType, Params: []Param{{Name: "n", Type: NumberType}}},
+	"sign":     {Comment: []string{"Returns `-1`, `0`, or `1` depending on the sign of `n`."}, ReturnType: NumberType, Params: []Param{{Name: "n", Type: NumberType}}},
+	"max":      {Comment: []string{"Returns the largest of `a` and `b`."}, ReturnType: NumberType, Params: []Param{{Name: "a", Type: NumberType}, {Name: "b", Type: NumberType}}},
+	"min":      {Comment: []string{"Returns the smallest of `a` and `b`."}, ReturnType: NumberType, Params: []Param{{Name: "a", Type: NumberType}, {Name: "b", Type: NumberType}}},
+	"mod":      {Comment: []string{"Returns `a` modulo `b`, the same as the `%` operator for numbers."}, ReturnType: NumberType, Params: []Param{{Name: "a", Type: NumberType}, {Name: "b", Type: NumberType}}},
+	"pow":      {Comment: []string{"Returns `x` raised to the power of `n`."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}, {Name: "n", Type: NumberType}}},
+	"exp":      {Comment: []string{"Returns e raised to the power of `x`."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"log":      {Comment: []string{"Returns the natural logarithm of `x`."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"exponent": {Comment: []string{"Returns the exponent of `x` as an IEEE754 floating point number, such that\n`x == std.mantissa(x) * std.pow(2, std.exponent(x))`."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"mantissa": {Comment: []string{"Returns the mantissa of `x` as an IEEE754 floating point number, such that\n`x == std.mantissa(x) * std.pow(2, std.exponent(x))`."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"floor":    {Comment: []string{"Returns the largest integer that is not greater than `x`."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"ceil":     {Comment: []string{"Returns the smallest integer that is not less than `x`."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"sqrt":     {Comment: []string{"Returns the square root of `x`."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"sin":      {Comment: []string{"Returns the sine of `x`, in radians."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"cos":      {Comment: []string{"Returns the cosine of `x`, in radians."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"tan":      {Comment: []string{"Returns the tangent of `x`, in radians."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"asin":     {Comment: []string{"Returns the arcsine of `x`, in radians."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"acos":     {Comment: []string{"Returns the arccosine of `x`, in radians."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"atan":     {Comment: []string{"Returns the arctangent of `x`, in radians."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+	"round":    {Comment: []string{"Returns `x` rounded to the nearest integer, with halves rounded away from zero."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+}
+
+// StdLibSince is the version of jsonnet each function of the standard library is available
+// since. Functions that predate versioned documentation are available since 0.10.0.
+var StdLibSince = map[string]string{
+	"abs":                  "0.10.0",
+	"acos":                 "0.10.0",
+	"all":                  "0.19.0",
+	"any":                  "0.19.0",
+	"asciiLower":           "0.10.0",
+	"asciiUpper":           "0.10.0",
+	"asin":                 "0.10.0",
+	"assertEqual":          "0.10.0",
+	"atan":                 "0.10.0",
+	"base64":               "0.10.0",
+	"base64Decode":         "0.10.0",
+	"base64DecodeBytes":    "0.10.0",
+	"ceil":                 "0.10.0",
+	"char":                 "0.10.0",
+	"clamp":                "0.15.0",
+	"codepoint":            "0.10.0",
+	"cos":                  "0.10.0",
+	"count":                "0.10.0",
+	"decodeUTF8":           "0.13.0",
+	"deepJoin":             "0.10.0",
+	"encodeUTF8":           "0.13.0",
+	"endsWith":             "0.10.0",
+	"escapeStringBash":     "0.10.0",
+	"escapeStringDollars":  "0.10.0",
+	"escapeStringJson":     "0.10.0",
+	"escapeStringPython":   "0.10.0",
+	"escapeStringXML":      "0.10.0",
+	"exp":                  "0.10.0",
+	"exponent":             "0.10.0",
+	"extVar":               "0.10.0",
+	"filter":               "0.10.0",
+	"filterMap":            "0.10.0",
+	"find":                 "0.10.0",
+	"findSubstr":           "0.10.0",
+	"flatMap":              "0.10.0",
+	"flattenArrays":        "0.10.0",
+	"floor":                "0.10.0",
+	"foldl":                "0.10.0",
+	"foldr":                "0.10.0",
+	"format":               "0.10.0",
+	"get":                  "0.18.0",
+	"id":                   "0.10.0",
+	"isArray":              "0.10.0",
+	"isBoolean":            "0.10.0",
+	"isEmpty":              "0.20.0",
+	"isFunction":           "0.10.0",
+	"isNumber":             "0.10.0",
+	"isObject":             "0.10.0",
+	"isString":             "0.10.0",
+	"join":                 "0.10.0",
+	"length":               "0.10.0",
+	"lines":                "0.10.0",
+	"log":                  "0.10.0",
+	"lstripChars":          "0.15.0",
+	"makeArray":            "0.10.0",
+	"manifestIni":          "0.10.0",
+	"manifestJson":         "0.10.0",
+	"manifestJsonEx":       "0.10.0",
+	"manifestJsonMinified": "0.18.0",
+	"manifestPython":       "0.10.0",
+	"manifestPythonVars":   "0.10.0",
+	"manifestToml":         "0.18.0",
+	"manifestTomlEx":       "0.18.0",
+	"manifestXmlJsonml":    "0.10.0",
+	"manifestYamlDoc":      "0.10.0",
+	"manifestYamlStream":   "0.10.0",
+	"mantissa":             "0.10.0",
+	"map":                  "0.10.0",
+	"mapWithIndex":         "0.10.0",
+	"mapWithKey":           "0.10.0",
+	"max":                  "0.10.0",
+	"md5":                  "0.10.0",
+	"member":               "0.15.0",
+	"mergePatch":           "0.10.0",
+	"min":                  "0.10.0",
+	"mod":                  "0.10.0",
+	"native":               "0.10.0",
+	"objectFields":         "0.10.0",
+	"objectFieldsAll":      "0.10.0",
+	"objectHas":            "0.10.0",
+	"objectHasAll":         "0.10.0",
+	"objectKeysValues":     "0.20.0",
+	"objectKeysValuesAll":  "0.20.0",
+	"objectValues":         "0.17.0",
+	"objectValuesAll":      "0.17.0",
+	"parseHex":             "0.10.0",
+	"parseInt":             "0.10.0",
+	"parseJson":            "0.13.0",
+	"parseOctal":           "0.10.0",
+	"parseYaml":            "0.18.0",
+	"pow":                  "0.10.0",
+	"prune":                "0.10.0",
+	"range":                "0.10.0",
+	"repeat":               "0.15.0",
+	"resolvePath":          "0.10.0",
+	"reverse":              "0.13.0",
+	"round":                "0.20.0",
+	"rstripChars":          "0.15.0",
+	"set":                  "0.10.0",
+	"setDiff":              "0.10.0",
+	"setInter":             "0.10.0",
+	"setMember":            "0.10.0",
+	"setUnion":             "0.10.0",
+	"sign":                 "0.10.0",
+	"sin":                  "0.10.0",
+	"slice":                "0.10.0",
+	"sort":                 "0.10.0",
+	"split":                "0.10.0",
+	"splitLimit":           "0.10.0",
+	"splitLimitR":          "0.19.0",
+	"sqrt":                 "0.10.0",
+	"startsWith":           "0.10.0",
+	"strReplace":           "0.10.0",
+	"stringChars":          "0.10.0",
+	"stripChars":           "0.15.0",
+	"substr":               "0.10.0",
+	"sum":                  "0.20.0",
+	"tan":                  "0.10.0",
+	"thisFile":             "0.10.0",
+	"toString":             "0.10.0",
+	"trace":                "0.11.0",
+	"type":                 "0.10.0",
+	"uniq":                 "0.10.0",
+	"xnor":                 "0.20.0",
+	"xor":                  "0.20.0",
 }
 
 var StdLibValue = func(fns map[string]*Function) *Value {
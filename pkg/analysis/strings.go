@@ -0,0 +1,183 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// stringKindNames are the names of the ways a string can be written, for display.
+var stringKindNames = map[ast.LiteralStringKind]string{
+	ast.StringSingle:         "single quoted string",
+	ast.StringDouble:         "double quoted string",
+	ast.StringBlock:          "text block",
+	ast.VerbatimStringSingle: "verbatim single quoted string",
+	ast.VerbatimStringDouble: "verbatim double quoted string",
+}
+
+// StringKindName returns a readable name for the kind of string literal.
+func StringKindName(kind ast.LiteralStringKind) string {
+	return stringKindNames[kind]
+}
+
+// sourceStringKind returns how a string literal is written in the source. The kind
+// of literals is not kept by desugaring.
+func sourceStringKind(text string) (ast.LiteralStringKind, bool) {
+	switch {
+	case strings.HasPrefix(text, "'"):
+		return ast.StringSingle, true
+	case strings.HasPrefix(text, "\""):
+		return ast.StringDouble, true
+	case strings.HasPrefix(text, "@'"):
+		return ast.VerbatimStringSingle, true
+	case strings.HasPrefix(text, "@\""):
+		return ast.VerbatimStringDouble, true
+	case strings.HasPrefix(text, "|||"):
+		return ast.StringBlock, true
+	}
+	return 0, false
+}
+
+// StringLiteralAt returns the string literal at `pos`, and the kind of string it is
+// written as. Field names written as identifiers are not string literals.
+func StringLiteralAt(root ast.Node, pos ast.Location) (*ast.LiteralString, ast.LiteralStringKind, bool) {
+	stack := StackAtLoc(root, pos)
+	if len(stack) == 0 {
+		return nil, 0, false
+	}
+	lit, ok := stack[len(stack)-1].(*ast.LiteralString)
+	if !ok {
+		// the paths of imports are not visited when walking the AST
+		lit = ImportFile(stack[len(stack)-1])
+	}
+	if lit == nil || !lit.LocRange.IsSet() {
+		return nil, 0, false
+	}
+	kind, ok := sourceStringKind(rangeSource(lit.LocRange))
+	if !ok {
+		return nil, 0, false
+	}
+	return lit, kind, true
+}
+
+// QuoteString writes `value` as a string literal of the given kind. Returns false if the
+// value cannot be written as that kind.
+func QuoteString(value string, kind ast.LiteralStringKind) (string, bool) {
+	switch kind {
+	case ast.StringSingle, ast.StringDouble:
+		quote := byte('\'')
+		if kind == ast.StringDouble {
+			quote = '"'
+		}
+		sb := strings.Builder{}
+		sb.WriteByte(quote)
+		for _, r := range value {
+			switch {
+			case r == rune(quote) || r == '\\':
+				sb.WriteByte('\\')
+				sb.WriteRune(r)
+			case r == '\n':
+				sb.WriteString(`\n`)
+			case r == '\t':
+				sb.WriteString(`\t`)
+			case r == '\r':
+				sb.WriteString(`\r`)
+			case r == '\b':
+				sb.WriteString(`\b`)
+			case r == '\f':
+				sb.WriteString(`\f`)
+			case r < 0x20:
+				sb.WriteString(fmt.Sprintf(`\u%04x`, r))
+			default:
+				sb.WriteRune(r)
+			}
+		}
+		sb.WriteByte(quote)
+		return sb.String(), true
+	case ast.VerbatimStringSingle, ast.VerbatimStringDouble:
+		// verbatim strings have no escapes, control characters would be written as is
+		if strings.IndexFunc(value, func(r rune) bool { return r < 0x20 }) >= 0 {
+			return "", false
+		}
+		quote := "'"
+		if kind == ast.VerbatimStringDouble {
+			quote = "\""
+		}
+		return "@" + quote + strings.ReplaceAll(value, quote, quote+quote) + quote, true
+	}
+	return "", false
+}
+
+// maxInlineBlockLines is the most lines a text block can have to be offered to be
+// converted to a quoted string.
+const maxInlineBlockLines = 5
+
+// textBlock writes `value` as a text block, with the lines indented one `unit` more than
+// `indent`. Returns false if the value cannot be written as a text block: it must end
+// with a newline, the first line cannot start with whitespace as that would be taken as
+// indentation, and no line can start with `|||` as that would end the block.
+func textBlock(value, indent, unit string) (string, bool) {
+	if !strings.HasSuffix(value, "\n") || strings.TrimLeft(value, " \t\n") != value {
+		return "", false
+	}
+	lines := strings.Split(strings.TrimSuffix(value, "\n"), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimLeft(line, " \t"), "|||") {
+			return "", false
+		}
+	}
+	if strings.IndexFunc(value, func(r rune) bool { return r < 0x20 && r != '\n' && r != '\t' }) >= 0 {
+		return "", false
+	}
+	sb := strings.Builder{}
+	sb.WriteString("|||\n")
+	for _, line := range lines {
+		if line != "" {
+			sb.WriteString(indent + unit + line)
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString(indent + "|||")
+	return sb.String(), true
+}
+
+// StringConversions returns the kinds a string literal can be converted to: the other
+// quote, to or from a verbatim string, to a text block if it has multiple lines, and
+// from a text block if it is short.
+func StringConversions(value string, kind ast.LiteralStringKind) []ast.LiteralStringKind {
+	res := []ast.LiteralStringKind{}
+	switch kind {
+	case ast.StringSingle:
+		res = append(res, ast.StringDouble, ast.VerbatimStringSingle)
+	case ast.StringDouble:
+		res = append(res, ast.StringSingle, ast.VerbatimStringDouble)
+	case ast.VerbatimStringSingle:
+		res = append(res, ast.VerbatimStringDouble, ast.StringSingle)
+	case ast.VerbatimStringDouble:
+		res = append(res, ast.VerbatimStringSingle, ast.StringDouble)
+	case ast.StringBlock:
+		if strings.Count(value, "\n") <= maxInlineBlockLines {
+			res = append(res, ast.StringSingle, ast.StringDouble)
+		}
+		return res
+	}
+	if strings.Contains(value, "\n") {
+		res = append(res, ast.StringBlock)
+	}
+	return res
+}
+
+// ConvertString writes the value of a string literal as the kind `to`. Text blocks are
+// indented one `unit` more than the line the literal starts on. Returns false if the
+// value cannot be written as that kind.
+func ConvertString(lit *ast.LiteralString, to ast.LiteralStringKind, unit string) (string, bool) {
+	if to != ast.StringBlock {
+		return QuoteString(lit.Value, to)
+	}
+	indent := ""
+	if file := lit.LocRange.File; file != nil && lit.LocRange.Begin.Line <= len(file.Lines) {
+		indent = leadingSpace(file.Lines[lit.LocRange.Begin.Line-1])
+	}
+	return textBlock(lit.Value, indent, unit)
+}
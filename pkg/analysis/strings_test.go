@@ -0,0 +1,90 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringLiteralAt(t *testing.T) {
+	source := "local a = import 'a.libsonnet';\n{ 'b': @\"c\", d: \"e\" }"
+	resolver, _ := newAnonMockResolver(t, source)
+	cases := []struct {
+		Line, Column int
+		Value        string
+		Kind         ast.LiteralStringKind
+	}{
+		{1, 20, "a.libsonnet", ast.StringSingle},
+		{2, 4, "b", ast.StringSingle},
+		{2, 10, "c", ast.VerbatimStringDouble},
+		{2, 18, "e", ast.StringDouble},
+	}
+	for _, tc := range cases {
+		lit, kind, ok := StringLiteralAt(resolver.root, ast.Location{Line: tc.Line, Column: tc.Column})
+		require.True(t, ok)
+		assert.Equal(t, tc.Value, lit.Value)
+		assert.Equal(t, tc.Kind, kind)
+	}
+
+	// identifier field names are not string literals
+	_, _, ok := StringLiteralAt(resolver.root, ast.Location{Line: 2, Column: 15})
+	assert.False(t, ok)
+}
+
+func TestQuoteString(t *testing.T) {
+	values := []string{"plain", `it's "quoted"`, `back\slash`, "new\nline\ttab", "\x01", "ünï"}
+	kinds := []ast.LiteralStringKind{ast.StringSingle, ast.StringDouble, ast.VerbatimStringSingle, ast.VerbatimStringDouble}
+	for _, value := range values {
+		for _, kind := range kinds {
+			quoted, ok := QuoteString(value, kind)
+			if !ok {
+				continue
+			}
+			resolver, _ := newAnonMockResolver(t, quoted)
+			lit, ok := resolver.root.(*ast.LiteralString)
+			require.True(t, ok, quoted)
+			assert.Equal(t, value, lit.Value, quoted)
+		}
+	}
+
+	quoted, _ := QuoteString(`it's "quoted"`, ast.StringSingle)
+	assert.Equal(t, `'it\'s "quoted"'`, quoted)
+	quoted, _ = QuoteString(`it's "quoted"`, ast.VerbatimStringDouble)
+	assert.Equal(t, `@"it's ""quoted"""`, quoted)
+	_, ok := QuoteString("new\nline", ast.VerbatimStringSingle)
+	assert.False(t, ok)
+}
+
+func TestConvertString(t *testing.T) {
+	source := "{\n  a: 'line one\\n\\n  line two\\n',\n  b: |||\n    block\n  |||,\n}"
+	resolver, _ := newAnonMockResolver(t, source)
+
+	lit, kind, ok := StringLiteralAt(resolver.root, ast.Location{Line: 2, Column: 6})
+	require.True(t, ok)
+	assert.Contains(t, StringConversions(lit.Value, kind), ast.StringBlock)
+	block, ok := ConvertString(lit, ast.StringBlock, "  ")
+	require.True(t, ok)
+	assert.Equal(t, "|||\n    line one\n\n      line two\n  |||", block)
+	converted, _ := newAnonMockResolver(t, "{\n  a: "+block+",\n}")
+	assert.Equal(t, lit.Value, converted.root.(*ast.DesugaredObject).Fields[0].Body.(*ast.LiteralString).Value)
+
+	lit, kind, ok = StringLiteralAt(resolver.root, ast.Location{Line: 4, Column: 6})
+	require.True(t, ok)
+	assert.Equal(t, ast.StringBlock, kind)
+	assert.Equal(t, []ast.LiteralStringKind{ast.StringSingle, ast.StringDouble}, StringConversions(lit.Value, kind))
+	quoted, ok := ConvertString(lit, ast.StringSingle, "  ")
+	require.True(t, ok)
+	assert.Equal(t, `'block\n'`, quoted)
+
+	// the first line would be taken as indentation
+	_, ok = textBlock("  indented\n", "", "  ")
+	assert.False(t, ok)
+	// text blocks always end with a newline
+	_, ok = textBlock("no newline", "", "  ")
+	assert.False(t, ok)
+	// a line starting with ||| would end the block
+	_, ok = textBlock("a\n  ||| b\n", "", "  ")
+	assert.False(t, ok)
+}
@@ -0,0 +1,106 @@
+package analysis
+
+import (
+	"github.com/google/go-jsonnet/ast"
+)
+
+type SymbolKind int
+
+const (
+	LocalSymbol SymbolKind = 0
+	FieldSymbol SymbolKind = 1
+)
+
+// Symbol is a named definition in a document, used to build an outline of the file.
+type Symbol struct {
+	Name   string
+	Kind   SymbolKind
+	Type   ValueType
+	Detail string
+	// Range covers the whole definition, and NameRange only the name. NameRange is
+	// always contained in Range.
+	Range     ast.LocationRange
+	NameRange ast.LocationRange
+	Hidden    bool
+	Node      ast.Node
+	Children  []Symbol
+}
+
+func symbolDetail(body ast.Node) (ValueType, string) {
+	if fn, ok := body.(*ast.Function); ok {
+		return FunctionType, "function" + functionToValue(fn).Function.String()
+	}
+	tp, _ := simpleToValueType(body)
+	return tp, tp.String()
+}
+
+func bindSymbol(b ast.LocalBind) (Symbol, bool) {
+	decl := bindDecl(b)
+	rng := b.LocRange
+	if !rng.IsSet() && decl.Body != nil && decl.Body.Loc() != nil {
+		rng = *decl.Body.Loc()
+	}
+	if !rng.IsSet() || !decl.Range.IsSet() {
+		return Symbol{}, false
+	}
+	tp, detail := symbolDetail(decl.Body)
+	return Symbol{
+		Name:      decl.Name,
+		Kind:      LocalSymbol,
+		Type:      tp,
+		Detail:    detail,
+		Range:     rng,
+		NameRange: decl.Range,
+		Node:      decl.Body,
+		Children:  DocumentSymbols(decl.Body),
+	}, true
+}
+
+// DocumentSymbols returns a tree of the locals and object fields defined in `node`.
+// Locals and fields nested in definitions are returned as children of the definition.
+func DocumentSymbols(node ast.Node) []Symbol {
+	res := []Symbol{}
+	switch n := node.(type) {
+	case *ast.Local:
+		for _, b := range n.Binds {
+			if sym, ok := bindSymbol(b); ok {
+				res = append(res, sym)
+			}
+		}
+		res = append(res, DocumentSymbols(n.Body)...)
+	case *ast.DesugaredObject:
+		for _, b := range n.Locals {
+			if sym, ok := bindSymbol(b); ok {
+				res = append(res, sym)
+			}
+		}
+		for i, fld := range n.Fields {
+			name, nameRng, ok := FieldNameRange(&n.Fields[i])
+			if !ok || !fld.LocRange.IsSet() {
+				continue
+			}
+			tp, detail := symbolDetail(fld.Body)
+			res = append(res, Symbol{
+				Name:      name,
+				Kind:      FieldSymbol,
+				Type:      tp,
+				Detail:    detail,
+				Range:     fld.LocRange,
+				NameRange: nameRng,
+				Hidden:    fld.Hide == ast.ObjectFieldHidden,
+				Node:      fld.Body,
+				Children:  DocumentSymbols(fld.Body),
+			})
+		}
+	case *ast.Function:
+		res = append(res, DocumentSymbols(n.Body)...)
+	case *ast.Binary:
+		// object mixins, f.ex `base + { ... }`
+		res = append(res, DocumentSymbols(n.Left)...)
+		res = append(res, DocumentSymbols(n.Right)...)
+	case *ast.Conditional:
+		res = append(res, DocumentSymbols(n.BranchTrue)...)
+		res = append(res, DocumentSymbols(n.BranchFalse)...)
+	}
+	return res
+}
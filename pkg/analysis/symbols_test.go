@@ -0,0 +1,62 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// symbolTree flattens a symbol tree into "parent.child" names for easy comparison.
+func symbolTree(prefix string, syms []Symbol) []string {
+	res := []string{}
+	for _, sym := range syms {
+		res = append(res, prefix+sym.Name)
+		res = append(res, symbolTree(prefix+sym.Name+".", sym.Children)...)
+	}
+	return res
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	source := `local a = 1;
+local fn(x, y=2) = { inner: x };
+local base = { b: 1 };
+base + {
+  local hidden = 3,
+  obj: {
+    nested: 'str',
+    'quoted': null,
+  },
+  method(z):: local tmp = z; tmp,
+}`
+	resolver, _ := newAnonMockResolver(t, source)
+	syms := DocumentSymbols(resolver.root)
+
+	assert.Equal(t, []string{
+		"a", "fn", "fn.inner", "base", "base.b",
+		"hidden", "obj", "obj.nested", "obj.quoted", "method", "method.tmp",
+	}, symbolTree("", syms))
+
+	require.Len(t, syms, 6)
+	assert.Equal(t, LocalSymbol, syms[0].Kind)
+	assert.Equal(t, NumberType, syms[0].Type)
+	assert.Equal(t, valueRange{1, 7, 1, 8}, rangeToTestRange(syms[0].NameRange))
+
+	assert.Equal(t, FunctionType, syms[1].Type)
+	assert.Equal(t, "function(x, y=2) -> object", syms[1].Detail)
+	assert.Equal(t, valueRange{2, 7, 2, 9}, rangeToTestRange(syms[1].NameRange))
+
+	obj := syms[4]
+	assert.Equal(t, FieldSymbol, obj.Kind)
+	assert.Equal(t, ObjectType, obj.Type)
+	assert.Equal(t, valueRange{6, 3, 6, 6}, rangeToTestRange(obj.NameRange))
+	assert.Equal(t, valueRange{8, 5, 8, 13}, rangeToTestRange(obj.Children[1].NameRange))
+
+	method := syms[5]
+	assert.True(t, method.Hidden)
+	assert.Equal(t, FunctionType, method.Type)
+
+	for _, sym := range syms {
+		assert.True(t, LocInRange(sym.Range, sym.NameRange.Begin), "name of %s not in its range", sym.Name)
+	}
+}
@@ -81,7 +81,58 @@ func (p *Param) String() string {
 		res += ": " + p.Type.String()
 	}
 	if p.Default != nil {
-		res += "=null"
+		res += "=" + defaultString(p.Default)
+	}
+	return res
+}
+
+// maxDefaultLen limits how much of a default argument is shown in signatures.
+const maxDefaultLen = 32
+
+// nodeSource returns the source text of a node, or an empty string if the node was
+// not parsed from a file (f.ex desugared nodes or stdlib definitions).
+func nodeSource(node ast.Node) string {
+	loc := node.Loc()
+	if loc == nil {
+		return ""
+	}
+	return rangeSource(*loc)
+}
+
+// rangeSource returns the source text in the range, or an empty string if the range
+// has no source file.
+func rangeSource(loc ast.LocationRange) string {
+	if !loc.IsSet() || loc.File == nil || loc.End.Line > len(loc.File.Lines) {
+		return ""
+	}
+	lines := loc.File.Lines[loc.Begin.Line-1 : loc.End.Line]
+	text := strings.Join(lines, "")
+	begin := loc.Begin.Column - 1
+	end := len(text) - len(lines[len(lines)-1]) + loc.End.Column - 1
+	if begin < 0 || end > len(text) || begin > end {
+		return ""
+	}
+	return text[begin:end]
+}
+
+func defaultString(node ast.Node) string {
+	res := strings.Join(strings.Fields(nodeSource(node)), " ")
+	if res == "" {
+		switch node := node.(type) {
+		case *ast.LiteralNull:
+			res = "null"
+		case *ast.LiteralBoolean:
+			res = strconv.FormatBool(node.Value)
+		case *ast.LiteralNumber:
+			res = node.OriginalString
+		case *ast.LiteralString:
+			res = strconv.Quote(node.Value)
+		default:
+			res = "..."
+		}
+	}
+	if len(res) > maxDefaultLen {
+		res = res[:maxDefaultLen] + "..."
 	}
 	return res
 }
@@ -108,13 +159,26 @@ func (f *Function) String() string {
 	return res
 }
 
+// Arity returns the number of arguments a call of the function needs, and the number it
+// accepts. Parameters with a default value are optional.
+func (f *Function) Arity() (required, total int) {
+	for _, param := range f.Params {
+		if param.Default == nil {
+			required++
+		}
+	}
+	return required, len(f.Params)
+}
+
 type Field struct {
-	Name    string            `json:"name,omitempty"`
-	Type    ValueType         `json:"type"`
-	Range   ast.LocationRange `json:"-"`
-	Comment []string          `json:"comment,omitempty"`
-	Hidden  bool              `json:"hidden,omitempty"`
-	Node    ast.Node          `json:"-"`
+	Name  string            `json:"name,omitempty"`
+	Type  ValueType         `json:"type"`
+	Range ast.LocationRange `json:"-"`
+	// The range of the field name where the field was declared
+	NameRange ast.LocationRange `json:"-"`
+	Comment   []string          `json:"comment,omitempty"`
+	Hidden    bool              `json:"hidden,omitempty"`
+	Node      ast.Node          `json:"-"`
 }
 
 type Object struct {
@@ -180,6 +244,7 @@ func functionToValue(node *ast.Function) *Value {
 	_, res.Function.Return = UnwindLocals(node.Body)
 	res.Function.ReturnType, _ = simpleToValueType(res.Function.Return)
 
+	inferred := paramTypes(node)
 	for i, param := range node.Parameters {
 		var comments []string
 		if i+1 == len(node.Parameters) {
@@ -195,6 +260,10 @@ func functionToValue(node *ast.Function) *Value {
 			Comment: comments,
 			Type:    commentsToType(comments),
 		}
+		if res.Function.Params[i].Type == AnyType {
+			// without a type hint, the type is inferred from the uses of the parameter
+			res.Function.Params[i].Type = inferred[string(param.Name)]
+		}
 	}
 
 	return res
@@ -212,7 +281,7 @@ func objectToValue(node *ast.DesugaredObject, resolver Resolver) *Value {
 	}
 
 	unknownFields := false
-	for _, fld := range node.Fields {
+	for i, fld := range node.Fields {
 		fieldName := ""
 
 		if nt, ok := fld.Name.(*ast.LiteralString); ok {
@@ -231,18 +300,52 @@ func objectToValue(node *ast.DesugaredObject, resolver Resolver) *Value {
 			rng = *fldfn.Body.Loc()
 		}
 
+		_, nameRng, _ := FieldNameRange(&node.Fields[i])
+		defRng := nameRng
+		if !defRng.IsSet() {
+			defRng = fld.LocRange
+		}
+
 		res.Object.Fields = append(res.Object.Fields, Field{
-			Name:    fieldName,
-			Type:    ft,
-			Comment: foddersToComment(fld.Body), // XXX: Name comments?
-			Range:   rng,
-			Node:    fld.Body,
-			Hidden:  fld.Hide == ast.ObjectFieldHidden,
+			Name:      fieldName,
+			Type:      ft,
+			Comment:   append(leadingComment(defRng), foddersToComment(fld.Body)...),
+			Range:     rng,
+			NameRange: nameRng,
+			Node:      fld.Body,
+			Hidden:    fld.Hide == ast.ObjectFieldHidden,
 		})
-		res.Object.FieldMap[fieldName] = &(res.Object.Fields[len(res.Object.Fields)-1])
 	}
 	res.Object.AllFieldsKnown = !unknownFields
 
+	// docsonnet documents a field in the field `#name`, and the object in `#`
+	index := map[string]int{}
+	for i := range res.Object.Fields {
+		index[res.Object.Fields[i].Name] = i
+	}
+	for _, fld := range res.Object.Fields {
+		if !IsDocField(fld) {
+			continue
+		}
+		doc, typ, ok := docsonnetComment(fld.Node)
+		if !ok {
+			continue
+		}
+		if fld.Name == "#" {
+			res.Comment = doc
+			continue
+		}
+		if i, ok := index[fld.Name[1:]]; ok {
+			res.Object.Fields[i].Comment = doc
+			if res.Object.Fields[i].Type == AnyType {
+				res.Object.Fields[i].Type = typ
+			}
+		}
+	}
+	for i := range res.Object.Fields {
+		res.Object.FieldMap[res.Object.Fields[i].Name] = &res.Object.Fields[i]
+	}
+
 	return res
 }
 
@@ -376,6 +479,30 @@ type Resolver interface {
 	Import(from, path string) ast.Node
 }
 
+// NativeResolver is a resolver that knows the native functions registered in the VM, which
+// `std.native` returns.
+type NativeResolver interface {
+	Resolver
+	// NativeFunction returns the native function `name`. Returns false if it is not
+	// registered, and true with a nil function if it is not known whether it is.
+	NativeFunction(name string) (*Function, bool)
+}
+
+// nativeFunction returns the native function a call to `std.native` returns, nil if it is
+// not known.
+func nativeFunction(apply *ast.Apply, resolver Resolver) *Function {
+	natives, ok := resolver.(NativeResolver)
+	if !ok {
+		return nil
+	}
+	name, ok := NativeName(apply)
+	if !ok {
+		return nil
+	}
+	fn, _ := natives.NativeFunction(name.Value)
+	return fn
+}
+
 var maxStackDepth = 300
 
 func nodeToValue(node ast.Node, resolver Resolver, stackDepth int) (res *Value) {
@@ -431,11 +558,20 @@ func nodeToValue(node ast.Node, resolver Resolver, stackDepth int) (res *Value)
 		}
 
 		v := resolver.Vars(node).Get(string(node.Id))
+		if v != nil && v.Node != nil {
+			return withComment(nodeToValue(v.Node, resolver, stackDepth + 1), leadingComment(v.Loc))
+		}
+		return defaultToValue(node)
+	case *ast.Self:
+		v := resolver.Vars(node).Get("self")
 		if v != nil && v.Node != nil {
 			return nodeToValue(v.Node, resolver, stackDepth + 1)
 		}
 		return defaultToValue(node)
 	case *ast.Apply:
+		if fn := nativeFunction(node, resolver); fn != nil {
+			return &Value{Type: FunctionType, Node: node, Range: node.LocRange, Comment: fn.Comment, Function: fn}
+		}
 		targfn := nodeToValue(node.Target, resolver, stackDepth + 1)
 		if targfn.Function == nil || targfn.Function.Return == nil {
 			return defaultToValue(node)
@@ -470,7 +606,8 @@ func nodeToValue(node ast.Node, resolver Resolver, stackDepth int) (res *Value)
 
 			// object dotted access
 			if lhs.Object != nil && lhs.Object.FieldMap[idx.Value] != nil {
-				return nodeToValue(lhs.Object.FieldMap[idx.Value].Node, resolver, stackDepth + 1)
+				fld := lhs.Object.FieldMap[idx.Value]
+				return withComment(nodeToValue(fld.Node, resolver, stackDepth + 1), fld.Comment)
 			}
 		}
 		return defaultToValue(node)
@@ -481,6 +618,15 @@ func nodeToValue(node ast.Node, resolver Resolver, stackDepth int) (res *Value)
 			if lhs.Object != nil && rhs.Object != nil {
 				return mergeObjectValues(lhs, rhs)
 			}
+			// mixins of unknown values (f.ex function parameters) keep the fields of the
+			// known side, although not all fields are known
+			unknown := &Value{Type: ObjectType, Range: node.LocRange, Node: node, Object: &Object{FieldMap: map[string]*Field{}}}
+			if lhs.Object != nil && rhs.Type == AnyType {
+				return mergeObjectValues(lhs, unknown)
+			}
+			if lhs.Type == AnyType && rhs.Object != nil {
+				return mergeObjectValues(unknown, rhs)
+			}
 			// resolve the addition of strings, which is a common operation that affects
 			// lookup resolution
 			if lhs.StringValue != nil && rhs.StringValue != nil {
@@ -508,3 +654,55 @@ func nodeToValue(node ast.Node, resolver Resolver, stackDepth int) (res *Value)
 func NodeToValue(node ast.Node, resolver Resolver) (res *Value) {
 	return nodeToValue(node, resolver, 0)
 }
+
+// innerObjectIndex returns the index of the innermost object in the stack, or -1.
+func innerObjectIndex(stack []ast.Node) int {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if _, ok := stack[i].(*ast.DesugaredObject); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+func isObjectPlus(node ast.Node) bool {
+	bin, ok := node.(*ast.Binary)
+	return ok && bin.Op == ast.BopPlus
+}
+
+// SelfValue returns the value of `self` at the end of the stack: the innermost object,
+// with the fields of the objects it is mixed with. Returns nil outside of objects.
+func SelfValue(stack []ast.Node, resolver Resolver) *Value {
+	i := innerObjectIndex(stack)
+	if i < 0 {
+		return nil
+	}
+	top := i
+	for top > 0 && isObjectPlus(stack[top-1]) {
+		top--
+	}
+	if top < i {
+		if val := NodeToValue(stack[top], resolver); val.Object != nil {
+			return val
+		}
+	}
+	return NodeToValue(stack[i], resolver)
+}
+
+// SuperValue returns the value of `super` at the end of the stack: the object the
+// innermost object is mixed into (`base + { ... }`). Returns nil if it is not known.
+func SuperValue(stack []ast.Node, resolver Resolver) *Value {
+	i := innerObjectIndex(stack)
+	if i < 1 {
+		return nil
+	}
+	bin, ok := stack[i-1].(*ast.Binary)
+	if !ok || bin.Op != ast.BopPlus || bin.Right != stack[i] {
+		return nil
+	}
+	val := NodeToValue(bin.Left, resolver)
+	if val.Object == nil {
+		return nil
+	}
+	return val
+}
@@ -161,3 +161,65 @@ func valueToTestResult(v *Value) valueResult {
 		Comment: v.Comment,
 	}
 }
+
+func fieldNames(v *Value) []string {
+	res := []string{}
+	for _, fld := range v.Object.Fields {
+		res = append(res, fld.Name)
+	}
+	return res
+}
+
+func TestSelfAndSuperValue(t *testing.T) {
+	source := "local base = { a: 1 };\nbase + { b: self.a, c: super.a, d: { e: self.e, f: super.a } } + { g: 1 }"
+	resolver, _ := newAnonMockResolver(t, source)
+
+	// in the mixin, self has the fields of every object it is mixed with
+	_, stack := resolver.NodeAt(ast.Location{Line: 2, Column: 14})
+	require.NotNil(t, SelfValue(stack, resolver))
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d", "g"}, fieldNames(SelfValue(stack, resolver)))
+	require.NotNil(t, SuperValue(stack, resolver))
+	assert.ElementsMatch(t, []string{"a"}, fieldNames(SuperValue(stack, resolver)))
+
+	// a nested object is not mixed into anything
+	_, stack = resolver.NodeAt(ast.Location{Line: 2, Column: 42})
+	require.NotNil(t, SelfValue(stack, resolver))
+	assert.ElementsMatch(t, []string{"e", "f"}, fieldNames(SelfValue(stack, resolver)))
+	assert.Nil(t, SuperValue(stack, resolver))
+
+	// outside of objects
+	_, stack = resolver.NodeAt(ast.Location{Line: 1, Column: 7})
+	assert.Nil(t, SelfValue(stack, resolver))
+	assert.Nil(t, SuperValue(stack, resolver))
+}
+
+type nativeMockResolver struct {
+	*mockResolver
+	natives map[string]*Function
+}
+
+func (r *nativeMockResolver) NativeFunction(name string) (*Function, bool) {
+	fn, ok := r.natives[name]
+	return fn, ok
+}
+
+func TestNativeFunction(t *testing.T) {
+	mock, out := newAnonMockResolver(t, "local sha = std.native('sha256'); [sha, std.native('missing'), std.native('sha' + '256')]")
+	resolver := &nativeMockResolver{mockResolver: mock, natives: map[string]*Function{
+		"sha256": {Params: []Param{{Name: "str"}}},
+	}}
+	arr, ok := out.(*ast.Array)
+	require.True(t, ok)
+	require.Len(t, arr.Elements, 3)
+
+	sha := NodeToValue(arr.Elements[0].Expr, resolver)
+	assert.Equal(t, FunctionType, sha.Type)
+	require.NotNil(t, sha.Function)
+	assert.Equal(t, "(str)", sha.Function.String())
+
+	// unknown natives and names that are not constant have no signature
+	assert.Nil(t, NodeToValue(arr.Elements[1].Expr, resolver).Function)
+	assert.Nil(t, NodeToValue(arr.Elements[2].Expr, resolver).Function)
+	// resolvers that do not know the natives are not asked
+	assert.Nil(t, NodeToValue(arr.Elements[0].Expr, mock).Function)
+}
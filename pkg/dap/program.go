@@ -0,0 +1,193 @@
+package dap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// valueSnippet evaluates an expression in the scope of the top level locals, functions
+// are shown by their type as they cannot be manifested.
+const valueSnippet = `%s
+local __value = (%s);
+if std.isFunction(__value) then '<function>' else __value
+`
+
+// step is a part of the program the debugger stops before: a top level import, a top
+// level field, or the manifestation of the whole output.
+type step struct {
+	name string
+	loc  ast.LocationRange
+	// expr is evaluated and forced to run the step, the whole program is evaluated if it
+	// is empty
+	expr string
+}
+
+type program struct {
+	path string
+	root ast.Node
+	// prefix is the source of the top level locals, expressions are evaluated after it to
+	// be in their scope
+	prefix string
+	locals []string
+	steps  []step
+}
+
+func loadProgram(path string) (*program, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	contents := string(data)
+	root, err := jsonnet.SnippetToAST(path, contents)
+	if err != nil {
+		return nil, err
+	}
+	p := &program{path: path, root: root}
+
+	// the imports of the top level locals
+	body := root
+	for {
+		local, ok := body.(*ast.Local)
+		if !ok {
+			break
+		}
+		for _, b := range local.Binds {
+			p.locals = append(p.locals, string(b.Variable))
+			if imp, ok := b.Body.(*ast.Import); ok {
+				lit, _ := json.Marshal(imp.File.Value)
+				loc := b.LocRange
+				if !loc.IsSet() {
+					loc = imp.LocRange
+				}
+				p.steps = append(p.steps, step{name: "import " + string(lit), loc: loc, expr: "import " + string(lit)})
+			}
+		}
+		body = local.Body
+	}
+	if loc := body.Loc(); loc != nil && loc.IsSet() {
+		p.prefix = contents[:locOffset(contents, loc.Begin)]
+	}
+
+	// the visible fields of an object
+	self, _ := json.Marshal(filepath.Base(path))
+	if obj, ok := body.(*ast.DesugaredObject); ok {
+		for _, fld := range obj.Fields {
+			name, ok := fld.Name.(*ast.LiteralString)
+			if !ok || fld.Hide == ast.ObjectFieldHidden {
+				continue
+			}
+			lit, _ := json.Marshal(name.Value)
+			p.steps = append(p.steps, step{name: name.Value, loc: fld.LocRange, expr: fmt.Sprintf("(import %s)[%s]", self, lit)})
+		}
+	}
+	p.steps = append(p.steps, step{name: "manifest", loc: *body.Loc()})
+	return p, nil
+}
+
+// run runs a step, and returns the output of the program for the last step.
+func (p *program) run(vm *jsonnet.VM, st step) (string, error) {
+	if st.expr == "" {
+		return vm.Evaluate(p.root)
+	}
+	return p.evaluate(vm, analysis.ForceSnippet(st.expr))
+}
+
+// value evaluates an expression in the scope of the top level locals, and returns its
+// value decoded from JSON.
+func (p *program) value(vm *jsonnet.VM, expr string) (interface{}, error) {
+	out, err := p.evaluate(vm, fmt.Sprintf(valueSnippet, p.prefix, expr))
+	if err != nil {
+		return nil, err
+	}
+	var res interface{}
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// evaluate evaluates code as if it was the program, imports are relative to it.
+func (p *program) evaluate(vm *jsonnet.VM, snippet string) (string, error) {
+	node, err := jsonnet.SnippetToAST(p.path, snippet)
+	if err != nil {
+		return "", err
+	}
+	return vm.Evaluate(node)
+}
+
+// stepAt returns the step of a line of the program.
+func (p *program) stepAt(line int) (int, bool) {
+	for i, st := range p.steps {
+		if st.loc.Begin.Line <= line && line <= st.loc.End.Line {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// sourceLocals returns the variables in scope at a location of a file, with the source of
+// their definition. Their values are not known outside of the top level.
+func sourceLocals(loc ast.LocationRange) []variable {
+	if loc.File == nil {
+		return nil
+	}
+	root, err := jsonnet.SnippetToAST(loc.FileName, strings.Join(loc.File.Lines, ""))
+	if err != nil {
+		return nil
+	}
+	vars := analysis.StackVars(analysis.StackAtLoc(root, loc.Begin))
+	res := []variable{}
+	for name, v := range vars {
+		if name == "std" || name == "$std" || name == "self" || name == "$" || v.Node == nil {
+			continue
+		}
+		res = append(res, variable{Name: name, Value: nodeText(v.Node), Type: v.Type.String()})
+	}
+	sortVariables(res)
+	return res
+}
+
+// nodeText returns the first line of the source of a node.
+func nodeText(node ast.Node) string {
+	loc := node.Loc()
+	if loc == nil || loc.File == nil || loc.Begin.Line < 1 || loc.Begin.Line > len(loc.File.Lines) {
+		return ""
+	}
+	line := loc.File.Lines[loc.Begin.Line-1]
+	text := strings.TrimRight(line[min(loc.Begin.Column-1, len(line)):], "\r\n")
+	if loc.End.Line == loc.Begin.Line && loc.End.Column-loc.Begin.Column <= len(text) {
+		return text[:loc.End.Column-loc.Begin.Column]
+	}
+	return text + " ..."
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// locOffset returns the byte offset of a location in the contents.
+func locOffset(contents string, loc ast.Location) int {
+	offset := 0
+	for line := 1; line < loc.Line; line++ {
+		i := strings.IndexByte(contents[offset:], '\n')
+		if i < 0 {
+			return len(contents)
+		}
+		offset += i + 1
+	}
+	return min(offset+loc.Column-1, len(contents))
+}
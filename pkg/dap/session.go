@@ -0,0 +1,428 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+)
+
+// The debugger cannot pause the jsonnet VM in the middle of an evaluation. Instead it
+// runs the program one step at a time: each top level import, each visible top level
+// field, and finally the manifestation of the output. The VM keeps the values it has
+// evaluated, so every step only evaluates what the previous ones have not.
+//
+// When a step fails, the debugger stops on the runtime error with its stack trace. The
+// top level locals can be inspected and expressions evaluated in their scope at every stop.
+
+// threadID is the only thread of the debugger.
+const threadID = 1
+
+// maxValueLen limits the length of values shown in the variables view.
+const maxValueLen = 200
+
+// Session is a debugging session with a single client.
+type Session struct {
+	in   *bufio.Reader
+	out  io.Writer
+	lock sync.Mutex
+	seq  int
+
+	launch      launchArguments
+	program     *program
+	vm          *jsonnet.VM
+	breakpoints map[int]bool
+	// next is the step the program is stopped before
+	next int
+	// stopErr is the runtime error the program is stopped on
+	stopErr *jsonnet.RuntimeError
+	// the values shown in the variables view, referenced by their index + 1. They are
+	// valid until the program continues.
+	handles []interface{}
+	// a step of the program failed, the program exits with an error
+	failed bool
+	done   bool
+}
+
+// topLevelScope refers to the top level locals of the program, evaluated when shown.
+type topLevelScope struct{}
+
+// NewSession creates a session that reads requests from `in` and writes responses and
+// events to `out`.
+func NewSession(in io.Reader, out io.Writer) *Session {
+	return &Session{in: bufio.NewReader(in), out: out, breakpoints: map[int]bool{}}
+}
+
+// Run handles the requests of the client until it disconnects.
+func (s *Session) Run() error {
+	for {
+		req, err := readRequest(s.in)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		body, err := s.handle(req)
+		resp := &response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: body}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		s.send(resp)
+
+		// the program runs after the client knows the request succeeded
+		if err == nil {
+			switch req.Command {
+			case "launch":
+				// breakpoints are set once the program is loaded
+				s.event("initialized", nil)
+			case "configurationDone":
+				if s.launch.StopOnEntry {
+					s.stop("entry", "")
+				} else if s.breakpoints[0] {
+					s.stop("breakpoint", "")
+				} else {
+					s.resume(false)
+				}
+			case "continue":
+				s.resume(false)
+			case "next", "stepIn", "stepOut":
+				s.resume(true)
+			case "disconnect", "terminate":
+				return nil
+			}
+		}
+	}
+}
+
+func (s *Session) handle(req *request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return &capabilities{SupportsConfigurationDoneRequest: true, SupportsTerminateRequest: true}, nil
+	case "launch":
+		if err := json.Unmarshal(req.Arguments, &s.launch); err != nil {
+			return nil, err
+		}
+		return nil, s.load()
+	case "setBreakpoints":
+		args := &setBreakpointsArguments{}
+		if err := json.Unmarshal(req.Arguments, args); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"breakpoints": s.setBreakpoints(args)}, nil
+	case "setExceptionBreakpoints", "configurationDone", "continue", "next", "stepIn", "stepOut", "disconnect", "terminate":
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{"threads": []thread{{ID: threadID, Name: "main"}}}, nil
+	case "stackTrace":
+		frames := s.stackTrace()
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+	case "scopes":
+		args := &frameArguments{}
+		if err := json.Unmarshal(req.Arguments, args); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"scopes": s.scopes(args.FrameID)}, nil
+	case "variables":
+		args := &variablesArguments{}
+		if err := json.Unmarshal(req.Arguments, args); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"variables": s.variables(args.VariablesReference)}, nil
+	case "evaluate":
+		args := &evaluateArguments{}
+		if err := json.Unmarshal(req.Arguments, args); err != nil {
+			return nil, err
+		}
+		if s.program == nil {
+			return nil, fmt.Errorf("no program is running")
+		}
+		val, err := s.program.value(s.vm, args.Expression)
+		if err != nil {
+			return nil, errors.New(strings.TrimSpace(err.Error()))
+		}
+		v := s.variable("", val)
+		return map[string]interface{}{"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}, nil
+	}
+	return nil, fmt.Errorf("unsupported request %s", req.Command)
+}
+
+// load parses the program and creates its VM.
+func (s *Session) load() error {
+	prog, err := loadProgram(s.launch.Program)
+	if err != nil {
+		return err
+	}
+	s.program = prog
+	s.vm = jsonnet.MakeVM()
+	s.vm.Importer(&jsonnet.FileImporter{JPaths: s.launch.JPath})
+	s.vm.SetTraceOut(traceWriter{s})
+	for k, v := range s.launch.ExtVars {
+		s.vm.ExtVar(k, v)
+	}
+	for k, v := range s.launch.ExtCode {
+		s.vm.ExtCode(k, v)
+	}
+	for k, v := range s.launch.TLAVars {
+		s.vm.TLAVar(k, v)
+	}
+	for k, v := range s.launch.TLACode {
+		s.vm.TLACode(k, v)
+	}
+	return nil
+}
+
+func (s *Session) setBreakpoints(args *setBreakpointsArguments) []breakpoint {
+	res := []breakpoint{}
+	if s.program == nil || !sameFile(args.Source.Path, s.program.path) {
+		for _, bp := range args.Breakpoints {
+			res = append(res, breakpoint{Line: bp.Line, Message: "breakpoints are only supported in the launched program"})
+		}
+		return res
+	}
+	s.breakpoints = map[int]bool{}
+	for _, bp := range args.Breakpoints {
+		i, ok := s.program.stepAt(bp.Line)
+		if !ok {
+			res = append(res, breakpoint{Line: bp.Line, Message: "breakpoints can only be set on top level imports and fields"})
+			continue
+		}
+		s.breakpoints[i] = true
+		res = append(res, breakpoint{Verified: true, Line: s.program.steps[i].loc.Begin.Line})
+	}
+	return res
+}
+
+// resume runs the steps of the program until a breakpoint, a runtime error, or the next
+// step when stepping.
+func (s *Session) resume(stepping bool) {
+	if s.program == nil {
+		s.terminate()
+		return
+	}
+	s.stopErr = nil
+	for s.next < len(s.program.steps) {
+		st := s.program.steps[s.next]
+		out, err := s.program.run(s.vm, st)
+		s.next++
+		if err != nil {
+			s.output("stderr", err.Error()+"\n")
+			s.failed = true
+			rterr, ok := err.(jsonnet.RuntimeError)
+			if !ok {
+				s.terminate()
+				return
+			}
+			s.stopErr = &rterr
+			s.stop("exception", rterr.Msg)
+			return
+		}
+		if s.next == len(s.program.steps) {
+			s.output("stdout", out)
+			break
+		}
+		if stepping || s.breakpoints[s.next] {
+			reason := "step"
+			if !stepping {
+				reason = "breakpoint"
+			}
+			s.stop(reason, "")
+			return
+		}
+	}
+	s.terminate()
+}
+
+func (s *Session) stop(reason, text string) {
+	s.handles = nil
+	s.event("stopped", &stoppedEvent{Reason: reason, Text: text, Description: text, ThreadID: threadID, AllThreadsStopped: true})
+}
+
+func (s *Session) terminate() {
+	if s.done {
+		return
+	}
+	s.done = true
+	code := 0
+	if s.failed {
+		code = 1
+	}
+	s.event("exited", map[string]int{"exitCode": code})
+	s.event("terminated", nil)
+}
+
+func (s *Session) stackTrace() []stackFrame {
+	if s.program == nil {
+		return []stackFrame{}
+	}
+	if s.stopErr != nil {
+		res := []stackFrame{}
+		for i, frame := range s.stopErr.StackTrace {
+			name := frame.Name
+			if name == "" {
+				name = "<anonymous>"
+			}
+			res = append(res, stackFrame{
+				ID:        i + 1,
+				Name:      name,
+				Source:    &source{Path: frame.Loc.FileName},
+				Line:      frame.Loc.Begin.Line,
+				Column:    frame.Loc.Begin.Column,
+				EndLine:   frame.Loc.End.Line,
+				EndColumn: frame.Loc.End.Column,
+			})
+		}
+		return res
+	}
+	if s.next >= len(s.program.steps) {
+		return []stackFrame{}
+	}
+	st := s.program.steps[s.next]
+	return []stackFrame{{
+		ID:        1,
+		Name:      st.name,
+		Source:    &source{Path: s.program.path},
+		Line:      st.loc.Begin.Line,
+		Column:    st.loc.Begin.Column,
+		EndLine:   st.loc.End.Line,
+		EndColumn: st.loc.End.Column,
+	}}
+}
+
+func (s *Session) scopes(frameID int) []scope {
+	res := []scope{}
+	if s.stopErr != nil && frameID >= 1 && frameID <= len(s.stopErr.StackTrace) {
+		// the variables in scope of a frame are only known by their definition
+		locals := sourceLocals(s.stopErr.StackTrace[frameID-1].Loc)
+		res = append(res, scope{Name: "Locals", VariablesReference: s.addHandle(locals)})
+	}
+	return append(res, scope{Name: "Top level", VariablesReference: s.addHandle(topLevelScope{}), Expensive: true})
+}
+
+func (s *Session) variables(ref int) []variable {
+	if ref < 1 || ref > len(s.handles) {
+		return []variable{}
+	}
+	res := []variable{}
+	switch val := s.handles[ref-1].(type) {
+	case topLevelScope:
+		for _, name := range s.program.locals {
+			v, err := s.program.value(s.vm, name)
+			if err != nil {
+				res = append(res, variable{Name: name, Value: firstLine(err.Error()), Type: "error"})
+				continue
+			}
+			res = append(res, s.variable(name, v))
+		}
+	case []variable:
+		res = val
+	case map[string]interface{}:
+		for name, v := range val {
+			res = append(res, s.variable(name, v))
+		}
+		sortVariables(res)
+	case []interface{}:
+		for i, v := range val {
+			res = append(res, s.variable(fmt.Sprintf("[%d]", i), v))
+		}
+	}
+	return res
+}
+
+// variable shows a JSON value, objects and arrays can be expanded.
+func (s *Session) variable(name string, val interface{}) variable {
+	res := variable{Name: name}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		res.Type = "object"
+		res.Value = fmt.Sprintf("{%d fields}", len(v))
+		res.VariablesReference = s.addHandle(v)
+	case []interface{}:
+		res.Type = "array"
+		res.Value = fmt.Sprintf("[%d elements]", len(v))
+		res.VariablesReference = s.addHandle(v)
+	default:
+		data, _ := json.Marshal(v)
+		res.Value = string(data)
+		switch v.(type) {
+		case string:
+			res.Type = "string"
+		case float64:
+			res.Type = "number"
+		case bool:
+			res.Type = "boolean"
+		case nil:
+			res.Type = "null"
+		}
+		if v == "<function>" {
+			res.Type, res.Value = "function", "function"
+		}
+	}
+	if len(res.Value) > maxValueLen {
+		res.Value = res.Value[:maxValueLen] + "..."
+	}
+	return res
+}
+
+func (s *Session) addHandle(val interface{}) int {
+	s.handles = append(s.handles, val)
+	return len(s.handles)
+}
+
+func (s *Session) output(category, text string) {
+	s.event("output", &outputEvent{Category: category, Output: text})
+}
+
+func (s *Session) event(name string, body interface{}) {
+	s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+func (s *Session) send(msg interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seq++
+	switch msg := msg.(type) {
+	case *response:
+		msg.Seq = s.seq
+	case *event:
+		msg.Seq = s.seq
+	}
+	if err := writeMessage(s.out, msg); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write debug adapter message: %v\n", err)
+	}
+}
+
+// traceWriter sends the output of `std.trace` to the debug console.
+type traceWriter struct {
+	s *Session
+}
+
+func (w traceWriter) Write(p []byte) (int, error) {
+	w.s.output("console", string(p))
+	return len(p), nil
+}
+
+func sortVariables(vars []variable) {
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+func sameFile(a, b string) bool {
+	ia, errA := os.Stat(a)
+	ib, errB := os.Stat(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return os.SameFile(ia, ib)
+}
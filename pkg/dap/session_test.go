@@ -0,0 +1,120 @@
+package dap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testMessage struct {
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	Success    bool            `json:"success"`
+	RequestSeq int             `json:"request_seq"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// runSession runs a session with the requests, and returns the messages it sent.
+func runSession(t *testing.T, requests ...interface{}) []testMessage {
+	in := &bytes.Buffer{}
+	for i, args := range requests {
+		cmd := args.([2]interface{})
+		data, err := json.Marshal(map[string]interface{}{"seq": i + 1, "type": "request", "command": cmd[0], "arguments": cmd[1]})
+		require.NoError(t, err)
+		require.NoError(t, writeMessage(in, json.RawMessage(data)))
+	}
+	out := &bytes.Buffer{}
+	require.NoError(t, NewSession(in, out).Run())
+
+	res := []testMessage{}
+	r := bufio.NewReader(out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err != nil {
+			break
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		require.NoError(t, err)
+		data := make([]byte, length)
+		_, err = io.ReadFull(r, data)
+		require.NoError(t, err)
+		msg := testMessage{}
+		require.NoError(t, json.Unmarshal(data, &msg))
+		res = append(res, msg)
+	}
+	return res
+}
+
+func req(command string, args interface{}) [2]interface{} {
+	return [2]interface{}{command, args}
+}
+
+func TestSession(t *testing.T) {
+	dir := t.TempDir()
+	program := filepath.Join(dir, "main.jsonnet")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lib.libsonnet"), []byte("{ x: 1 }\n"), 0o644))
+	require.NoError(t, os.WriteFile(program, []byte(`local lib = import 'lib.libsonnet';
+local n = 2;
+{
+  a: lib.x + n,
+  b: error 'boom',
+}
+`), 0o644))
+
+	msgs := runSession(t,
+		req("initialize", map[string]interface{}{}),
+		req("launch", map[string]interface{}{"program": program, "stopOnEntry": true}),
+		req("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": program}, "breakpoints": []map[string]int{{"line": 5}, {"line": 2}}}),
+		req("configurationDone", nil),
+		// stopped on entry, before the import
+		req("stackTrace", map[string]int{"threadId": 1}),
+		req("scopes", map[string]int{"frameId": 1}),
+		req("variables", map[string]int{"variablesReference": 1}),
+		req("evaluate", map[string]interface{}{"expression": "lib.x + n * 10"}),
+		// stops at the breakpoint on `b`
+		req("continue", map[string]int{"threadId": 1}),
+		req("stackTrace", map[string]int{"threadId": 1}),
+		// `b` fails
+		req("next", map[string]int{"threadId": 1}),
+		req("stackTrace", map[string]int{"threadId": 1}),
+		// the manifestation fails too, and the program terminates
+		req("continue", map[string]int{"threadId": 1}),
+		req("continue", map[string]int{"threadId": 1}),
+		req("disconnect", nil),
+	)
+
+	events := []string{}
+	bodies := map[string][]string{}
+	for _, msg := range msgs {
+		if msg.Type == "event" {
+			events = append(events, msg.Event)
+			continue
+		}
+		assert.True(t, msg.Success, "%s failed: %s", msg.Command, msg.Body)
+		bodies[msg.Command] = append(bodies[msg.Command], string(msg.Body))
+	}
+	assert.Equal(t, []string{"initialized", "stopped", "stopped", "output", "stopped", "output", "stopped", "exited", "terminated"}, events)
+
+	// only `b` is a step, locals are not
+	assert.JSONEq(t, `{"breakpoints": [{"verified": true, "line": 5}, {"verified": false, "line": 2, "message": "breakpoints can only be set on top level imports and fields"}]}`, bodies["setBreakpoints"][0])
+	assert.Contains(t, bodies["stackTrace"][0], `"name":"import \"lib.libsonnet\""`)
+	assert.Contains(t, bodies["stackTrace"][1], `"name":"b","source":{"path":"`+program+`"},"line":5`)
+	// the stack trace of the error
+	assert.Contains(t, bodies["stackTrace"][2], `"line":5`)
+	assert.JSONEq(t, `{"scopes": [{"name": "Top level", "variablesReference": 1, "expensive": true}]}`, bodies["scopes"][0])
+	assert.JSONEq(t, `{"variables": [
+		{"name": "lib", "value": "{1 fields}", "type": "object", "variablesReference": 2},
+		{"name": "n", "value": "2", "type": "number", "variablesReference": 0}
+	]}`, bodies["variables"][0])
+	assert.JSONEq(t, `{"result": "21", "type": "number", "variablesReference": 0}`, bodies["evaluate"][0])
+}
@@ -0,0 +1,490 @@
+// Package jsonschema validates JSON documents against a JSON Schema. It implements the
+// validation keywords of drafts 4 to 2020-12 that are common in practice, see
+// https://json-schema.org/draft/2020-12/json-schema-validation.html. References are only
+// resolved within the schema, and `format` is not checked.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a parsed JSON Schema.
+type Schema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// Violation is a value of the document that does not match the schema.
+type Violation struct {
+	// Path is the location of the value in the document: the names of the fields (strings)
+	// and the indexes of the array elements (ints) from the root.
+	Path    []interface{}
+	Message string
+}
+
+// PathString formats the path like a jsonnet expression, f.ex `spec.ports[0].name`.
+func (v Violation) PathString() string {
+	sb := strings.Builder{}
+	for _, p := range v.Path {
+		switch p := p.(type) {
+		case int:
+			fmt.Fprintf(&sb, "[%d]", p)
+		case string:
+			if identRe.MatchString(p) {
+				if sb.Len() > 0 {
+					sb.WriteByte('.')
+				}
+				sb.WriteString(p)
+			} else {
+				fmt.Fprintf(&sb, "[%q]", p)
+			}
+		}
+	}
+	return sb.String()
+}
+
+var identRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Parse parses a JSON Schema.
+func Parse(data []byte) (*Schema, error) {
+	var root interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	switch root.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return nil, fmt.Errorf("a schema must be an object or a boolean")
+	}
+	return &Schema{root: root, patterns: map[string]*regexp.Regexp{}}, nil
+}
+
+// Validate validates a document decoded from JSON, and returns the values that do not
+// match the schema.
+func (s *Schema) Validate(doc interface{}) []Violation {
+	v := &validator{schema: s}
+	return v.validate(s.root, doc, nil, 0)
+}
+
+// maxRefDepth limits how deep references are followed, schemas can be recursive.
+const maxRefDepth = 100
+
+type validator struct {
+	schema *Schema
+}
+
+func (v *validator) validate(schema, doc interface{}, path []interface{}, depth int) []Violation {
+	switch schema := schema.(type) {
+	case bool:
+		if !schema {
+			return []Violation{violation(path, "no value is allowed")}
+		}
+		return nil
+	case map[string]interface{}:
+		return v.validateObject(schema, doc, path, depth)
+	}
+	return nil
+}
+
+func violation(path []interface{}, format string, args ...interface{}) Violation {
+	return Violation{Path: append([]interface{}{}, path...), Message: fmt.Sprintf(format, args...)}
+}
+
+func (v *validator) validateObject(schema map[string]interface{}, doc interface{}, path []interface{}, depth int) []Violation {
+	res := []Violation{}
+	if ref, ok := schema["$ref"].(string); ok {
+		if depth > maxRefDepth {
+			return nil
+		}
+		target, ok := v.resolve(ref)
+		if !ok {
+			return []Violation{violation(path, "cannot resolve schema reference %q", ref)}
+		}
+		res = append(res, v.validate(target, doc, path, depth+1)...)
+	}
+
+	if types, ok := schema["type"]; ok && !matchesType(types, doc) {
+		// the other keywords do not apply to values of the wrong type
+		return append(res, violation(path, "expected %s, got %s", formatTypes(types), typeOf(doc)))
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !contains(enum, doc) {
+		res = append(res, violation(path, "value must be one of %s", formatValues(enum)))
+	}
+	if c, ok := schema["const"]; ok && !equal(c, doc) {
+		res = append(res, violation(path, "value must be %s", formatValue(c)))
+	}
+
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		res = append(res, v.validateFields(schema, doc, path, depth)...)
+	case []interface{}:
+		res = append(res, v.validateElements(schema, doc, path, depth)...)
+	case float64:
+		res = append(res, validateNumber(schema, doc, path)...)
+	case string:
+		res = append(res, v.validateString(schema, doc, path)...)
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			res = append(res, v.validate(sub, doc, path, depth+1)...)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		if v.matching(anyOf, doc, path, depth) == 0 {
+			res = append(res, v.bestMatch(anyOf, doc, path, depth, "value does not match any of the allowed schemas")...)
+		}
+	}
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		switch n := v.matching(one, doc, path, depth); {
+		case n == 0:
+			res = append(res, v.bestMatch(one, doc, path, depth, "value does not match any of the allowed schemas")...)
+		case n > 1:
+			res = append(res, violation(path, "value matches %d schemas, but must match exactly one", n))
+		}
+	}
+	if not, ok := schema["not"]; ok && len(v.validate(not, doc, path, depth+1)) == 0 {
+		res = append(res, violation(path, "value matches a schema it must not match"))
+	}
+	if cond, ok := schema["if"]; ok {
+		if len(v.validate(cond, doc, path, depth+1)) == 0 {
+			if then, ok := schema["then"]; ok {
+				res = append(res, v.validate(then, doc, path, depth+1)...)
+			}
+		} else if els, ok := schema["else"]; ok {
+			res = append(res, v.validate(els, doc, path, depth+1)...)
+		}
+	}
+	return res
+}
+
+// matching returns how many of the schemas the value matches.
+func (v *validator) matching(schemas []interface{}, doc interface{}, path []interface{}, depth int) int {
+	n := 0
+	for _, sub := range schemas {
+		if len(v.validate(sub, doc, path, depth+1)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// bestMatch explains why a value matches none of the schemas. If only one of the schemas
+// has the type of the value, f.ex an object or a reference to it, its violations are
+// more useful than a generic message.
+func (v *validator) bestMatch(schemas []interface{}, doc interface{}, path []interface{}, depth int, msg string) []Violation {
+	var best []Violation
+	candidates := 0
+	for _, sub := range schemas {
+		obj, ok := sub.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if ref, ok := obj["$ref"].(string); ok {
+			if target, ok := v.resolve(ref); ok {
+				obj, _ = target.(map[string]interface{})
+			}
+		}
+		if types, ok := obj["type"]; ok && !matchesType(types, doc) {
+			continue
+		}
+		candidates++
+		best = v.validate(sub, doc, path, depth+1)
+	}
+	if candidates == 1 {
+		return best
+	}
+	return []Violation{violation(path, msg)}
+}
+
+func (v *validator) validateFields(schema map[string]interface{}, doc map[string]interface{}, path []interface{}, depth int) []Violation {
+	res := []Violation{}
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, has := doc[name]; !has {
+					res = append(res, violation(path, "missing required field %q", name))
+				}
+			}
+		}
+	}
+	if n, ok := schemaInt(schema, "minProperties"); ok && len(doc) < n {
+		res = append(res, violation(path, "object must have at least %d fields", n))
+	}
+	if n, ok := schemaInt(schema, "maxProperties"); ok && len(doc) > n {
+		res = append(res, violation(path, "object must have at most %d fields", n))
+	}
+
+	props, _ := schema["properties"].(map[string]interface{})
+	patterns, _ := schema["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := schema["additionalProperties"]
+	names, hasNames := schema["propertyNames"]
+	for _, name := range sortedKeys(doc) {
+		value := doc[name]
+		fieldPath := append(append([]interface{}{}, path...), name)
+		if hasNames {
+			for _, viol := range v.validate(names, name, fieldPath, depth+1) {
+				viol.Message = "field name: " + viol.Message
+				res = append(res, viol)
+			}
+		}
+		matched := false
+		if sub, ok := props[name]; ok {
+			matched = true
+			res = append(res, v.validate(sub, value, fieldPath, depth+1)...)
+		}
+		for _, pattern := range sortedKeys(patterns) {
+			if re := v.regexp(pattern); re != nil && re.MatchString(name) {
+				matched = true
+				res = append(res, v.validate(patterns[pattern], value, fieldPath, depth+1)...)
+			}
+		}
+		if matched || !hasAdditional {
+			continue
+		}
+		if allowed, ok := additional.(bool); ok && !allowed {
+			res = append(res, violation(fieldPath, "field %q is not allowed", name))
+			continue
+		}
+		res = append(res, v.validate(additional, value, fieldPath, depth+1)...)
+	}
+	return res
+}
+
+func (v *validator) validateElements(schema map[string]interface{}, doc []interface{}, path []interface{}, depth int) []Violation {
+	res := []Violation{}
+	if n, ok := schemaInt(schema, "minItems"); ok && len(doc) < n {
+		res = append(res, violation(path, "array must have at least %d elements", n))
+	}
+	if n, ok := schemaInt(schema, "maxItems"); ok && len(doc) > n {
+		res = append(res, violation(path, "array must have at most %d elements", n))
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+	outer:
+		for i := range doc {
+			for j := 0; j < i; j++ {
+				if equal(doc[i], doc[j]) {
+					res = append(res, violation(append(path, i), "array elements must be unique, same as element %d", j))
+					break outer
+				}
+			}
+		}
+	}
+
+	// the schemas of the first elements, from `prefixItems` or the array form of `items`,
+	// and the schema of the other elements
+	prefix, _ := schema["prefixItems"].([]interface{})
+	rest, hasRest := schema["items"]
+	if tuple, ok := rest.([]interface{}); ok {
+		prefix = tuple
+		rest, hasRest = schema["additionalItems"]
+	}
+	for i, elem := range doc {
+		elemPath := append(append([]interface{}{}, path...), i)
+		switch {
+		case i < len(prefix):
+			res = append(res, v.validate(prefix[i], elem, elemPath, depth+1)...)
+		case hasRest:
+			res = append(res, v.validate(rest, elem, elemPath, depth+1)...)
+		}
+	}
+	if contains, ok := schema["contains"]; ok {
+		found := false
+		for i, elem := range doc {
+			if len(v.validate(contains, elem, append(path, i), depth+1)) == 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, violation(path, "array does not contain a matching element"))
+		}
+	}
+	return res
+}
+
+func validateNumber(schema map[string]interface{}, doc float64, path []interface{}) []Violation {
+	res := []Violation{}
+	if min, ok := schema["minimum"].(float64); ok && doc < min {
+		res = append(res, violation(path, "value must be >= %s", formatNumber(min)))
+	}
+	if max, ok := schema["maximum"].(float64); ok && doc > max {
+		res = append(res, violation(path, "value must be <= %s", formatNumber(max)))
+	}
+	// draft 4 has boolean exclusive bounds, that apply to the minimum and maximum
+	exclMin, exclMax := schema["exclusiveMinimum"], schema["exclusiveMaximum"]
+	if excl, ok := exclMin.(bool); ok && excl {
+		exclMin = schema["minimum"]
+	}
+	if excl, ok := exclMax.(bool); ok && excl {
+		exclMax = schema["maximum"]
+	}
+	if min, ok := exclMin.(float64); ok && doc <= min {
+		res = append(res, violation(path, "value must be > %s", formatNumber(min)))
+	}
+	if max, ok := exclMax.(float64); ok && doc >= max {
+		res = append(res, violation(path, "value must be < %s", formatNumber(max)))
+	}
+	if mult, ok := schema["multipleOf"].(float64); ok && mult > 0 {
+		if q := doc / mult; math.Abs(q-math.Round(q)) > 1e-9 {
+			res = append(res, violation(path, "value must be a multiple of %s", formatNumber(mult)))
+		}
+	}
+	return res
+}
+
+func (v *validator) validateString(schema map[string]interface{}, doc string, path []interface{}) []Violation {
+	res := []Violation{}
+	length := utf8.RuneCountInString(doc)
+	if n, ok := schemaInt(schema, "minLength"); ok && length < n {
+		res = append(res, violation(path, "string must have at least %d characters", n))
+	}
+	if n, ok := schemaInt(schema, "maxLength"); ok && length > n {
+		res = append(res, violation(path, "string must have at most %d characters", n))
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if re := v.regexp(pattern); re != nil && !re.MatchString(doc) {
+			res = append(res, violation(path, "string must match the pattern %q", pattern))
+		}
+	}
+	return res
+}
+
+// regexp compiles a pattern of the schema, patterns that are not valid in Go (f.ex with
+// lookaheads) are ignored.
+func (v *validator) regexp(pattern string) *regexp.Regexp {
+	if re, ok := v.schema.patterns[pattern]; ok {
+		return re
+	}
+	re, _ := regexp.Compile(pattern)
+	v.schema.patterns[pattern] = re
+	return re
+}
+
+// resolve resolves a reference within the schema, f.ex `#/definitions/port`.
+func (v *validator) resolve(ref string) (interface{}, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	cur := v.schema.root
+	for _, tok := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		tok = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			next, ok := node[tok]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func typeOf(doc interface{}) string {
+	switch doc := doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if doc == math.Trunc(doc) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", doc)
+}
+
+func matchesType(types, doc interface{}) bool {
+	actual := typeOf(doc)
+	match := func(typ interface{}) bool {
+		return typ == actual || (typ == "number" && actual == "integer")
+	}
+	if list, ok := types.([]interface{}); ok {
+		for _, typ := range list {
+			if match(typ) {
+				return true
+			}
+		}
+		return false
+	}
+	return match(types)
+}
+
+func formatTypes(types interface{}) string {
+	if list, ok := types.([]interface{}); ok {
+		names := make([]string, len(list))
+		for i, typ := range list {
+			names[i] = fmt.Sprint(typ)
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+func contains(values []interface{}, doc interface{}) bool {
+	for _, v := range values {
+		if equal(v, doc) {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func formatValue(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func formatValues(values []interface{}) string {
+	res := make([]string, len(values))
+	for i, v := range values {
+		res[i] = formatValue(v)
+	}
+	return strings.Join(res, ", ")
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+func schemaInt(schema map[string]interface{}, key string) (int, bool) {
+	n, ok := schema[key].(float64)
+	return int(n), ok
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
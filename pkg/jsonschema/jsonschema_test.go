@@ -0,0 +1,97 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+  "type": "object",
+  "required": ["name", "spec"],
+  "additionalProperties": false,
+  "properties": {
+    "name": {"type": "string", "pattern": "^[a-z-]+$", "maxLength": 10},
+    "spec": {"$ref": "#/definitions/spec"},
+    "labels": {"type": "object", "additionalProperties": {"type": "string"}}
+  },
+  "definitions": {
+    "spec": {
+      "type": "object",
+      "properties": {
+        "replicas": {"type": "integer", "minimum": 1},
+        "ports": {"type": "array", "items": {"$ref": "#/definitions/port"}, "uniqueItems": true},
+        "mode": {"enum": ["a", "b"]},
+        "target": {"oneOf": [{"type": "string"}, {"$ref": "#/definitions/port"}]}
+      }
+    },
+    "port": {
+      "type": "object",
+      "required": ["port"],
+      "properties": {"port": {"type": "number", "exclusiveMaximum": 65536}}
+    }
+  }
+}`
+
+func TestValidate(t *testing.T) {
+	schema, err := Parse([]byte(testSchema))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		Name   string
+		Doc    string
+		Expect []string
+	}{
+		{"valid", `{"name": "web", "spec": {"replicas": 2, "ports": [{"port": 80}]}, "labels": {"a": "b"}}`, nil},
+		{"missing fields", `{}`, []string{
+			`: missing required field "name"`,
+			`: missing required field "spec"`,
+		}},
+		{"wrong type", `{"name": 1, "spec": []}`, []string{
+			`name: expected string, got integer`,
+			`spec: expected object, got array`,
+		}},
+		{"unknown field", `{"name": "web", "spec": {}, "extra": true}`, []string{
+			`extra: field "extra" is not allowed`,
+		}},
+		{"string", `{"name": "Web-Server-01", "spec": {}}`, []string{
+			`name: string must have at most 10 characters`,
+			`name: string must match the pattern "^[a-z-]+$"`,
+		}},
+		{"nested", `{"name": "web", "spec": {"replicas": 0.5, "ports": [{"port": 80}, {"port": 70000}, {}], "mode": "c"}}`, []string{
+			`spec.mode: value must be one of "a", "b"`,
+			`spec.ports[1].port: value must be < 65536`,
+			`spec.ports[2]: missing required field "port"`,
+			`spec.replicas: expected integer, got number`,
+		}},
+		{"unique", `{"name": "web", "spec": {"ports": [{"port": 80}, {"port": 80}]}}`, []string{
+			`spec.ports[1]: array elements must be unique, same as element 0`,
+		}},
+		{"one of", `{"name": "web", "spec": {"target": {}}}`, []string{
+			`spec.target: missing required field "port"`,
+		}},
+		{"additional properties", `{"name": "web", "spec": {}, "labels": {"a": 1, "b.c/d": "x"}}`, []string{
+			`labels.a: expected string, got integer`,
+		}},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			var doc interface{}
+			require.NoError(t, json.Unmarshal([]byte(tc.Doc), &doc))
+			res := []string{}
+			for _, v := range schema.Validate(doc) {
+				res = append(res, v.PathString()+": "+v.Message)
+			}
+			if tc.Expect == nil {
+				tc.Expect = []string{}
+			}
+			assert.Equal(t, tc.Expect, res)
+		})
+	}
+}
+
+func TestPathString(t *testing.T) {
+	v := Violation{Path: []interface{}{"spec", "ports", 0, "app.kubernetes.io/name"}}
+	assert.Equal(t, `spec.ports[0]["app.kubernetes.io/name"]`, v.PathString())
+}
@@ -0,0 +1,387 @@
+// Package kube loads the schemas of Kubernetes resources from OpenAPI documents and
+// CustomResourceDefinitions, to complete and check the fields of resources written as
+// object literals.
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Elements is the element of a path for the elements of an array.
+const Elements = "[]"
+
+// Catalog is the schemas of resources by their apiVersion and kind.
+type Catalog struct {
+	resources map[gvk]*Schema
+	// the metadata of custom resources is usually an object without properties in the
+	// CRD, the ObjectMeta of an OpenAPI document is used in its place when there is one
+	objectMeta *Schema
+}
+
+type gvk struct {
+	apiVersion, kind string
+}
+
+// document is the schemas a `$ref` refers to, the `definitions` of an OpenAPI v2 document
+// or the `components.schemas` of an OpenAPI v3 document.
+type document struct {
+	defs map[string]interface{}
+}
+
+// Schema is the OpenAPI schema of a resource or one of its fields.
+type Schema struct {
+	node map[string]interface{}
+	doc  *document
+}
+
+// Field is a property of an object schema.
+type Field struct {
+	Name     string
+	Schema   *Schema
+	Required bool
+}
+
+// Load reads the schemas of the files, and of the `.json`, `.yaml` and `.yml` files in
+// the directories. The files can be OpenAPI documents (f.ex from `kubectl get --raw
+// /openapi/v2`), the JSON Schema of a single resource, or CustomResourceDefinitions
+// (also in a `List`, f.ex from `kubectl get crds -o yaml`). The catalog has the schemas
+// of every file that could be read, the error is the first file that could not be.
+func Load(paths ...string) (*Catalog, error) {
+	c := &Catalog{resources: map[gvk]*Schema{}}
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		if !info.IsDir() {
+			if err := c.loadFile(p); err != nil {
+				fail(err)
+			}
+			continue
+		}
+		_ = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				fail(err)
+				return nil
+			}
+			switch filepath.Ext(path) {
+			case ".json", ".yaml", ".yml":
+				if !d.IsDir() {
+					if err := c.loadFile(path); err != nil {
+						fail(err)
+					}
+				}
+			}
+			return nil
+		})
+	}
+	return c, firstErr
+}
+
+func (c *Catalog) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	parts := [][]byte{data}
+	if filepath.Ext(path) != ".json" {
+		// YAML files can have several documents
+		parts = bytes.Split(data, []byte("\n---"))
+	}
+	for _, part := range parts {
+		if len(bytes.TrimSpace(part)) == 0 {
+			continue
+		}
+		var doc interface{}
+		if err := yaml.Unmarshal(part, &doc); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		c.add(doc)
+	}
+	return nil
+}
+
+func (c *Catalog) add(doc interface{}) {
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if items, ok := m["items"].([]interface{}); ok && strings.HasSuffix(str(m["kind"]), "List") {
+		for _, item := range items {
+			c.add(item)
+		}
+		return
+	}
+	if str(m["kind"]) == "CustomResourceDefinition" {
+		c.addCRD(m)
+		return
+	}
+
+	defs, _ := m["definitions"].(map[string]interface{})
+	if defs == nil {
+		defs, _ = dig(m, "components", "schemas").(map[string]interface{})
+	}
+	if defs == nil {
+		// the JSON Schema of a single resource
+		c.addSchema(m, &document{})
+		return
+	}
+	d := &document{defs: defs}
+	for name, def := range defs {
+		node, ok := def.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		c.addSchema(node, d)
+		if strings.HasSuffix(name, "apimachinery.pkg.apis.meta.v1.ObjectMeta") {
+			c.objectMeta = &Schema{node: node, doc: d}
+		}
+	}
+}
+
+// addSchema adds a schema for each of the kinds in its `x-kubernetes-group-version-kind`.
+func (c *Catalog) addSchema(node map[string]interface{}, d *document) {
+	kinds, _ := node["x-kubernetes-group-version-kind"].([]interface{})
+	for _, k := range kinds {
+		k, _ := k.(map[string]interface{})
+		if k == nil || str(k["kind"]) == "" {
+			continue
+		}
+		c.resources[gvk{apiVersion(str(k["group"]), str(k["version"])), str(k["kind"])}] = &Schema{node: node, doc: d}
+	}
+}
+
+func (c *Catalog) addCRD(crd map[string]interface{}) {
+	group, _ := dig(crd, "spec", "group").(string)
+	kind, _ := dig(crd, "spec", "names", "kind").(string)
+	if kind == "" {
+		return
+	}
+	versions, _ := dig(crd, "spec", "versions").([]interface{})
+	for _, v := range versions {
+		v, _ := v.(map[string]interface{})
+		node, _ := dig(v, "schema", "openAPIV3Schema").(map[string]interface{})
+		if node == nil {
+			// apiextensions.k8s.io/v1beta1 has a single schema for every version
+			node, _ = dig(crd, "spec", "validation", "openAPIV3Schema").(map[string]interface{})
+		}
+		if node != nil {
+			c.resources[gvk{apiVersion(group, str(v["name"])), kind}] = &Schema{node: node, doc: &document{}}
+		}
+	}
+	if version, _ := dig(crd, "spec", "version").(string); version != "" && len(versions) == 0 {
+		if node, _ := dig(crd, "spec", "validation", "openAPIV3Schema").(map[string]interface{}); node != nil {
+			c.resources[gvk{apiVersion(group, version), kind}] = &Schema{node: node, doc: &document{}}
+		}
+	}
+}
+
+// Len returns the number of resources in the catalog.
+func (c *Catalog) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.resources)
+}
+
+// Lookup returns the schema of a resource, or nil if it is not in the catalog.
+func (c *Catalog) Lookup(apiVersion, kind string) *Schema {
+	if c == nil {
+		return nil
+	}
+	return c.resources[gvk{apiVersion, kind}]
+}
+
+// Resolve returns the schema of a field of a resource, by the path of field names and
+// Elements from the resource. Returns nil if the schema of the field is not known.
+func (c *Catalog) Resolve(apiVersion, kind string, path []string) *Schema {
+	s := c.Lookup(apiVersion, kind)
+	for i, p := range path {
+		if s == nil {
+			return nil
+		}
+		if p == Elements {
+			s = s.Items()
+			continue
+		}
+		s = s.Property(p)
+		if i == 0 && p == "metadata" && c.objectMeta != nil && (s == nil || len(s.Fields()) == 0) {
+			s = c.objectMeta
+		}
+	}
+	return s
+}
+
+// resolved follows the `$ref` of a schema, and the `allOf` with a single schema that
+// OpenAPI v3 documents wrap references in.
+func (s *Schema) resolved() *Schema {
+	for i := 0; s != nil && i < 32; i++ {
+		if ref, ok := s.node["$ref"].(string); ok {
+			name := ref[strings.LastIndex(ref, "/")+1:]
+			node, _ := s.doc.defs[name].(map[string]interface{})
+			if node == nil {
+				return nil
+			}
+			s = &Schema{node: node, doc: s.doc}
+			continue
+		}
+		if all, ok := s.node["allOf"].([]interface{}); ok && len(all) == 1 && s.node["properties"] == nil {
+			node, _ := all[0].(map[string]interface{})
+			if node == nil {
+				return s
+			}
+			s = &Schema{node: node, doc: s.doc}
+			continue
+		}
+		return s
+	}
+	return s
+}
+
+func (s *Schema) sub(v interface{}) *Schema {
+	node, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return &Schema{node: node, doc: s.doc}
+}
+
+// Property returns the schema of a field of an object, or the schema of the values of
+// a map. Returns nil if the object has no such field.
+func (s *Schema) Property(name string) *Schema {
+	r := s.resolved()
+	if r == nil {
+		return nil
+	}
+	if props, ok := r.node["properties"].(map[string]interface{}); ok {
+		if p := r.sub(props[name]); p != nil {
+			return p
+		}
+	}
+	return r.sub(r.node["additionalProperties"])
+}
+
+// Items returns the schema of the elements of an array.
+func (s *Schema) Items() *Schema {
+	r := s.resolved()
+	if r == nil {
+		return nil
+	}
+	return r.sub(r.node["items"])
+}
+
+// Fields returns the properties of an object, sorted by name.
+func (s *Schema) Fields() []Field {
+	r := s.resolved()
+	if r == nil {
+		return nil
+	}
+	required := map[string]bool{}
+	reqs, _ := r.node["required"].([]interface{})
+	for _, name := range reqs {
+		required[str(name)] = true
+	}
+	props, _ := r.node["properties"].(map[string]interface{})
+	res := make([]Field, 0, len(props))
+	for name, p := range props {
+		if sub := r.sub(p); sub != nil {
+			res = append(res, Field{Name: name, Schema: sub, Required: required[name]})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// Closed returns true if an object can only have the fields of its properties.
+func (s *Schema) Closed() bool {
+	r := s.resolved()
+	if r == nil {
+		return false
+	}
+	if props, _ := r.node["properties"].(map[string]interface{}); len(props) == 0 {
+		return false
+	}
+	if preserve, _ := r.node["x-kubernetes-preserve-unknown-fields"].(bool); preserve {
+		return false
+	}
+	additional, ok := r.node["additionalProperties"]
+	return !ok || additional == false
+}
+
+// Type returns the type of the value, f.ex `string` or `object`, or "" if it is unknown.
+func (s *Schema) Type() string {
+	r := s.resolved()
+	if r == nil {
+		return ""
+	}
+	if intOrString, _ := r.node["x-kubernetes-int-or-string"].(bool); intOrString || r.node["format"] == "int-or-string" {
+		return "integer | string"
+	}
+	if typ := str(r.node["type"]); typ != "" {
+		return typ
+	}
+	if r.node["properties"] != nil {
+		return "object"
+	}
+	return ""
+}
+
+// Description returns the documentation of the value.
+func (s *Schema) Description() string {
+	// the description of a reference is usually more specific than the one of its target
+	if d := str(s.node["description"]); d != "" {
+		return d
+	}
+	if r := s.resolved(); r != nil {
+		return str(r.node["description"])
+	}
+	return ""
+}
+
+// Enum returns the values the value can have, or nil if it can have any value of its type.
+func (s *Schema) Enum() []interface{} {
+	r := s.resolved()
+	if r == nil {
+		return nil
+	}
+	enum, _ := r.node["enum"].([]interface{})
+	return enum
+}
+
+func apiVersion(group, version string) string {
+	if group == "" {
+		return version
+	}
+	return group + "/" + version
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func dig(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
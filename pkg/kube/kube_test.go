@@ -0,0 +1,136 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadTestCatalog(t *testing.T) *Catalog {
+	catalog, err := Load("testdata")
+	require.NoError(t, err)
+	return catalog
+}
+
+func fieldNames(s *Schema) []string {
+	res := []string{}
+	for _, fld := range s.Fields() {
+		name := fld.Name
+		if fld.Required {
+			name += "!"
+		}
+		res = append(res, name)
+	}
+	return res
+}
+
+func TestCatalog(t *testing.T) {
+	catalog := loadTestCatalog(t)
+	assert.Equal(t, 2, catalog.Len())
+	assert.Nil(t, catalog.Lookup("apps/v1", "StatefulSet"))
+
+	deploy := catalog.Lookup("apps/v1", "Deployment")
+	require.NotNil(t, deploy)
+	assert.True(t, deploy.Closed())
+	assert.Equal(t, []string{"apiVersion", "kind", "metadata", "spec"}, fieldNames(deploy))
+	assert.Equal(t, "Specification of the desired behavior of the Deployment.", deploy.Property("spec").Description())
+
+	spec := catalog.Resolve("apps/v1", "Deployment", []string{"spec"})
+	assert.Equal(t, []string{"replicas", "selector!", "strategy", "template!"}, fieldNames(spec))
+	assert.Equal(t, []interface{}{"Recreate", "RollingUpdate"}, catalog.Resolve("apps/v1", "Deployment", []string{"spec", "strategy", "type"}).Enum())
+
+	container := catalog.Resolve("apps/v1", "Deployment", []string{"spec", "template", "spec", "containers", Elements})
+	assert.Equal(t, []string{"image", "name!", "port"}, fieldNames(container))
+	assert.Equal(t, "integer | string", container.Property("port").Type())
+
+	// maps are not closed, and have the schema of their values for any key
+	labels := catalog.Resolve("apps/v1", "Deployment", []string{"metadata", "labels"})
+	assert.False(t, labels.Closed())
+	assert.Equal(t, "string", labels.Property("app").Type())
+
+	widget := catalog.Resolve("example.com/v1", "Widget", []string{"spec"})
+	assert.Equal(t, []string{"color", "extra", "size"}, fieldNames(widget))
+	assert.False(t, widget.Property("extra").Closed())
+	// the metadata of custom resources is the ObjectMeta of the OpenAPI document
+	assert.Equal(t, []string{"labels", "name"}, fieldNames(catalog.Resolve("example.com/v1", "Widget", []string{"metadata"})))
+}
+
+func TestResourceAt(t *testing.T) {
+	source := `local base = { replicas: 1 };
+{
+  deployment: {
+    apiVersion: 'apps/v1',
+    kind: 'Deployment',
+    spec: base + {
+      local name = 'app',
+      template: { spec: { containers: [{ name: name, image: 'nginx' }] } },
+    },
+  },
+}`
+	root, err := jsonnet.SnippetToAST("anon", source)
+	require.NoError(t, err)
+
+	cases := []struct {
+		Line, Column int
+		Path         string
+		Found        bool
+	}{
+		{Line: 11, Column: 1, Found: false},
+		{Line: 4, Column: 5, Path: "", Found: true},
+		{Line: 6, Column: 20, Path: "spec", Found: true},
+		{Line: 8, Column: 41, Path: "spec.template.spec.containers.[]", Found: true},
+		{Line: 8, Column: 62, Path: "spec.template.spec.containers.[].image", Found: true},
+		// the locals of objects are not fields
+		{Line: 1, Column: 17, Found: false},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%d:%d", tc.Line, tc.Column), func(t *testing.T) {
+			stack := analysis.StackAtLoc(root, ast.Location{Line: tc.Line, Column: tc.Column})
+			res, path, ok := ResourceAt(stack)
+			require.Equal(t, tc.Found, ok)
+			if ok {
+				assert.Equal(t, "Deployment", res.Kind)
+				assert.Equal(t, tc.Path, strings.Join(path, "."))
+			}
+		})
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	source := `{
+  apiVersion: 'apps/v1',
+  kind: 'Deployment',
+  metadata: { name: 'app', labels: { anything: 'goes' }, owner: 'me' },
+  spec: {
+    replicas: 1,
+    replica: 2,
+    hidden:: true,
+    template: { spec: { containers: [{ name: 'app', img: 'nginx' }] } },
+  },
+  widgets: [{
+    apiVersion: 'example.com/v1',
+    kind: 'Widget',
+    spec: { color: 'red', shape: 'round', extra: { shape: 'round' } },
+  }],
+}`
+	root, err := jsonnet.SnippetToAST("anon", source)
+	require.NoError(t, err)
+
+	res := []string{}
+	for _, d := range UnknownFields(root, loadTestCatalog(t)) {
+		res = append(res, fmt.Sprintf("%d:%d %s", d.Range.Start.Line+1, d.Range.Start.Character+1, d.Message))
+	}
+	assert.Equal(t, []string{
+		"4:58 Deployment.metadata has no field 'owner'",
+		"7:5 Deployment.spec has no field 'replica'",
+		"9:53 Deployment.spec.template.spec.containers[] has no field 'img'",
+		"11:3 Deployment has no field 'widgets'",
+		"14:27 Widget.spec has no field 'shape'",
+	}, res)
+}
@@ -0,0 +1,222 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+// Resource is an object literal with constant `apiVersion` and `kind` fields.
+type Resource struct {
+	Object     *ast.DesugaredObject
+	APIVersion string
+	Kind       string
+}
+
+// AsResource returns the resource of an object literal with constant `apiVersion` and
+// `kind` fields.
+func AsResource(n ast.Node) (Resource, bool) {
+	obj, ok := n.(*ast.DesugaredObject)
+	if !ok {
+		return Resource{}, false
+	}
+	res := Resource{Object: obj}
+	for _, fld := range obj.Fields {
+		name, ok := fld.Name.(*ast.LiteralString)
+		if !ok {
+			continue
+		}
+		val, ok := fld.Body.(*ast.LiteralString)
+		if !ok {
+			continue
+		}
+		switch name.Value {
+		case "apiVersion":
+			res.APIVersion = val.Value
+		case "kind":
+			res.Kind = val.Value
+		}
+	}
+	return res, res.APIVersion != "" && res.Kind != ""
+}
+
+// transparent returns true if the value of a node is the value of its child, f.ex the
+// body of a local or the mixins of `base + {...}`.
+func transparent(n, child ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.Local:
+		return n.Body == child
+	case *ast.Parens:
+		return n.Inner == child
+	case *ast.Conditional:
+		return n.BranchTrue == child || n.BranchFalse == child
+	case *ast.Binary:
+		return n.Op == ast.BopPlus
+	}
+	return false
+}
+
+// ResourceAt returns the resource enclosing the node at the end of the stack, and the path
+// of field names and Elements from the resource to the node.
+func ResourceAt(stack []ast.Node) (Resource, []string, bool) {
+	path := []string{}
+	for i := len(stack) - 1; i >= 0; {
+		if res, ok := AsResource(stack[i]); ok {
+			for l, r := 0, len(path)-1; l < r; l, r = l+1, r-1 {
+				path[l], path[r] = path[r], path[l]
+			}
+			return res, path, true
+		}
+		j := i - 1
+		for j >= 0 && transparent(stack[j], stack[j+1]) {
+			j--
+		}
+		if j < 0 {
+			break
+		}
+		switch parent := stack[j].(type) {
+		case *ast.DesugaredObject:
+			name, ok := fieldWithBody(parent, stack[j+1])
+			if !ok {
+				return Resource{}, nil, false
+			}
+			path = append(path, name)
+		case *ast.Array:
+			path = append(path, Elements)
+		default:
+			return Resource{}, nil, false
+		}
+		i = j
+	}
+	return Resource{}, nil, false
+}
+
+func fieldWithBody(obj *ast.DesugaredObject, body ast.Node) (string, bool) {
+	for _, fld := range obj.Fields {
+		if fld.Body != body {
+			continue
+		}
+		name, ok := fld.Name.(*ast.LiteralString)
+		if !ok {
+			return "", false
+		}
+		return name.Value, true
+	}
+	return "", false
+}
+
+// literals returns the object and array literals that are the value of an expression.
+func literals(n ast.Node) []ast.Node {
+	switch n := n.(type) {
+	case *ast.DesugaredObject, *ast.Array:
+		return []ast.Node{n}
+	case *ast.Local:
+		return literals(n.Body)
+	case *ast.Parens:
+		return literals(n.Inner)
+	case *ast.Conditional:
+		return append(literals(n.BranchTrue), literals(n.BranchFalse)...)
+	case *ast.Binary:
+		if n.Op == ast.BopPlus {
+			return append(literals(n.Left), literals(n.Right)...)
+		}
+	}
+	return nil
+}
+
+// UnknownFields reports the fields of the resources of a file that are not in their
+// schema. Fields are only reported in objects that cannot have other fields, like the
+// `spec` of a Deployment, not in maps like its `labels`.
+func UnknownFields(root ast.Node, catalog *Catalog) []protocol.Diagnostic {
+	diags := []protocol.Diagnostic{}
+	if catalog.Len() == 0 {
+		return diags
+	}
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		res, ok := AsResource(n)
+		if ok && catalog.Lookup(res.APIVersion, res.Kind) != nil {
+			diags = unknownFields(diags, catalog, res, res.Object, nil)
+		}
+		return true
+	})
+	return diags
+}
+
+func unknownFields(diags []protocol.Diagnostic, catalog *Catalog, res Resource, obj *ast.DesugaredObject, path []string) []protocol.Diagnostic {
+	schema := catalog.Resolve(res.APIVersion, res.Kind, path)
+	if schema == nil {
+		return diags
+	}
+	closed := schema.Closed()
+	for i := range obj.Fields {
+		fld := &obj.Fields[i]
+		if fld.Hide == ast.ObjectFieldHidden {
+			// hidden fields are not in the output
+			continue
+		}
+		name, rng, ok := analysis.FieldNameRange(fld)
+		if !ok {
+			continue
+		}
+		if schema.Property(name) == nil && !(len(path) == 0 && name == "metadata") {
+			if closed {
+				diags = append(diags, protocol.Diagnostic{
+					Range:    rangeToProto(rng),
+					Severity: protocol.DiagnosticSeverityWarning,
+					Code:     linter.UnknownField,
+					Source:   "kubernetes",
+					Message:  fmt.Sprintf("%s has no field '%s'", describe(res.Kind, path), name),
+				})
+			}
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), name)
+		for _, lit := range literals(fld.Body) {
+			diags = literalFields(diags, catalog, res, lit, fieldPath)
+		}
+	}
+	return diags
+}
+
+func literalFields(diags []protocol.Diagnostic, catalog *Catalog, res Resource, lit ast.Node, path []string) []protocol.Diagnostic {
+	switch lit := lit.(type) {
+	case *ast.DesugaredObject:
+		if _, ok := AsResource(lit); ok {
+			// nested resources, f.ex the items of a List, are checked with their own schema
+			return diags
+		}
+		return unknownFields(diags, catalog, res, lit, path)
+	case *ast.Array:
+		elemPath := append(append([]string{}, path...), Elements)
+		for _, el := range lit.Elements {
+			for _, elLit := range literals(el.Expr) {
+				diags = literalFields(diags, catalog, res, elLit, elemPath)
+			}
+		}
+	}
+	return diags
+}
+
+// describe returns a path of a resource for messages, f.ex `Deployment.spec.containers[]`.
+func describe(kind string, path []string) string {
+	sb := strings.Builder{}
+	sb.WriteString(kind)
+	for _, p := range path {
+		if p != Elements {
+			sb.WriteString(".")
+		}
+		sb.WriteString(p)
+	}
+	return sb.String()
+}
+
+func rangeToProto(r ast.LocationRange) protocol.Range {
+	return protocol.Range{
+		Start: protocol.Position{Line: uint32(r.Begin.Line - 1), Character: uint32(r.Begin.Column - 1)},
+		End:   protocol.Position{Line: uint32(r.End.Line - 1), Character: uint32(r.End.Column - 1)},
+	}
+}
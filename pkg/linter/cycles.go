@@ -0,0 +1,103 @@
+package linter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// importEdge is an import of a file, and the file that imports it.
+type importEdge struct {
+	node *ast.Import
+	file string
+}
+
+// fileImports returns the imports of a file, in the order they are written. Imports of
+// strings and bytes (`importstr` and `importbin`) are not evaluated, they cannot be part
+// of a cycle.
+func fileImports(root ast.Node) []*ast.Import {
+	res := []*ast.Import{}
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		if imp, ok := n.(*ast.Import); ok {
+			res = append(res, imp)
+		}
+		return true
+	})
+	return res
+}
+
+// ImportCycles reports the imports of the file that lead back to it, directly or through
+// other files. The files of the cycle are in the related information of the diagnostic,
+// in the order they import each other. Cycles can be evaluated as long as no value
+// depends on itself, otherwise they overflow the stack, so they are only warnings.
+func ImportCycles(root ast.Node, resolver analysis.Resolver) []Diagnostic {
+	diags := []Diagnostic{}
+	if root == nil || root.Loc() == nil {
+		return diags
+	}
+	file := root.Loc().FileName
+	for _, imp := range fileImports(root) {
+		if cycle := importCycle(file, importEdge{imp, file}, resolver); len(cycle) > 0 {
+			diags = append(diags, cycleDiagnostic(cycle))
+		}
+	}
+	return diags
+}
+
+// importCycle searches the imports reachable from `start` for one of `file`, and returns
+// the imports on the way there, or nil if there are none.
+func importCycle(file string, start importEdge, resolver analysis.Resolver) []importEdge {
+	seen := map[string]bool{}
+	var search func(path []importEdge) []importEdge
+	search = func(path []importEdge) []importEdge {
+		last := path[len(path)-1]
+		target := resolver.Import(last.file, last.node.File.Value)
+		if target == nil || target.Loc() == nil {
+			return nil
+		}
+		name := target.Loc().FileName
+		if name == file {
+			return path
+		}
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+		for _, imp := range fileImports(target) {
+			next := append(path[:len(path):len(path)], importEdge{imp, name})
+			if cycle := search(next); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+	return search([]importEdge{start})
+}
+
+func cycleDiagnostic(cycle []importEdge) Diagnostic {
+	names := []string{filepath.Base(cycle[0].file)}
+	related := []protocol.DiagnosticRelatedInformation{}
+	for i, edge := range cycle {
+		imported := cycle[0].file
+		if i+1 < len(cycle) {
+			imported = cycle[i+1].file
+		}
+		names = append(names, filepath.Base(imported))
+		related = append(related, protocol.DiagnosticRelatedInformation{
+			Location: protocol.Location{URI: uri.File(edge.file), Range: rangeToProto(edge.node.LocRange)},
+			Message:  fmt.Sprintf("%s imports %s", filepath.Base(edge.file), filepath.Base(imported)),
+		})
+	}
+	return Diagnostic{
+		Range:              rangeToProto(cycle[0].node.LocRange),
+		Code:               ImportCycle,
+		Severity:           protocol.DiagnosticSeverityWarning,
+		Message:            fmt.Sprintf("import cycle: %s", strings.Join(names, " → ")),
+		RelatedInformation: related,
+	}
+}
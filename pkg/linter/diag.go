@@ -1,13 +1,48 @@
 package linter
 
+import (
+	"strings"
+
+	"go.lsp.dev/protocol"
+)
+
 type DiagCode string
 
 const (
 	ImportNotFound      DiagCode = "ImportNotFound"
+	ImportError         DiagCode = "ImportError"
+	ImportCycle         DiagCode = "ImportCycle"
+	LargeImport         DiagCode = "LargeImport"
 	UnusedVar           DiagCode = "UnusedVar"
+	UnusedParam         DiagCode = "UnusedParam"
+	UnusedImport        DiagCode = "UnusedImport"
+	DuplicateField      DiagCode = "DuplicateField"
+	ShadowedVar         DiagCode = "ShadowedVar"
 	TypeMismatch        DiagCode = "TypeMismatch"
 	RedundantCondition  DiagCode = "RedundantCondition"
 	UnknownField        DiagCode = "UnknownField"
 	UnknownArgument     DiagCode = "UnknownArgument"
 	ArgumentCardinality DiagCode = "ArgumentCardinality"
+	UnknownNative       DiagCode = "UnknownNative"
+	UnknownDocParam     DiagCode = "UnknownDocParam"
 )
+
+// ParseSeverity parses a configured severity: `error`, `warning`, `information`, or
+// `hint`. Returns false if the diagnostic is turned `off`.
+func ParseSeverity(s string, def protocol.DiagnosticSeverity) (protocol.DiagnosticSeverity, bool) {
+	switch strings.ToLower(s) {
+	case "":
+		return def, true
+	case "error":
+		return protocol.DiagnosticSeverityError, true
+	case "warning", "warn":
+		return protocol.DiagnosticSeverityWarning, true
+	case "information", "info":
+		return protocol.DiagnosticSeverityInformation, true
+	case "hint":
+		return protocol.DiagnosticSeverityHint, true
+	case "off", "none":
+		return 0, false
+	}
+	return def, true
+}
@@ -0,0 +1,79 @@
+package linter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+// unknownDocParam reports a documented parameter that the function does not have.
+func unknownDocParam(rng ast.LocationRange, name, fnName string, fn *ast.Function) Diagnostic {
+	params := make([]string, len(fn.Parameters))
+	for i, p := range fn.Parameters {
+		params[i] = string(p.Name)
+	}
+	msg := fmt.Sprintf("'%s' is not a parameter of '%s'", name, fnName)
+	if len(params) > 0 {
+		msg += fmt.Sprintf(", its parameters are: %s", strings.Join(params, ", "))
+	}
+	return Diagnostic{
+		Range:    rangeToProto(rng),
+		Code:     UnknownDocParam,
+		Severity: protocol.DiagnosticSeverityWarning,
+		Message:  msg,
+	}
+}
+
+func hasParam(fn *ast.Function, name string) bool {
+	for _, p := range fn.Parameters {
+		if string(p.Name) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DocParams reports the parameters documented for a function that it does not have: the
+// `@param` tags of its comment, and the `d.arg` arguments of its docsonnet doc field.
+func DocParams(root ast.Node) []Diagnostic {
+	diags := []Diagnostic{}
+	for _, def := range analysis.FunctionDefinitions(root) {
+		for _, tag := range analysis.DocTags(def.Loc) {
+			if tag.Kind == analysis.ParamTag && !hasParam(def.Function, tag.Name) {
+				diags = append(diags, unknownDocParam(tag.Range, tag.Name, def.Name, def.Function))
+			}
+		}
+	}
+
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		obj, ok := n.(*ast.DesugaredObject)
+		if !ok {
+			return true
+		}
+		fns := map[string]*ast.Function{}
+		for i := range obj.Fields {
+			if name, _, ok := analysis.FieldNameRange(&obj.Fields[i]); ok {
+				if fn, ok := obj.Fields[i].Body.(*ast.Function); ok {
+					fns[name] = fn
+				}
+			}
+		}
+		for i := range obj.Fields {
+			name, _, ok := analysis.FieldNameRange(&obj.Fields[i])
+			if !ok || !strings.HasPrefix(name, "#") || fns[name[1:]] == nil {
+				continue
+			}
+			fn := fns[name[1:]]
+			for _, arg := range analysis.DocsonnetArgNames(obj.Fields[i].Body) {
+				if !hasParam(fn, arg.Value) && arg.LocRange.IsSet() {
+					diags = append(diags, unknownDocParam(arg.LocRange, arg.Value, name[1:], fn))
+				}
+			}
+		}
+		return true
+	})
+	return sortDiags(diags)
+}
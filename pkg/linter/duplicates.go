@@ -0,0 +1,148 @@
+package linter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// duplicateFieldError is the parse error for a field defined twice in an object literal.
+const duplicateFieldError = "Duplicate field: "
+
+// maxDuplicateReparses limits how many times a file is re-parsed to find the duplicate
+// fields the parser stopped at.
+const maxDuplicateReparses = 10
+
+// DuplicateFields reports fields defined more than once in the same object, including
+// computed fields with a constant string name. Every definition is reported, with the
+// others as related information.
+func DuplicateFields(root ast.Node) []Diagnostic {
+	return duplicateFields(root, func(loc ast.Location) ast.Location { return loc })
+}
+
+func duplicateFields(root ast.Node, mapLoc func(ast.Location) ast.Location) []Diagnostic {
+	diags := []Diagnostic{}
+	mapRange := func(rng ast.LocationRange) ast.LocationRange {
+		rng.Begin, rng.End = mapLoc(rng.Begin), mapLoc(rng.End)
+		return rng
+	}
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		obj, ok := n.(*ast.DesugaredObject)
+		if !ok {
+			return true
+		}
+		names := []string{}
+		byName := map[string][]ast.LocationRange{}
+		for i := range obj.Fields {
+			name, rng, ok := analysis.FieldNameRange(&obj.Fields[i])
+			if !ok {
+				continue
+			}
+			if byName[name] == nil {
+				names = append(names, name)
+			}
+			byName[name] = append(byName[name], mapRange(rng))
+		}
+		for _, name := range names {
+			defs := byName[name]
+			if len(defs) < 2 {
+				continue
+			}
+			for i, def := range defs {
+				related := []protocol.DiagnosticRelatedInformation{}
+				for j, other := range defs {
+					if i == j {
+						continue
+					}
+					related = append(related, protocol.DiagnosticRelatedInformation{
+						Location: protocol.Location{URI: uri.File(other.FileName), Range: rangeToProto(other)},
+						Message:  fmt.Sprintf("'%s' is also defined here", name),
+					})
+				}
+				diags = append(diags, Diagnostic{
+					Range:              rangeToProto(def),
+					Code:               DuplicateField,
+					Severity:           protocol.DiagnosticSeverityError,
+					Message:            fmt.Sprintf("duplicate field '%s'", name),
+					RelatedInformation: related,
+				})
+			}
+		}
+		return true
+	})
+	return sortDiags(diags)
+}
+
+// fieldPatch is a field name rewritten as a computed field, f.ex `a` as `["a"]`. The
+// columns are of the name before it was rewritten.
+type fieldPatch struct {
+	line, begin, end int
+	prefix, suffix   int
+}
+
+// unpatch maps a location in the patched source back to the original source.
+func (p fieldPatch) unpatch(loc ast.Location) ast.Location {
+	if loc.Line != p.line || loc.Column <= p.begin {
+		return loc
+	}
+	switch {
+	case loc.Column < p.end+p.prefix:
+		loc.Column -= p.prefix
+		if loc.Column < p.begin {
+			loc.Column = p.begin
+		}
+	case loc.Column <= p.end+p.prefix+p.suffix:
+		loc.Column = p.end
+	default:
+		loc.Column -= p.prefix + p.suffix
+	}
+	return loc
+}
+
+// ParseDuplicateFields reports the duplicate fields of a file that failed to parse with
+// `err` because a field is defined twice. The parser stops at the second definition, so
+// it is rewritten as a computed field (which the parser does not check) and the file is
+// parsed again. Returns false if the error is not a duplicate field, or the file still
+// does not parse.
+func ParseDuplicateFields(filename, contents string, err error) ([]Diagnostic, bool) {
+	lines := strings.SplitAfter(contents, "\n")
+	patches := []fieldPatch{}
+	for i := 0; i < maxDuplicateReparses; i++ {
+		locErr, ok := err.(interface{ Loc() ast.LocationRange })
+		if !ok || !strings.Contains(err.Error(), duplicateFieldError) {
+			return nil, false
+		}
+		loc := locErr.Loc()
+		if loc.Begin.Line != loc.End.Line || loc.Begin.Line > len(lines) || loc.End.Column > len(lines[loc.Begin.Line-1])+1 {
+			return nil, false
+		}
+		line := lines[loc.Begin.Line-1]
+		name := line[loc.Begin.Column-1 : loc.End.Column-1]
+		prefix, suffix := "[", "]"
+		if analysis.SafeIdent(name) == name {
+			prefix, suffix = `["`, `"]`
+		}
+		lines[loc.Begin.Line-1] = line[:loc.Begin.Column-1] + prefix + name + suffix + line[loc.End.Column-1:]
+		patches = append(patches, fieldPatch{
+			line: loc.Begin.Line, begin: loc.Begin.Column, end: loc.End.Column,
+			prefix: len(prefix), suffix: len(suffix),
+		})
+
+		var root ast.Node
+		root, err = jsonnet.SnippetToAST(filename, strings.Join(lines, ""))
+		if err == nil {
+			return duplicateFields(root, func(loc ast.Location) ast.Location {
+				for i := len(patches) - 1; i >= 0; i-- {
+					loc = patches[i].unpatch(loc)
+				}
+				return loc
+			}), true
+		}
+	}
+	return nil, false
+}
@@ -2,12 +2,14 @@ package linter
 
 import (
 	"fmt"
+	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
 	"github.com/google/go-jsonnet/ast"
 	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
 )
 
 func FmtDiag(diag protocol.Diagnostic) string {
@@ -49,44 +51,6 @@ func rangeToProto(r ast.LocationRange) protocol.Range {
 	return protocol.Range{Start: posToProto(r.Begin), End: posToProto(r.End)}
 }
 
-type varbind struct {
-	def  ast.Node
-	name string
-}
-
-type varbindInfo struct {
-	refs  int
-	loc   ast.LocationRange
-	body  ast.Node
-	param bool
-}
-
-func findVarbindInStack(v string, stack []ast.Node) *varbind {
-	for i := len(stack) - 1; i >= 0; i-- {
-		switch n := stack[i].(type) {
-		case *ast.Local:
-			for _, b := range n.Binds {
-				if string(b.Variable) == v {
-					return &varbind{n, v}
-				}
-			}
-		case *ast.DesugaredObject:
-			for _, b := range n.Locals {
-				if string(b.Variable) == v {
-					return &varbind{n, v}
-				}
-			}
-		case *ast.Function:
-			for _, b := range n.Parameters {
-				if string(b.Name) == v {
-					return &varbind{n, v}
-				}
-			}
-		}
-	}
-	return nil
-}
-
 func sortDiags(diags []Diagnostic) []Diagnostic {
 	sort.Slice(diags, func(i, j int) bool {
 		if diags[i].Range.Start.Line != diags[j].Range.Start.Line {
@@ -158,13 +122,10 @@ func checkFunctionCall(fn *analysis.Value, call *ast.Apply, resolver analysis.Re
 
 	paramsByName := map[string]*analysis.Param{}
 	{
-		minArgs := 0
 		for _, param := range fn.Function.Params {
 			paramsByName[param.Name] = &param
-			if param.Default == nil {
-				minArgs++
-			}
 		}
+		minArgs, _ := fn.Function.Arity()
 
 		// too few arguments
 		if args := len(call.Arguments.Named) + len(call.Arguments.Positional); args < minArgs {
@@ -288,7 +249,7 @@ func checkIndex(target, idx *analysis.Value, node *ast.Index) []Diagnostic {
 				Range:    rangeToProto(node.LocRange),
 				Code:     TypeMismatch,
 				Severity: protocol.DiagnosticSeverityError,
-				Message:  fmt.Sprintf("cannot index array with type '%s' (expected number)", target.Type),
+				Message:  fmt.Sprintf("cannot index array with type '%s' (expected number)", idx.Type),
 			})
 		}
 	case analysis.ObjectType:
@@ -297,7 +258,7 @@ func checkIndex(target, idx *analysis.Value, node *ast.Index) []Diagnostic {
 				Range:    rangeToProto(node.LocRange),
 				Code:     TypeMismatch,
 				Severity: protocol.DiagnosticSeverityError,
-				Message:  fmt.Sprintf("cannot index object with type '%s' (expected string)", target.Type),
+				Message:  fmt.Sprintf("cannot index object with type '%s' (expected string)", idx.Type),
 			})
 		}
 		if sl, ok := idx.Node.(*ast.LiteralString); ok && target.Object != nil && target.Object.AllFieldsKnown && target.Object.FieldMap != nil {
@@ -316,7 +277,7 @@ func checkIndex(target, idx *analysis.Value, node *ast.Index) []Diagnostic {
 				Range:    rangeToProto(node.LocRange),
 				Code:     TypeMismatch,
 				Severity: protocol.DiagnosticSeverityError,
-				Message:  fmt.Sprintf("cannot index string with type '%s' (expected number)", target.Type),
+				Message:  fmt.Sprintf("cannot index string with type '%s' (expected number)", idx.Type),
 			})
 		}
 	default:
@@ -331,6 +292,95 @@ func checkIndex(target, idx *analysis.Value, node *ast.Index) []Diagnostic {
 	return diags
 }
 
+// inferredValue is the value of an expression with the type inferred for it, for the
+// expressions the value of which is not known, f.ex the branches of a conditional.
+func inferredValue(node ast.Node, resolver analysis.Resolver) *analysis.Value {
+	shape := analysis.InferShape(node, resolver)
+	res := &analysis.Value{Type: shape.Type, Function: shape.Function, Node: node}
+	if node != nil && node.Loc() != nil {
+		res.Range = *node.Loc()
+	}
+	return res
+}
+
+// typedValue is the value of an expression, or its inferred type if the value is not
+// known. Returns true if the type is inferred.
+func typedValue(node ast.Node, resolver analysis.Resolver) (*analysis.Value, bool) {
+	if val := analysis.NodeToValue(node, resolver); val.Type != analysis.AnyType {
+		return val, false
+	}
+	return inferredValue(node, resolver), true
+}
+
+// asWarnings lowers errors to warnings, for the diagnostics of inferred types which may
+// not be exact.
+func asWarnings(diags []Diagnostic) []Diagnostic {
+	for i := range diags {
+		if diags[i].Severity == protocol.DiagnosticSeverityError {
+			diags[i].Severity = protocol.DiagnosticSeverityWarning
+		}
+	}
+	return diags
+}
+
+// checkFormat checks the string formatted by `std.format` and the `%` operator, which is
+// desugared to `std.mod`. `%` is the modulo of two numbers, or formats a string.
+func checkFormat(call *ast.Apply, resolver analysis.Resolver) []Diagnostic {
+	name := analysis.StdCallName(call)
+	if (name != "mod" && name != "format") || len(call.Arguments.Positional) != 2 {
+		return nil
+	}
+	lhs, inferred := typedValue(call.Arguments.Positional[0].Expr, resolver)
+	rhs, _ := typedValue(call.Arguments.Positional[1].Expr, resolver)
+	switch {
+	case lhs.Type == analysis.AnyType || lhs.Type == analysis.StringType:
+		return nil
+	case name == "format" && !inferred:
+		// the type of a known argument is checked with the call
+		return nil
+	case name == "format":
+		return []Diagnostic{{
+			Range:    rangeToProto(call.LocRange),
+			Code:     TypeMismatch,
+			Severity: protocol.DiagnosticSeverityWarning,
+			Message:  fmt.Sprintf("mismatched argument type for 'str' expected 'string' got '%s'", lhs.Type),
+		}}
+	case lhs.Type != analysis.NumberType:
+		return []Diagnostic{{
+			Range:    rangeToProto(lhs.Range),
+			Code:     TypeMismatch,
+			Severity: protocol.DiagnosticSeverityWarning,
+			Message:  fmt.Sprintf("expected number or format string for lhs of operator '%%' but got type '%s'", lhs.Type),
+		}}
+	case rhs.Type != analysis.AnyType && rhs.Type != analysis.NumberType:
+		return []Diagnostic{{
+			Range:    rangeToProto(rhs.Range),
+			Code:     TypeMismatch,
+			Severity: protocol.DiagnosticSeverityWarning,
+			Message:  fmt.Sprintf("expected number for rhs of operator '%%' but got type '%s'", rhs.Type),
+		}}
+	}
+	return nil
+}
+
+// checkInferredIndex checks the fields of objects that are only known by their inferred
+// shape. The field must be in one of the objects of a union.
+func checkInferredIndex(target *analysis.Shape, idx *analysis.Value, node *ast.Index) []Diagnostic {
+	sl, ok := idx.Node.(*ast.LiteralString)
+	if !ok {
+		return nil
+	}
+	if has, known := target.HasField(sl.Value); has || !known {
+		return nil
+	}
+	return []Diagnostic{{
+		Range:    rangeToProto(node.LocRange),
+		Code:     UnknownField,
+		Severity: protocol.DiagnosticSeverityWarning,
+		Message:  fmt.Sprintf("object has no field '%s'", sl.Value),
+	}}
+}
+
 func checkBinaryOp(lhs, rhs *analysis.Value, node *ast.Binary) []Diagnostic {
 	if lhs.Type == analysis.AnyType || rhs.Type == analysis.AnyType {
 		return nil
@@ -390,7 +440,8 @@ func checkBinaryOp(lhs, rhs *analysis.Value, node *ast.Binary) []Diagnostic {
 			})
 		}
 	case ast.BopPlus:
-		if lhs.Type != rhs.Type {
+		// strings are concatenated with the string of any value
+		if lhs.Type != rhs.Type && lhs.Type != analysis.StringType && rhs.Type != analysis.StringType {
 			diags = append(diags, Diagnostic{
 				Range:    rangeToProto(node.LocRange),
 				Code:     TypeMismatch,
@@ -413,69 +464,172 @@ func checkBinaryOp(lhs, rhs *analysis.Value, node *ast.Binary) []Diagnostic {
 	return diags
 }
 
+// ImportSearcher is implemented by resolvers that can list the paths an import is searched
+// at, which are listed in the diagnostic of an import that is not found.
+type ImportSearcher interface {
+	SearchedPaths(from, path string) []string
+}
+
+// importNotFound reports an import, importstr, or importbin that is not found on its path.
+func importNotFound(n ast.Node, file *ast.LiteralString, resolver analysis.Resolver) Diagnostic {
+	rng := *n.Loc()
+	if file.LocRange.IsSet() {
+		rng = file.LocRange
+	}
+	msg := fmt.Sprintf("import not found: '%s'", file.Value)
+	if searcher, ok := resolver.(ImportSearcher); ok && n.Loc() != nil {
+		if paths := searcher.SearchedPaths(n.Loc().FileName, file.Value); len(paths) > 0 {
+			msg += "\nsearched:\n  " + strings.Join(paths, "\n  ")
+		}
+	}
+	return Diagnostic{
+		Range:    rangeToProto(rng),
+		Code:     ImportNotFound,
+		Severity: protocol.DiagnosticSeverityWarning,
+		Message:  msg,
+	}
+}
+
+// ImportChecker is implemented by resolvers that know when an imported file is found but
+// does not parse, which is reported at the import instead of the import not being found.
+type ImportChecker interface {
+	ImportError(from, path string) error
+}
+
+// importError reports an import of a file that does not parse. The file is imported as
+// the last version of it that did, if there is one, so the error is linked to.
+func importError(n *ast.Import, err error) Diagnostic {
+	rng := n.LocRange
+	if n.File.LocRange.IsSet() {
+		rng = n.File.LocRange
+	}
+	diag := Diagnostic{
+		Range:    rangeToProto(rng),
+		Code:     ImportError,
+		Severity: protocol.DiagnosticSeverityError,
+		Message:  fmt.Sprintf("imported file '%s' has errors: %s", n.File.Value, err),
+	}
+	if locErr, ok := err.(interface{ Loc() ast.LocationRange }); ok {
+		if loc := locErr.Loc(); loc.IsSet() {
+			diag.RelatedInformation = []protocol.DiagnosticRelatedInformation{{
+				Location: protocol.Location{URI: uri.File(loc.FileName), Range: rangeToProto(loc)},
+				Message:  fmt.Sprintf("error in %s", filepath.Base(loc.FileName)),
+			}}
+		}
+	}
+	return diag
+}
+
+// RawImporter is implemented by resolvers that can find the files imported with importstr
+// and importbin, which are not parsed.
+type RawImporter interface {
+	ImportedSize(from, path string) (int, bool)
+}
+
+// maxImportBinSize is the size of the files imported with importbin above which the import
+// is reported, as every byte of the file is an element of the array it is imported as.
+const maxImportBinSize = 1 << 20
+
+// checkRawImport reports an importstr or importbin of a file that is not found, and an
+// importbin of a large file.
+func checkRawImport(n ast.Node, file *ast.LiteralString, resolver analysis.Resolver) []Diagnostic {
+	raw, ok := resolver.(RawImporter)
+	if !ok || file == nil || n.Loc() == nil {
+		return nil
+	}
+	size, found := raw.ImportedSize(n.Loc().FileName, file.Value)
+	if !found {
+		return []Diagnostic{importNotFound(n, file, resolver)}
+	}
+	if _, bin := n.(*ast.ImportBin); bin && size > maxImportBinSize {
+		rng := *n.Loc()
+		if file.LocRange.IsSet() {
+			rng = file.LocRange
+		}
+		return []Diagnostic{{
+			Range:    rangeToProto(rng),
+			Code:     LargeImport,
+			Severity: protocol.DiagnosticSeverityWarning,
+			Message:  fmt.Sprintf("importbin of a %.1f MB file: every byte is an element of the array it is imported as", float64(size)/(1<<20)),
+		}}
+	}
+	return nil
+}
+
+// checkNative reports a call to `std.native` with the name of a function that is not
+// registered, which returns null.
+func checkNative(call *ast.Apply, resolver analysis.Resolver) []Diagnostic {
+	natives, ok := resolver.(analysis.NativeResolver)
+	if !ok {
+		return nil
+	}
+	name, ok := analysis.NativeName(call)
+	if !ok {
+		return nil
+	}
+	if _, found := natives.NativeFunction(name.Value); found {
+		return nil
+	}
+	return []Diagnostic{{
+		Range:    rangeToProto(name.LocRange),
+		Code:     UnknownNative,
+		Severity: protocol.DiagnosticSeverityWarning,
+		Message:  fmt.Sprintf("native function '%s' not found, std.native returns null", name.Value),
+	}}
+}
+
 func LintAST(root ast.Node, resolver analysis.Resolver) []Diagnostic {
-	diags := []Diagnostic{}
-	declaredVars := map[varbind]*varbindInfo{}
+	diags := ImportCycles(root, resolver)
 
-	analysis.WalkStack(root, func(n ast.Node, stack []ast.Node) bool {
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
 		switch n := n.(type) {
-		case *ast.Local:
-			for _, b := range n.Binds {
-				declaredVars[varbind{n, string(b.Variable)}] = &varbindInfo{loc: b.LocRange, body: b.Body}
-			}
-		case *ast.DesugaredObject:
-			// add $
-			declaredVars[varbind{n, "self"}] = &varbindInfo{loc: n.LocRange, body: n}
-			for _, b := range n.Locals {
-				declaredVars[varbind{n, string(b.Variable)}] = &varbindInfo{loc: b.LocRange, body: b.Body}
-			}
-		case *ast.Function:
-			for _, b := range n.Parameters {
-				declaredVars[varbind{n, string(b.Name)}] = &varbindInfo{loc: b.LocRange, body: b.DefaultArg, param: true}
-			}
-		case *ast.Var:
-			// unknown variables references result in AST errors, so this should always succeed
-			if bound := findVarbindInStack(string(n.Id), stack); bound != nil {
-				declaredVars[*bound].refs++
-			}
 		case *ast.Import:
+			if checker, ok := resolver.(ImportChecker); ok && n.File != nil && n.Loc() != nil {
+				if err := checker.ImportError(n.Loc().FileName, n.File.Value); err != nil {
+					diags = append(diags, importError(n, err))
+					break
+				}
+			}
 			val := analysis.NodeToValue(n, resolver)
 			if val.Node == nil && val.Type == analysis.AnyType {
-				diags = append(diags, Diagnostic{
-					Range:    rangeToProto(n.LocRange),
-					Code:     ImportNotFound,
-					Severity: protocol.DiagnosticSeverityWarning,
-					Message:  fmt.Sprintf("import not found: '%s'", n.File.Value),
-				})
+				diags = append(diags, importNotFound(n, n.File, resolver))
 			}
+		case *ast.ImportStr:
+			diags = append(diags, checkRawImport(n, n.File, resolver)...)
+		case *ast.ImportBin:
+			diags = append(diags, checkRawImport(n, n.File, resolver)...)
 		case *ast.Apply:
 			targFn := analysis.NodeToValue(n.Target, resolver)
+			if targFn.Type == analysis.AnyType {
+				targFn = inferredValue(n.Target, resolver)
+			}
 			diags = append(diags, checkFunctionCall(targFn, n, resolver)...)
+			diags = append(diags, checkFormat(n, resolver)...)
+			diags = append(diags, checkNative(n, resolver)...)
 		case *ast.Index:
 			target := analysis.NodeToValue(n.Target, resolver)
-			idx := analysis.NodeToValue(n.Index, resolver)
-			diags = append(diags, checkIndex(target, idx, n)...)
+			idx, _ := typedValue(n.Index, resolver)
+			if target.Type != analysis.AnyType {
+				diags = append(diags, checkIndex(target, idx, n)...)
+				break
+			}
+			shape := analysis.InferShape(n.Target, resolver)
+			diags = append(diags, asWarnings(checkIndex(inferredValue(n.Target, resolver), idx, n))...)
+			diags = append(diags, checkInferredIndex(shape, idx, n)...)
 		case *ast.Unary:
-			lhs := analysis.NodeToValue(n.Expr, resolver)
-			diags = append(diags, checkUnaryOp(lhs, n)...)
+			lhs, inferred := typedValue(n.Expr, resolver)
+			if d := checkUnaryOp(lhs, n); inferred {
+				diags = append(diags, asWarnings(d)...)
+			} else {
+				diags = append(diags, d...)
+			}
 		case *ast.Binary:
-			lhs := analysis.NodeToValue(n.Left, resolver)
-			rhs := analysis.NodeToValue(n.Right, resolver)
+			lhs, _ := typedValue(n.Left, resolver)
+			rhs, _ := typedValue(n.Right, resolver)
 			diags = append(diags, checkBinaryOp(lhs, rhs, n)...)
 		}
 		return true
 	})
 
-	for bind, info := range declaredVars {
-		if info.refs == 0 && !info.param && !strings.HasPrefix(bind.name, "$") && bind.name != "self" {
-			diags = append(diags, protocol.Diagnostic{
-				Range:    rangeToProto(info.loc),
-				Code:     UnusedVar,
-				Severity: protocol.DiagnosticSeverityWarning,
-				Message:  fmt.Sprintf("unused local variable '%s'", bind.name),
-			})
-		}
-	}
-
 	return sortDiags(diags)
 }
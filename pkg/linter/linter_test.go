@@ -5,6 +5,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 
@@ -28,6 +29,25 @@ var linterCases = []linterCase{
 		File: "unused_vars.jsonnet",
 		Expect: []string{
 			"[Warning|UnusedVar|2:7-2:17] unused local variable 'x'",
+			"[Hint|UnusedParam|4:15-4:21] unused parameter 'unused'",
+			"[Hint|UnusedParam|5:20-5:23] unused parameter 'arg'",
+		},
+	},
+	{
+		File: "unused_imports.jsonnet",
+		Expect: []string{
+			"[Warning|UnusedImport|1:7-1:45] unused import 'functions'",
+			"[Warning|UnusedImport|4:7-4:45] unused import 'text'",
+			"[Warning|UnusedImport|7:9-7:47] unused import 'unusedObj'",
+		},
+	},
+	{
+		File: "duplicate_fields.jsonnet",
+		Expect: []string{
+			"[Error|DuplicateField|2:3-2:4] duplicate field 'a'",
+			"[Error|DuplicateField|3:4-3:7] duplicate field 'a'",
+			"[Error|DuplicateField|4:8-4:9] duplicate field 'c'",
+			"[Error|DuplicateField|4:15-4:18] duplicate field 'c'",
 		},
 	},
 	{
@@ -37,11 +57,50 @@ var linterCases = []linterCase{
 			"[Error|ArgumentCardinality|2:21-2:45] too many arguments in function call (3 arguments for 2 parameters)",
 			"[Warning|TypeMismatch|3:22-3:32] mismatched argument type for 'arr' expected 'array' got 'number'",
 			"[Error|TypeMismatch|5:24-5:35] calling non-function type 'string'",
+			"[Hint|UnusedParam|6:19-6:20] unused parameter 'a'",
+			"[Hint|UnusedParam|6:27-6:28] unused parameter 'b'",
 			"[Warning|ArgumentCardinality|7:29-7:50] duplicate named argument 'a'",
+			"[Hint|UnusedParam|8:15-8:16] unused parameter 'a'",
+			"[Hint|UnusedParam|8:29-8:30] unused parameter 'b'",
+			"[Hint|UnusedParam|8:43-8:44] unused parameter 'c'",
 			"[Warning|TypeMismatch|9:26-9:43] mismatched argument type for 'a' expected 'string' got 'number'",
 			"[Warning|TypeMismatch|9:26-9:43] mismatched argument type for 'b' expected 'number' got 'boolean'",
 		},
 	},
+	{
+		File: "cycle_a.jsonnet",
+		Expect: []string{
+			"[Warning|ImportCycle|1:11-1:35] import cycle: cycle_a.jsonnet → cycle_b.jsonnet → cycle_c.jsonnet → cycle_a.jsonnet",
+		},
+	},
+	{
+		File: "shadowed.jsonnet",
+		Expect: []string{
+			"[Information|ShadowedVar|4:20-4:26] parameter 'config' shadows the local on line 2",
+			"[Warning|ShadowedVar|6:9-6:10] local 'k' shadows the import on line 1",
+		},
+	},
+	{
+		File: "misuse.jsonnet",
+		Expect: []string{
+			"[Warning|TypeMismatch|5:21-5:26] cannot index type 'number'",
+			"[Warning|TypeMismatch|6:21-6:30] cannot index type 'number'",
+			"[Warning|TypeMismatch|7:26-7:30] expected number for rhs of operator '-' but got type 'string'",
+			"[Warning|TypeMismatch|8:19-8:33] + operator cannot add different types 'number' and 'object'",
+			"[Warning|TypeMismatch|10:22-10:43] mismatched argument type for 'str' expected 'string' got 'number'",
+			"[Warning|TypeMismatch|11:37-11:41] expected number or format string for lhs of operator '%' but got type 'boolean'",
+			"[Warning|TypeMismatch|12:29-12:32] expected number for rhs of operator '%' but got type 'array'",
+		},
+	},
+	{
+		File: "inferred.jsonnet",
+		Expect: []string{
+			"[Warning|UnknownField|3:22-3:27] object has no field 'c'",
+			"[Error|ArgumentCardinality|6:21-6:29] too many arguments in function call (2 arguments for 1 parameters)",
+			"[Error|TypeMismatch|8:24-8:35] calling non-function type 'string'",
+			"[Warning|TypeMismatch|12:18-12:28] mismatched argument type for 'n' expected 'number' got 'string'",
+		},
+	},
 }
 
 func fmtDiags(diags []protocol.Diagnostic) string {
@@ -61,7 +120,13 @@ func TestLinter(t *testing.T) {
 			require.NoError(t, err, "must be able to import root AST")
 
 			resolver := NewResolver(root, vm)
-			diags := linter.LintAST(root, resolver)
+			diags := append(linter.LintAST(root, resolver), linter.UnusedBindings(root)...)
+			diags = append(diags, linter.DuplicateFields(root)...)
+			diags = append(diags, linter.ShadowedBindings(root, nil)...)
+			sort.SliceStable(diags, func(i, j int) bool {
+				a, b := diags[i].Range.Start, diags[j].Range.Start
+				return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+			})
 			require.Equal(t, len(c.Expect), len(diags), "mismatch in expected length of diags, got:\n%s", fmtDiags(diags))
 			for i, d := range diags {
 				assert.Equal(t, c.Expect[i], linter.FmtDiag(d), "mismatch on diag %d", i)
@@ -70,6 +135,201 @@ func TestLinter(t *testing.T) {
 	}
 }
 
+func TestImportCycles(t *testing.T) {
+	vm := jsonnet.MakeVM()
+	vm.Importer(&FSImporter{FS: testdata.TestDataFS})
+	root, _, err := vm.ImportAST("cycle_b.jsonnet", "cycle_b.jsonnet")
+	require.NoError(t, err)
+
+	diags := linter.ImportCycles(root, NewResolver(root, vm))
+	require.Len(t, diags, 1)
+	related := []string{}
+	for _, rel := range diags[0].RelatedInformation {
+		related = append(related, fmt.Sprintf("%s:%d %s", filepath.Base(rel.Location.URI.Filename()), rel.Location.Range.Start.Line+1, rel.Message))
+	}
+	assert.Equal(t, []string{
+		"cycle_b.jsonnet:1 cycle_b.jsonnet imports cycle_c.jsonnet",
+		"cycle_c.jsonnet:1 cycle_c.jsonnet imports cycle_a.jsonnet",
+		"cycle_a.jsonnet:1 cycle_a.jsonnet imports cycle_b.jsonnet",
+	}, related)
+
+	// files importing a cycle they are not part of have no diagnostics
+	root, err = jsonnet.SnippetToAST("main.jsonnet", "import 'cycle_a.jsonnet'")
+	require.NoError(t, err)
+	assert.Empty(t, linter.ImportCycles(root, NewResolver(root, vm)))
+}
+
+// searchingResolver lists fixed paths for imports that are not found.
+type searchingResolver struct {
+	*resolver
+	paths []string
+}
+
+func (r searchingResolver) SearchedPaths(from, path string) []string {
+	return r.paths
+}
+
+func TestImportNotFound(t *testing.T) {
+	vm := jsonnet.MakeVM()
+	vm.Importer(&FSImporter{FS: testdata.TestDataFS})
+	root, err := jsonnet.SnippetToAST("main.jsonnet", "local a = import 'missing.libsonnet';\na")
+	require.NoError(t, err)
+
+	diags := linter.LintAST(root, searchingResolver{NewResolver(root, vm), []string{"missing.libsonnet", "vendor/missing.libsonnet"}})
+	require.Len(t, diags, 1)
+	assert.Equal(t, "[Warning|ImportNotFound|1:18-1:37] import not found: 'missing.libsonnet'\nsearched:\n  missing.libsonnet\n  vendor/missing.libsonnet", linter.FmtDiag(diags[0]))
+}
+
+// checkingResolver reports fixed errors for the imported files that do not parse.
+type checkingResolver struct {
+	*resolver
+	errs map[string]error
+}
+
+func (r checkingResolver) ImportError(from, path string) error {
+	return r.errs[path]
+}
+
+func TestImportError(t *testing.T) {
+	_, parseErr := jsonnet.SnippetToAST("broken.libsonnet", "{ a: }")
+	require.Error(t, parseErr)
+	vm := jsonnet.MakeVM()
+	vm.Importer(&FSImporter{FS: testdata.TestDataFS})
+	root, err := jsonnet.SnippetToAST("main.jsonnet", "local b = import 'broken.libsonnet';\nb")
+	require.NoError(t, err)
+
+	diags := linter.LintAST(root, checkingResolver{NewResolver(root, vm), map[string]error{"broken.libsonnet": parseErr}})
+	require.Len(t, diags, 1)
+	assert.Equal(t, "[Error|ImportError|1:18-1:36] imported file 'broken.libsonnet' has errors: "+parseErr.Error(), linter.FmtDiag(diags[0]))
+	require.Len(t, diags[0].RelatedInformation, 1)
+	assert.Equal(t, "error in broken.libsonnet", diags[0].RelatedInformation[0].Message)
+	assert.Equal(t, protocol.Position{Line: 0, Character: 5}, diags[0].RelatedInformation[0].Location.Range.Start)
+}
+
+// sizingResolver finds the files of raw imports with fixed sizes.
+type sizingResolver struct {
+	*resolver
+	sizes map[string]int
+}
+
+func (r sizingResolver) ImportedSize(from, path string) (int, bool) {
+	size, ok := r.sizes[path]
+	return size, ok
+}
+
+func TestRawImports(t *testing.T) {
+	vm := jsonnet.MakeVM()
+	vm.Importer(&FSImporter{FS: testdata.TestDataFS})
+	root, err := jsonnet.SnippetToAST("main.jsonnet", "[importstr 'a.txt', importstr 'missing.txt', importbin 'small.bin', importbin 'large.bin']")
+	require.NoError(t, err)
+
+	diags := linter.LintAST(root, sizingResolver{NewResolver(root, vm), map[string]int{"a.txt": 10, "small.bin": 1024, "large.bin": 3 << 20}})
+	require.Len(t, diags, 2)
+	assert.Equal(t, "[Warning|ImportNotFound|1:31-1:44] import not found: 'missing.txt'", linter.FmtDiag(diags[0]))
+	assert.Equal(t, "[Warning|LargeImport|1:79-1:90] importbin of a 3.0 MB file: every byte is an element of the array it is imported as", linter.FmtDiag(diags[1]))
+}
+
+// nativeResolver knows the native functions registered in the VM.
+type nativeResolver struct {
+	*resolver
+	natives map[string]*analysis.Function
+}
+
+func (r nativeResolver) NativeFunction(name string) (*analysis.Function, bool) {
+	fn, ok := r.natives[name]
+	return fn, ok
+}
+
+func TestNativeFunctions(t *testing.T) {
+	vm := jsonnet.MakeVM()
+	root, err := jsonnet.SnippetToAST("main.jsonnet", "local sha = std.native('sha256'); [sha('a'), sha('a', 'b'), std.native('missing')('a')]")
+	require.NoError(t, err)
+
+	natives := map[string]*analysis.Function{"sha256": {Params: []analysis.Param{{Name: "str"}}}}
+	diags := linter.LintAST(root, nativeResolver{NewResolver(root, vm), natives})
+	require.Len(t, diags, 2)
+	assert.Equal(t, "[Error|ArgumentCardinality|1:46-1:59] too many arguments in function call (2 arguments for 1 parameters)", linter.FmtDiag(diags[0]))
+	assert.Equal(t, "[Warning|UnknownNative|1:72-1:81] native function 'missing' not found, std.native returns null", linter.FmtDiag(diags[1]))
+
+	// natives are not reported by resolvers that do not know them
+	assert.Empty(t, linter.LintAST(root, NewResolver(root, vm)))
+}
+
+func TestShadowedBindingsAllowed(t *testing.T) {
+	root, err := jsonnet.SnippetToAST("main.jsonnet", "local k = import 'k.libsonnet';\nlocal f(k, x) = local x = k; x;\nf")
+	require.NoError(t, err)
+	diags := linter.ShadowedBindings(root, []string{"k"})
+	require.Len(t, diags, 1)
+	assert.Equal(t, "[Information|ShadowedVar|2:23-2:24] local 'x' shadows the parameter on line 2", linter.FmtDiag(diags[0]))
+	assert.Equal(t, uint32(1), diags[0].RelatedInformation[0].Location.Range.Start.Line)
+}
+
+func TestDocParams(t *testing.T) {
+	src := `// Adds two numbers.
+// @param a the first
+// @param c the second
+local add(a, b) = a + b;
+local d = import 'doc-util/main.libsonnet';
+{
+  /* The name of the service.
+   * @param envName the environment */
+  name(env):: env,
+  '#address':: d.fn('The address.', [d.arg('env', d.T.string), d.arg('port', d.T.number)]),
+  address(env):: env,
+  x: add(1, 2),
+}
+`
+	root, err := jsonnet.SnippetToAST("main.jsonnet", src)
+	require.NoError(t, err)
+	diags := linter.DocParams(root)
+	require.Len(t, diags, 3)
+	assert.Equal(t, "[Warning|UnknownDocParam|3:11-3:12] 'c' is not a parameter of 'add', its parameters are: a, b", linter.FmtDiag(diags[0]))
+	assert.Equal(t, "[Warning|UnknownDocParam|8:13-8:20] 'envName' is not a parameter of 'name', its parameters are: env", linter.FmtDiag(diags[1]))
+	assert.Equal(t, "[Warning|UnknownDocParam|10:70-10:76] 'port' is not a parameter of 'address', its parameters are: env", linter.FmtDiag(diags[2]))
+}
+
+func TestParseDuplicateFields(t *testing.T) {
+	src := "{\n  a: 1, 'b': 2,\n  c: { a: 1 },\n  b: 3, a: 4,\n}\n"
+	_, err := jsonnet.SnippetToAST("dup.jsonnet", src)
+	require.Error(t, err)
+
+	diags, ok := linter.ParseDuplicateFields("dup.jsonnet", src, err)
+	require.True(t, ok)
+	expect := []string{
+		"[Error|DuplicateField|2:3-2:4] duplicate field 'a'",
+		"[Error|DuplicateField|2:9-2:12] duplicate field 'b'",
+		"[Error|DuplicateField|4:3-4:4] duplicate field 'b'",
+		"[Error|DuplicateField|4:9-4:10] duplicate field 'a'",
+	}
+	require.Equal(t, len(expect), len(diags), "mismatch in expected length of diags, got:\n%s", fmtDiags(diags))
+	for i, d := range diags {
+		assert.Equal(t, expect[i], linter.FmtDiag(d), "mismatch on diag %d", i)
+	}
+	require.Len(t, diags[0].RelatedInformation, 1)
+	assert.Equal(t, diags[3].Range, diags[0].RelatedInformation[0].Location.Range)
+
+	_, err = jsonnet.SnippetToAST("err.jsonnet", "{ a: }")
+	_, ok = linter.ParseDuplicateFields("err.jsonnet", "{ a: }", err)
+	assert.False(t, ok)
+}
+
+func TestParseSeverity(t *testing.T) {
+	def := protocol.DiagnosticSeverityWarning
+	for rule, expect := range map[string]protocol.DiagnosticSeverity{
+		"":        def,
+		"Error":   protocol.DiagnosticSeverityError,
+		"hint":    protocol.DiagnosticSeverityHint,
+		"info":    protocol.DiagnosticSeverityInformation,
+		"unknown": def,
+	} {
+		sev, ok := linter.ParseSeverity(rule, def)
+		assert.True(t, ok, rule)
+		assert.Equal(t, expect, sev, rule)
+	}
+	_, ok := linter.ParseSeverity("off", def)
+	assert.False(t, ok)
+}
+
 // FSImporter imports data from the filesystem.
 type FSImporter struct {
 	FS      fs.FS
@@ -0,0 +1,68 @@
+package linter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// declKind describes a declaration in a diagnostic.
+func declKind(decl analysis.Decl) string {
+	switch {
+	case decl.Param:
+		return "parameter"
+	case isImport(decl.Body):
+		return "import"
+	}
+	return "local"
+}
+
+// ShadowedBindings reports locals and parameters that hide a variable of an enclosing
+// scope, which makes the outer variable unreachable where the inner one is in scope.
+// Hiding an import (f.ex `k` or `lib`) is a warning, as code written for the import will
+// silently use the inner variable instead. Names starting with an underscore, and the
+// names in `allowed`, can be shadowed.
+func ShadowedBindings(root ast.Node, allowed []string) []Diagnostic {
+	diags := []Diagnostic{}
+	skip := map[string]bool{}
+	for _, name := range allowed {
+		skip[name] = true
+	}
+	analysis.WalkStack(root, func(n ast.Node, stack []ast.Node) bool {
+		for _, decl := range analysis.Decls(n) {
+			if !decl.Range.IsSet() || strings.HasPrefix(decl.Name, "_") || skip[decl.Name] {
+				continue
+			}
+			outer := analysis.FindBinding(decl.Name, stack[:len(stack)-1])
+			if outer == nil {
+				continue
+			}
+			prev := outer.Decl()
+			// variables introduced by desugaring are not written by the user
+			if prev == nil || !prev.Range.IsSet() {
+				continue
+			}
+			diag := Diagnostic{
+				Range:    rangeToProto(decl.Range),
+				Code:     ShadowedVar,
+				Severity: protocol.DiagnosticSeverityInformation,
+				Message: fmt.Sprintf("%s '%s' shadows the %s on line %d",
+					declKind(decl), decl.Name, declKind(*prev), prev.Range.Begin.Line),
+				RelatedInformation: []protocol.DiagnosticRelatedInformation{{
+					Location: protocol.Location{URI: uri.File(prev.Range.FileName), Range: rangeToProto(prev.Range)},
+					Message:  fmt.Sprintf("'%s' is declared here", decl.Name),
+				}},
+			}
+			if isImport(prev.Body) {
+				diag.Severity = protocol.DiagnosticSeverityWarning
+			}
+			diags = append(diags, diag)
+		}
+		return true
+	})
+	return sortDiags(diags)
+}
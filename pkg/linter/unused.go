@@ -0,0 +1,81 @@
+package linter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+// isImport returns true if the node is an `import`, `importstr`, or `importbin`.
+func isImport(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.Import, *ast.ImportStr, *ast.ImportBin:
+		return true
+	}
+	return false
+}
+
+// bindRange returns the range of the bind `name = ...` of a local, or the range of the
+// name if the bind has none (f.ex binds using function syntax, and parameters).
+func bindRange(def ast.Node, decl analysis.Decl) ast.LocationRange {
+	var binds ast.LocalBinds
+	switch def := def.(type) {
+	case *ast.Local:
+		binds = def.Binds
+	case *ast.DesugaredObject:
+		binds = def.Locals
+	}
+	for _, b := range binds {
+		if string(b.Variable) == decl.Name && b.LocRange.IsSet() {
+			return b.LocRange
+		}
+	}
+	return decl.Range
+}
+
+// UnusedBindings reports locals, imports, and parameters that are never referenced. This
+// only resolves variables in the AST and needs no VM, so it is cheap enough to run on
+// every change. Names starting with an underscore are intentionally unused.
+func UnusedBindings(root ast.Node) []Diagnostic {
+	diags := []Diagnostic{}
+	refs := map[analysis.Binding]int{}
+	analysis.WalkStack(root, func(n ast.Node, stack []ast.Node) bool {
+		if v, ok := n.(*ast.Var); ok {
+			if bind := analysis.FindBinding(string(v.Id), stack); bind != nil {
+				refs[*bind]++
+			}
+		}
+		return true
+	})
+
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		for _, decl := range analysis.Decls(n) {
+			// variables introduced by desugaring (like `$` or comprehension variables) have no range
+			if !decl.Range.IsSet() || strings.HasPrefix(decl.Name, "_") || refs[analysis.Binding{Def: n, Name: decl.Name}] > 0 {
+				continue
+			}
+			diag := Diagnostic{
+				Range: rangeToProto(bindRange(n, decl)),
+				Tags:  []protocol.DiagnosticTag{protocol.DiagnosticTagUnnecessary},
+			}
+			switch {
+			case decl.Param:
+				// parameters are often required by the caller, so are only hinted at
+				diag.Code, diag.Severity = UnusedParam, protocol.DiagnosticSeverityHint
+				diag.Message = fmt.Sprintf("unused parameter '%s'", decl.Name)
+			case isImport(decl.Body):
+				diag.Code, diag.Severity = UnusedImport, protocol.DiagnosticSeverityWarning
+				diag.Message = fmt.Sprintf("unused import '%s'", decl.Name)
+			default:
+				diag.Code, diag.Severity = UnusedVar, protocol.DiagnosticSeverityWarning
+				diag.Message = fmt.Sprintf("unused local variable '%s'", decl.Name)
+			}
+			diags = append(diags, diag)
+		}
+		return true
+	})
+	return sortDiags(diags)
+}
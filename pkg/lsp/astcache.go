@@ -0,0 +1,79 @@
+package lsp
+
+import (
+	"crypto/sha256"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// importASTCache keeps the parsed AST of every imported file, by the hash of its contents.
+// It is shared by all VMs, so switching between files that import the same large library
+// does not parse it again when a new VM is created for another file.
+type importASTCache struct {
+	lock sync.Mutex
+	// only the latest contents of each file are kept
+	files map[string]cachedAST
+}
+
+type cachedAST struct {
+	hash [sha256.Size]byte
+	root ast.Node
+	// the size of the contents, and when the AST was last used
+	size int
+	used time.Time
+}
+
+func newImportASTCache() *importASTCache {
+	return &importASTCache{files: map[string]cachedAST{}}
+}
+
+// get returns the AST of the file at `foundAt`, if it was parsed with the same contents.
+func (c *importASTCache) get(foundAt string, contents jsonnet.Contents) (ast.Node, bool) {
+	hash := sha256.Sum256(contents.Data())
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ent, ok := c.files[foundAt]
+	if !ok || ent.hash != hash {
+		return nil, false
+	}
+	ent.used = time.Now()
+	c.files[foundAt] = ent
+	return ent.root, true
+}
+
+func (c *importASTCache) put(foundAt string, contents jsonnet.Contents, root ast.Node) {
+	hash := sha256.Sum256(contents.Data())
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.files[foundAt] = cachedAST{hash: hash, root: root, size: len(contents.Data()), used: time.Now()}
+}
+
+// usage returns the number of cached ASTs, and the size of their contents.
+func (c *importASTCache) usage() (files, bytes int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, ent := range c.files {
+		bytes += ent.size
+	}
+	return len(c.files), bytes
+}
+
+// evict drops the least recently used half of the ASTs, and returns how many were dropped.
+func (c *importASTCache) evict() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	paths := make([]string, 0, len(c.files))
+	for path := range c.files {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return c.files[paths[i]].used.Before(c.files[paths[j]].used) })
+	evicted := (len(paths) + 1) / 2
+	for _, path := range paths[:evicted] {
+		delete(c.files, path)
+	}
+	return evicted
+}
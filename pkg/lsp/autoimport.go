@@ -0,0 +1,120 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Fields of other files are only completed with their import once the start of their name
+// is typed, as every library of the workspace matches a shorter one.
+const (
+	minAutoImportPrefix = 2
+	maxAutoImports      = 50
+)
+
+// importName returns the name a file is imported as: its name without the extension, or
+// the name of its directory for `main.libsonnet`. Returns false if it is not an identifier.
+func importName(file string) (string, bool) {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	if name == "main" {
+		name = filepath.Base(filepath.Dir(file))
+	}
+	name = strings.NewReplacer("-", "_", ".", "_").Replace(name)
+	return name, name != "" && analysis.SafeIdent(name) == name
+}
+
+// autoImportCandidates returns the fields exported by the libsonnet files of the workspace
+// whose name starts with the one being typed. They are inserted as an access of the field
+// of the import, and add the import at the top of the file unless it is already imported
+// by a variable in scope.
+func (s *Server) autoImportCandidates(ctx context.Context, u uri.URI, contents, prefix string, vars analysis.VarMap) []completionCandidate {
+	res := []completionCandidate{}
+	if len(prefix) < minAutoImportPrefix {
+		return res
+	}
+	from := u.Filename()
+	folder := s.folderOf(u)
+
+	// the variables in scope that import a file, by the file
+	imported := map[string]string{}
+	for name, v := range vars {
+		if imp, ok := v.Node.(*ast.Import); ok && imp.File != nil {
+			if _, foundAt, err := folder.importer.Import(from, imp.File.Value); err == nil {
+				imported[filepath.Clean(foundAt)] = name
+			}
+		}
+	}
+
+	line, quote := analysis.ImportInsertLine(contents)
+	insertAt := protocol.Position{Line: uint32(line - 1)}
+	lower := strings.ToLower(prefix)
+	for _, f := range s.indexedFiles(ctx, "") {
+		file := filepath.Clean(f.uri.Filename())
+		if file == filepath.Clean(from) || filepath.Ext(file) != ".libsonnet" {
+			continue
+		}
+		fields := []string{}
+		for _, name := range f.exports {
+			if strings.HasPrefix(strings.ToLower(name), lower) && analysis.SafeIdent(name) == name {
+				fields = append(fields, name)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		lib, ok := imported[file]
+		doc := fmt.Sprintf("`%s` is imported as `%s`", filepath.Base(file), lib)
+		var edits []protocol.TextEdit
+		if !ok {
+			if lib, ok = importName(file); !ok || vars[lib] != nil {
+				continue
+			}
+			path, found := s.importPath(from, file, nil)
+			if !found {
+				continue
+			}
+			imp := fmt.Sprintf("local %s = import %c%s%c;", lib, quote, path, quote)
+			doc = fmt.Sprintf("Adds `%s`", imp)
+			edits = s.positions().editsToClient(u, []protocol.TextEdit{{
+				Range:   protocol.Range{Start: insertAt, End: insertAt},
+				NewText: imp + "\n",
+			}})
+		}
+
+		details := map[string]analysis.Symbol{}
+		for _, sym := range f.symbols {
+			if sym.Kind == analysis.FieldSymbol {
+				details[sym.Name] = sym
+			}
+		}
+		for _, name := range fields {
+			sym := details[name]
+			if sym.Hidden {
+				continue
+			}
+			res = append(res, completionCandidate{item: protocol.CompletionItem{
+				Label:               name,
+				Detail:              lib + "." + name + " " + sym.Detail,
+				Documentation:       &protocol.MarkupContent{Kind: protocol.Markdown, Value: doc},
+				Kind:                typeToCompletionKind(sym.Type, protocol.CompletionItemKindField),
+				InsertText:          lib + "." + name,
+				FilterText:          name,
+				AdditionalTextEdits: edits,
+			}, typ: sym.Type})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].item.Label < res[j].item.Label })
+	if len(res) > maxAutoImports {
+		res = res[:maxAutoImports]
+	}
+	return res
+}
@@ -0,0 +1,85 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// jsonnetfile is the manifest (or lock file) of jsonnet-bundler, listing the packages
+// installed into the vendor directory.
+type jsonnetfile struct {
+	Dependencies []struct {
+		Source struct {
+			Git *struct {
+				Remote string `json:"remote"`
+				Subdir string `json:"subdir"`
+			} `json:"git"`
+		} `json:"source"`
+		// Name is the legacy import name of the package
+		Name string `json:"name"`
+	} `json:"dependencies"`
+}
+
+// jsonnetBundle is a workspace using jsonnet-bundler.
+type jsonnetBundle struct {
+	// vendor is the directory packages are installed to, relative to the root
+	vendor string
+	// legacy maps the legacy import names of packages (f.ex `ksonnet-util`) to their
+	// directory relative to the root. jsonnet-bundler symlinks these into the vendor
+	// directory, but the links may be missing.
+	legacy map[string]string
+}
+
+// gitPackagePath returns the path of a git package in the vendor directory, which is the
+// host and path of the remote, f.ex `github.com/org/repo`.
+func gitPackagePath(remote string) string {
+	remote = strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git")
+	if i := strings.Index(remote, "://"); i >= 0 {
+		remote = remote[i+3:]
+	} else if i := strings.Index(remote, ":"); i >= 0 {
+		// scp-like syntax: git@github.com:org/repo
+		remote = remote[:i] + "/" + remote[i+1:]
+	}
+	if i := strings.Index(remote, "@"); i >= 0 && i < strings.Index(remote, "/") {
+		remote = remote[i+1:]
+	}
+	return remote
+}
+
+// loadJsonnetBundle detects jsonnet-bundler from a `jsonnetfile.json` or
+// `jsonnetfile.lock.json` at the root of the workspace. The lock file is preferred as it
+// also lists the dependencies of dependencies.
+func loadJsonnetBundle(root fs.FS) (*jsonnetBundle, bool) {
+	var data []byte
+	for _, name := range []string{"jsonnetfile.lock.json", "jsonnetfile.json"} {
+		if b, err := fs.ReadFile(root, name); err == nil {
+			data = b
+			break
+		}
+	}
+	if data == nil {
+		return nil, false
+	}
+
+	res := &jsonnetBundle{vendor: "vendor", legacy: map[string]string{}}
+	jf := jsonnetfile{}
+	if err := json.Unmarshal(data, &jf); err != nil {
+		logf("failed to parse jsonnetfile: %v", err)
+		return res, true
+	}
+	for _, dep := range jf.Dependencies {
+		git := dep.Source.Git
+		if git == nil || git.Remote == "" {
+			continue
+		}
+		pkg := path.Join(gitPackagePath(git.Remote), git.Subdir)
+		name := dep.Name
+		if name == "" {
+			name = path.Base(pkg)
+		}
+		res.legacy[name] = path.Join(res.vendor, pkg)
+	}
+	return res, true
+}
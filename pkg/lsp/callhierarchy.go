@@ -0,0 +1,173 @@
+package lsp
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// callItemKey identifies a call hierarchy item, used to group calls by function.
+type callItemKey struct {
+	uri protocol.DocumentURI
+	rng protocol.Range
+}
+
+func functionDefToItem(def *analysis.FunctionDef) protocol.CallHierarchyItem {
+	kind := protocol.SymbolKindFunction
+	if def.Field {
+		kind = protocol.SymbolKindMethod
+	}
+	return protocol.CallHierarchyItem{
+		Name:           def.Name,
+		Kind:           kind,
+		Detail:         def.Detail,
+		URI:            protocol.DocumentURI(uri.File(def.Range.FileName)),
+		Range:          rangeToProto(def.Range),
+		SelectionRange: rangeToProto(def.NameRange),
+	}
+}
+
+// fileCallItem is the caller for calls made outside of any named function.
+func fileCallItem(root ast.Node) protocol.CallHierarchyItem {
+	loc := *root.Loc()
+	return protocol.CallHierarchyItem{
+		Name:           filepath.Base(loc.FileName),
+		Kind:           protocol.SymbolKindFile,
+		URI:            protocol.DocumentURI(uri.File(loc.FileName)),
+		Range:          rangeToProto(loc),
+		SelectionRange: rangeToProto(ast.LocationRange{Begin: loc.Begin, End: loc.Begin}),
+	}
+}
+
+// resolverFor returns a resolver for a file, using the contents in the editor if it is
+// open. Files that are not open make imports through `vm`.
+func (s *Server) resolverFor(ctx context.Context, u uri.URI, vm *vmCache) *valueResolver {
+	if resolver := s.NewResolver(ctx, u); resolver != nil {
+		return resolver
+	}
+	contents, ok := s.fileContents(u)
+	if !ok {
+		return nil
+	}
+	root, err := jsonnet.SnippetToAST(u.Filename(), contents)
+	if err != nil || root == nil {
+		return nil
+	}
+	return newRootResolver(ctx, root, vm)
+}
+
+// callItemDef finds the function definition of a call hierarchy item. The client only
+// sends back the item, so the definition is found again from its name.
+func (s *Server) callItemDef(ctx context.Context, item protocol.CallHierarchyItem, vm *vmCache) (*analysis.FunctionDef, *valueResolver) {
+	resolver := s.resolverFor(ctx, uri.URI(item.URI), vm)
+	if resolver == nil {
+		return nil, nil
+	}
+	pos := s.positions().fromClient(uri.URI(item.URI), item.SelectionRange.Start)
+	return analysis.FunctionDefAt(resolver.rootAST, protoToPos(pos), resolver), resolver
+}
+
+func (s *Server) PrepareCallHierarchy(ctx context.Context, params *protocol.CallHierarchyPrepareParams) ([]protocol.CallHierarchyItem, error) {
+	res := []protocol.CallHierarchyItem{}
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
+	if resolver == nil {
+		return res, nil
+	}
+	positions := s.positions()
+	pos := protoToPos(positions.fromClient(params.TextDocument.URI, params.Position))
+	if def := analysis.FunctionDefAt(resolver.rootAST, pos, resolver); def != nil {
+		res = append(res, positions.callItemToClient(functionDefToItem(def)))
+	}
+	return res, nil
+}
+
+func (s *Server) IncomingCalls(ctx context.Context, params *protocol.CallHierarchyIncomingCallsParams) ([]protocol.CallHierarchyIncomingCall, error) {
+	res := []protocol.CallHierarchyIncomingCall{}
+	vm := s.newVMCache(uri.URI(params.Item.URI))
+	def, defResolver := s.callItemDef(ctx, params.Item, vm)
+	if def == nil {
+		return res, nil
+	}
+
+	// Locals can only be called from the file they are defined in, but fields can be
+	// called from any file that imports them.
+	resolvers := []*valueResolver{defResolver}
+	if def.Field {
+		for _, f := range s.indexedFiles(ctx, def.Name) {
+			if f.uri.Filename() != def.Range.FileName && len(f.refs.Calls[def.Name]) > 0 {
+				resolvers = append(resolvers, newRootResolver(ctx, f.root, vm))
+			}
+		}
+	}
+
+	byCaller := map[callItemKey]int{}
+	for _, resolver := range resolvers {
+		if ctx.Err() != nil {
+			return res, nil
+		}
+		calls := analysis.Calls(resolver.rootAST, resolver, func(name string, _ ast.LocationRange) bool { return name == def.Name })
+		for _, call := range calls {
+			if !analysis.SameRange(call.Target.NameRange, def.NameRange) {
+				continue
+			}
+			from := fileCallItem(resolver.rootAST)
+			if call.Caller != nil {
+				from = functionDefToItem(call.Caller)
+			}
+			key := callItemKey{from.URI, from.SelectionRange}
+			idx, ok := byCaller[key]
+			if !ok {
+				idx = len(res)
+				byCaller[key] = idx
+				res = append(res, protocol.CallHierarchyIncomingCall{From: from})
+			}
+			res[idx].FromRanges = append(res[idx].FromRanges, rangeToProto(call.NameRange))
+		}
+	}
+	positions := s.positions()
+	for i := range res {
+		for j, rng := range res[i].FromRanges {
+			res[i].FromRanges[j] = positions.rangeToClient(uri.URI(res[i].From.URI), rng)
+		}
+		res[i].From = positions.callItemToClient(res[i].From)
+	}
+	return res, nil
+}
+
+func (s *Server) OutgoingCalls(ctx context.Context, params *protocol.CallHierarchyOutgoingCallsParams) ([]protocol.CallHierarchyOutgoingCall, error) {
+	res := []protocol.CallHierarchyOutgoingCall{}
+	vm := s.newVMCache(uri.URI(params.Item.URI))
+	def, resolver := s.callItemDef(ctx, params.Item, vm)
+	if def == nil {
+		return res, nil
+	}
+
+	inBody := func(_ string, rng ast.LocationRange) bool {
+		return analysis.LocInRange(def.Range, rng.Begin) && !analysis.LocInRange(def.NameRange, rng.Begin)
+	}
+	byTarget := map[callItemKey]int{}
+	for _, call := range analysis.Calls(resolver.rootAST, resolver, inBody) {
+		to := functionDefToItem(call.Target)
+		key := callItemKey{to.URI, to.SelectionRange}
+		idx, ok := byTarget[key]
+		if !ok {
+			idx = len(res)
+			byTarget[key] = idx
+			res = append(res, protocol.CallHierarchyOutgoingCall{To: to})
+		}
+		res[idx].FromRanges = append(res[idx].FromRanges, rangeToProto(call.NameRange))
+	}
+	positions := s.positions()
+	for i := range res {
+		for j, rng := range res[i].FromRanges {
+			res[i].FromRanges[j] = positions.rangeToClient(uri.URI(params.Item.URI), rng)
+		}
+		res[i].To = positions.callItemToClient(res[i].To)
+	}
+	return res, nil
+}
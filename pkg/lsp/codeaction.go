@@ -0,0 +1,214 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// kindRequested returns true if the client asked for code actions of `kind`. An empty
+// `only` means every kind is requested, and kinds are hierarchical (`refactor` includes
+// `refactor.extract`).
+func kindRequested(only []protocol.CodeActionKind, kind protocol.CodeActionKind) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, k := range only {
+		if k == kind || strings.HasPrefix(string(kind), string(k)+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func editsToProto(u uri.URI, edits []analysis.TextEdit) *protocol.WorkspaceEdit {
+	res := make([]protocol.TextEdit, len(edits))
+	for i, e := range edits {
+		res[i] = protocol.TextEdit{Range: rangeToProto(e.Range), NewText: e.NewText}
+	}
+	return &protocol.WorkspaceEdit{Changes: map[uri.URI][]protocol.TextEdit{u: res}}
+}
+
+func extractLocalAction(u uri.URI, root ast.Node, rng protocol.Range) (protocol.CodeAction, bool) {
+	if rng.Start == rng.End {
+		return protocol.CodeAction{}, false
+	}
+	sel := ast.LocationRange{Begin: protoToPos(rng.Start), End: protoToPos(rng.End)}
+	_, edits, ok := analysis.ExtractLocal(root, sel)
+	if !ok {
+		return protocol.CodeAction{}, false
+	}
+	return protocol.CodeAction{
+		Title: "Extract to local",
+		Kind:  protocol.RefactorExtract,
+		Edit:  editsToProto(u, edits),
+	}, true
+}
+
+func extractFunctionAction(u uri.URI, root ast.Node, rng protocol.Range) (protocol.CodeAction, bool) {
+	if rng.Start == rng.End {
+		return protocol.CodeAction{}, false
+	}
+	sel := ast.LocationRange{Begin: protoToPos(rng.Start), End: protoToPos(rng.End)}
+	_, edits, ok := analysis.ExtractFunction(root, sel)
+	if !ok {
+		return protocol.CodeAction{}, false
+	}
+	return protocol.CodeAction{
+		Title: "Extract to function",
+		Kind:  protocol.RefactorExtract,
+		Edit:  editsToProto(u, edits),
+	}, true
+}
+
+func inlineLocalAction(u uri.URI, root ast.Node, pos protocol.Position) (protocol.CodeAction, bool) {
+	name, edits, ok := analysis.InlineLocal(root, protoToPos(pos))
+	if !ok {
+		return protocol.CodeAction{}, false
+	}
+	return protocol.CodeAction{
+		Title: fmt.Sprintf("Inline local '%s'", name),
+		Kind:  protocol.RefactorInline,
+		Edit:  editsToProto(u, edits),
+	}, true
+}
+
+// unusedBindingActions returns the quick fixes for an unused local, import, or parameter
+// reported by the linter: removing the local, or prefixing its name with an underscore.
+func unusedBindingActions(u uri.URI, root ast.Node, diag protocol.Diagnostic) []protocol.CodeAction {
+	res := []protocol.CodeAction{}
+	code := fmt.Sprint(diag.Code)
+	if code != string(linter.UnusedVar) && code != string(linter.UnusedParam) && code != string(linter.UnusedImport) {
+		return res
+	}
+	pos := protoToPos(diag.Range.Start)
+	if code != string(linter.UnusedParam) {
+		if name, edit, ok := analysis.RemoveUnusedLocal(root, pos); ok {
+			res = append(res, protocol.CodeAction{
+				Title:       fmt.Sprintf("Remove unused local '%s'", name),
+				Kind:        protocol.QuickFix,
+				Diagnostics: []protocol.Diagnostic{diag},
+				IsPreferred: true,
+				Edit:        editsToProto(u, []analysis.TextEdit{edit}),
+			})
+		}
+	}
+	if name, edit, ok := analysis.UnderscoreBinding(root, pos); ok {
+		res = append(res, protocol.CodeAction{
+			Title:       fmt.Sprintf("Rename to '%s'", name),
+			Kind:        protocol.QuickFix,
+			Diagnostics: []protocol.Diagnostic{diag},
+			IsPreferred: len(res) == 0,
+			Edit:        editsToProto(u, []analysis.TextEdit{edit}),
+		})
+	}
+	return res
+}
+
+func (s *Server) convertStringActions(u uri.URI, root ast.Node, pos protocol.Position) []protocol.CodeAction {
+	res := []protocol.CodeAction{}
+	lit, kind, ok := analysis.StringLiteralAt(root, protoToPos(pos))
+	if !ok {
+		return res
+	}
+	unit := "  "
+	if indent := s.config.FormatterOptions().Indent; indent > 0 {
+		unit = strings.Repeat(" ", indent)
+	}
+	for _, to := range analysis.StringConversions(lit.Value, kind) {
+		text, ok := analysis.ConvertString(lit, to, unit)
+		if !ok {
+			continue
+		}
+		res = append(res, protocol.CodeAction{
+			Title: "Convert to " + analysis.StringKindName(to),
+			Kind:  protocol.RefactorRewrite,
+			Edit:  editsToProto(u, []analysis.TextEdit{{Range: lit.LocRange, NewText: text}}),
+		})
+	}
+	return res
+}
+
+func (s *Server) organizeImportsAction(u uri.URI, root ast.Node, contents string) (protocol.CodeAction, bool) {
+	var quote byte
+	switch s.config.FormatterOptions().StringStyle {
+	case formatter.StringStyleSingle:
+		quote = '\''
+	case formatter.StringStyleDouble:
+		quote = '"'
+	}
+	edit, ok := analysis.OrganizeImports(root, contents, quote)
+	if !ok {
+		return protocol.CodeAction{}, false
+	}
+	return protocol.CodeAction{
+		Title: "Organize imports",
+		Kind:  protocol.SourceOrganizeImports,
+		Edit:  editsToProto(u, []analysis.TextEdit{edit}),
+	}, true
+}
+
+func (s *Server) CodeAction(ctx context.Context, params *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
+	res := []protocol.CodeAction{}
+	positions := s.positions()
+	params.Range = positions.rangeFromClient(params.TextDocument.URI, params.Range)
+	params.Context.Diagnostics = positions.diagnosticsFromClient(params.TextDocument.URI, params.Context.Diagnostics)
+	only := params.Context.Only
+	if kindRequested(only, protocol.QuickFix) {
+		contents, _ := s.fileContents(params.TextDocument.URI)
+		for _, diag := range params.Context.Diagnostics {
+			if strings.HasPrefix(diag.Message, unknownVariablePrefix) {
+				res = append(res, s.importActions(ctx, params.TextDocument.URI, contents, diag)...)
+			}
+			res = append(res, s.missingImportActions(ctx, params.TextDocument.URI, contents, diag)...)
+		}
+	}
+
+	// refactors edit the file based on the AST, so it must be parsed from the current contents
+	current, parsed := s.overlay.Current(params.TextDocument.URI), s.overlay.Parsed(params.TextDocument.URI)
+	if current == nil || parsed == nil || current.Version != parsed.Version {
+		return positions.actionsToClient(params.TextDocument.URI, res), nil
+	}
+	pr, _ := parsed.Data.(*ParseResult)
+	if pr == nil || pr.Root == nil || pr.Err != nil {
+		return positions.actionsToClient(params.TextDocument.URI, res), nil
+	}
+	root := pr.Root
+	if kindRequested(only, protocol.QuickFix) {
+		for _, diag := range params.Context.Diagnostics {
+			res = append(res, unusedBindingActions(params.TextDocument.URI, root, diag)...)
+		}
+	}
+	if kindRequested(only, protocol.RefactorExtract) {
+		if action, ok := extractLocalAction(params.TextDocument.URI, root, params.Range); ok {
+			res = append(res, action)
+		}
+		if action, ok := extractFunctionAction(params.TextDocument.URI, root, params.Range); ok {
+			res = append(res, action)
+		}
+	}
+	if kindRequested(only, protocol.RefactorInline) {
+		if action, ok := inlineLocalAction(params.TextDocument.URI, root, params.Range.Start); ok {
+			res = append(res, action)
+		}
+	}
+	if kindRequested(only, protocol.RefactorRewrite) {
+		res = append(res, s.convertStringActions(params.TextDocument.URI, root, params.Range.Start)...)
+	}
+	if kindRequested(only, refactorMove) {
+		res = append(res, s.moveToFileActions(params.TextDocument.URI, root, params.Range.Start)...)
+	}
+	if kindRequested(only, protocol.SourceOrganizeImports) {
+		if action, ok := s.organizeImportsAction(params.TextDocument.URI, root, current.Contents); ok {
+			res = append(res, action)
+		}
+	}
+	return positions.actionsToClient(params.TextDocument.URI, res), nil
+}
@@ -0,0 +1,111 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+const (
+	commandEvaluate     = "jsonnet.lsp.evaluate"
+	commandEvaluateYaml = "jsonnet.lsp.evaluateYaml"
+	commandProfile      = "jsonnet.lsp.profile"
+	// shows the references of a definition, registered by the editor extension
+	commandShowReferences = "jsonnet.lsp.showReferences"
+)
+
+// evaluateCommand builds a command that evaluates the document when run by the client.
+// Arguments are passed as a JSON string, the same as ExecuteCommand expects them.
+func evaluateCommand(title string, params *EvaluateParams) *protocol.Command {
+	data, _ := json.Marshal(params)
+	return &protocol.Command{Title: title, Command: commandEvaluate, Arguments: []interface{}{string(data)}}
+}
+
+// CodeLens shows lenses to evaluate the file and each field of the object it exports, and
+// the number of references of its top level locals and exported fields. References are only
+// counted when a lens is resolved, as fields can be referenced from any file of the
+// workspace.
+func (s *Server) CodeLens(ctx context.Context, params *protocol.CodeLensParams) ([]protocol.CodeLens, error) {
+	res := []protocol.CodeLens{}
+	root := s.getCurrentAST(params.TextDocument.URI)
+	if root == nil || s.isLargeOpenFile(ctx, params.TextDocument.URI) {
+		return res, nil
+	}
+	res = append(res, protocol.CodeLens{
+		Range:   protocol.Range{},
+		Command: evaluateCommand("Evaluate", &EvaluateParams{TextDocument: &protocol.TextDocumentIdentifier{URI: params.TextDocument.URI}}),
+	})
+
+	positions := s.positions()
+	// the fields of the object, f.ex the resources of an environment, are evaluated on their
+	// own; functions cannot be
+	for _, fld := range analysis.ExportedFields(root) {
+		if _, isFn := fld.Body.(*ast.Function); isFn || !fld.Range.IsSet() {
+			continue
+		}
+		res = append(res, protocol.CodeLens{
+			Range:   positions.rangeToClient(params.TextDocument.URI, rangeToProto(fld.Range)),
+			Command: evaluateCommand("Evaluate", &EvaluateParams{TextDocument: &protocol.TextDocumentIdentifier{URI: params.TextDocument.URI}, Field: fld.Name}),
+		})
+	}
+
+	for _, decl := range analysis.TopLevelDecls(root) {
+		switch decl.Body.(type) {
+		case *ast.Import, *ast.ImportStr, *ast.ImportBin:
+			// imports are referenced where their fields are, which have their own lenses
+			continue
+		}
+		if !decl.Range.IsSet() {
+			continue
+		}
+		rng := positions.rangeToClient(params.TextDocument.URI, rangeToProto(decl.Range))
+		res = append(res, protocol.CodeLens{
+			Range: rng,
+			Data: protocol.TextDocumentPositionParams{
+				TextDocument: params.TextDocument,
+				Position:     rng.Start,
+			},
+		})
+	}
+	return res, nil
+}
+
+// showReferencesArgs are the arguments of the command that shows the references of a
+// definition, passed as a JSON string like the ones of the evaluate command.
+type showReferencesArgs struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Position     protocol.Position               `json:"position"`
+	Locations    []protocol.Location             `json:"locations"`
+}
+
+// CodeLensResolve counts the references of the definition of a lens, and makes the lens
+// show them when clicked.
+func (s *Server) CodeLensResolve(ctx context.Context, lens *protocol.CodeLens) (*protocol.CodeLens, error) {
+	if lens.Command != nil {
+		return lens, nil
+	}
+	params := protocol.TextDocumentPositionParams{}
+	data, _ := json.Marshal(lens.Data)
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("invalid code lens data: %v", err)
+	}
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
+	if resolver == nil {
+		return lens, nil
+	}
+	positions := s.positions()
+	pos := protoToPos(positions.fromClient(params.TextDocument.URI, params.Position))
+	refs := positions.locationsToClient(s.references(ctx, resolver, pos, false))
+
+	title := fmt.Sprintf("%d references", len(refs))
+	if len(refs) == 1 {
+		title = "1 reference"
+	}
+	args, _ := json.Marshal(&showReferencesArgs{TextDocument: params.TextDocument, Position: params.Position, Locations: refs})
+	lens.Command = &protocol.Command{Title: title, Command: commandShowReferences, Arguments: []interface{}{string(args)}}
+	return lens, nil
+}
@@ -0,0 +1,80 @@
+package lsp
+
+import (
+	"encoding/json"
+)
+
+// legacySection is the section of the settings of the vscode-jsonnet extension, which
+// other editors and extensions also follow. They are read so users of that extension do
+// not have to configure the server again.
+const legacySection = "jsonnet"
+
+// legacySettings is the settings of the vscode-jsonnet extension the server understands.
+type legacySettings struct {
+	LibPaths []string          `json:"libPaths"`
+	ExtStrs  map[string]string `json:"extStrs"`
+	ExtCode  map[string]string `json:"extCode"`
+	TLAStrs  map[string]string `json:"tlaStrs"`
+	TLACode  map[string]string `json:"tlaCode"`
+}
+
+// legacyConfigSettings returns the settings of the vscode-jsonnet extension from the
+// settings of a configuration change, for clients that send all of their settings.
+func legacyConfigSettings(settings interface{}) interface{} {
+	if m, ok := settings.(map[string]interface{}); ok {
+		return m[legacySection]
+	}
+	return nil
+}
+
+// apply adds the legacy settings to the configuration. The settings of the server take
+// priority: search paths are added after its own, and variables it sets are kept.
+func (l *legacySettings) apply(cfg *Configuration) {
+	for _, p := range l.LibPaths {
+		if !containsString(cfg.JPaths, p) {
+			cfg.JPaths = append(cfg.JPaths, p)
+		}
+	}
+	cfg.ExtVars = mergeMissing(cfg.ExtVars, l.ExtStrs)
+	cfg.ExtCode = mergeMissing(cfg.ExtCode, l.ExtCode)
+	cfg.TLAVars = mergeMissing(cfg.TLAVars, l.TLAStrs)
+	cfg.TLACode = mergeMissing(cfg.TLACode, l.TLACode)
+}
+
+// parseLegacySettings decodes the settings of the vscode-jsonnet extension.
+func parseLegacySettings(settings interface{}) (*legacySettings, error) {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	res := &legacySettings{}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// mergeMissing adds the entries of `from` whose key is not in `to`.
+func mergeMissing(to, from map[string]string) map[string]string {
+	if len(from) == 0 {
+		return to
+	}
+	if to == nil {
+		to = map[string]string{}
+	}
+	for k, v := range from {
+		if _, ok := to[k]; !ok {
+			to[k] = v
+		}
+	}
+	return to
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
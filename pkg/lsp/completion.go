@@ -0,0 +1,113 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"go.lsp.dev/protocol"
+)
+
+// completionCache keeps the fields of the last completion list, so the detail and
+// documentation of a field are only resolved when the client shows the item
+// (`completionItem/resolve`). Objects like the k8s libraries have hundreds of fields, and
+// resolving the value of each field made completion slow.
+type completionCache struct {
+	lock     sync.Mutex
+	id       int64
+	resolver *valueResolver
+	fields   []analysis.Field
+}
+
+// completionData identifies a field of a completion list in the data of its item.
+type completionData struct {
+	List  int64 `json:"list"`
+	Field int   `json:"field"`
+}
+
+// reset starts a new completion list, the items of older lists are no longer resolved.
+func (c *completionCache) reset(resolver *valueResolver) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.id++
+	c.resolver = resolver
+	c.fields = nil
+}
+
+// add returns the data of a completion item to resolve the field.
+func (c *completionCache) add(fld analysis.Field) completionData {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fields = append(c.fields, fld)
+	return completionData{List: c.id, Field: len(c.fields) - 1}
+}
+
+// fieldItem returns the completion item of a field without its detail and documentation,
+// which are filled in by CompletionResolve.
+func (s *Server) fieldItem(fld analysis.Field) protocol.CompletionItem {
+	return protocol.CompletionItem{
+		Label:      fld.Name,
+		InsertText: analysis.SafeIdent(fld.Name),
+		Kind:       typeToCompletionKind(fld.Type, protocol.CompletionItemKindField),
+		Data:       s.completions.add(fld),
+	}
+}
+
+// shapeFieldItems returns the completion items of the fields of an inferred object, with
+// the fields of each object of a union.
+func shapeFieldItems(shape *analysis.Shape) []completionCandidate {
+	res := []completionCandidate{}
+	seen := map[string]bool{}
+	members := shape.Union
+	if members == nil {
+		members = []*analysis.Shape{shape}
+	}
+	for _, m := range members {
+		for _, fld := range m.Fields {
+			if seen[fld.Name] || strings.HasPrefix(fld.Name, "#") {
+				continue
+			}
+			seen[fld.Name] = true
+			res = append(res, completionCandidate{item: protocol.CompletionItem{
+				Label:      fld.Name,
+				InsertText: analysis.SafeIdent(fld.Name),
+				Detail:     fld.Shape.Inline(),
+				Kind:       typeToCompletionKind(fld.Shape.Type, protocol.CompletionItemKindField),
+			}, typ: fld.Shape.Type})
+		}
+	}
+	return res
+}
+
+func (s *Server) CompletionResolve(ctx context.Context, item *protocol.CompletionItem) (*protocol.CompletionItem, error) {
+	raw, err := json.Marshal(item.Data)
+	if err != nil {
+		return item, nil
+	}
+	data := completionData{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return item, nil
+	}
+
+	c := &s.completions
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if data.List != c.id || data.Field < 0 || data.Field >= len(c.fields) || c.resolver == nil {
+		// the item is from an older list
+		return item, nil
+	}
+	fld := c.fields[data.Field]
+	// the resolver is kept from the completion request, imports are made with this request
+	resolver := *c.resolver
+	resolver.ctx = ctx
+	val := analysis.NodeToValue(fld.Node, &resolver)
+	item.Detail = valueToDetail(val)
+	if val.Type == analysis.AnyType {
+		// the type may still be inferred, f.ex from the branches of a conditional
+		item.Detail = analysis.InferShape(fld.Node, &resolver).Inline()
+	}
+	item.Documentation = strings.Join(fld.Comment, "\n")
+	return item, nil
+}
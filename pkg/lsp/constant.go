@@ -0,0 +1,37 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// maxConstantLen limits the length of constant values shown in hover.
+const maxConstantLen = 1000
+
+// constantValue folds the expression at the end of the stack if it is constant, and
+// returns its value as JSON. Literals are not shown, they are their own value.
+func constantValue(stack []ast.Node) (string, bool) {
+	if len(stack) == 0 {
+		return "", false
+	}
+	switch node := stack[len(stack)-1].(type) {
+	case *ast.LiteralNull, *ast.LiteralBoolean, *ast.LiteralNumber, *ast.LiteralString, *ast.Function:
+		return "", false
+	case *ast.Var:
+		if node.Id == "std" || node.Id == "$std" {
+			return "", false
+		}
+	}
+	out, ok := analysis.ConstantValue(stack)
+	if !ok {
+		return "", false
+	}
+	buf := bytes.Buffer{}
+	if err := json.Indent(&buf, []byte(out), "", "  "); err != nil || buf.Len() > maxConstantLen {
+		return "", false
+	}
+	return buf.String(), true
+}
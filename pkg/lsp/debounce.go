@@ -0,0 +1,83 @@
+package lsp
+
+import (
+	"sync"
+	"time"
+
+	"go.lsp.dev/uri"
+)
+
+// Default delays of the diagnostics of a file that is being edited, the linter can take
+// hundreds of milliseconds on large files and should not run on every keystroke.
+const (
+	defaultLintDelay     = 200
+	defaultEvaluateDelay = 1000
+)
+
+// delay returns a delay of the configuration in milliseconds as a duration.
+func delay(ms int) time.Duration {
+	if ms < 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// diagSchedule is the diagnostics of changed files waiting for the user to stop typing.
+// A change replaces the pending diagnostics of the file, so a burst of changes is only
+// checked once.
+type diagSchedule struct {
+	lock   sync.Mutex
+	timers map[uri.URI]*time.Timer
+	// when the files last changed
+	changed map[uri.URI]time.Time
+}
+
+// schedule runs `fn` after `wait`, unless the file changes again before.
+func (d *diagSchedule) schedule(u uri.URI, wait time.Duration, fn func()) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.timers == nil {
+		d.timers = map[uri.URI]*time.Timer{}
+		d.changed = map[uri.URI]time.Time{}
+	}
+	d.changed[u] = time.Now()
+	if t := d.timers[u]; t != nil {
+		t.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(wait, func() {
+		d.lock.Lock()
+		if d.timers[u] == t {
+			delete(d.timers, u)
+		}
+		d.lock.Unlock()
+		fn()
+	})
+	d.timers[u] = t
+}
+
+// cancel drops the pending diagnostics of a file, f.ex when it is closed.
+func (d *diagSchedule) cancel(u uri.URI) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if t := d.timers[u]; t != nil {
+		t.Stop()
+		delete(d.timers, u)
+	}
+	delete(d.changed, u)
+}
+
+// untilQuiet returns how long until the file has not changed for `quiet`, zero if it
+// already has not.
+func (d *diagSchedule) untilQuiet(u uri.URI, quiet time.Duration) time.Duration {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	changed, ok := d.changed[u]
+	if !ok {
+		return 0
+	}
+	if wait := quiet - time.Since(changed); wait > 0 {
+		return wait
+	}
+	return 0
+}
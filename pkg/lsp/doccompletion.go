@@ -0,0 +1,155 @@
+package lsp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// docsonnetImport is the library whose functions document fields, imported as `d` by
+// convention.
+const docsonnetImport = "doc-util/main.libsonnet"
+
+// docTagCompletions returns the tags that can be typed after an `@` in the comment the
+// cursor is in: a `@param` for each parameter of the function the comment documents that
+// it does not document yet, and `@return`. Returns false if the cursor is not in a
+// comment, where nothing else is completed.
+func (s *Server) docTagCompletions(u uri.URI, root ast.Node, contents string, cursor ast.Location) ([]protocol.CompletionItem, bool) {
+	offset := locToOffset(contents, cursor)
+	if _, ok := analysis.CommentAt(contents, offset); !ok {
+		return nil, false
+	}
+	res := []protocol.CompletionItem{}
+	at := offset
+	for at > 0 && isIdentByte(contents[at-1]) {
+		at--
+	}
+	if at == 0 || contents[at-1] != '@' || root == nil {
+		return res, true
+	}
+	at--
+	def, ok := analysis.DocumentedFunction(root, cursor.Line)
+	if !ok {
+		return res, true
+	}
+
+	documented := map[string]bool{}
+	for _, tag := range analysis.DocTags(def.Loc) {
+		documented[tag.Kind+" "+tag.Name] = true
+	}
+	// the typed tag is replaced, including the `@`
+	rng := s.positions().rangeToClient(u, rangeToProto(ast.LocationRange{
+		Begin: ast.Location{Line: cursor.Line, Column: cursor.Column - (offset - at)},
+		End:   cursor,
+	}))
+	item := func(label, detail, text string) protocol.CompletionItem {
+		return s.snippetItem(protocol.CompletionItem{
+			Label:            label,
+			Detail:           detail,
+			Kind:             protocol.CompletionItemKindKeyword,
+			TextEdit:         &protocol.TextEdit{Range: rng, NewText: text},
+			InsertTextFormat: protocol.InsertTextFormatSnippet,
+			// in order of the parameters
+			SortText: fmt.Sprintf("%04d", len(res)),
+		})
+	}
+	for _, p := range def.Function.Parameters {
+		if documented[analysis.ParamTag+" "+string(p.Name)] {
+			continue
+		}
+		res = append(res, item(
+			"@"+analysis.ParamTag+" "+string(p.Name),
+			fmt.Sprintf("documents the parameter '%s' of '%s'", p.Name, def.Name),
+			"@"+analysis.ParamTag+" "+string(p.Name)+" $0",
+		))
+	}
+	if !documented[analysis.ReturnTag+" "] {
+		res = append(res, item("@"+analysis.ReturnTag, fmt.Sprintf("documents the result of '%s'", def.Name), "@"+analysis.ReturnTag+" $0"))
+	}
+	return res, true
+}
+
+// docFieldAt returns the object and the name of the docsonnet doc field, f.ex `'#name'`,
+// whose value the cursor is in.
+func docFieldAt(stack []ast.Node) (*ast.DesugaredObject, string, bool) {
+	for i := len(stack) - 2; i >= 0; i-- {
+		obj, ok := stack[i].(*ast.DesugaredObject)
+		if !ok {
+			continue
+		}
+		for j := range obj.Fields {
+			if obj.Fields[j].Body != stack[i+1] {
+				continue
+			}
+			name, _, ok := analysis.FieldNameRange(&obj.Fields[j])
+			return obj, name, ok && strings.HasPrefix(name, "#")
+		}
+		return nil, "", false
+	}
+	return nil, "", false
+}
+
+// docsonnetVar returns the name of the variable docsonnet is imported as.
+func docsonnetVar(vars analysis.VarMap) (string, bool) {
+	names := []string{}
+	for name, v := range vars {
+		if imp, ok := v.Node.(*ast.Import); ok && strings.HasSuffix(imp.File.Value, docsonnetImport) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+	return names[0], true
+}
+
+// docsonnetCandidates returns the docsonnet calls that document the field of the doc field
+// the cursor is in: `d.fn` with an argument for each parameter of a function, `d.obj` and
+// `d.val`. There are none outside of doc fields, or if docsonnet is not imported.
+func (s *Server) docsonnetCandidates(stack []ast.Node, vars analysis.VarMap) []completionCandidate {
+	obj, name, ok := docFieldAt(stack)
+	if !ok {
+		return nil
+	}
+	d, ok := docsonnetVar(vars)
+	if !ok {
+		return nil
+	}
+
+	args := "$2"
+	for i := range obj.Fields {
+		fld, _, ok := analysis.FieldNameRange(&obj.Fields[i])
+		fn, isFn := obj.Fields[i].Body.(*ast.Function)
+		if !ok || !isFn || fld != name[1:] {
+			continue
+		}
+		parts := make([]string, len(fn.Parameters))
+		for j, p := range fn.Parameters {
+			parts[j] = fmt.Sprintf("%s.arg('%s', ${%d:%s.T.any})", d, p.Name, j+2, d)
+		}
+		args = strings.Join(parts, ", ")
+	}
+	snippets := []constructSnippet{
+		{label: d + ".fn", detail: "documents a function", body: d + ".fn(${1:'help'}, [" + args + "])$0"},
+		{label: d + ".obj", detail: "documents an object", body: d + ".obj(${1:'help'})$0"},
+		{label: d + ".val", detail: "documents a value", body: d + ".val(${1:" + d + ".T.any}, ${2:'help'})$0"},
+	}
+	res := make([]completionCandidate, 0, len(snippets))
+	for _, snip := range snippets {
+		res = append(res, completionCandidate{item: s.snippetItem(protocol.CompletionItem{
+			Label:            snip.label,
+			Detail:           snip.detail,
+			Documentation:    &protocol.MarkupContent{Kind: protocol.Markdown, Value: "```jsonnet\n" + snippetPlaceholders(snip.body) + "\n```"},
+			Kind:             protocol.CompletionItemKindSnippet,
+			InsertText:       snip.body,
+			InsertTextFormat: protocol.InsertTextFormatSnippet,
+		}), typ: analysis.ObjectType})
+	}
+	return res
+}
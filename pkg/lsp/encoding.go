@@ -0,0 +1,270 @@
+package lsp
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/overlay"
+	"github.com/hexops/gotextdiff"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Position encodings of LSP 3.17. The character of a position counts code units of the
+// negotiated encoding: UTF-16 unless the client supports another. go-jsonnet counts columns
+// in bytes, so positions are kept in bytes inside the server and converted with the text
+// of their line when they are exchanged with the client.
+const (
+	encodingUTF8  = "utf-8"
+	encodingUTF16 = "utf-16"
+	encodingUTF32 = "utf-32"
+)
+
+// negotiatePositionEncoding picks the position encoding from the `general.positionEncodings`
+// client capability in the raw `initialize` params. UTF-8 is preferred as it needs no
+// conversion, otherwise the first encoding of the client that is supported. Returns false
+// if the client did not list any, in which case UTF-16 is used.
+func negotiatePositionEncoding(params json.RawMessage) (string, bool) {
+	caps := struct {
+		Capabilities struct {
+			General struct {
+				PositionEncodings []string `json:"positionEncodings"`
+			} `json:"general"`
+		} `json:"capabilities"`
+	}{}
+	if err := json.Unmarshal(params, &caps); err != nil {
+		return encodingUTF16, false
+	}
+	encodings := caps.Capabilities.General.PositionEncodings
+	for _, enc := range encodings {
+		if enc == encodingUTF8 {
+			return enc, true
+		}
+	}
+	for _, enc := range encodings {
+		if enc == encodingUTF16 || enc == encodingUTF32 {
+			return enc, true
+		}
+	}
+	return encodingUTF16, len(encodings) > 0
+}
+
+// runeUnits returns the number of code units of a character in the encoding.
+func runeUnits(r rune, enc string) uint32 {
+	switch enc {
+	case encodingUTF8:
+		return uint32(utf8.RuneLen(r))
+	case encodingUTF32:
+		return 1
+	default:
+		if r >= 0x10000 {
+			// surrogate pair
+			return 2
+		}
+		return 1
+	}
+}
+
+// byteColumn converts a character offset in the encoding into a byte offset in the line.
+// Offsets past the end of the line are kept past the end by the same amount.
+func byteColumn(line string, char uint32, enc string) uint32 {
+	units := uint32(0)
+	for i, r := range line {
+		if units >= char {
+			return uint32(i)
+		}
+		units += runeUnits(r, enc)
+	}
+	if units >= char {
+		return uint32(len(line))
+	}
+	return uint32(len(line)) + char - units
+}
+
+// encodedColumn converts a byte offset in the line into a character offset in the encoding.
+func encodedColumn(line string, col uint32, enc string) uint32 {
+	past := uint32(0)
+	if col > uint32(len(line)) {
+		past = col - uint32(len(line))
+		col = uint32(len(line))
+	}
+	units := uint32(0)
+	for _, r := range line[:col] {
+		units += runeUnits(r, enc)
+	}
+	return units + past
+}
+
+// positionMapper converts the positions of a request between the bytes used by the server
+// and the encoding of the client. The lines of each file are only split once.
+type positionMapper struct {
+	s        *Server
+	encoding string
+	// the lines of each file, nil for files that are ASCII which need no conversion
+	lines map[uri.URI][]string
+}
+
+func (s *Server) positions() *positionMapper {
+	return &positionMapper{s: s, encoding: s.positionEncoding, lines: map[uri.URI][]string{}}
+}
+
+func (m *positionMapper) line(u uri.URI, line uint32) (string, bool) {
+	lines, ok := m.lines[u]
+	if !ok {
+		if contents, found := m.s.fileContents(u); found && !isASCII(contents) {
+			lines = strings.Split(contents, "\n")
+		}
+		m.lines[u] = lines
+	}
+	if int(line) >= len(lines) {
+		return "", false
+	}
+	return lines[line], true
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// toClient converts a position in bytes into the encoding of the client.
+func (m *positionMapper) toClient(u uri.URI, p protocol.Position) protocol.Position {
+	if m.encoding == encodingUTF8 {
+		return p
+	}
+	if line, ok := m.line(u, p.Line); ok {
+		p.Character = encodedColumn(line, p.Character, m.encoding)
+	}
+	return p
+}
+
+// fromClient converts a position in the encoding of the client into bytes.
+func (m *positionMapper) fromClient(u uri.URI, p protocol.Position) protocol.Position {
+	if m.encoding == encodingUTF8 {
+		return p
+	}
+	if line, ok := m.line(u, p.Line); ok {
+		p.Character = byteColumn(line, p.Character, m.encoding)
+	}
+	return p
+}
+
+func (m *positionMapper) rangeToClient(u uri.URI, r protocol.Range) protocol.Range {
+	return protocol.Range{Start: m.toClient(u, r.Start), End: m.toClient(u, r.End)}
+}
+
+func (m *positionMapper) rangeFromClient(u uri.URI, r protocol.Range) protocol.Range {
+	return protocol.Range{Start: m.fromClient(u, r.Start), End: m.fromClient(u, r.End)}
+}
+
+func (m *positionMapper) locationsToClient(locs []protocol.Location) []protocol.Location {
+	for i := range locs {
+		locs[i].Range = m.rangeToClient(locs[i].URI, locs[i].Range)
+	}
+	return locs
+}
+
+func (m *positionMapper) callItemToClient(item protocol.CallHierarchyItem) protocol.CallHierarchyItem {
+	item.Range = m.rangeToClient(uri.URI(item.URI), item.Range)
+	item.SelectionRange = m.rangeToClient(uri.URI(item.URI), item.SelectionRange)
+	return item
+}
+
+func (m *positionMapper) highlightsToClient(u uri.URI, highlights []protocol.DocumentHighlight) []protocol.DocumentHighlight {
+	for i := range highlights {
+		highlights[i].Range = m.rangeToClient(u, highlights[i].Range)
+	}
+	return highlights
+}
+
+func (m *positionMapper) symbolsToClient(u uri.URI, syms []protocol.DocumentSymbol) []protocol.DocumentSymbol {
+	for i := range syms {
+		syms[i].Range = m.rangeToClient(u, syms[i].Range)
+		syms[i].SelectionRange = m.rangeToClient(u, syms[i].SelectionRange)
+		syms[i].Children = m.symbolsToClient(u, syms[i].Children)
+	}
+	return syms
+}
+
+func (m *positionMapper) editsToClient(u uri.URI, edits []protocol.TextEdit) []protocol.TextEdit {
+	for i := range edits {
+		edits[i].Range = m.rangeToClient(u, edits[i].Range)
+	}
+	return edits
+}
+
+func (m *positionMapper) workspaceEditToClient(edit *protocol.WorkspaceEdit) *protocol.WorkspaceEdit {
+	if edit == nil {
+		return nil
+	}
+	for u, edits := range edit.Changes {
+		edit.Changes[u] = m.editsToClient(u, edits)
+	}
+	for i := range edit.DocumentChanges {
+		change := &edit.DocumentChanges[i]
+		change.Edits = m.editsToClient(change.TextDocument.URI, change.Edits)
+	}
+	return edit
+}
+
+func (m *positionMapper) actionsToClient(u uri.URI, actions []protocol.CodeAction) []protocol.CodeAction {
+	for i := range actions {
+		actions[i].Edit = m.workspaceEditToClient(actions[i].Edit)
+		actions[i].Diagnostics = m.diagnosticsToClient(u, actions[i].Diagnostics)
+	}
+	return actions
+}
+
+// diagnosticsToClient returns a copy of the diagnostics in the encoding of the client, as
+// diagnostics are kept to be published again.
+func (m *positionMapper) diagnosticsToClient(u uri.URI, diags []protocol.Diagnostic) []protocol.Diagnostic {
+	res := make([]protocol.Diagnostic, len(diags))
+	for i, diag := range diags {
+		diag.Range = m.rangeToClient(u, diag.Range)
+		if len(diag.RelatedInformation) > 0 {
+			related := make([]protocol.DiagnosticRelatedInformation, len(diag.RelatedInformation))
+			for j, rel := range diag.RelatedInformation {
+				rel.Location.Range = m.rangeToClient(rel.Location.URI, rel.Location.Range)
+				related[j] = rel
+			}
+			diag.RelatedInformation = related
+		}
+		res[i] = diag
+	}
+	return res
+}
+
+func (m *positionMapper) diagnosticsFromClient(u uri.URI, diags []protocol.Diagnostic) []protocol.Diagnostic {
+	for i := range diags {
+		diags[i].Range = m.rangeFromClient(u, diags[i].Range)
+	}
+	return diags
+}
+
+// changeEdits converts the content changes of `textDocument/didChange` into edits in bytes,
+// with the lines of the contents they are applied to.
+func changeEdits(events []protocol.TextDocumentContentChangeEvent, enc string) overlay.EditsFunc {
+	return func(contents string) []gotextdiff.TextEdit {
+		if enc == encodingUTF8 || isASCII(contents) {
+			return convChangeEvents(events)
+		}
+		lines := strings.Split(contents, "\n")
+		toBytes := func(p protocol.Position) protocol.Position {
+			if int(p.Line) < len(lines) {
+				p.Character = byteColumn(lines[p.Line], p.Character, enc)
+			}
+			return p
+		}
+		converted := make([]protocol.TextDocumentContentChangeEvent, len(events))
+		for i, ev := range events {
+			ev.Range = protocol.Range{Start: toBytes(ev.Range.Start), End: toBytes(ev.Range.End)}
+			converted[i] = ev
+		}
+		return convChangeEvents(converted)
+	}
+}
@@ -0,0 +1,93 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// The protocol package only implements LSP 3.16. Methods added to the protocol after
+// that are dispatched here before falling through to the protocol handler, and their
+// capabilities are added to the result of `initialize`.
+
+type customMethodFn func(s *Server, ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// customMethod adapts a typed handler into a customMethodFn that decodes its params.
+func customMethod[P any, R any](fn func(s *Server, ctx context.Context, params *P) (R, error)) customMethodFn {
+	return func(s *Server, ctx context.Context, raw json.RawMessage) (interface{}, error) {
+		params := new(P)
+		if err := json.Unmarshal(raw, params); err != nil {
+			return nil, fmt.Errorf("%s: %w", jsonrpc2.ErrParse, err)
+		}
+		return fn(s, ctx, params)
+	}
+}
+
+var customMethods = map[string]customMethodFn{
+	methodInlayHint:           customMethod((*Server).InlayHint),
+	methodInlineValue:         customMethod((*Server).InlineValue),
+	methodSelectionRange:      customMethod((*Server).SelectionRange),
+	methodPreview:             customMethod((*Server).Preview),
+	methodClosePreview:        customMethod((*Server).ClosePreview),
+	methodMemoryUsage:         customMethod((*Server).MemoryUsage),
+	methodDocumentDiagnostic:  customMethod((*Server).DocumentDiagnostic),
+	methodWorkspaceDiagnostic: customMethod((*Server).WorkspaceDiagnostic),
+}
+
+// serverCapabilities extends the protocol capabilities with ones added after LSP 3.16.
+type serverCapabilities struct {
+	protocol.ServerCapabilities
+	InlayHintProvider   bool               `json:"inlayHintProvider,omitempty"`
+	InlineValueProvider bool               `json:"inlineValueProvider,omitempty"`
+	PositionEncoding    string             `json:"positionEncoding,omitempty"`
+	DiagnosticProvider  *diagnosticOptions `json:"diagnosticProvider,omitempty"`
+}
+
+type initializeResult struct {
+	Capabilities serverCapabilities   `json:"capabilities"`
+	ServerInfo   *protocol.ServerInfo `json:"serverInfo,omitempty"`
+}
+
+func (s *Server) extendCapabilities(res *protocol.InitializeResult) *initializeResult {
+	return &initializeResult{
+		Capabilities: serverCapabilities{
+			ServerCapabilities:  res.Capabilities,
+			InlayHintProvider:   true,
+			InlineValueProvider: true,
+			// the diagnostics of a file depend on the files it imports
+			DiagnosticProvider: &diagnosticOptions{InterFileDependencies: true, WorkspaceDiagnostics: true},
+		},
+		ServerInfo: res.ServerInfo,
+	}
+}
+
+func (s *Server) extensionHandler(next jsonrpc2.Handler) jsonrpc2.Handler {
+	return func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if fn, ok := customMethods[req.Method()]; ok {
+			res, err := fn(s, ctx, req.Params())
+			return reply(ctx, res, err)
+		}
+		if req.Method() == protocol.MethodInitialize {
+			// position encodings are negotiated with a capability the protocol package
+			// does not decode
+			enc, negotiated := negotiatePositionEncoding(req.Params())
+			s.positionEncoding = enc
+			s.pullDiagnostics, s.diagnosticRefresh = pullDiagnosticsSupport(req.Params())
+			origReply := reply
+			reply = func(ctx context.Context, result interface{}, err error) error {
+				if res, ok := result.(*protocol.InitializeResult); ok && res != nil {
+					ext := s.extendCapabilities(res)
+					if negotiated {
+						ext.Capabilities.PositionEncoding = enc
+					}
+					return origReply(ctx, ext, err)
+				}
+				return origReply(ctx, result, err)
+			}
+		}
+		return next(ctx, reply, req)
+	}
+}
@@ -0,0 +1,100 @@
+package lsp
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// extVarCall returns the name literal at the end of the stack if it is the name of a
+// `std.extVar` call.
+func extVarCall(stack []ast.Node) (*ast.LiteralString, bool) {
+	if len(stack) < 2 {
+		return nil, false
+	}
+	apply, ok := stack[len(stack)-2].(*ast.Apply)
+	if !ok {
+		return nil, false
+	}
+	name, ok := analysis.ExtVarName(apply)
+	return name, ok && name == stack[len(stack)-1]
+}
+
+// extVarCompletions completes the name of an external variable in `std.extVar('|')` of
+// the file `u` with root `root`: the variables configured in `extVars` and `extCode`, and
+// the ones read elsewhere in the workspace. The name replaces the contents of `lit`.
+func (s *Server) extVarCompletions(u uri.URI, root ast.Node, lit *ast.LiteralString) []protocol.CompletionItem {
+	items := map[string]protocol.CompletionItem{}
+	for name, value := range s.config.ExtVars {
+		items[name] = protocol.CompletionItem{Label: name, Detail: fmt.Sprintf("extVar = %q", value), SortText: "0_" + name}
+	}
+	for name, code := range s.config.ExtCode {
+		items[name] = protocol.CompletionItem{Label: name, Detail: "extCode = " + code, SortText: "0_" + name}
+	}
+
+	// The number of files reading each variable. The index of the current file may be
+	// older, and the name being typed is not a use of the variable.
+	used := map[string]int{}
+	s.index.lock.Lock()
+	for fu, f := range s.index.files {
+		if fu == u {
+			continue
+		}
+		for _, name := range f.extVars {
+			used[name]++
+		}
+	}
+	s.index.lock.Unlock()
+	for name, calls := range analysis.BuildRefIndex(root).ExtVars {
+		if name != lit.Value || len(calls) > 1 {
+			used[name]++
+		}
+	}
+	for name, files := range used {
+		if _, ok := items[name]; ok || name == "" {
+			continue
+		}
+		detail := fmt.Sprintf("not configured, read in %d files", files)
+		if files == 1 {
+			detail = "not configured, read in 1 file"
+		}
+		items[name] = protocol.CompletionItem{Label: name, Detail: detail, SortText: "1_" + name}
+	}
+
+	edit, hasEdit := s.stringContentsRange(u, lit)
+	res := make([]protocol.CompletionItem, 0, len(items))
+	for name, item := range items {
+		item.Kind = protocol.CompletionItemKindVariable
+		if hasEdit {
+			item.TextEdit = &protocol.TextEdit{Range: edit, NewText: name}
+		}
+		res = append(res, item)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].SortText < res[j].SortText })
+	return res
+}
+
+// stringContentsRange returns the range of the contents of a single line string literal,
+// without the quotes, in the encoding of the client.
+func (s *Server) stringContentsRange(u uri.URI, lit *ast.LiteralString) (protocol.Range, bool) {
+	rng := lit.LocRange
+	contents, ok := s.fileContents(u)
+	if !ok || !rng.IsSet() || rng.Begin.Line != rng.End.Line {
+		return protocol.Range{}, false
+	}
+	src := textInRange(contents, rng)
+	quote := 1
+	if len(src) > 0 && src[0] == '@' {
+		quote = 2
+	}
+	if len(src) < quote+1 {
+		return protocol.Range{}, false
+	}
+	rng.Begin.Column += quote
+	rng.End.Column--
+	return s.positions().rangeToClient(u, rangeToProto(rng)), true
+}
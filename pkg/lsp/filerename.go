@@ -0,0 +1,124 @@
+package lsp
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// fileRenames maps the paths of files to their path after a rename of files and folders.
+type fileRenames map[string]string
+
+// renamed returns the path of `path` after the renames, and false if it is not renamed.
+// Files in a renamed folder are renamed with it.
+func (r fileRenames) renamed(path string) (string, bool) {
+	for from, to := range r {
+		if path == from {
+			return to, true
+		}
+		if prefix := from + string(filepath.Separator); strings.HasPrefix(path, prefix) {
+			return filepath.Join(to, strings.TrimPrefix(path, prefix)), true
+		}
+	}
+	return path, false
+}
+
+// renamedImport returns the path that imports `target` from `from` after the renames,
+// for an import of `path` that was found at `foundAt`. Paths relative to a search path
+// stay relative to it, and paths relative to the importing file stay relative to it.
+func renamedImport(path, from, foundAt, newFrom, target string) (string, bool) {
+	clean := filepath.Clean(filepath.FromSlash(path))
+	base := filepath.Clean(strings.TrimSuffix(foundAt, clean))
+	if !strings.HasSuffix(foundAt, clean) || base == filepath.Dir(from) {
+		// relative to the importing file
+		if filepath.Join(filepath.Dir(from), clean) != foundAt {
+			return "", false
+		}
+		base = filepath.Dir(newFrom)
+	}
+	rel, err := filepath.Rel(base, target)
+	if err != nil || (strings.HasPrefix(rel, "..") && base != filepath.Dir(newFrom)) {
+		// the search path does not have the new file, it is imported relative to the file
+		if rel, err = filepath.Rel(filepath.Dir(newFrom), target); err != nil {
+			return "", false
+		}
+	}
+	rel = filepath.ToSlash(rel)
+	if strings.HasPrefix(path, "./") && !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel, rel != path
+}
+
+// WillRenameFiles rewrites the imports of the renamed files in every workspace file, and
+// the relative imports of the renamed files themselves. The edits are applied before the
+// files are renamed, so imports are resolved in the current layout.
+func (s *Server) WillRenameFiles(ctx context.Context, params *protocol.RenameFilesParams) (*protocol.WorkspaceEdit, error) {
+	renames := fileRenames{}
+	for _, f := range params.Files {
+		from, to := uri.URI(f.OldURI), uri.URI(f.NewURI)
+		if from.Filename() == "" || to.Filename() == "" {
+			continue
+		}
+		renames[filepath.Clean(from.Filename())] = filepath.Clean(to.Filename())
+	}
+	if len(renames) == 0 {
+		return nil, nil
+	}
+
+	res := &protocol.WorkspaceEdit{Changes: map[uri.URI][]protocol.TextEdit{}}
+	for _, file := range s.workspaceFiles(ctx) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		f := s.indexFile(file, true)
+		if f == nil || f.root == nil || !strings.Contains(f.contents, "import") {
+			continue
+		}
+		from := f.uri.Filename()
+		newFrom, movedFrom := renames.renamed(from)
+		folder := s.folderOf(f.uri)
+		for _, imp := range analysis.BuildRefIndex(f.root).Imports {
+			lit := analysis.ImportFile(imp)
+			if lit == nil || !lit.LocRange.IsSet() || lit.Kind == ast.VerbatimStringSingle || lit.Kind == ast.VerbatimStringDouble {
+				continue
+			}
+			_, foundAt, err := folder.importer.Import(from, lit.Value)
+			if err != nil {
+				continue
+			}
+			target, movedTarget := renames.renamed(filepath.Clean(foundAt))
+			if !movedFrom && !movedTarget {
+				continue
+			}
+			path, changed := renamedImport(lit.Value, from, filepath.Clean(foundAt), newFrom, target)
+			if !changed {
+				continue
+			}
+			text := textInRange(f.contents, lit.LocRange)
+			if len(text) < 2 || (text[0] != '\'' && text[0] != '"') {
+				continue
+			}
+			res.Changes[f.uri] = append(res.Changes[f.uri], protocol.TextEdit{
+				Range:   rangeToProto(lit.LocRange),
+				NewText: string(text[0]) + path + string(text[0]),
+			})
+		}
+	}
+	if len(res.Changes) == 0 {
+		return nil, nil
+	}
+	return s.positions().workspaceEditToClient(res), nil
+}
+
+// willRenameFilters are the renames the server updates imports for: jsonnet and JSON
+// files, and the folders that contain them.
+var willRenameFilters = []protocol.FileOperationFilter{
+	{Scheme: "file", Pattern: protocol.FileOperationPattern{Glob: "**/*.{jsonnet,libsonnet,json}", Matches: protocol.FileOperationPatternKindFile}},
+	{Scheme: "file", Pattern: protocol.FileOperationPattern{Glob: "**", Matches: protocol.FileOperationPatternKindFolder}},
+}
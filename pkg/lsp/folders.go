@@ -0,0 +1,206 @@
+package lsp
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// workspaceFolder is a root directory of the workspace. Each folder has its own search
+// paths and imports are resolved relative to the folder containing the importing file.
+type workspaceFolder struct {
+	uri         uri.URI
+	fs          fs.FS
+	searchPaths []string
+	importer    *OverlayImporter
+	// the index cache of the folder is loaded once
+	loadCache sync.Once
+}
+
+// newWorkspaceFolder detects the layout of the folder at `root` (bazel, Tanka, and
+// jsonnet-bundler) and creates an importer for it.
+func (s *Server) newWorkspaceFolder(root uri.URI) *workspaceFolder {
+	f := &workspaceFolder{uri: root, fs: os.DirFS(root.Filename())}
+
+	// Check for bazel generated output directory
+	if _, err := fs.Stat(f.fs, "bazel-bin"); err == nil {
+		f.searchPaths = append(f.searchPaths, "bazel-bin")
+	} else {
+		logf("no bazel-bin dir: %v", err)
+	}
+
+	// Check for a Tanka project, which imports from `lib` before `vendor`
+	tanka := isTankaProject(f.fs)
+	if tanka {
+		f.searchPaths = append(f.searchPaths, "lib")
+	}
+
+	// Check for jsonnet-bundler, which installs packages into the vendor directory
+	var vendorAliases map[string]string
+	if bundle, ok := loadJsonnetBundle(f.fs); ok {
+		f.searchPaths = append(f.searchPaths, bundle.vendor)
+		vendorAliases = bundle.legacy
+	} else if _, err := fs.Stat(f.fs, "vendor"); tanka && err == nil {
+		f.searchPaths = append(f.searchPaths, "vendor")
+	} else if grafonnetVendored(f.fs) {
+		// Grafonnet imports its dependencies from the vendor directory
+		f.searchPaths = append(f.searchPaths, "vendor")
+	}
+
+	f.importer = &OverlayImporter{
+		overlay:       s.overlay,
+		rootURI:       f.uri,
+		rootFS:        f.fs,
+		paths:         f.searchPaths,
+		vendorAliases: vendorAliases,
+		tanka:         tanka,
+	}
+	if cfg := s.config; cfg != nil {
+		f.importer.SetJPaths(cfg.JPaths)
+		f.importer.SetImportOrder(cfg.Imports.Order, cfg.Imports.Strict)
+	}
+	return f
+}
+
+// inDirectory returns true if the file is inside the directory `dir`.
+func inDirectory(dir, u uri.URI) bool {
+	rel, err := filepath.Rel(dir.Filename(), u.Filename())
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// folders returns the folders of the workspace, the first folder is the main one.
+func (s *Server) folders() []*workspaceFolder {
+	s.folderLock.Lock()
+	defer s.folderLock.Unlock()
+	return append([]*workspaceFolder{}, s.workspaceFolders...)
+}
+
+// mainFolder returns the first folder of the workspace, or nil if there are none.
+func (s *Server) mainFolder() *workspaceFolder {
+	s.folderLock.Lock()
+	defer s.folderLock.Unlock()
+	if len(s.workspaceFolders) == 0 {
+		return nil
+	}
+	return s.workspaceFolders[0]
+}
+
+// folderOf returns the workspace folder a file belongs to: the innermost folder containing
+// it, or the main folder for files outside of the workspace. Files opened without any
+// folder get a folder of their own directory.
+func (s *Server) folderOf(u uri.URI) *workspaceFolder {
+	folders := s.folders()
+	var res *workspaceFolder
+	for _, f := range folders {
+		if inDirectory(f.uri, u) && (res == nil || len(f.uri.Filename()) > len(res.uri.Filename())) {
+			res = f
+		}
+	}
+	if res != nil {
+		return res
+	}
+	if len(folders) > 0 {
+		return folders[0]
+	}
+
+	// the folder is created once, as detecting its layout reads the directory
+	dir := uri.File(filepath.Dir(u.Filename()))
+	s.folderLock.Lock()
+	defer s.folderLock.Unlock()
+	if f := s.looseFolders[dir]; f != nil {
+		return f
+	}
+	if s.looseFolders == nil {
+		s.looseFolders = map[uri.URI]*workspaceFolder{}
+	}
+	f := s.newWorkspaceFolder(dir)
+	s.looseFolders[dir] = f
+	return f
+}
+
+// importerFolders returns the folders of the workspace, and the ones of files opened
+// without a workspace folder, whose importers follow the configuration.
+func (s *Server) importerFolders() []*workspaceFolder {
+	s.folderLock.Lock()
+	defer s.folderLock.Unlock()
+	res := append([]*workspaceFolder{}, s.workspaceFolders...)
+	for _, f := range s.looseFolders {
+		res = append(res, f)
+	}
+	return res
+}
+
+// bazelProjectRoot returns the project root of a directory generated by a bazel IDE
+// plugin, or the directory itself.
+func bazelProjectRoot(rootDir string) uri.URI {
+	// The IntelliJ Bazel Plugin generates an artificial .ijwb project directory
+	// inside the actual project root.
+	// https://blog.bazel.build/2019/09/29/intellij-bazel-sync.html
+	bazelDirs := []string{"/.ijwb", "/.aswb", "/.clwb"}
+	for _, bazelDir := range bazelDirs {
+		if strings.Contains(rootDir, bazelDir) {
+			rootDir = strings.Replace(rootDir, bazelDir, "", 1)
+			break
+		}
+	}
+	return uri.URI(rootDir)
+}
+
+// findRootDirectories returns the root of every workspace folder, or the root of the
+// workspace for clients without workspace folders.
+func findRootDirectories(params *protocol.InitializeParams) []uri.URI {
+	res := []uri.URI{}
+	for _, f := range params.WorkspaceFolders {
+		res = append(res, bazelProjectRoot(f.URI))
+	}
+	if len(res) == 0 {
+		res = append(res, bazelProjectRoot(rootDirectoryFrom(params)))
+	}
+	return res
+}
+
+func (s *Server) DidChangeWorkspaceFolders(ctx context.Context, params *protocol.DidChangeWorkspaceFoldersParams) error {
+	removed := map[uri.URI]bool{}
+	for _, f := range params.Event.Removed {
+		removed[bazelProjectRoot(f.URI)] = true
+	}
+	added := []*workspaceFolder{}
+	for _, f := range params.Event.Added {
+		added = append(added, s.newWorkspaceFolder(bazelProjectRoot(f.URI)))
+	}
+
+	s.folderLock.Lock()
+	folders := []*workspaceFolder{}
+	for _, f := range s.workspaceFolders {
+		if !removed[f.uri] {
+			folders = append(folders, f)
+		}
+	}
+	s.workspaceFolders = append(folders, added...)
+	s.folderLock.Unlock()
+
+	// forget the files of removed folders, and resolve the imports of open files again
+	s.index.lock.Lock()
+	for u := range s.index.files {
+		for root := range removed {
+			if inDirectory(root, u) {
+				delete(s.index.files, u)
+				delete(s.index.used, u)
+			}
+		}
+	}
+	s.index.lock.Unlock()
+	s.flushVMs()
+	go s.refreshDiagnostics(ctx)
+	if len(added) > 0 {
+		go s.indexWorkspace(ctx)
+		go s.preloadGrafonnet(ctx, added)
+	}
+	return nil
+}
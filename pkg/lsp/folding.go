@@ -0,0 +1,35 @@
+package lsp
+
+import (
+	"context"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"go.lsp.dev/protocol"
+)
+
+var foldingKindToProto = map[analysis.FoldingKind]protocol.FoldingRangeKind{
+	analysis.FoldRegion:  protocol.RegionFoldingRange,
+	analysis.FoldComment: protocol.CommentFoldingRange,
+	analysis.FoldImports: protocol.ImportsFoldingRange,
+}
+
+func (s *Server) FoldingRanges(ctx context.Context, params *protocol.FoldingRangeParams) ([]protocol.FoldingRange, error) {
+	res := []protocol.FoldingRange{}
+	parsed := s.overlay.Parsed(params.TextDocument.URI)
+	if parsed == nil {
+		return res, nil
+	}
+	pr, _ := parsed.Data.(*ParseResult)
+	if pr == nil || pr.Root == nil {
+		return res, nil
+	}
+
+	for _, fr := range analysis.FoldingRanges(pr.Root, parsed.Contents) {
+		res = append(res, protocol.FoldingRange{
+			StartLine: uint32(fr.StartLine - 1),
+			EndLine:   uint32(fr.EndLine - 1),
+			Kind:      foldingKindToProto[fr.Kind],
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,161 @@
+package lsp
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+	"go.lsp.dev/protocol"
+)
+
+// formatterOptions returns the configured formatter options, using the editor's tab
+// size when no indent is configured.
+func (s *Server) formatterOptions(params protocol.FormattingOptions) formatter.Options {
+	opts := s.config.FormatterOptions()
+	if opts.Indent <= 0 {
+		opts.Indent = int(params.TabSize)
+	}
+	return opts
+}
+
+// FormattedFile is a file formatted from the command line.
+type FormattedFile struct {
+	Path      string
+	Contents  string
+	Formatted string
+	// the file does not parse, it is not formatted
+	Err error
+}
+
+// Format formats jsonnet files with the formatter settings of the workspace at `root`, the
+// way the server formats them. Paths are files or directories, the whole workspace is
+// formatted if there are none. Files that do not parse are returned with their error.
+func Format(ctx context.Context, root string, paths []string) ([]FormattedFile, error) {
+	s, err := newHeadlessServer(root)
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.headlessFiles(ctx, root, paths)
+	if err != nil {
+		return nil, err
+	}
+	// without an editor, the indent is the one of the default options when not configured
+	opts := s.formatterOptions(protocol.FormattingOptions{TabSize: uint32(formatter.DefaultOptions().Indent)})
+	res := make([]FormattedFile, 0, len(files))
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		f := FormattedFile{Path: file, Contents: string(data)}
+		f.Formatted, f.Err = formatter.Format(file, f.Contents, opts)
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+// lineIndent returns the leading whitespace of the line `loc` is on.
+func lineIndent(contents string, loc ast.Location) string {
+	line := contents[locToOffset(contents, ast.Location{Line: loc.Line, Column: 1}):]
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// formatNode formats the text in `rng` on its own, and indents the result to match the
+// line it starts on. Returns false if the text is not a complete expression.
+func formatNode(fname, contents string, rng ast.LocationRange, opts formatter.Options) (string, bool) {
+	out, err := formatter.Format(fname, textInRange(contents, rng), opts)
+	if err != nil {
+		return "", false
+	}
+	indent := lineIndent(contents, rng.Begin)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// RangeFormatting formats the smallest expression that contains the whole selection,
+// leaving the rest of the document untouched.
+func (s *Server) RangeFormatting(ctx context.Context, params *protocol.DocumentRangeFormattingParams) ([]protocol.TextEdit, error) {
+	current := s.overlay.Current(params.TextDocument.URI)
+	parsed := s.overlay.Parsed(params.TextDocument.URI)
+	if current == nil || parsed == nil || parsed.Contents != current.Contents {
+		return []protocol.TextEdit{}, nil
+	}
+	pr, _ := parsed.Data.(*ParseResult)
+	if pr == nil || pr.Root == nil || pr.Err != nil {
+		return []protocol.TextEdit{}, nil
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	opts := s.formatterOptions(params.Options)
+	sel := s.positions().rangeFromClient(params.TextDocument.URI, params.Range)
+	end := protoToPos(sel.End)
+	for _, rng := range analysis.SelectionRanges(pr.Root, protoToPos(sel.Start)) {
+		if !analysis.LocInRange(rng, end) {
+			continue
+		}
+		out, ok := formatNode(fname, current.Contents, rng, opts)
+		if !ok {
+			continue
+		}
+		begin, stop := locToOffset(current.Contents, rng.Begin), locToOffset(current.Contents, rng.End)
+		return diffEdits(current.Contents, current.Contents[:begin]+out+current.Contents[stop:]), nil
+	}
+	return []protocol.TextEdit{}, nil
+}
+
+// reindentLine returns an edit that replaces the indentation of `line` (0-indexed) with
+// the indentation it has after formatting, or false if it is already correct.
+func reindentLine(contents string, line uint32, unit string) (protocol.TextEdit, bool) {
+	indent, ok := analysis.LineIndent(contents, int(line)+1, unit)
+	if !ok {
+		return protocol.TextEdit{}, false
+	}
+	text := contents[locToOffset(contents, ast.Location{Line: int(line) + 1, Column: 1}):]
+	if nl := strings.IndexByte(text, '\n'); nl >= 0 {
+		text = text[:nl]
+	}
+	current := text[:len(text)-len(strings.TrimLeft(text, " \t"))]
+	if current == indent {
+		return protocol.TextEdit{}, false
+	}
+	return protocol.TextEdit{
+		Range: protocol.Range{
+			Start: protocol.Position{Line: line},
+			End:   protocol.Position{Line: line, Character: uint32(len(current))},
+		},
+		NewText: indent,
+	}, true
+}
+
+// OnTypeFormatting fixes the indentation of a line once it is completed with a newline,
+// and of closing brackets as they are typed.
+func (s *Server) OnTypeFormatting(ctx context.Context, params *protocol.DocumentOnTypeFormattingParams) ([]protocol.TextEdit, error) {
+	res := []protocol.TextEdit{}
+	current := s.overlay.Current(params.TextDocument.URI)
+	if current == nil {
+		return res, nil
+	}
+	unit := strings.Repeat(" ", s.formatterOptions(params.Options).Indent)
+
+	lines := []uint32{params.Position.Line}
+	if params.Ch == "\n" && params.Position.Line > 0 {
+		lines = []uint32{params.Position.Line - 1, params.Position.Line}
+	}
+	for _, line := range lines {
+		if edit, ok := reindentLine(current.Contents, line, unit); ok {
+			res = append(res, edit)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,85 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/uri"
+)
+
+// grafonnetEntrypoints are the import paths of the main files of Grafonnet: the generated
+// library (grafana/grafonnet), and the older grafonnet-lib with its legacy import name.
+var grafonnetEntrypoints = []string{
+	"github.com/grafana/grafonnet/gen/grafonnet-latest/main.libsonnet",
+	"github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet",
+	"grafonnet/grafana.libsonnet",
+}
+
+// grafonnetVendored returns true if Grafonnet is installed in the vendor directory of a
+// workspace folder.
+func grafonnetVendored(root fs.FS) bool {
+	for _, dir := range []string{"vendor/github.com/grafana/grafonnet", "vendor/github.com/grafana/grafonnet-lib"} {
+		if info, err := fs.Stat(root, dir); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// preloadGrafonnet parses every file of Grafonnet in the background, in the folders where
+// it is vendored. Its API is spread over hundreds of large generated files, which would
+// otherwise be parsed by the first completion, hover or signature help that uses them.
+func (s *Server) preloadGrafonnet(ctx context.Context, folders []*workspaceFolder) {
+	for _, folder := range folders {
+		if ctx.Err() != nil {
+			return
+		}
+		if grafonnetVendored(folder.fs) {
+			s.preloadImports(ctx, folder, "Indexing Grafonnet", grafonnetEntrypoints)
+		}
+	}
+}
+
+// preloadImports parses the files imported by the paths, and the files they import, into
+// the ASTs shared by every VM. Paths that are not found are skipped.
+func (s *Server) preloadImports(ctx context.Context, folder *workspaceFolder, title string, paths []string) {
+	// imports are resolved like they are from a file at the root of the folder
+	from := filepath.Join(folder.uri.Filename(), "main.jsonnet")
+	vm := s.newVMCache(uri.File(from))
+	// the overall progress is shown instead of the progress of each file
+	vm.startProgress = func(string, string) *workProgress { return &workProgress{s: s} }
+	p := s.startProgress(title, "")
+	defer p.end("")
+
+	start := time.Now()
+	type pendingImport struct{ from, path string }
+	queue := []pendingImport{}
+	for _, path := range paths {
+		queue = append(queue, pendingImport{from, path})
+	}
+	seen := map[string]bool{}
+	for len(queue) > 0 && ctx.Err() == nil {
+		imp := queue[0]
+		queue = queue[1:]
+		root, foundAt := vm.ImportAST(imp.from, imp.path)
+		if root == nil || seen[foundAt.Filename()] {
+			continue
+		}
+		seen[foundAt.Filename()] = true
+		p.report(fmt.Sprintf("%d files", len(seen)), 0)
+		analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+			if i, ok := n.(*ast.Import); ok {
+				queue = append(queue, pendingImport{foundAt.Filename(), i.File.Value})
+			}
+			return true
+		})
+	}
+	if len(seen) > 0 {
+		logf("%s: parsed %d files in %s", title, len(seen), time.Since(start))
+	}
+}
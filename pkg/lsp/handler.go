@@ -7,21 +7,80 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
+	"github.com/carlverge/jsonnet-lsp/pkg/overlay"
 	"github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/formatter"
 	"go.lsp.dev/jsonrpc2"
 	"go.lsp.dev/protocol"
 	"go.lsp.dev/uri"
+	"sigs.k8s.io/yaml"
 )
 
 
 type DiagConfiguration struct {
 	Linter   bool `json:"linter"`
 	Evaluate bool `json:"evaluate"`
+	// Evaluate files when they are saved, instead of on every change
+	EvaluateOnSave bool `json:"evaluateOnSave"`
+	// Milliseconds between the last change of a file and linting it, and evaluating it if
+	// evaluate is set. The changes made in the meantime are checked at once.
+	LintDelay     int `json:"lintDelay"`
+	EvaluateDelay int `json:"evaluateDelay"`
+	// Severity of unused imports: error, warning, information, hint, or off
+	UnusedImports Severity `json:"unusedImports"`
+	// Names of variables that can shadow a variable of an enclosing scope without a warning
+	AllowShadowing []string `json:"allowShadowing"`
+	// Severity overrides by diagnostic code, f.ex `{"UnusedVar": "hint"}`. Diagnostics
+	// set to off are not reported.
+	Rules map[string]Severity `json:"rules"`
+}
+
+// Severity is the configured severity of a diagnostic. YAML reads a bare `off` as false, so
+// booleans are accepted too: false turns the diagnostic off.
+type Severity string
+
+func (sev *Severity) UnmarshalJSON(data []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(data, &enabled); err == nil {
+		*sev = ""
+		if !enabled {
+			*sev = "off"
+		}
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	*sev = Severity(str)
+	return nil
+}
+
+// applyRules overrides the severity of diagnostics by their code, and drops the ones
+// that are turned off.
+func (c DiagConfiguration) applyRules(diags []protocol.Diagnostic) []protocol.Diagnostic {
+	if len(c.Rules) == 0 {
+		return diags
+	}
+	res := make([]protocol.Diagnostic, 0, len(diags))
+	for _, d := range diags {
+		if rule, ok := c.Rules[fmt.Sprint(d.Code)]; ok && d.Code != nil {
+			sev, enabled := linter.ParseSeverity(string(rule), d.Severity)
+			if !enabled {
+				continue
+			}
+			d.Severity = sev
+		}
+		res = append(res, d)
+	}
+	return res
 }
 
 type FmtConfiguration struct {
@@ -39,8 +98,11 @@ type FmtConfiguration struct {
 func defaultConfiguration() *Configuration {
 	return &Configuration{
 		Diag: DiagConfiguration{
-			Linter:   true,
-			Evaluate: false,
+			Linter:        true,
+			Evaluate:      false,
+			UnusedImports: "warning",
+			LintDelay:     defaultLintDelay,
+			EvaluateDelay: defaultEvaluateDelay,
 		},
 		Fmt: FmtConfiguration{
 			Indent:           2,
@@ -53,6 +115,18 @@ func defaultConfiguration() *Configuration {
 			ImplicitPlus:     true,
 			SortImports:      true,
 		},
+		LargeFile: LargeFileConfiguration{
+			MaxBytes: defaultLargeFileBytes,
+			MaxLines: defaultLargeFileLines,
+		},
+		Index: IndexConfiguration{
+			Exclude:   []string{"node_modules"},
+			Gitignore: true,
+		},
+		Imports: ImportConfiguration{
+			// copied, the settings are decoded into it
+			Order: append([]string{}, defaultImportOrder...),
+		},
 	}
 }
 
@@ -60,6 +134,63 @@ type Configuration struct {
 	Diag   DiagConfiguration `json:"diag"`
 	JPaths []string          `json:"jpaths"`
 	Fmt    FmtConfiguration  `json:"fmt"`
+	// External variables and top-level arguments given to every VM, as strings or as
+	// jsonnet code.
+	ExtVars map[string]string `json:"extVars"`
+	ExtCode map[string]string `json:"extCode"`
+	TLAVars map[string]string `json:"tlaVars"`
+	TLACode map[string]string `json:"tlaCode"`
+	// Native functions registered by the tool that evaluates the files, by name with the
+	// names of their parameters, f.ex `{"sha256": ["str"]}`. They are evaluated as stubs
+	// that return null.
+	NativeFunctions map[string][]string `json:"nativeFunctions"`
+	// JSON Schemas the output of files is validated against when they are saved, by globs
+	// of the files relative to the workspace folder, f.ex `{"environments/**/main.jsonnet":
+	// "schemas/environment.json"}`.
+	Schemas map[string]string `json:"schemas"`
+	// OpenAPI documents and CustomResourceDefinitions, files or directories relative to the
+	// workspace folder, used to complete and check the fields of Kubernetes resources.
+	KubernetesSchemas []string `json:"kubernetesSchemas"`
+	// Files over these limits are only parsed, f.ex large generated files.
+	LargeFile LargeFileConfiguration `json:"largeFile"`
+	// Megabytes of memory the server stays under by dropping its caches, zero is no budget.
+	MemoryBudget int `json:"memoryBudget"`
+	// The files of the workspace that are indexed.
+	Index IndexConfiguration `json:"index"`
+	// Where imports are searched for the files they import.
+	Imports ImportConfiguration `json:"imports"`
+}
+
+// ImportConfiguration is the order imports are resolved in.
+type ImportConfiguration struct {
+	// The places searched, in order: `root` (the workspace folder), `importer` (the
+	// directory of the importing file), `tanka` (the environment of the importing file),
+	// `searchPaths` (detected directories like `bazel-bin` and `vendor`), `bundler` (legacy
+	// jsonnet-bundler package names), and `jpaths`. Places that are not listed are not
+	// searched.
+	Order []string `json:"order"`
+	// Resolve imports exactly like the jsonnet command given a `-J` flag for each jpath:
+	// the directory of the importing file, then the jpaths from the last one. The order is
+	// ignored.
+	Strict bool `json:"strict"`
+}
+
+// IndexConfiguration is the paths of the workspace that are skipped when indexing it, f.ex
+// dependencies or large generated directories.
+type IndexConfiguration struct {
+	// Globs of the paths relative to the workspace folder, f.ex `node_modules` or
+	// `generated/**`. A glob without a slash matches the name of a file or directory in
+	// any directory.
+	Exclude []string `json:"exclude"`
+	// Whether the paths the .gitignore files of the workspace ignore are skipped.
+	Gitignore bool `json:"gitignore"`
+}
+
+// LargeFileConfiguration is the limits of the files that are checked live, zero is no
+// limit.
+type LargeFileConfiguration struct {
+	MaxBytes int `json:"maxBytes"`
+	MaxLines int `json:"maxLines"`
 }
 
 func (c *Configuration) FormatterOptions() formatter.Options {
@@ -97,12 +228,27 @@ func (c *Configuration) FormatterOptions() formatter.Options {
 	return opts
 }
 
+// Handler handles the messages of the client in order. Messages are read while the
+// previous one is handled, so requests can be canceled with `$/cancelRequest` while they
+// are waiting or running.
 func (s *Server) Handler() jsonrpc2.Handler {
 	serverHandler := protocol.ServerHandler(s, jsonrpc2.MethodNotFoundHandler)
-	return serverHandler
+	return protocol.CancelHandler(jsonrpc2.AsyncHandler(skipCanceled(s.extensionHandler(serverHandler))))
+}
+
+// skipCanceled replies to requests that were canceled while waiting for the previous
+// message to be handled, without handling them.
+func skipCanceled(next jsonrpc2.Handler) jsonrpc2.Handler {
+	return func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if _, ok := req.(*jsonrpc2.Call); ok && ctx.Err() != nil {
+			return reply(ctx, nil, protocol.ErrRequestCancelled)
+		}
+		return next(ctx, reply, req)
+	}
 }
 
 func (s *Server) Shutdown(ctx context.Context) (err error) {
+	s.saveIndexCache()
 	return nil
 }
 
@@ -112,23 +258,38 @@ func (s *Server) Exit(ctx context.Context) (err error) {
 }
 
 func (s *Server) Initialized(ctx context.Context, params *protocol.InitializedParams) (err error) {
+	// requests to the client cannot be made while handling a message from it
+	if s.configPull {
+		go s.pullConfiguration(ctx)
+	}
+	if s.watchFiles {
+		go s.registerFileWatcher(ctx)
+	}
 	return nil
 }
 
 func (s *Server) Initialize(ctx context.Context, params *protocol.InitializeParams) (result *protocol.InitializeResult, err error) {
 
-	s.rootURI = findRootDirectory(params)
-	// s.rootFS = os.DirFS("/")
-	s.rootFS = os.DirFS(s.rootURI.Filename())
-
-	// Check for bazel generated output directory
-	if _, err := fs.Stat(s.rootFS, "bazel-bin"); err == nil {
-		s.searchPaths = append(s.searchPaths, "bazel-bin")
-	} else {
-		logf("no bazel-bin dir: %v", err)
+	for _, root := range findRootDirectories(params) {
+		s.workspaceFolders = append(s.workspaceFolders, s.newWorkspaceFolder(root))
 	}
-
-	s.importer = &OverlayImporter{overlay: s.overlay, rootURI: s.rootURI, rootFS: s.rootFS, paths: s.searchPaths}
+	if params.InitializationOptions != nil {
+		s.setConfiguration(params.InitializationOptions, legacyConfigSettings(params.InitializationOptions))
+	}
+	if ws := params.Capabilities.Workspace; ws != nil {
+		s.configPull = ws.Configuration
+		s.watchFiles = ws.DidChangeWatchedFiles != nil && ws.DidChangeWatchedFiles.DynamicRegistration
+	}
+	if w := params.Capabilities.Window; w != nil {
+		s.workDoneProgress = w.WorkDoneProgress
+	}
+	if td := params.Capabilities.TextDocument; td != nil && td.Completion != nil && td.Completion.CompletionItem != nil {
+		s.snippetSupport = td.Completion.CompletionItem.SnippetSupport
+	}
+	go s.watchProjectConfig(ctx)
+	go s.watchMemory(ctx)
+	go s.indexWorkspace(ctx)
+	go s.preloadGrafonnet(ctx, s.folders())
 
 	_ = s.notifier.LogMessage(ctx, &protocol.LogMessageParams{
 		Message: "Jsonnet LSP Server Initialized",
@@ -146,43 +307,195 @@ func (s *Server) Initialize(ctx context.Context, params *protocol.InitializePara
 				TriggerCharacters:   []string{"("},
 				RetriggerCharacters: []string{","},
 			},
-			DocumentSymbolProvider: true,
+			DocumentSymbolProvider:  true,
+			WorkspaceSymbolProvider: true,
 			CompletionProvider: &protocol.CompletionOptions{
-				TriggerCharacters: []string{".", "/"},
+				ResolveProvider:   true,
+				TriggerCharacters: []string{".", "/", "@"},
+			},
+			DocumentFormattingProvider:      true,
+			DocumentRangeFormattingProvider: true,
+			DocumentOnTypeFormattingProvider: &protocol.DocumentOnTypeFormattingOptions{
+				FirstTriggerCharacter: "\n",
+				MoreTriggerCharacter:  []string{"}", "]"},
 			},
-			DocumentFormattingProvider: true,
 			HoverProvider:              true,
 			DefinitionProvider:         true,
+			ReferencesProvider:         true,
+			DocumentHighlightProvider:  true,
+			CallHierarchyProvider:      true,
+			CodeLensProvider:           &protocol.CodeLensOptions{ResolveProvider: true},
+			FoldingRangeProvider:       true,
+			DocumentLinkProvider:       &protocol.DocumentLinkOptions{},
+			SelectionRangeProvider:     true,
+			LinkedEditingRangeProvider: true,
+			RenameProvider:             &protocol.RenameOptions{PrepareProvider: true},
+			CodeActionProvider: &protocol.CodeActionOptions{
+				CodeActionKinds: []protocol.CodeActionKind{
+					protocol.QuickFix,
+					protocol.RefactorExtract,
+					protocol.RefactorInline,
+					protocol.RefactorRewrite,
+					refactorMove,
+					protocol.SourceOrganizeImports,
+				},
+			},
+			ExecuteCommandProvider: &protocol.ExecuteCommandOptions{
+				// the other commands are registered by the editor extension
+				Commands: []string{commandMoveToFile, commandCompletionAccepted},
+			},
+			Workspace: &protocol.ServerCapabilitiesWorkspace{
+				WorkspaceFolders: &protocol.ServerCapabilitiesWorkspaceFolders{
+					Supported:           true,
+					ChangeNotifications: true,
+				},
+				FileOperations: &protocol.ServerCapabilitiesWorkspaceFileOperations{
+					WillRename: &protocol.FileOperationRegistrationOptions{Filters: willRenameFilters},
+				},
+			},
 		},
 	}, nil
 }
 
-func (s *Server) DidChangeConfiguration(ctx context.Context, params *protocol.DidChangeConfigurationParams) (err error) {
-	data, _ := json.Marshal(params.Settings)
-	logf("did change config: %s", string(data))
-	newcfg := &Configuration{}
-	if err := json.Unmarshal(data, newcfg); err != nil {
-		logf("failed to parse new configuration: %+v", err)
-		return nil
+// setConfiguration sets the settings sent by the client, as initialization options or a
+// configuration change, along with the settings of the vscode-jsonnet extension.
+func (s *Server) setConfiguration(settings, legacy interface{}) bool {
+	s.configLock.Lock()
+	s.settings = settings
+	s.legacySettings = legacy
+	s.configLock.Unlock()
+	return s.applyConfiguration()
+}
+
+// applyConfiguration builds the configuration from the defaults, the settings of the
+// vscode-jsonnet extension, the client settings, and the project configuration file, in
+// increasing priority. Returns true if the files of the workspace that are indexed changed.
+func (s *Server) applyConfiguration() bool {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+
+	newcfg := defaultConfiguration()
+	if s.settings != nil {
+		data, _ := json.Marshal(s.settings)
+		logf("set config: %s", string(data))
+		if err := json.Unmarshal(data, newcfg); err != nil {
+			logf("failed to parse new configuration: %+v", err)
+			return false
+		}
+	}
+	if s.legacySettings != nil {
+		// the other extension may be configured in ways the server does not understand,
+		// which should not prevent its own settings from applying
+		if legacy, err := parseLegacySettings(s.legacySettings); err != nil {
+			logf("failed to parse the %q settings: %+v", legacySection, err)
+		} else {
+			legacy.apply(newcfg)
+		}
+	}
+	if s.projectConfig != nil {
+		logf("set project config: %s", string(s.projectConfig))
+		if err := json.Unmarshal(s.projectConfig, newcfg); err != nil {
+			logf("failed to parse project configuration: %+v", err)
+			return false
+		}
+	}
+
+	for _, source := range newcfg.Imports.Order {
+		if !isImportSource(source) {
+			logf("unknown place %q in imports.order, expected one of %v", source, defaultImportOrder)
+		}
 	}
 
 	// TODO(@carlverge): Rethink how paths are threaded through the code, this is getting too messy.
-	s.importer.SetJPaths(newcfg.JPaths)
+	for _, f := range s.importerFolders() {
+		f.importer.SetJPaths(newcfg.JPaths)
+		f.importer.SetImportOrder(newcfg.Imports.Order, newcfg.Imports.Strict)
+	}
 
+	// indexing the workspace again forgets the files that are now excluded
+	reindex := !reflect.DeepEqual(s.config.Index, newcfg.Index)
 	// Racy in the sense we could see an old pointer, but that is OK.
 	s.config = newcfg
 
+	// the schema files are read again, they may have been regenerated
+	s.kube.reset()
+
+	// the cached VMs have the external variables of the old configuration
+	s.flushVMs()
+
+	setMemoryLimit(newcfg.memoryBudget())
+	return reindex
+}
+
+// configSection is the section of the client settings of the server.
+const configSection = "jsonnet.lsp"
+
+// pullConfiguration requests the settings of the server from the client, and publishes the
+// diagnostics of the open files again with the new configuration.
+func (s *Server) pullConfiguration(ctx context.Context) {
+	res, err := s.notifier.Configuration(ctx, &protocol.ConfigurationParams{
+		Items: []protocol.ConfigurationItem{{Section: configSection}, {Section: legacySection}},
+	})
+	if err != nil {
+		logf("failed to pull configuration: %v", err)
+		return
+	}
+	var legacy interface{}
+	if len(res) > 1 {
+		legacy = res[1]
+	}
+	if len(res) == 0 || (res[0] == nil && legacy == nil) {
+		return
+	}
+	if s.setConfiguration(res[0], legacy) {
+		go s.indexWorkspace(ctx)
+	}
+	s.refreshDiagnostics(ctx)
+}
+
+// configSettings returns the settings of the server from the settings of a configuration
+// change. Some clients send all of their settings, with the ones of the server nested
+// under its section.
+func configSettings(settings interface{}) interface{} {
+	nested := settings
+	for _, key := range strings.Split(configSection, ".") {
+		m, ok := nested.(map[string]interface{})
+		if !ok || m[key] == nil {
+			return settings
+		}
+		nested = m[key]
+	}
+	return nested
+}
+
+func (s *Server) DidChangeConfiguration(ctx context.Context, params *protocol.DidChangeConfigurationParams) (err error) {
+	if s.configPull {
+		// clients that support pulling may send no settings with the notification
+		go s.pullConfiguration(ctx)
+		return nil
+	}
+	if params.Settings == nil {
+		return nil
+	}
+	if s.setConfiguration(configSettings(params.Settings), legacyConfigSettings(params.Settings)) {
+		go s.indexWorkspace(ctx)
+	}
+	go s.refreshDiagnostics(ctx)
 	return nil
 }
 
+// DidOpen publishes the diagnostics of a file as soon as it is opened, unlike changes they
+// do not wait for the lint delay. The client opens the files of its visible editors after
+// the server is initialized, so they are checked without being edited first.
 func (s *Server) DidOpen(ctx context.Context, params *protocol.DidOpenTextDocumentParams) error {
 	logf("did-open: uri=%s ver=%d txtlen=%d", params.TextDocument.URI, params.TextDocument.Version, len(params.TextDocument.Text))
+	s.diagReports.changed(params.TextDocument.URI, int64(params.TextDocument.Version))
 	s.overlay.Replace(
 		params.TextDocument.URI,
 		int64(params.TextDocument.Version),
 		params.TextDocument.Text,
 		parseJsonnetFn(params.TextDocument.URI),
-		s.processFileUpdateFn(ctx, params.TextDocument.URI),
+		s.processFileUpdateFn(ctx, params.TextDocument.URI, false),
 	)
 	return nil
 }
@@ -197,25 +510,41 @@ func lastCharIsDot(ce []protocol.TextDocumentContentChangeEvent) bool {
 
 func (s *Server) DidChange(ctx context.Context, params *protocol.DidChangeTextDocumentParams) error {
 	tracef("did-change: uri=%s ver=%d changes=%d", params.TextDocument.URI, params.TextDocument.Version, len(params.ContentChanges))
-	s.overlay.Update(
+	s.diagReports.changed(params.TextDocument.URI, int64(params.TextDocument.Version))
+	// the other files see the new contents of the file they import
+	s.dropImporters(params.TextDocument.URI)
+	s.overlay.UpdateWith(
 		params.TextDocument.URI,
 		int64(params.TextDocument.Version),
-		convChangeEvents(params.ContentChanges),
+		changeEdits(params.ContentChanges, s.positionEncoding),
 		parseJsonnetFn(params.TextDocument.URI),
-		s.processFileUpdateFn(ctx, params.TextDocument.URI),
+		s.debouncedUpdateFn(ctx, params.TextDocument.URI),
 	)
 	s.lastCharIsDot = lastCharIsDot(params.ContentChanges)
+	s.schedulePreviews()
 	return nil
 }
 
 func (s *Server) DidSave(ctx context.Context, params *protocol.DidSaveTextDocumentParams) (err error) {
 	tracef("did-save: uri=%s", params.TextDocument.URI)
+	u := params.TextDocument.URI
+	// files with a JSON Schema are evaluated to validate their output
+	contents, _ := s.fileContents(u)
+	if (!s.config.Diag.EvaluateOnSave || s.config.Diag.Evaluate) && s.schemaFor(u, contents) == "" {
+		// files are already evaluated on every change
+		return nil
+	}
+	update := overlay.UpdateResult{Current: s.overlay.Current(u), Parsed: s.overlay.Parsed(u)}
+	go s.processFileUpdateFn(ctx, u, true)(update)
 	return nil
 }
 
 func (s *Server) DidClose(_ context.Context, params *protocol.DidCloseTextDocumentParams) (err error) {
 	logf("did-close: uri=%s", params.TextDocument.URI)
 	s.overlay.Close(params.TextDocument.URI)
+	s.diagSchedule.cancel(params.TextDocument.URI)
+	s.diagReports.drop(params.TextDocument.URI)
+	s.closePreviews(params.TextDocument.URI)
 	return nil
 }
 
@@ -247,7 +576,7 @@ func isObjectFieldsCompletion(stk []ast.Node, resolver analysis.Resolver) []anal
 		// If the user has already filled out a field in the template, do not show it in the
 		// completion list (or if the field is hidden)
 		for _, fld := range lhs.Object.Fields {
-			if seenFields[fld.Name] || fld.Hidden {
+			if seenFields[fld.Name] || fld.Hidden || analysis.IsDocField(fld) {
 				continue
 			}
 			res = append(res, fld)
@@ -290,25 +619,68 @@ func valueToDetail(v *analysis.Value) string {
 	return v.Type.String()
 }
 
+// stdlibFields are the members of the stdlib that are not functions.
+var stdlibFields = map[string]bool{"thisFile": true}
+
+// stdlibSnippet inserts a call of a stdlib function, with a placeholder for each parameter
+// that has no default value.
+func stdlibSnippet(name string, fn *analysis.Function) string {
+	if stdlibFields[name] {
+		return name
+	}
+	params := []string{}
+	for i, p := range fn.Params {
+		if p.Default != nil {
+			break
+		}
+		params = append(params, fmt.Sprintf("${%d:%s}", i+1, p.Name))
+	}
+	return name + "(" + strings.Join(params, ", ") + ")$0"
+}
+
+// stdlibDocumentation is the signature and description of a stdlib function, and the
+// version of jsonnet it is available since.
+func stdlibDocumentation(name string, fn *analysis.Function) string {
+	sb := strings.Builder{}
+	sb.WriteString("```jsonnet\nstd." + name)
+	if !stdlibFields[name] {
+		sb.WriteString(fn.String())
+	}
+	sb.WriteString("\n```\n")
+	if len(fn.Comment) > 0 {
+		sb.WriteString("\n" + strings.Join(fn.Comment, "\n") + "\n")
+	}
+	if since := analysis.StdLibSince[name]; since != "" {
+		sb.WriteString("\n*Available since jsonnet " + since + "*\n")
+	}
+	return sb.String()
+}
+
 // precompute these as they are numerous and commonly used
 // this also lets us bypass the issue of their not having a real
 // ast node associated with them
-var stdlibCompletions = func() (res []protocol.CompletionItem) {
+var stdlibCompletions = func() (res []completionCandidate) {
 	for name, val := range analysis.StdLibFunctions {
-
-		res = append(res, protocol.CompletionItem{
-			Label:         name,
-			Detail:        name + val.String(),
-			Documentation: &protocol.MarkupContent{Kind: protocol.Markdown, Value: strings.Join(val.Comment, "\n")},
-			Kind:          protocol.CompletionItemKindFunction,
-		})
+		kind, typ := protocol.CompletionItemKindFunction, analysis.FunctionType
+		if stdlibFields[name] {
+			kind, typ = protocol.CompletionItemKindField, analysis.AnyType
+		}
+		res = append(res, completionCandidate{item: protocol.CompletionItem{
+			Label:            name,
+			Detail:           name + val.String(),
+			Documentation:    &protocol.MarkupContent{Kind: protocol.Markdown, Value: stdlibDocumentation(name, val)},
+			Kind:             kind,
+			InsertText:       stdlibSnippet(name, val),
+			InsertTextFormat: protocol.InsertTextFormatSnippet,
+		}, typ: typ})
 	}
+	sort.Slice(res, func(i, j int) bool { return res[i].item.Label < res[j].item.Label })
 	return res
 }()
 
 func (s *Server) Completion(ctx context.Context, params *protocol.CompletionParams) (*protocol.CompletionList, error) {
 	res := &protocol.CompletionList{IsIncomplete: false, Items: []protocol.CompletionItem{}}
-	resolver := s.NewResolver(params.TextDocument.URI)
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
 	if resolver == nil {
 		return res, nil
 	}
@@ -316,26 +688,46 @@ func (s *Server) Completion(ctx context.Context, params *protocol.CompletionPara
 	isDotComplete := s.lastCharIsDot || (params.Context != nil && params.Context.TriggerCharacter == ".")
 	isSlashComplete := params.Context != nil && params.Context.TriggerCharacter == "/"
 
-	pos := protoToPos(params.Position)
+	pos := protoToPos(s.positions().fromClient(params.TextDocument.URI, params.Position))
+	cursor := pos
 	if isDotComplete {
 		pos.Column--
 	}
 	node, stack := resolver.NodeAt(pos)
+	contents, _ := s.fileContents(params.TextDocument.URI)
+	ranker := s.completionRanker(contents, cursor, stack, resolver)
+
+	// In comments only the tags documenting functions are completed
+	if items, ok := s.docTagCompletions(params.TextDocument.URI, resolver.rootAST, contents, cursor); ok {
+		res.Items = items
+		return res, nil
+	}
+	if params.Context != nil && params.Context.TriggerCharacter == "@" {
+		return res, nil
+	}
 
 	// Import file completion
 	if imp, ok := node.(*ast.Import); ok {
 		// always search a directory
+		folder := s.folderOf(params.TextDocument.URI)
+		from := params.TextDocument.URI.Filename()
 		path := filepath.Dir(imp.File.Value)
-		if finfo, err := fs.Stat(s.rootFS, filepath.Clean(imp.File.Value)); err == nil && finfo.IsDir() {
-			path = filepath.Clean(imp.File.Value)
+		if candidates, err := folder.importer.candidates(from, filepath.Clean(imp.File.Value)); err == nil {
+			for _, c := range candidates {
+				if finfo, err := os.Stat(c.uri.Filename()); err == nil && finfo.IsDir() {
+					path = filepath.Clean(imp.File.Value)
+					break
+				}
+			}
 		}
 
 		seen := map[string]bool{}
 		ents := []fs.DirEntry{}
 
-		// Dedup files/directories from search paths
-		for _, sp := range append(append([]string{""}, s.searchPaths...), s.config.JPaths...) {
-			entries, _ := fs.ReadDir(s.rootFS, filepath.Join(sp, path))
+		// Dedup files/directories from the places the import is searched at
+		candidates, _ := folder.importer.candidates(from, path)
+		for _, c := range candidates {
+			entries, _ := os.ReadDir(c.uri.Filename())
 			for _, ent := range entries {
 				if seen[ent.Name()] {
 					continue
@@ -368,69 +760,170 @@ func (s *Server) Completion(ctx context.Context, params *protocol.CompletionPara
 		return res, nil
 	}
 
+	if lit, ok := extVarCall(stack); ok {
+		res.Items = s.extVarCompletions(params.TextDocument.URI, resolver.rootAST, lit)
+		return res, nil
+	}
+
+	// On the name of a field access (`k.fo`, or after the dot of `(import 'k.libsonnet').`),
+	// complete the fields of the object it indexes.
+	namePos := pos
 	if isDotComplete {
-		topVal := analysis.NodeToValue(node, resolver)
+		namePos.Column++
+	}
+	if idx, _, ok := analysis.DottedIndexAt(stack, namePos); ok {
+		node, isDotComplete = idx.Target, true
+	}
+
+	// `self.` completes the fields of the enclosing object and the objects it is mixed
+	// with, and `super.` the fields of the object it is mixed into.
+	keyword := accessKeyword(contents, namePos)
+
+	if isDotComplete || keyword != "" {
+		var topVal *analysis.Value
+		// the templates wrapping the expression in a std call, f.ex `arr.map`
+		var postfix []completionCandidate
+		switch keyword {
+		case "self":
+			topVal = analysis.SelfValue(stack, resolver)
+		case "super":
+			topVal = analysis.SuperValue(stack, resolver)
+		default:
+			topVal = analysis.NodeToValue(node, resolver)
+			postfix = s.postfixCandidates(params.TextDocument.URI, contents, node, cursor, resolver)
+		}
+		if topVal == nil {
+			res.Items, res.IsIncomplete = ranker.rank(postfix)
+			return res, nil
+		}
 		if topVal.Object == nil {
+			// the fields may still be inferred, f.ex the fields common to the branches of
+			// a conditional
+			if keyword == "" {
+				res.Items, res.IsIncomplete = ranker.rank(append(shapeFieldItems(analysis.InferShape(node, resolver)), postfix...))
+			}
 			return res, nil
 		}
 
 		if topVal == analysis.StdLibValue {
-			res.Items = stdlibCompletions
+			candidates := make([]completionCandidate, len(stdlibCompletions))
+			for i, c := range stdlibCompletions {
+				c.item = s.snippetItem(c.item)
+				candidates[i] = c
+			}
+			res.Items, res.IsIncomplete = ranker.rank(candidates)
 			return res, nil
 		}
 
+		// the values of the fields are resolved for the items the client shows
+		s.completions.reset(resolver)
+		candidates := []completionCandidate{}
 		for _, fld := range topVal.Object.Fields {
-			fldVal := analysis.NodeToValue(fld.Node, resolver)
-
-			res.Items = append(res.Items, protocol.CompletionItem{
-				Label:         fld.Name,
-				InsertText:    analysis.SafeIdent(fld.Name),
-				Detail:        valueToDetail(fldVal),
-				Documentation: strings.Join(fld.Comment, "\n"),
-				Kind:          typeToCompletionKind(fld.Type, protocol.CompletionItemKindField),
-			})
+			// docsonnet doc fields are shown as the documentation of their field
+			if analysis.IsDocField(fld) {
+				continue
+			}
+			candidates = append(candidates, completionCandidate{item: s.fieldItem(fld), typ: fld.Type})
 		}
+		res.Items, res.IsIncomplete = ranker.rank(append(candidates, postfix...))
+		return res, nil
+	}
+
+	if items, ok := s.kubeCompletions(params.TextDocument.URI, stack); ok {
+		res.Items = items
 		return res, nil
 	}
 
 	if flds := isObjectFieldsCompletion(stack, resolver); flds != nil {
+		candidates := []completionCandidate{}
 		for _, fld := range flds {
-			res.Items = append(res.Items, protocol.CompletionItem{
+			candidates = append(candidates, completionCandidate{item: s.snippetItem(protocol.CompletionItem{
 				Label:            fld.Name,
 				InsertText:       analysis.SafeIdent(fld.Name) + ": $1,$0",
 				InsertTextFormat: protocol.InsertTextFormatSnippet,
 				Detail:           fld.Type.String(),
 				Documentation:    strings.Join(fld.Comment, "\n"),
 				Kind:             protocol.CompletionItemKindField,
-			})
+			}), typ: fld.Type})
 		}
+		res.Items, res.IsIncomplete = ranker.rank(candidates)
 		return res, nil
 	}
 
-	for name, v := range resolver.Vars(node) {
+	// locals of nearer scopes are ranked higher, along with the snippets of constructs like
+	// keywords and the fields of other files that are imported when picked
+	vars := resolver.Vars(node)
+	candidates := append(s.snippetCandidates(), s.autoImportCandidates(ctx, params.TextDocument.URI, contents, ranker.prefix, vars)...)
+	candidates = append(candidates, s.docsonnetCandidates(stack, vars)...)
+	for name, v := range vars {
 		if v.Node != nil {
 			val := analysis.NodeToValue(v.Node, resolver)
+			detail := val.Type.String()
+			if val.Function != nil {
+				// the signature of user functions, like the functions of the stdlib
+				detail += val.Function.String()
+			}
 
-			res.Items = append(res.Items, protocol.CompletionItem{
+			candidates = append(candidates, completionCandidate{item: protocol.CompletionItem{
 				Label:         name,
 				InsertText:    name,
-				Detail:        val.Type.String(),
+				Detail:        detail,
 				Documentation: strings.Join(val.Comment, "\n"),
 				Kind:          typeToCompletionKind(val.Type, protocol.CompletionItemKindVariable),
-				SortText:      fmt.Sprintf("%3d_%s", v.StackPos, name),
-			})
+			}, typ: val.Type, depth: v.StackPos})
 		} else {
-			res.Items = append(res.Items, protocol.CompletionItem{
-				Label:    name,
-				Kind:     protocol.CompletionItemKindVariable,
-				SortText: fmt.Sprintf("%3d_%s", 0, name),
-			})
+			candidates = append(candidates, completionCandidate{item: protocol.CompletionItem{
+				Label: name,
+				Kind:  protocol.CompletionItemKindVariable,
+			}, typ: v.Type, depth: v.StackPos})
 		}
 	}
+	res.Items, res.IsIncomplete = ranker.rank(candidates)
 
 	return res, nil
 }
 
+func symbolKindToProto(sym *analysis.Symbol) protocol.SymbolKind {
+	switch sym.Type {
+	case analysis.FunctionType:
+		if sym.Kind == analysis.FieldSymbol {
+			return protocol.SymbolKindMethod
+		}
+		return protocol.SymbolKindFunction
+	case analysis.ObjectType:
+		return protocol.SymbolKindObject
+	case analysis.ArrayType:
+		return protocol.SymbolKindArray
+	case analysis.StringType:
+		return protocol.SymbolKindString
+	case analysis.NumberType:
+		return protocol.SymbolKindNumber
+	case analysis.BooleanType:
+		return protocol.SymbolKindBoolean
+	case analysis.NullType:
+		return protocol.SymbolKindNull
+	}
+	if sym.Kind == analysis.FieldSymbol {
+		return protocol.SymbolKindField
+	}
+	return protocol.SymbolKindVariable
+}
+
+func symbolsToProto(syms []analysis.Symbol) []protocol.DocumentSymbol {
+	res := make([]protocol.DocumentSymbol, len(syms))
+	for i := range syms {
+		res[i] = protocol.DocumentSymbol{
+			Name:           syms[i].Name,
+			Kind:           symbolKindToProto(&syms[i]),
+			Detail:         syms[i].Detail,
+			Range:          rangeToProto(syms[i].Range),
+			SelectionRange: rangeToProto(syms[i].NameRange),
+			Children:       symbolsToProto(syms[i].Children),
+		}
+	}
+	return res
+}
+
 func (s *Server) DocumentSymbol(ctx context.Context, params *protocol.DocumentSymbolParams) ([]interface{}, error) {
 	res := []interface{}{}
 	root := s.getCurrentAST(params.TextDocument.URI)
@@ -438,65 +931,38 @@ func (s *Server) DocumentSymbol(ctx context.Context, params *protocol.DocumentSy
 		return res, nil
 	}
 
-	locals, _ := analysis.UnwindLocals(root)
-	for _, name := range locals.Names() {
-		v := locals.Get(name)
-		res = append(res, protocol.DocumentSymbol{
-			Name:           string(name),
-			Kind:           protocol.SymbolKindVariable,
-			Detail:         v.Type.String(),
-			Range:          rangeToProto(v.Loc),
-			SelectionRange: rangeToProto(v.Loc),
-		})
+	syms := symbolsToProto(analysis.DocumentSymbols(root))
+	for _, sym := range s.positions().symbolsToClient(params.TextDocument.URI, syms) {
+		res = append(res, sym)
 	}
-
 	return res, nil
 }
 
 func (s *Server) SignatureHelp(ctx context.Context, params *protocol.SignatureHelpParams) (*protocol.SignatureHelp, error) {
-	resolver := s.NewResolver(params.TextDocument.URI)
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
 	if resolver == nil {
 		return &protocol.SignatureHelp{Signatures: []protocol.SignatureInformation{}}, nil
 	}
 
-	node, _ := resolver.NodeAt(protoToPos(params.Position))
-	if node == nil {
-		return &protocol.SignatureHelp{Signatures: []protocol.SignatureInformation{}}, nil
-	}
-
-	apply, ok := node.(*ast.Apply)
-	if !ok {
+	pos := protoToPos(s.positions().fromClient(params.TextDocument.URI, params.Position))
+	_, stack := resolver.NodeAt(pos)
+	apply := analysis.EnclosingCall(stack, pos)
+	if apply == nil {
 		return &protocol.SignatureHelp{Signatures: []protocol.SignatureInformation{}}, nil
 	}
 
 	targ := analysis.NodeToValue(apply.Target, resolver)
+	shape := analysis.InferShape(apply.Target, resolver)
+	if targ.Function == nil {
+		// the signature may still be inferred, f.ex when every branch of a conditional
+		// is the same function
+		targ = &analysis.Value{Type: analysis.FunctionType, Function: shape.Function}
+	}
 	if targ.Function == nil {
 		return &protocol.SignatureHelp{Signatures: []protocol.SignatureInformation{}}, nil
 	}
 
-	// for each positional param, the active is at least len(positional)
-	// if there are named, find the first named parameter in order that is
-	// not in the named params list
-	// The AST doesn't parse with partial named params, so we can't fully
-	// properly highlight the active named (without gnarly string parsing)
-
-	activeParam := 0
-	if len(apply.Arguments.Positional) < len(targ.Function.Params) {
-		seenNamed := map[string]bool{}
-		for i := range apply.Arguments.Positional {
-			seenNamed[targ.Function.Params[i].Name] = true
-		}
-		for _, p := range apply.Arguments.Named {
-			seenNamed[string(p.Name)] = true
-		}
-		for i, p := range targ.Function.Params {
-			if seenNamed[p.Name] {
-				continue
-			}
-			activeParam = i
-			break
-		}
-	}
+	activeParam := analysis.ActiveParam(apply, targ.Function, pos)
 
 	fnName := "function"
 	switch name := apply.Target.(type) {
@@ -516,9 +982,15 @@ func (s *Server) SignatureHelp(ctx context.Context, params *protocol.SignatureHe
 		})
 	}
 
+	label := fnName + targ.Function.String()
+	if shape.Function != nil && !shape.Return.IsAny() {
+		// the inferred shape of the result has more details than its type
+		label = fnName + (&analysis.Function{Params: targ.Function.Params}).String() + " -> " + shape.Return.Inline()
+	}
+
 	res := &protocol.SignatureHelp{
 		Signatures: []protocol.SignatureInformation{{
-			Label:           fnName + targ.Function.String(),
+			Label:           label,
 			Documentation:   strings.Join(targ.Comment, "\n"),
 			Parameters:      sigp,
 			ActiveParameter: uint32(activeParam),
@@ -530,26 +1002,31 @@ func (s *Server) SignatureHelp(ctx context.Context, params *protocol.SignatureHe
 }
 
 func (s *Server) Hover(ctx context.Context, params *protocol.HoverParams) (result *protocol.Hover, err error) {
-	resolver := s.NewResolver(params.TextDocument.URI)
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
 	if resolver == nil {
 		return &protocol.Hover{}, nil
 	}
 
-	node, _ := resolver.NodeAt(protoToPos(params.Position))
+	positions := s.positions()
+	pos := protoToPos(positions.fromClient(params.TextDocument.URI, params.Position))
+	node, stack := resolver.NodeAt(pos)
 	if node == nil {
 		return &protocol.Hover{}, nil
 	}
+	if hover, ok := s.importHover(params.TextDocument.URI, node, pos); ok {
+		return hover, nil
+	}
 
 	value := analysis.NodeToValue(node, resolver)
 	var rnge *protocol.Range
 	if value.Range.IsSet() {
-		v := rangeToProto(value.Range)
+		v := positions.rangeToClient(uri.File(value.Range.FileName), rangeToProto(value.Range))
 		rnge = &v
 	}
 
-	doc := value.Type.String()
-	if value.Function != nil {
-		doc += value.Function.String()
+	doc := analysis.InferShape(node, resolver).String()
+	if val, ok := constantValue(stack); ok {
+		doc += "\n= " + val
 	}
 	if len(value.Comment) > 0 {
 		doc += "\n"
@@ -566,25 +1043,54 @@ func (s *Server) Hover(ctx context.Context, params *protocol.HoverParams) (resul
 }
 
 func (s *Server) Definition(ctx context.Context, params *protocol.DefinitionParams) ([]protocol.Location, error) {
-	resolver := s.NewResolver(params.TextDocument.URI)
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
 	if resolver == nil {
 		return []protocol.Location{}, nil
 	}
 
-	node, _ := resolver.NodeAt(protoToPos(params.Position))
+	positions := s.positions()
+	pos := protoToPos(positions.fromClient(params.TextDocument.URI, params.Position))
+	node, stack := resolver.NodeAt(pos)
 	if node == nil {
 		return []protocol.Location{}, nil
 	}
+	// Open the imported file from anywhere in the path of an import
+	if locs, ok := s.importDefinition(params.TextDocument.URI, node, pos); ok {
+		return locs, nil
+	}
+
+	// Jump to where a field is defined when the cursor is on the name of an index
+	for i := len(stack) - 1; i >= 0; i-- {
+		idx, ok := stack[i].(*ast.Index)
+		if !ok {
+			continue
+		}
+		name, rng, ok := analysis.IndexNameRange(idx)
+		if !ok || !analysis.LocInRange(rng, pos) {
+			continue
+		}
+		// functions of the stdlib open its source, the builtins have none
+		if analysis.NodeToValue(idx.Target, resolver) == analysis.StdLibValue {
+			if loc, ok := stdDefinition(name); ok {
+				return positions.locationsToClient([]protocol.Location{loc}), nil
+			}
+			break
+		}
+		if fld := analysis.FieldDefinition(idx, resolver); fld != nil && fld.NameRange.IsSet() {
+			return positions.locationsToClient([]protocol.Location{rangeToLocation(fld.NameRange)}), nil
+		}
+		break
+	}
 
 	value := analysis.NodeToValue(node, resolver)
 	if !value.Range.IsSet() {
 		return []protocol.Location{}, nil
 	}
 
-	return []protocol.Location{{
+	return positions.locationsToClient([]protocol.Location{{
 		URI:   uri.File(value.Range.FileName),
 		Range: rangeToProto(value.Range),
-	}}, nil
+	}}), nil
 
 }
 
@@ -595,21 +1101,18 @@ func (s *Server) Formatting(ctx context.Context, params *protocol.DocumentFormat
 	}
 
 	fname := params.TextDocument.URI.Filename()
-	opts := s.config.FormatterOptions()
-	if opts.Indent <= 0 {
-		opts.Indent = int(params.Options.TabSize)
-	}
-
-	out, err := formatter.Format(fname, current.Contents, opts)
+	out, err := formatter.Format(fname, current.Contents, s.formatterOptions(params.Options))
 	if err != nil {
 		return []protocol.TextEdit{}, nil
 	}
-    lines := uint32(strings.Count(current.Contents, "\n") + 1)
-	return []protocol.TextEdit{{Range: protocol.Range{End: protocol.Position{Line: lines}}, NewText: string(out)}}, nil
+	return diffEdits(current.Contents, out), nil
 }
 
 type EvaluateParams struct {
 	TextDocument *protocol.TextDocumentIdentifier `json:"textDocument"`
+	// Field is a top level field to evaluate instead of the whole file, f.ex one resource of
+	// an environment.
+	Field string `json:"field,omitempty"`
 }
 
 type EvaluateResult struct {
@@ -630,21 +1133,80 @@ func formatRuntimeError(err error) string {
 }
 
 func (s *Server) Evaluate(ctx context.Context, params *EvaluateParams) (*EvaluateResult, error) {
+	out, err := s.evaluate(params)
+	if err != nil {
+		return nil, err
+	}
+	return &EvaluateResult{Output: out}, nil
+}
+
+// EvaluateYaml evaluates the document and renders it as YAML, like it is deployed by
+// Tanka or kubecfg. A top level array is rendered as a stream of YAML documents.
+func (s *Server) EvaluateYaml(ctx context.Context, params *EvaluateParams) (*EvaluateResult, error) {
+	out, err := s.evaluate(params)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid([]byte(out)) {
+		// runtime errors are shown as they are
+		return &EvaluateResult{Output: out}, nil
+	}
+	docs := []json.RawMessage{}
+	stream := json.Unmarshal([]byte(out), &docs) == nil
+	if !stream {
+		docs = []json.RawMessage{json.RawMessage(out)}
+	}
+
+	sb := strings.Builder{}
+	for _, doc := range docs {
+		data, err := yaml.JSONToYAML(doc)
+		if err != nil {
+			return nil, fmt.Errorf("cannot render '%s' as YAML: %v", params.TextDocument.URI.Filename(), err)
+		}
+		if stream {
+			sb.WriteString("---\n")
+		}
+		sb.Write(data)
+	}
+	return &EvaluateResult{Output: sb.String()}, nil
+}
+
+// evaluate returns the output of the document, or its runtime error formatted with the
+// stack trace.
+func (s *Server) evaluate(params *EvaluateParams) (string, error) {
 	cvm := s.getVM(params.TextDocument.URI)
 	curAST := s.getCurrentAST(params.TextDocument.URI)
 	if cvm == nil || curAST == nil {
-		return nil, fmt.Errorf("cannot get jsonnet VM for file '%s'", params.TextDocument.URI.Filename())
+		return "", fmt.Errorf("cannot get jsonnet VM for file '%s'", params.TextDocument.URI.Filename())
 	}
 
-	result := &EvaluateResult{}
-	var err error
+	if params.Field != "" {
+		entry, err := fieldEntrypoint(params.TextDocument.URI, params.Field)
+		if err != nil {
+			return "", err
+		}
+		curAST = entry
+	}
+
+	p := s.startProgress("Evaluating", params.TextDocument.URI.Filename())
+	defer p.end("")
+	var out string
 	cvm.Use(func(vm *jsonnet.VM) {
-		result.Output, err = vm.Evaluate(curAST)
+		var err error
+		out, err = vm.Evaluate(curAST)
 		if err != nil {
-			result.Output = formatRuntimeError(err)
+			out = formatRuntimeError(err)
 		}
 	})
-	return result, nil
+	return out, nil
+}
+
+// fieldEntrypoint returns the code that evaluates a single top level field of a file. The
+// fields of an object are evaluated lazily, so only the field and what it uses are.
+func fieldEntrypoint(u uri.URI, field string) (ast.Node, error) {
+	self, _ := json.Marshal(filepath.Base(u.Filename()))
+	name, _ := json.Marshal(field)
+	return jsonnet.SnippetToAST(u.Filename(), fmt.Sprintf("(import %s)[%s]", self, name))
 }
 
 func (s *Server) ExecuteCommand(ctx context.Context, params *protocol.ExecuteCommandParams) (result interface{}, err error) {
@@ -657,12 +1219,36 @@ func (s *Server) ExecuteCommand(ctx context.Context, params *protocol.ExecuteCom
 	}
 
 	switch params.Command {
-	case "jsonnet.lsp.evaluate":
+	case commandEvaluate:
 		args := &EvaluateParams{}
 		if err := json.Unmarshal([]byte(argData), args); err != nil {
 			return nil, jsonrpc2.ErrInvalidParams
 		}
 		return s.Evaluate(ctx, args)
+	case commandEvaluateYaml:
+		args := &EvaluateParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.EvaluateYaml(ctx, args)
+	case commandProfile:
+		args := &EvaluateParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.Profile(ctx, args)
+	case commandMoveToFile:
+		args := &MoveToFileParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.MoveToFile(ctx, args)
+	case commandCompletionAccepted:
+		args := &CompletionAcceptedParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.CompletionAccepted(args)
 	}
 
 	return nil, jsonrpc2.ErrMethodNotFound
@@ -0,0 +1,129 @@
+package lsp
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ignoreRule is a pattern of a .gitignore file.
+type ignoreRule struct {
+	// the directory of the .gitignore file, relative to the workspace folder
+	dir     string
+	pattern string
+	negate  bool
+	// patterns ending with a slash only match directories
+	dirOnly bool
+}
+
+// parseGitignore returns the patterns of the .gitignore file of `dir`.
+func parseGitignore(dir string, data []byte) []ignoreRule {
+	res := []ignoreRule{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{dir: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate, line = true, line[1:]
+		}
+		// patterns starting with `#` or `!` are escaped with a backslash
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		res = append(res, rule)
+	}
+	return res
+}
+
+// matches returns true if the rule matches the slash separated path relative to the
+// workspace folder. Patterns with a slash are relative to the directory of the .gitignore
+// file, the others match the name of a file or directory below it.
+func (r ignoreRule) matches(p string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.dir != "" {
+		if !strings.HasPrefix(p, r.dir+"/") {
+			return false
+		}
+		p = p[len(r.dir)+1:]
+	}
+	return matchGlob(r.pattern, p)
+}
+
+// pathFilter is the paths of a workspace folder that are not indexed: the ones matching the
+// `index.exclude` globs, and the ones its .gitignore files ignore. The .gitignore files are
+// read the first time a path of their directory is checked, a filter is used for one walk
+// of the folder.
+type pathFilter struct {
+	fsys      fs.FS
+	exclude   []string
+	gitignore bool
+	rules     []ignoreRule
+	// the directories whose .gitignore file was read
+	loaded map[string]bool
+}
+
+func (s *Server) pathFilter(folder *workspaceFolder) *pathFilter {
+	cfg := s.config.Index
+	return &pathFilter{fsys: folder.fs, exclude: cfg.Exclude, gitignore: cfg.Gitignore, loaded: map[string]bool{}}
+}
+
+// excluded returns true if the slash separated path relative to the folder is not indexed.
+// The directories of the path are expected to have been checked, as they are when walking
+// the folder.
+func (f *pathFilter) excluded(p string, isDir bool) bool {
+	for _, glob := range f.exclude {
+		if matchGlob(glob, p) {
+			return true
+		}
+	}
+	if !f.gitignore {
+		return false
+	}
+	f.load(path.Dir(p))
+	// the last matching pattern wins, the ones of nested .gitignore files come later
+	res := false
+	for _, r := range f.rules {
+		if r.matches(p, isDir) {
+			res = !r.negate
+		}
+	}
+	return res
+}
+
+// excludedPath is like excluded, but also checks the directories of the path, f.ex for a
+// file changed outside of the editor.
+func (f *pathFilter) excludedPath(p string, isDir bool) bool {
+	parts := strings.Split(p, "/")
+	for i := 1; i < len(parts); i++ {
+		if f.excluded(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return f.excluded(p, isDir)
+}
+
+// load reads the .gitignore files of `dir` and of the directories above it.
+func (f *pathFilter) load(dir string) {
+	if dir == "." {
+		dir = ""
+	}
+	if f.loaded[dir] {
+		return
+	}
+	if dir != "" {
+		f.load(path.Dir(dir))
+	}
+	f.loaded[dir] = true
+	if data, err := fs.ReadFile(f.fsys, path.Join(dir, ".gitignore")); err == nil {
+		f.rules = append(f.rules, parseGitignore(dir, data)...)
+	}
+}
@@ -0,0 +1,121 @@
+package lsp
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/overlay"
+	"go.lsp.dev/uri"
+)
+
+// ImportError returns the error of an imported file that is found but does not parse. Open
+// files are imported as the last version of them that parsed, so the version in the editor
+// is checked as well.
+func (c *vmCache) ImportError(from, path string) error {
+	root, foundAt := c.ImportAST(from, path)
+	if real, ok := c.importer.real.(*OverlayImporter); ok && root != nil {
+		if cur := real.overlay.Current(foundAt); cur != nil {
+			pr, _ := cur.Data.(*ParseResult)
+			if se := pr.StaticErr(); se != nil {
+				return se
+			}
+		}
+		return nil
+	}
+	if root != nil {
+		return nil
+	}
+	_, at, err := c.importer.Import(from, path)
+	if err != nil {
+		// not found, which the linter reports on its own
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.errs[at]
+}
+
+// ImportError returns the error of an imported file that does not parse, which is
+// reported at the import.
+func (r *valueResolver) ImportError(from, path string) error {
+	vm := r.importVM()
+	if vm == nil {
+		return nil
+	}
+	return vm.ImportError(from, path)
+}
+
+// ImportedSize returns the size of a file imported with importstr or importbin, or false
+// if it is not found.
+func (c *vmCache) ImportedSize(from, path string) (int, bool) {
+	contents, _, err := c.importer.Import(from, path)
+	if err != nil {
+		return 0, false
+	}
+	return len(contents.Data()), true
+}
+
+// ImportedSize returns the size of a file imported with importstr or importbin, which are
+// reported at the import if it is not found or too large.
+func (r *valueResolver) ImportedSize(from, path string) (int, bool) {
+	vm := r.importVM()
+	if vm == nil {
+		// unknown, not reported
+		return 0, true
+	}
+	return vm.ImportedSize(from, path)
+}
+
+// brokenFileSet is the open files whose last version does not parse.
+type brokenFileSet struct {
+	lock  sync.Mutex
+	files map[uri.URI]bool
+}
+
+// toggle records whether a file parses, and returns true if it changed.
+func (b *brokenFileSet) toggle(u uri.URI, broken bool) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.files == nil {
+		b.files = map[uri.URI]bool{}
+	}
+	if b.files[u] == broken {
+		return false
+	}
+	if broken {
+		b.files[u] = true
+	} else {
+		delete(b.files, u)
+	}
+	return true
+}
+
+// checkImporters publishes the diagnostics of the other open files that import a file
+// again, after it was broken or fixed.
+func (s *Server) checkImporters(ctx context.Context, u uri.URI) {
+	path := filepath.Clean(u.Filename())
+	importers := []uri.URI{}
+	s.index.lock.Lock()
+	for _, open := range s.overlay.Open() {
+		if f := s.index.files[open]; open != u && f != nil {
+			for _, imp := range f.imports {
+				if imp == path {
+					importers = append(importers, open)
+					break
+				}
+			}
+		}
+	}
+	s.index.lock.Unlock()
+	if len(importers) == 0 {
+		return
+	}
+
+	// the VMs cache the contents of imported files, they have the version before the change
+	s.flushVMs()
+	for _, imp := range importers {
+		update := overlay.UpdateResult{Current: s.overlay.Current(imp), Parsed: s.overlay.Parsed(imp)}
+		go s.processFileUpdateFn(ctx, imp, false)(update)
+	}
+}
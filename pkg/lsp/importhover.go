@@ -0,0 +1,130 @@
+package lsp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// importPathAt returns the path literal of the import at `pos`, if `pos` is on it.
+func importPathAt(node ast.Node, pos ast.Location) (*ast.LiteralString, ast.LocationRange, bool) {
+	file := analysis.ImportFile(node)
+	if file == nil {
+		return nil, ast.LocationRange{}, false
+	}
+	rng := file.LocRange
+	if !rng.IsSet() {
+		rng = *node.Loc()
+	}
+	return file, rng, analysis.LocInRange(rng, pos)
+}
+
+// importDefinition returns the start of the file the import at `pos` is found at, the way
+// the VM resolves it.
+func (s *Server) importDefinition(u uri.URI, node ast.Node, pos ast.Location) ([]protocol.Location, bool) {
+	file, _, ok := importPathAt(node, pos)
+	if !ok {
+		return nil, false
+	}
+	found, _, err := s.folderOf(u).importer.resolve(u.Filename(), file.Value)
+	if err != nil {
+		return []protocol.Location{}, true
+	}
+	return []protocol.Location{{URI: found.uri}}, true
+}
+
+// importHover describes how the import at `pos` is resolved: the file it is found at, the
+// path that matched and the ones searched before it, whether the file is open in the
+// editor, and the comment at the top of the file.
+func (s *Server) importHover(u uri.URI, node ast.Node, pos ast.Location) (*protocol.Hover, bool) {
+	file, rng, ok := importPathAt(node, pos)
+	if !ok {
+		return nil, false
+	}
+
+	imp := s.folderOf(u).importer
+	lines := []string{}
+	candidates, _ := imp.candidates(u.Filename(), file.Value)
+	found, data, err := imp.resolve(u.Filename(), file.Value)
+	if err != nil {
+		lines = append(lines, fmt.Sprintf("'%s' is not found, searched at:", file.Value))
+		for _, c := range candidates {
+			lines = append(lines, fmt.Sprintf("  %s (%s)", imp.relPath(c.uri), c.source))
+		}
+	} else {
+		lines = append(lines, found.uri.Filename(), "found in "+found.source)
+		for _, c := range candidates {
+			if c.uri == found.uri {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("  not at %s (%s)", imp.relPath(c.uri), c.source))
+		}
+		lines = append(lines, s.overlayState(found.uri)...)
+		switch node.(type) {
+		case *ast.Import:
+			if contents, ok := s.fileContents(found.uri); ok {
+				if comment := analysis.FileComment(contents); len(comment) > 0 {
+					lines = append(append(lines, ""), comment...)
+				}
+			}
+		case *ast.ImportStr:
+			lines = append(lines, fmt.Sprintf("%d bytes", len(data)))
+			if preview, ok := textPreview(data); ok {
+				lines = append(append(lines, ""), preview...)
+			}
+		case *ast.ImportBin:
+			lines = append(lines, fmt.Sprintf("%d bytes, imported as an array of %d numbers", len(data), len(data)))
+		}
+	}
+
+	r := s.positions().rangeToClient(u, rangeToProto(rng))
+	return &protocol.Hover{
+		Range: &r,
+		Contents: protocol.MarkupContent{
+			Kind:  protocol.PlainText,
+			Value: strings.Join(lines, "\n"),
+		},
+	}, true
+}
+
+// Text files imported with importstr are previewed in the hover when they are small.
+const (
+	maxPreviewBytes = 4096
+	maxPreviewLines = 20
+)
+
+// textPreview returns the first lines of a small text file.
+func textPreview(data []byte) ([]string, bool) {
+	if len(data) > maxPreviewBytes || !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return nil, false
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > maxPreviewLines {
+		lines = append(lines[:maxPreviewLines], "...")
+	}
+	return lines, true
+}
+
+// overlayState describes the version of an imported file that is open in the editor, as
+// imports read it instead of the file on disk.
+func (s *Server) overlayState(u uri.URI) []string {
+	parsed := s.overlay.Parsed(u)
+	if parsed == nil {
+		return nil
+	}
+	res := []string{"open in the editor"}
+	if disk, err := os.ReadFile(u.Filename()); err != nil || string(disk) != parsed.Contents {
+		res[0] = "open in the editor, with unsaved changes"
+	}
+	if cur := s.overlay.Current(u); cur != nil && cur.Contents != parsed.Contents {
+		res = append(res, "the last version that parses is imported, the current one has errors")
+	}
+	return res
+}
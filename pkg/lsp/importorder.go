@@ -0,0 +1,39 @@
+package lsp
+
+// The places imports are searched at, named in the `imports.order` setting.
+const (
+	// the root of the workspace folder
+	importSourceRoot = "root"
+	// the directory of the importing file
+	importSourceImporter = "importer"
+	// the jpath of tk in Tanka projects: the environment of the importing file, `lib`, the
+	// vendor directory of the environment, and `vendor`
+	importSourceTanka = "tanka"
+	// the directories detected in the folder: `bazel-bin`, and `lib` and `vendor`
+	importSourceSearchPaths = "searchPaths"
+	// the legacy package names of jsonnet-bundler
+	importSourceBundler = "bundler"
+	// the `jpaths` of the configuration
+	importSourceJPaths = "jpaths"
+)
+
+// defaultImportOrder searches the workspace root before the directory of the importing
+// file, unlike the jsonnet command, as imports in large repositories are often written
+// from the root.
+var defaultImportOrder = []string{
+	importSourceRoot,
+	importSourceImporter,
+	importSourceTanka,
+	importSourceSearchPaths,
+	importSourceBundler,
+	importSourceJPaths,
+}
+
+func isImportSource(name string) bool {
+	for _, source := range defaultImportOrder {
+		if source == name {
+			return true
+		}
+	}
+	return false
+}
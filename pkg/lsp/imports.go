@@ -0,0 +1,215 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// maxImportSuggestions limits the number of import quick fixes for a single variable.
+const maxImportSuggestions = 5
+
+const unknownVariablePrefix = "Unknown variable: "
+
+// importPath returns the shortest path that imports `target` from the file `from`.
+// Paths relative to the file, the workspace root, and each search path are tried, as
+// well as any paths in `hints`.
+func (s *Server) importPath(from, target string, hints []string) (string, bool) {
+	folder := s.folderOf(uri.File(from))
+	root := folder.uri.Filename()
+	candidates := append([]string{}, hints...)
+	if rel, err := filepath.Rel(filepath.Dir(from), target); err == nil {
+		candidates = append(candidates, rel)
+	}
+	for _, search := range append(append([]string{""}, folder.searchPaths...), s.config.JPaths...) {
+		if !filepath.IsAbs(search) {
+			search = filepath.Join(root, search)
+		}
+		if rel, err := filepath.Rel(search, target); err == nil && !strings.HasPrefix(rel, "..") {
+			candidates = append(candidates, rel)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return len(candidates[i]) < len(candidates[j]) })
+
+	for _, path := range candidates {
+		path = filepath.ToSlash(path)
+		if _, foundAt, err := folder.importer.Import(from, path); err == nil && filepath.Clean(foundAt) == filepath.Clean(target) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// importTarget is a file that could be imported for a name, and the paths other files
+// import it with.
+type importTarget struct {
+	file  string
+	paths []string
+}
+
+// importTargets returns the files that could be imported as `name`. Files that other
+// files in the workspace already import as `name` come first, followed by files named
+// after it (`name.libsonnet` or `name/main.libsonnet`).
+func (s *Server) importTargets(ctx context.Context, name string) []*importTarget {
+	res := []*importTarget{}
+	byFile := map[string]*importTarget{}
+	add := func(file, path string) {
+		file = filepath.Clean(file)
+		if byFile[file] == nil {
+			byFile[file] = &importTarget{file: file}
+			res = append(res, byFile[file])
+		}
+		if path != "" {
+			byFile[file].paths = append(byFile[file].paths, path)
+		}
+	}
+
+	for _, f := range s.indexedFiles(ctx, name) {
+		for _, path := range f.imported[name] {
+			if _, foundAt, err := s.folderOf(f.uri).importer.Import(f.uri.Filename(), path); err == nil {
+				add(foundAt, path)
+			}
+		}
+	}
+	// the most commonly used import is the most likely
+	sort.SliceStable(res, func(i, j int) bool { return len(res[i].paths) > len(res[j].paths) })
+
+	for _, f := range s.workspaceFiles(ctx) {
+		stem := strings.TrimSuffix(filepath.Base(f.path), filepath.Ext(f.path))
+		if stem == name || (stem == "main" && filepath.Base(filepath.Dir(f.path)) == name) {
+			add(f.uri().Filename(), "")
+		}
+	}
+	return res
+}
+
+func (s *Server) importActions(ctx context.Context, u uri.URI, contents string, diag protocol.Diagnostic) []protocol.CodeAction {
+	res := []protocol.CodeAction{}
+	name := strings.TrimPrefix(diag.Message, unknownVariablePrefix)
+	if diag.Message == name || analysis.SafeIdent(name) != name {
+		return res
+	}
+
+	line, quote := analysis.ImportInsertLine(contents)
+	pos := protocol.Position{Line: uint32(line - 1)}
+	from := u.Filename()
+	for _, target := range s.importTargets(ctx, name) {
+		if len(res) >= maxImportSuggestions {
+			break
+		}
+		if filepath.Clean(target.file) == filepath.Clean(from) {
+			continue
+		}
+		path, ok := s.importPath(from, target.file, target.paths)
+		if !ok {
+			continue
+		}
+		text := fmt.Sprintf("local %s = import %c%s%c;\n", name, quote, path, quote)
+		res = append(res, protocol.CodeAction{
+			Title:       fmt.Sprintf("Import '%s' as %s", path, name),
+			Kind:        protocol.QuickFix,
+			Diagnostics: []protocol.Diagnostic{diag},
+			IsPreferred: len(res) == 0,
+			Edit: &protocol.WorkspaceEdit{Changes: map[uri.URI][]protocol.TextEdit{
+				u: {{Range: protocol.Range{Start: pos, End: pos}, NewText: text}},
+			}},
+		})
+	}
+	return res
+}
+
+// maxPathSuggestions limits the number of files suggested for an import that is not found.
+const maxPathSuggestions = 3
+
+// editDistance returns the Levenshtein distance between two strings, in bytes.
+func editDistance(a, b string) int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// missingImportActions suggests the workspace files with names closest to the one of an
+// import that is not found, replacing the path of the import.
+func (s *Server) missingImportActions(ctx context.Context, u uri.URI, contents string, diag protocol.Diagnostic) []protocol.CodeAction {
+	res := []protocol.CodeAction{}
+	if fmt.Sprint(diag.Code) != string(linter.ImportNotFound) {
+		return res
+	}
+	text := textInRange(contents, ast.LocationRange{Begin: protoToPos(diag.Range.Start), End: protoToPos(diag.Range.End)})
+	if len(text) < 2 || (text[0] != '\'' && text[0] != '"') || text[len(text)-1] != text[0] {
+		return res
+	}
+	missing := text[1 : len(text)-1]
+	name := strings.ToLower(filepath.Base(missing))
+
+	type suggestion struct {
+		file string
+		// distances of the name of the file and its path to the ones of the import
+		name, path int
+	}
+	suggestions := []suggestion{}
+	from := u.Filename()
+	for _, f := range s.workspaceFiles(ctx) {
+		file := f.uri().Filename()
+		if filepath.Clean(file) == filepath.Clean(from) {
+			continue
+		}
+		// a third of the name can be wrong, f.ex `utils.libsonnet` for `util.libsonnet`
+		if d := editDistance(name, strings.ToLower(filepath.Base(file))); d <= len(name)/3 {
+			suggestions = append(suggestions, suggestion{file: file, name: d, path: editDistance(missing, f.path)})
+		}
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].name != suggestions[j].name {
+			return suggestions[i].name < suggestions[j].name
+		}
+		return suggestions[i].path < suggestions[j].path
+	})
+
+	for _, sug := range suggestions {
+		if len(res) >= maxPathSuggestions {
+			break
+		}
+		path, ok := s.importPath(from, sug.file, nil)
+		if !ok || path == missing {
+			continue
+		}
+		res = append(res, protocol.CodeAction{
+			Title:       fmt.Sprintf("Change to '%s'", path),
+			Kind:        protocol.QuickFix,
+			Diagnostics: []protocol.Diagnostic{diag},
+			IsPreferred: len(res) == 0,
+			Edit: &protocol.WorkspaceEdit{Changes: map[uri.URI][]protocol.TextEdit{
+				u: {{Range: diag.Range, NewText: string(text[0]) + path + string(text[0])}},
+			}},
+		})
+	}
+	return res
+}
@@ -0,0 +1,207 @@
+package lsp
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/uri"
+)
+
+// indexCacheVersion is changed whenever the format of the index cache changes, older
+// caches are ignored.
+const indexCacheVersion = 4
+
+// indexCache is the summaries of the files of a workspace folder, saved so reopening a
+// large workspace does not need to parse every file again.
+type indexCache struct {
+	Version int
+	// Files by path relative to the folder
+	Files map[string]cachedFile
+}
+
+type cachedFile struct {
+	ModTime  time.Time
+	Hash     string
+	Symbols  []cachedSymbol
+	Imported map[string][]string
+	Imports  []string
+	Names    []string
+	ExtVars  []string
+	Exports  []string
+}
+
+// cachedSymbol is an analysis.Symbol without the AST node.
+type cachedSymbol struct {
+	Name      string
+	Kind      analysis.SymbolKind
+	Type      analysis.ValueType
+	Detail    string
+	Range     [2]ast.Location
+	NameRange [2]ast.Location
+	Hidden    bool
+	Children  []cachedSymbol
+}
+
+func toCachedSymbols(syms []analysis.Symbol) []cachedSymbol {
+	res := make([]cachedSymbol, len(syms))
+	for i, sym := range syms {
+		res[i] = cachedSymbol{
+			Name:      sym.Name,
+			Kind:      sym.Kind,
+			Type:      sym.Type,
+			Detail:    sym.Detail,
+			Range:     [2]ast.Location{sym.Range.Begin, sym.Range.End},
+			NameRange: [2]ast.Location{sym.NameRange.Begin, sym.NameRange.End},
+			Hidden:    sym.Hidden,
+			Children:  toCachedSymbols(sym.Children),
+		}
+	}
+	return res
+}
+
+func fromCachedSymbols(filename string, syms []cachedSymbol) []analysis.Symbol {
+	res := make([]analysis.Symbol, len(syms))
+	for i, sym := range syms {
+		res[i] = analysis.Symbol{
+			Name:      sym.Name,
+			Kind:      sym.Kind,
+			Type:      sym.Type,
+			Detail:    sym.Detail,
+			Range:     ast.LocationRange{FileName: filename, Begin: sym.Range[0], End: sym.Range[1]},
+			NameRange: ast.LocationRange{FileName: filename, Begin: sym.NameRange[0], End: sym.NameRange[1]},
+			Hidden:    sym.Hidden,
+			Children:  fromCachedSymbols(filename, sym.Children),
+		}
+	}
+	return res
+}
+
+// indexCachePath returns where the index cache of a folder is saved, in the user cache
+// directory (f.ex `~/.cache/jsonnet-lsp`).
+func indexCachePath(folder *workspaceFolder) (string, bool) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(folder.uri))
+	return filepath.Join(dir, "jsonnet-lsp", hex.EncodeToString(sum[:8])+".gob"), true
+}
+
+// loadIndexCache adds the files in the index cache of the folder to the index. Files that
+// changed since are parsed again when they are indexed.
+func (s *Server) loadIndexCache(folder *workspaceFolder) {
+	path, ok := indexCachePath(folder)
+	if !ok {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	cache := indexCache{}
+	if err := gob.NewDecoder(f).Decode(&cache); err != nil || cache.Version != indexCacheVersion {
+		logf("ignoring index cache %s: version=%d err=%v", path, cache.Version, err)
+		return
+	}
+
+	s.index.lock.Lock()
+	defer s.index.lock.Unlock()
+	for rel, cf := range cache.Files {
+		u := uri.File(filepath.Join(folder.uri.Filename(), rel))
+		if s.index.files[u] != nil {
+			continue
+		}
+		ent := &indexedFile{
+			uri:      u,
+			symbols:  fromCachedSymbols(u.Filename(), cf.Symbols),
+			imported: cf.Imported,
+			imports:  cf.Imports,
+			names:    map[string]bool{},
+			extVars:  cf.ExtVars,
+			exports:  cf.Exports,
+			modTime:  cf.ModTime,
+			hash:     cf.Hash,
+		}
+		for _, name := range cf.Names {
+			ent.names[name] = true
+		}
+		s.index.files[u] = ent
+	}
+	logf("loaded %d files from index cache %s", len(cache.Files), path)
+}
+
+// saveIndexCache saves the index of every workspace folder, if files were indexed since
+// it was last saved. Files open in the editor are left out, they may have unsaved changes.
+func (s *Server) saveIndexCache() {
+	s.index.lock.Lock()
+	if !s.index.dirty {
+		s.index.lock.Unlock()
+		return
+	}
+	s.index.dirty = false
+	files := make([]*indexedFile, 0, len(s.index.files))
+	for _, f := range s.index.files {
+		// files open in the editor may not be saved
+		if !f.modTime.IsZero() {
+			files = append(files, f)
+		}
+	}
+	s.index.lock.Unlock()
+
+	for _, folder := range s.folders() {
+		cache := indexCache{Version: indexCacheVersion, Files: map[string]cachedFile{}}
+		for _, f := range files {
+			rel, err := filepath.Rel(folder.uri.Filename(), f.uri.Filename())
+			if err != nil || !inDirectory(folder.uri, f.uri) {
+				continue
+			}
+			cf := cachedFile{
+				ModTime:  f.modTime,
+				Hash:     f.hash,
+				Symbols:  toCachedSymbols(f.symbols),
+				Imported: f.imported,
+				Imports:  f.imports,
+				ExtVars:  f.extVars,
+				Exports:  f.exports,
+			}
+			for name := range f.names {
+				cf.Names = append(cf.Names, name)
+			}
+			cache.Files[filepath.ToSlash(rel)] = cf
+		}
+		if err := writeIndexCache(folder, cache); err != nil {
+			logf("failed to save index cache of %s: %v", folder.uri, err)
+		}
+	}
+}
+
+func writeIndexCache(folder *workspaceFolder, cache indexCache) error {
+	path, ok := indexCachePath(folder)
+	if !ok {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// write to a temporary file first, so a cache is never partially written
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(cache); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,80 @@
+package lsp
+
+import (
+	"context"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+const methodInlayHint = "textDocument/inlayHint"
+
+type InlayHintParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Range        protocol.Range                  `json:"range"`
+}
+
+type InlayHintKind int
+
+const (
+	InlayHintKindType      InlayHintKind = 1
+	InlayHintKindParameter InlayHintKind = 2
+)
+
+type InlayHint struct {
+	Position     protocol.Position `json:"position"`
+	Label        string            `json:"label"`
+	Kind         InlayHintKind     `json:"kind,omitempty"`
+	PaddingLeft  bool              `json:"paddingLeft,omitempty"`
+	PaddingRight bool              `json:"paddingRight,omitempty"`
+}
+
+func locBefore(a, b ast.Location) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// InlayHint shows the parameter names for positional arguments of function calls.
+func (s *Server) InlayHint(ctx context.Context, params *InlayHintParams) ([]InlayHint, error) {
+	res := []InlayHint{}
+	if s.isLargeOpenFile(ctx, params.TextDocument.URI) {
+		return res, nil
+	}
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
+	if resolver == nil {
+		return res, nil
+	}
+	positions := s.positions()
+	rng := positions.rangeFromClient(params.TextDocument.URI, params.Range)
+	begin, end := protoToPos(rng.Start), protoToPos(rng.End)
+
+	analysis.WalkStack(resolver.rootAST, func(n ast.Node, stack []ast.Node) bool {
+		// skip subtrees outside of the requested range
+		if loc := n.Loc(); loc != nil && loc.IsSet() && (locBefore(loc.End, begin) || locBefore(end, loc.Begin)) {
+			return false
+		}
+		apply, ok := n.(*ast.Apply)
+		// calls added by desugaring (f.ex `std.slice` for `x[a:b]`) have no location
+		if !ok || apply.Target.Loc() == nil || !apply.Target.Loc().IsSet() {
+			return true
+		}
+		for i, param := range analysis.PositionalParams(apply, resolver) {
+			arg := apply.Arguments.Positional[i].Expr
+			if arg.Loc() == nil || !arg.Loc().IsSet() {
+				continue
+			}
+			// `fn(name)` is already clear about which parameter is being passed
+			if v, ok := arg.(*ast.Var); ok && string(v.Id) == param.Name {
+				continue
+			}
+			res = append(res, InlayHint{
+				Position:     positions.toClient(params.TextDocument.URI, posToProto(arg.Loc().Begin)),
+				Label:        param.Name + ":",
+				Kind:         InlayHintKindParameter,
+				PaddingRight: true,
+			})
+		}
+		return true
+	})
+	return res, nil
+}
@@ -0,0 +1,124 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+const methodInlineValue = "textDocument/inlineValue"
+
+type InlineValueContext struct {
+	FrameID int `json:"frameId"`
+	// the location where the execution has stopped
+	StoppedLocation protocol.Range `json:"stoppedLocation"`
+}
+
+type InlineValueParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Range        protocol.Range                  `json:"range"`
+	Context      InlineValueContext              `json:"context"`
+}
+
+// InlineValue is an InlineValueText when it has a text, and an InlineValueVariableLookup
+// otherwise, which the client looks up in the variables of the debug session.
+type InlineValue struct {
+	Range               protocol.Range `json:"range"`
+	Text                string         `json:"text,omitempty"`
+	VariableName        string         `json:"variableName,omitempty"`
+	CaseSensitiveLookup bool           `json:"caseSensitiveLookup,omitempty"`
+}
+
+const (
+	// maxInlineValues limits the number of locals folded for a request.
+	maxInlineValues = 100
+	// maxInlineValueLen limits the length of the values shown.
+	maxInlineValueLen = 80
+)
+
+// InlineValue shows the values of the locals in a range during a debug session. Locals
+// that are constant are folded, like for hover. The top level locals that
+// are not constant (f.ex imports) are looked up in the variables of the debug session,
+// once the execution has stopped after them.
+func (s *Server) InlineValue(ctx context.Context, params *InlineValueParams) ([]InlineValue, error) {
+	res := []InlineValue{}
+	if s.isLargeOpenFile(ctx, params.TextDocument.URI) {
+		return res, nil
+	}
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
+	if resolver == nil {
+		return res, nil
+	}
+	positions := s.positions()
+	u := params.TextDocument.URI
+	rng := positions.rangeFromClient(u, params.Range)
+	begin, end := protoToPos(rng.Start), protoToPos(rng.End)
+	stopped := protoToPos(positions.rangeFromClient(u, params.Context.StoppedLocation).Start)
+
+	folded := 0
+	analysis.WalkStack(resolver.rootAST, func(n ast.Node, stack []ast.Node) bool {
+		if loc := n.Loc(); loc != nil && loc.IsSet() && (locBefore(loc.End, begin) || locBefore(end, loc.Begin)) {
+			return false
+		}
+		var binds ast.LocalBinds
+		switch n := n.(type) {
+		case *ast.Local:
+			binds = n.Binds
+		case *ast.DesugaredObject:
+			binds = n.Locals
+		}
+		for _, bind := range binds {
+			if ctx.Err() != nil || folded >= maxInlineValues {
+				return false
+			}
+			loc := bind.LocRange
+			if !loc.IsSet() || locBefore(loc.Begin, begin) || locBefore(end, loc.Begin) {
+				continue
+			}
+			switch bind.Body.(type) {
+			case *ast.Function, *ast.LiteralNull, *ast.LiteralBoolean, *ast.LiteralNumber, *ast.LiteralString:
+				// functions have no value, and literals are their own value
+				continue
+			}
+			folded++
+			// the value is shown for the name of the local
+			loc.End = loc.Begin
+			loc.End.Column += len(bind.Variable)
+			valueRange := positions.rangeToClient(u, rangeToProto(loc))
+			bodyStack := append(stack[:len(stack):len(stack)], bind.Body)
+			if val, ok := constantValue(bodyStack); ok {
+				res = append(res, InlineValue{Range: valueRange, Text: string(bind.Variable) + " = " + inlineText(val)})
+			} else if topLevel(stack) && locBefore(loc.End, stopped) {
+				res = append(res, InlineValue{Range: valueRange, VariableName: string(bind.Variable), CaseSensitiveLookup: true})
+			}
+		}
+		return true
+	})
+	return res, nil
+}
+
+// topLevel returns true if the stack only has the top level locals of a file.
+func topLevel(stack []ast.Node) bool {
+	for _, n := range stack {
+		if _, ok := n.(*ast.Local); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// inlineText formats a value on a single line, shortened to maxInlineValueLen.
+func inlineText(val string) string {
+	buf := bytes.Buffer{}
+	if err := json.Compact(&buf, []byte(val)); err == nil {
+		val = buf.String()
+	}
+	if runes := []rune(val); len(runes) > maxInlineValueLen {
+		val = string(runes[:maxInlineValueLen-1]) + "…"
+	}
+	return val
+}
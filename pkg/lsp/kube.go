@@ -0,0 +1,123 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/carlverge/jsonnet-lsp/pkg/kube"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// kubeCatalogCache keeps the schemas of Kubernetes resources loaded from the paths of the
+// `kubernetesSchemas` setting, until the paths or the configuration change.
+type kubeCatalogCache struct {
+	lock    sync.Mutex
+	paths   string
+	catalog *kube.Catalog
+}
+
+func (c *kubeCatalogCache) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.paths, c.catalog = "", nil
+}
+
+// kubeCatalog returns the schemas of Kubernetes resources for a file, or nil if none are
+// configured. The paths are relative to the workspace folder of the file.
+func (s *Server) kubeCatalog(u uri.URI) *kube.Catalog {
+	cfg := s.config
+	if cfg == nil || len(cfg.KubernetesSchemas) == 0 {
+		return nil
+	}
+	root := s.folderOf(u).uri.Filename()
+	paths := make([]string, len(cfg.KubernetesSchemas))
+	for i, p := range cfg.KubernetesSchemas {
+		paths[i] = absPath(root, p)
+	}
+	key := strings.Join(paths, "\x00")
+
+	c := &s.kube
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.catalog != nil && c.paths == key {
+		return c.catalog
+	}
+	catalog, err := kube.Load(paths...)
+	if err != nil {
+		logf("failed to load kubernetes schemas: %v", err)
+	}
+	logf("loaded the schemas of %d kubernetes resources", catalog.Len())
+	c.paths, c.catalog = key, catalog
+	return catalog
+}
+
+// kubeCompletions completes the fields of the objects of a Kubernetes resource, and the
+// values of string fields with an enum. Returns false outside of the resources of the
+// catalog.
+func (s *Server) kubeCompletions(u uri.URI, stack []ast.Node) ([]protocol.CompletionItem, bool) {
+	if len(stack) == 0 {
+		return nil, false
+	}
+	catalog := s.kubeCatalog(u)
+	if catalog.Len() == 0 {
+		return nil, false
+	}
+	res, path, ok := kube.ResourceAt(stack)
+	if !ok {
+		return nil, false
+	}
+	schema := catalog.Resolve(res.APIVersion, res.Kind, path)
+	if schema == nil {
+		return nil, false
+	}
+
+	items := []protocol.CompletionItem{}
+	switch node := stack[len(stack)-1].(type) {
+	case *ast.LiteralString:
+		for _, v := range schema.Enum() {
+			val, ok := v.(string)
+			if !ok {
+				continue
+			}
+			items = append(items, protocol.CompletionItem{
+				Label:         val,
+				Kind:          protocol.CompletionItemKindEnumMember,
+				Detail:        schema.Type(),
+				Documentation: schema.Description(),
+			})
+		}
+	case *ast.DesugaredObject:
+		seen := map[string]bool{}
+		for _, fld := range node.Fields {
+			if name, ok := fld.Name.(*ast.LiteralString); ok {
+				seen[name.Value] = true
+			}
+		}
+		for _, fld := range schema.Fields() {
+			if seen[fld.Name] {
+				continue
+			}
+			// the required fields are listed first
+			sortText := "1_" + fld.Name
+			detail := fld.Schema.Type()
+			if fld.Required {
+				sortText = "0_" + fld.Name
+				detail = strings.TrimSpace(fmt.Sprintf("%s (required)", detail))
+			}
+			items = append(items, s.snippetItem(protocol.CompletionItem{
+				Label:            fld.Name,
+				InsertText:       analysis.SafeIdent(fld.Name) + ": $1,$0",
+				InsertTextFormat: protocol.InsertTextFormatSnippet,
+				Detail:           detail,
+				Documentation:    fld.Schema.Description(),
+				Kind:             protocol.CompletionItemKindField,
+				SortText:         sortText,
+			}))
+		}
+	}
+	return items, len(items) > 0
+}
@@ -0,0 +1,70 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Default limits of the files that are checked live. Generated files, f.ex vendored
+// CRD libraries, can be several megabytes.
+const (
+	defaultLargeFileBytes = 1 << 20
+	defaultLargeFileLines = 20000
+)
+
+// largeFileSet is the large files the user was told about, they are only told once.
+type largeFileSet struct {
+	lock     sync.Mutex
+	notified map[uri.URI]bool
+}
+
+// exceeded returns the limit a file is over, and false if it is under every limit.
+func (c LargeFileConfiguration) exceeded(contents string) (string, bool) {
+	if c.MaxBytes > 0 && len(contents) > c.MaxBytes {
+		return fmt.Sprintf("%.1fMB", float64(len(contents))/(1<<20)), true
+	}
+	if c.MaxLines > 0 {
+		if lines := strings.Count(contents, "\n") + 1; lines > c.MaxLines {
+			return fmt.Sprintf("%d lines", lines), true
+		}
+	}
+	return "", false
+}
+
+// isLargeFile returns true if a file is over the configured limits. Large files are only
+// parsed: the linter, evaluation, inlay hints, inline values, and code lenses are disabled
+// for them. The user is told the first time a large file is checked.
+func (s *Server) isLargeFile(ctx context.Context, u uri.URI, contents string) bool {
+	size, large := s.config.LargeFile.exceeded(contents)
+	if !large {
+		return false
+	}
+	s.largeFiles.lock.Lock()
+	notified := s.largeFiles.notified[u]
+	if s.largeFiles.notified == nil {
+		s.largeFiles.notified = map[uri.URI]bool{}
+	}
+	s.largeFiles.notified[u] = true
+	s.largeFiles.lock.Unlock()
+	if !notified {
+		logf("large file uri=%s size=%s, analysis is limited", u, size)
+		_ = s.notifier.ShowMessage(ctx, &protocol.ShowMessageParams{
+			Type: protocol.MessageTypeInfo,
+			Message: fmt.Sprintf("%s is too large to check live (%s), linting, evaluation, inlay hints, and code lenses are disabled for it. The limits are set with %s.largeFile.",
+				filepath.Base(u.Filename()), size, configSection),
+		})
+	}
+	return true
+}
+
+// isLargeOpenFile is isLargeFile for the contents of a file in the editor.
+func (s *Server) isLargeOpenFile(ctx context.Context, u uri.URI) bool {
+	cur := s.overlay.Current(u)
+	return cur != nil && s.isLargeFile(ctx, u, cur.Contents)
+}
@@ -0,0 +1,145 @@
+package lsp
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+// identifierPattern is the word pattern of linked field names, the link ends when a name
+// stops being an identifier.
+const identifierPattern = `[A-Za-z_][A-Za-z0-9_]*`
+
+// fieldOverride is a definition of a field, and the definition of the field it overrides
+// if it is mixed into an object that has it.
+type fieldOverride struct {
+	def, overrides ast.LocationRange
+}
+
+// superObject returns the value of `super` for the object at the end of the stack: the
+// left side of `base + {...}`, or the overridden value for the object of a `field+:`.
+func superObject(stack []ast.Node, resolver analysis.Resolver) *analysis.Value {
+	if val := analysis.SuperValue(stack, resolver); val != nil {
+		return val
+	}
+	if len(stack) < 2 {
+		return nil
+	}
+	parent, ok := stack[len(stack)-2].(*ast.DesugaredObject)
+	if !ok {
+		return nil
+	}
+	for _, fld := range parent.Fields {
+		name, ok := fld.Name.(*ast.LiteralString)
+		if !ok || fld.Body != stack[len(stack)-1] || !fld.PlusSuper {
+			continue
+		}
+		sup := superObject(stack[:len(stack)-1], resolver)
+		if sup == nil || sup.Object == nil || sup.Object.FieldMap[name.Value] == nil {
+			return nil
+		}
+		if val := analysis.NodeToValue(sup.Object.FieldMap[name.Value].Node, resolver); val.Object != nil {
+			return val
+		}
+	}
+	return nil
+}
+
+// LinkedEditingRange links the name of a field with the definitions in the same file that
+// override it or that it overrides, f.ex `foo` in `base + { foo+: ... }` when `base` is
+// defined in the file, and with the accesses of these definitions in the file.
+func (s *Server) LinkedEditingRange(ctx context.Context, params *protocol.LinkedEditingRangeParams) (*protocol.LinkedEditingRanges, error) {
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
+	if resolver == nil {
+		return nil, nil
+	}
+	positions := s.positions()
+	pos := protoToPos(positions.fromClient(params.TextDocument.URI, params.Position))
+
+	// only the names of field definitions are linked, not the names of accesses
+	name, current, ok := "", ast.LocationRange{}, false
+	_, stack := resolver.NodeAt(pos)
+	for i := len(stack) - 1; i >= 0 && !ok; i-- {
+		obj, isObj := stack[i].(*ast.DesugaredObject)
+		if !isObj {
+			continue
+		}
+		for j := range obj.Fields {
+			if n, rng, found := analysis.FieldNameRange(&obj.Fields[j]); found && analysis.LocInRange(rng, pos) {
+				name, current, ok = n, rng, true
+			}
+		}
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	overrides := []fieldOverride{}
+	analysis.WalkStack(resolver.rootAST, func(n ast.Node, stk []ast.Node) bool {
+		obj, ok := n.(*ast.DesugaredObject)
+		if !ok {
+			return ctx.Err() == nil
+		}
+		for i := range obj.Fields {
+			if n, rng, ok := analysis.FieldNameRange(&obj.Fields[i]); ok && n == name {
+				o := fieldOverride{def: rng}
+				if sup := superObject(stk, resolver); sup != nil && sup.Object.FieldMap[name] != nil {
+					o.overrides = sup.Object.FieldMap[name].NameRange
+				}
+				overrides = append(overrides, o)
+			}
+		}
+		return true
+	})
+
+	// the definitions linked with the one at the cursor, through chains of overrides
+	linked := []ast.LocationRange{current}
+	isLinked := func(rng ast.LocationRange) bool {
+		for _, l := range linked {
+			if analysis.SameRange(l, rng) {
+				return true
+			}
+		}
+		return false
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, o := range overrides {
+			if !o.overrides.IsSet() || isLinked(o.def) == isLinked(o.overrides) {
+				continue
+			}
+			if isLinked(o.def) {
+				linked = append(linked, o.overrides)
+			} else {
+				linked = append(linked, o.def)
+			}
+			changed = true
+		}
+	}
+
+	accesses := analysis.BuildRefIndex(resolver.rootAST).Fields[name]
+	for _, def := range append([]ast.LocationRange{}, linked...) {
+		for _, rng := range fieldAccesses(resolver, accesses, def) {
+			if !isLinked(rng) {
+				linked = append(linked, rng)
+			}
+		}
+	}
+
+	res := &protocol.LinkedEditingRanges{Ranges: []protocol.Range{}, WordPattern: identifierPattern}
+	fname := resolver.rootAST.Loc().FileName
+	for _, rng := range linked {
+		// linked ranges have the same text, quoted field names and other files are left out
+		if rng.FileName != fname || rng.Begin.Line != rng.End.Line || rng.End.Column-rng.Begin.Column != utf8.RuneCountInString(name) {
+			continue
+		}
+		res.Ranges = append(res.Ranges, positions.rangeToClient(params.TextDocument.URI, rangeToProto(rng)))
+	}
+	if len(res.Ranges) < 2 {
+		return nil, nil
+	}
+	return res, nil
+}
@@ -0,0 +1,44 @@
+package lsp
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// DocumentLink links every import path to the file it resolves to. Imports are resolved
+// with the same importer as the VM, so the links point to the file that would be loaded.
+func (s *Server) DocumentLink(ctx context.Context, params *protocol.DocumentLinkParams) ([]protocol.DocumentLink, error) {
+	res := []protocol.DocumentLink{}
+	root := s.getCurrentAST(params.TextDocument.URI)
+	if root == nil {
+		return res, nil
+	}
+	folder := s.folderOf(params.TextDocument.URI)
+	positions := s.positions()
+
+	from := params.TextDocument.URI.Filename()
+	for _, imp := range analysis.BuildRefIndex(root).Imports {
+		file := analysis.ImportFile(imp)
+		if file == nil || !file.LocRange.IsSet() {
+			continue
+		}
+		_, foundAt, err := folder.importer.Import(from, file.Value)
+		if err != nil {
+			continue
+		}
+		tooltip := foundAt
+		if rel, err := filepath.Rel(folder.uri.Filename(), foundAt); err == nil {
+			tooltip = rel
+		}
+		res = append(res, protocol.DocumentLink{
+			Range:   positions.rangeToClient(params.TextDocument.URI, rangeToProto(file.LocRange)),
+			Target:  protocol.DocumentURI(uri.File(foundAt)),
+			Tooltip: tooltip,
+		})
+	}
+	return res, nil
+}
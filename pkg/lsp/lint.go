@@ -0,0 +1,157 @@
+package lsp
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/overlay"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// FileDiagnostics is the diagnostics of a file checked from the command line.
+type FileDiagnostics struct {
+	Path        string
+	Diagnostics []protocol.Diagnostic
+}
+
+// newHeadlessServer creates a server without a client, for the workspace at `root` with its
+// project configuration. The logs of the server are not shown on the command line.
+func newHeadlessServer(root string) (*Server, error) {
+	traceEnable, logEnable = false, false
+
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		FallbackServer: &FallbackServer{},
+		overlay:        overlay.NewOverlay(),
+		index:          newWorkspaceIndex(),
+		asts:           newImportASTCache(),
+		cancel:         func() {},
+		config:         defaultConfiguration(),
+	}
+	s.workspaceFolders = []*workspaceFolder{s.newWorkspaceFolder(bazelProjectRoot(string(uri.File(abs))))}
+	data, err := readProjectConfig(s.mainFolder().fs)
+	if err != nil {
+		return nil, err
+	}
+	s.projectConfig = data
+	s.applyConfiguration()
+	return s, nil
+}
+
+// headlessFiles returns the jsonnet files of `paths`: files, or directories searched for
+// them the way the workspace is, without hidden directories and the paths excluded from
+// its index. Returns the files of the workspace at `root` if there are no paths.
+func (s *Server) headlessFiles(ctx context.Context, root string, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		// shown relative to the current directory, like the paths given
+		cwd, _ := os.Getwd()
+		files := []string{}
+		for _, f := range s.workspaceFiles(ctx) {
+			file := f.uri().Filename()
+			if rel, err := filepath.Rel(cwd, file); err == nil && cwd != "" {
+				file = rel
+			}
+			files = append(files, file)
+		}
+		return files, nil
+	}
+
+	res := []string{}
+	filters := map[*workspaceFolder]*pathFilter{}
+	excluded := func(path string, isDir bool) bool {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return false
+		}
+		f, ok := s.workspaceFileOf(uri.File(abs))
+		if !ok || f.path == "." {
+			return false
+		}
+		if filters[f.folder] == nil {
+			filters[f.folder] = s.pathFilter(f.folder)
+		}
+		return filters[f.folder].excludedPath(f.path, isDir)
+	}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			res = append(res, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != p && (strings.HasPrefix(d.Name(), ".") || excluded(path, true)) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if isJsonnetFile(path) && !excluded(path, false) {
+				res = append(res, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Lint checks jsonnet files with the configuration of the workspace at `root`, and returns
+// the diagnostics the server reports for them when they are not open: parse errors, the
+// linter, and Kubernetes schemas, without evaluating them. Paths are files or directories,
+// the whole workspace is checked if there are none. Positions are in UTF-16 code units.
+func Lint(ctx context.Context, root string, paths []string) ([]FileDiagnostics, error) {
+	s, err := newHeadlessServer(root)
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.headlessFiles(ctx, root, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	vms := map[*workspaceFolder]*vmCache{}
+	res := make([]FileDiagnostics, 0, len(files))
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			return nil, err
+		}
+		u := uri.File(abs)
+		folder := s.folderOf(u)
+		if vms[folder] == nil {
+			// imports shared by the files are only parsed once
+			vms[folder] = s.newVMCache(u)
+		}
+		// positions are in UTF-16 like the ones editors get by default
+		diags := s.positions().diagnosticsToClient(u, s.config.Diag.applyRules(s.checkFile(ctx, u, string(data), vms[folder])))
+		sort.SliceStable(diags, func(i, j int) bool {
+			a, b := diags[i].Range.Start, diags[j].Range.Start
+			return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+		})
+		res = append(res, FileDiagnostics{Path: file, Diagnostics: diags})
+	}
+	return res, nil
+}
@@ -6,13 +6,16 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/carlverge/jsonnet-lsp/pkg/kube"
 	"github.com/carlverge/jsonnet-lsp/pkg/linter"
 	"github.com/carlverge/jsonnet-lsp/pkg/overlay"
 	"github.com/google/go-jsonnet"
@@ -24,8 +27,12 @@ import (
 	"go.lsp.dev/uri"
 )
 
+var logEnable = true
+
 func logf(msg string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "I%s]%s\n", time.Now().Format("0201 15:04:05.00000"), fmt.Sprintf(msg, args...))
+	if logEnable {
+		fmt.Fprintf(os.Stderr, "I%s]%s\n", time.Now().Format("0201 15:04:05.00000"), fmt.Sprintf(msg, args...))
+	}
 }
 
 var traceEnable = true
@@ -39,31 +46,94 @@ func tracef(msg string, args ...interface{}) {
 type Server struct {
 	*FallbackServer
 
-	rootURI     uri.URI
-	rootFS      fs.FS
-	searchPaths []string
-
-	overlay  *overlay.Overlay
-	importer *OverlayImporter
-	vmlock   sync.Mutex
-	config   *Configuration
-
-	// intentionally only keep one active VM at once
-	// when an operation needs a full VM (f.ex if it needs to
-	// traverse imports) then dump the VM and create a new one.
-	// This usually only happens when users switch and then edit a file,
-	// and the latency is usually on the order of <1s. Not acceptable on
-	// every operation, but acceptable on file change. This helps keep
-	// memory usage low as we don't keep a VM in memory for every active
-	// file we're editing.
-	vm *vmCache
+	// the folders of the workspace, each with its own search paths and importer
+	folderLock       sync.Mutex
+	workspaceFolders []*workspaceFolder
+	// the folders of the directories of files opened without a workspace folder
+	looseFolders map[uri.URI]*workspaceFolder
+
+	overlay *overlay.Overlay
+	vmlock  sync.Mutex
+	config  *Configuration
+
+	// the settings sent by the client, and the project configuration file as JSON, that
+	// the configuration is built from
+	configLock    sync.Mutex
+	settings      interface{}
+	projectConfig []byte
+	// the settings of the vscode-jsonnet extension, under the `jsonnet` section
+	legacySettings interface{}
+	// the client supports `workspace/configuration`, settings are pulled from the client
+	configPull bool
+	// the client supports registering for `workspace/didChangeWatchedFiles`
+	watchFiles bool
+	// the client supports `window/workDoneProgress`, long operations report their progress
+	workDoneProgress bool
+	// the client supports snippets in completion items, they are inserted as plain text
+	// otherwise
+	snippetSupport bool
+	// the encoding of the characters of positions exchanged with the client
+	positionEncoding string
+	// the client pulls the diagnostics of the files it shows with `textDocument/diagnostic`,
+	// they are not published, and it can be asked to pull them again
+	pullDiagnostics   bool
+	diagnosticRefresh bool
+	// set while a refresh of the pulled diagnostics is waiting to be sent
+	diagnosticRefreshing int32
+
+	// intentionally only keep a few VMs at once, one for each of the
+	// files most recently used. When an operation needs a full VM (f.ex
+	// if it needs to traverse imports) for another file, the least recently
+	// used VM is dropped and a new one is created. The latency is usually on
+	// the order of <1s. Not acceptable on every operation, but acceptable on
+	// file change. Keeping a few lets users alternate between files without
+	// paying it on every switch, while keeping memory usage low as we don't
+	// keep a VM in memory for every active file we're editing.
+	// Ordered from the most recently used.
+	vms []*vmCache
+	// incremented when the VMs are flushed, the shapes inferred from imports are dropped
+	vmGeneration int64
+	// the files imported by the VMs dropped from the pool since the VMs were last flushed,
+	// the shapes inferred from them are still dropped when they change
+	evictedImports map[string]bool
+	// the number of times the caches were evicted to stay under the memory budget
+	evictions int64
+
+	// parsed files of the whole workspace, used for cross-file features
+	index *workspaceIndex
+	// parsed imports, shared by every VM
+	asts *importASTCache
+
+	// the fields of the last completion list, resolved on demand
+	completions completionCache
+	// the completion items picked recently, ranked higher
+	recentCompletions recentCompletions
+	// the schemas of Kubernetes resources, loaded on demand
+	kube kubeCatalogCache
 
 	// set to true if the last edit to the document was a '.'
 	// used to change autocomplete behaviour
 	lastCharIsDot bool
 
+	// the open live previews of the output of files
+	previews previewSet
+	// the diagnostics of the files being edited, waiting for the user to stop typing
+	diagSchedule diagSchedule
+	// the files too large to check live
+	largeFiles largeFileSet
+	// the open files that do not parse, the files importing them are checked again when
+	// they are fixed or broken
+	brokenFiles brokenFileSet
+	// the last diagnostics of the open files, for clients that pull them
+	diagReports diagReports
+	// the last diagnostics of the workspace files that are not open
+	workspaceReports   workspaceReports
+	workspaceResultIDs int64
+
 	cancel   context.CancelFunc
 	notifier protocol.Client
+	// the connection to the client, for notifications the protocol package does not have
+	conn jsonrpc2.Conn
 }
 
 type readCloser struct {
@@ -72,21 +142,68 @@ type readCloser struct {
 }
 
 func RunServer(ctx context.Context, stdout *os.File) error {
+	logger := protocol.LoggerFromContext(ctx)
+	logger.Debug("running in stdio mode")
+	return serveConn(ctx, &readCloser{os.Stdin, stdout})
+}
+
+// ListenServer accepts connections over TCP on `addr`, f.ex `localhost:7777`. Every
+// connection is a separate session of the language server, and the listener runs until
+// the context is done.
+func ListenServer(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logf("listening for connections on %s", lis.Addr())
+	go func() {
+		<-ctx.Done()
+		_ = lis.Close()
+	}()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		logf("accepted connection from %s", conn.RemoteAddr())
+		go func() {
+			defer conn.Close()
+			// the session is canceled when the client exits
+			if err := serveConn(ctx, conn); err != nil && !errors.Is(err, context.Canceled) {
+				logf("connection from %s failed: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// serveConn runs a session of the language server on the connection until the client
+// exits or disconnects.
+func serveConn(ctx context.Context, conn io.ReadWriteCloser) error {
+	return serveStream(ctx, jsonrpc2.NewStream(conn))
+}
+
+// serveStream runs a session of the language server on a stream of messages. Every session
+// has its own server state.
+func serveStream(ctx context.Context, stream jsonrpc2.Stream) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	conn := &readCloser{os.Stdin, stdout}
 
 	logger := protocol.LoggerFromContext(ctx)
-	logger.Debug("running in stdio mode")
-	stream := jsonrpc2.NewStream(conn)
 	jsonConn := jsonrpc2.NewConn(stream)
 	notifier := protocol.ClientDispatcher(jsonConn, logger.Named("notify"))
 
 	srv := &Server{
 		FallbackServer: &FallbackServer{},
 		overlay:        overlay.NewOverlay(),
+		index:          newWorkspaceIndex(),
+		asts:           newImportASTCache(),
 		cancel:         cancel,
 		notifier:       notifier,
+		conn:           jsonConn,
 		config:         defaultConfiguration(),
 	}
 
@@ -128,22 +245,6 @@ func rootDirectoryFrom(params *protocol.InitializeParams) string {
 	return cwd
 }
 
-func findRootDirectory(params *protocol.InitializeParams) uri.URI {
-	rootDir := rootDirectoryFrom(params)
-
-	// The IntelliJ Bazel Plugin generates an artificial .ijwb project directory
-	// inside the actual project root.
-	// https://blog.bazel.build/2019/09/29/intellij-bazel-sync.html
-	bazelDirs := []string{"/.ijwb", "/.aswb", "/.clwb"}
-	for _, bazelDir := range bazelDirs {
-		if strings.Contains(rootDir, bazelDir) {
-			rootDir = strings.Replace(rootDir, bazelDir, "", 1)
-			break
-		}
-	}
-	return uri.URI(rootDir)
-}
-
 // cachedImporter will keep the file contents
 // of each imported file stable. This is important for an LSP as
 // file contents will change rather dynamically. The jsonnet VM
@@ -185,15 +286,42 @@ func (imp *cachedImporter) Import(from, path string) (contents jsonnet.Contents,
 	return imp.cache[foundAt], foundAt, nil
 }
 
+// files returns the paths of the imported files.
+func (imp *cachedImporter) files() []string {
+	imp.lock.Lock()
+	defer imp.lock.Unlock()
+	res := make([]string, 0, len(imp.cache))
+	for file := range imp.cache {
+		res = append(res, file)
+	}
+	return res
+}
+
+// imported returns true if the file was imported, the importer keeps its contents at the
+// time.
+func (imp *cachedImporter) imported(file string) bool {
+	imp.lock.Lock()
+	defer imp.lock.Unlock()
+	_, ok := imp.cache[file]
+	return ok
+}
+
 type OverlayImporter struct {
 	overlay *overlay.Overlay
 	rootURI uri.URI
 	rootFS  fs.FS
 	paths   []string
+	// legacy jsonnet-bundler package names, mapped to their directory in the vendor tree
+	vendorAliases map[string]string
+	// tanka projects also search the environment directory of the importing file
+	tanka bool
 
-	// Additional user specified paths (can change at runtime)
+	// Additional user specified paths, and the order imports are searched in (can change
+	// at runtime)
 	jpathLock sync.Mutex
 	jpaths    []string
+	order     []string
+	strict    bool
 }
 
 func (imp *OverlayImporter) readURI(uri uri.URI) (res []byte, err error) {
@@ -227,52 +355,157 @@ func (imp *OverlayImporter) SetJPaths(jpaths []string) {
 	imp.jpaths = jpaths
 }
 
-func (imp *OverlayImporter) Import(from, path string) (jsonnet.Contents, string, error) {
+// SetImportOrder sets the places imports are searched at, in order, or the semantics of
+// the jsonnet command if `strict` is set.
+func (imp *OverlayImporter) SetImportOrder(order []string, strict bool) {
+	imp.jpathLock.Lock()
+	defer imp.jpathLock.Unlock()
+	imp.order, imp.strict = order, strict
+}
+
+// importCandidate is a path an import is searched at, and where the path comes from.
+type importCandidate struct {
+	uri    uri.URI
+	source string
+}
+
+// candidates returns the paths an import of `path` from the file `from` is searched at,
+// in order.
+func (imp *OverlayImporter) candidates(from, path string) ([]importCandidate, error) {
 	rootPath := imp.rootURI.Filename()
 
-	// if absolute, rel it to the workspace root
+	// absolute paths are only found where they point to, like the jsonnet command does
 	if filepath.IsAbs(path) {
-		path, _ = filepath.Rel(rootPath, path)
+		return []importCandidate{{uri.File(filepath.Clean(path)), "the absolute path"}}, nil
 	}
 
 	// the path to the importer, relative to the root
 	fromPath, err := filepath.Rel(rootPath, filepath.Dir(from))
 	if err != nil {
-		return jsonnet.Contents{}, "", fmt.Errorf("failed to open '%s' -- could not relativize '%s' to root '%s' %v", path, from, imp.rootURI, err)
-	}
-
-	// Build a list of candidate URIs to try for the file
-	candidates := []uri.URI{
-		uri.File(filepath.Join(rootPath, path)),
-		uri.File(filepath.Join(rootPath, fromPath, path)),
-	}
-	for _, search := range imp.paths {
-		candidates = append(candidates, uri.File(filepath.Join(rootPath, search, path)))
+		return nil, fmt.Errorf("failed to open '%s' -- could not relativize '%s' to root '%s' %v", path, from, imp.rootURI, err)
 	}
 
 	// JPaths feel very hacked in here.
 	// They need to be reconfigurable at runtime.
 	imp.jpathLock.Lock()
-	jpaths := imp.jpaths
+	jpaths, order, strict := imp.jpaths, imp.order, imp.strict
 	imp.jpathLock.Unlock()
-	for _, search := range jpaths {
+
+	jpathCandidate := func(search string) importCandidate {
 		if filepath.IsAbs(search) {
-			candidates = append(candidates, uri.File(filepath.Join(search, path)))
-		} else {
-			candidates = append(candidates, uri.File(filepath.Join(rootPath, search, path)))
+			return importCandidate{uri.File(filepath.Join(search, path)), "the jpath " + search}
+		}
+		return importCandidate{uri.File(filepath.Join(rootPath, search, path)), "the jpath " + search}
+	}
+	importerCandidate := importCandidate{uri.File(filepath.Join(rootPath, fromPath, path)), "the directory of the importing file"}
+	if strict {
+		// the jsonnet command searches the `-J` paths from the last one
+		candidates := []importCandidate{importerCandidate}
+		for i := len(jpaths) - 1; i >= 0; i-- {
+			candidates = append(candidates, jpathCandidate(jpaths[i]))
 		}
+		return candidates, nil
+	}
+	if order == nil {
+		order = defaultImportOrder
 	}
 
+	// Build a list of candidate URIs to try for the file
+	candidates := []importCandidate{}
+	for _, source := range order {
+		switch source {
+		case importSourceRoot:
+			candidates = append(candidates, importCandidate{uri.File(filepath.Join(rootPath, path)), "the workspace root"})
+		case importSourceImporter:
+			candidates = append(candidates, importerCandidate)
+		case importSourceTanka:
+			if !imp.tanka {
+				continue
+			}
+			// the jpath of tk, which is searched from the environment to the vendor directory
+			// of the project
+			if base, ok := tankaBaseDir(imp.rootFS, fromPath); ok {
+				candidates = append(candidates,
+					importCandidate{uri.File(filepath.Join(rootPath, base, path)), "the tanka environment " + base},
+					importCandidate{uri.File(filepath.Join(rootPath, "lib", path)), "the tanka lib directory"},
+					importCandidate{uri.File(filepath.Join(rootPath, base, "vendor", path)), "the vendor directory of the tanka environment " + base},
+					importCandidate{uri.File(filepath.Join(rootPath, "vendor", path)), "the tanka vendor directory"},
+				)
+			}
+		case importSourceSearchPaths:
+			for _, search := range imp.paths {
+				candidates = append(candidates, importCandidate{uri.File(filepath.Join(rootPath, search, path)), "the search path " + search})
+			}
+		case importSourceBundler:
+			// legacy jsonnet-bundler imports, in case the symlinks in the vendor tree are missing
+			if first, rest, ok := strings.Cut(filepath.ToSlash(path), "/"); ok && imp.vendorAliases[first] != "" {
+				candidates = append(candidates, importCandidate{uri.File(filepath.Join(rootPath, imp.vendorAliases[first], rest)), "the jsonnet-bundler package " + first})
+			}
+		case importSourceJPaths:
+			for _, search := range jpaths {
+				candidates = append(candidates, jpathCandidate(search))
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// SearchedPaths returns the paths an import of `path` from the file `from` is searched
+// at, relative to the workspace root if they are in it.
+func (imp *OverlayImporter) SearchedPaths(from, path string) []string {
+	candidates, err := imp.candidates(from, path)
+	if err != nil {
+		return nil
+	}
+	res := []string{}
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		name := imp.relPath(candidate.uri)
+		if !seen[name] {
+			seen[name] = true
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// relPath returns the path of a file relative to the workspace root, or its absolute path
+// if it is not in the workspace.
+func (imp *OverlayImporter) relPath(u uri.URI) string {
+	name := u.Filename()
+	if rel, err := filepath.Rel(imp.rootURI.Filename(), name); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return name
+}
+
+// resolve returns the candidate an import is found at, and the contents of the file.
+func (imp *OverlayImporter) resolve(from, path string) (importCandidate, []byte, error) {
+	candidates, err := imp.candidates(from, path)
+	if err != nil {
+		return importCandidate{}, nil, err
+	}
 	tracef("read-path: path='%s' from='%s' candidates=%v", path, from, candidates)
-	tracef("searching for path '%s' in candidates %v", path, candidates)
 	for _, candidate := range candidates {
-		data, err := imp.readURI(candidate)
+		data, err := imp.readURI(candidate.uri)
 		if err == nil {
-			tracef("read-path-hit: path='%s' foundAt=%s", path, candidate.Filename())
-			return jsonnet.MakeContentsRaw(data), candidate.Filename(), nil
+			tracef("read-path-hit: path='%s' foundAt=%s", path, candidate.uri.Filename())
+			return candidate, data, nil
 		}
 	}
-	return jsonnet.Contents{}, "", fmt.Errorf("path '%s' not found in candidates %v", path, candidates)
+	searched := make([]uri.URI, len(candidates))
+	for i, c := range candidates {
+		searched[i] = c.uri
+	}
+	return importCandidate{}, nil, fmt.Errorf("path '%s' not found in candidates %v", path, searched)
+}
+
+func (imp *OverlayImporter) Import(from, path string) (jsonnet.Contents, string, error) {
+	found, data, err := imp.resolve(from, path)
+	if err != nil {
+		return jsonnet.Contents{}, "", err
+	}
+	return jsonnet.MakeContentsRaw(data), found.uri.Filename(), nil
 }
 
 func posToProto(p ast.Location) protocol.Position {
@@ -294,6 +527,10 @@ func rangeToProto(r ast.LocationRange) protocol.Range {
 	return protocol.Range{Start: posToProto(r.Begin), End: posToProto(r.End)}
 }
 
+func rangeToLocation(r ast.LocationRange) protocol.Location {
+	return protocol.Location{URI: uri.File(r.FileName), Range: rangeToProto(r)}
+}
+
 // staticError shadows the staticError internal interface in go-jsonnet/internal/errors
 type staticError interface {
 	Error() string
@@ -311,6 +548,17 @@ type vmCache struct {
 	// from is the file that created the VM
 	from uri.URI
 	vm   *jsonnet.VM
+
+	importer *cachedImporter
+	// the ASTs shared by all VMs, and the ones this VM has taken from it by path
+	shared *importASTCache
+	asts   map[string]ast.Node
+	// the errors of the imported files that do not parse, by path
+	errs map[string]error
+	// shows the progress of slow imports in the client
+	startProgress func(title, message string) *workProgress
+	// the native functions declared in the configuration, registered as stubs
+	natives map[string]*analysis.Function
 }
 
 func (c *vmCache) Use(fn func(vm *jsonnet.VM)) {
@@ -322,33 +570,152 @@ func (c *vmCache) Use(fn func(vm *jsonnet.VM)) {
 func (c *vmCache) ImportAST(from, path string) (ast.Node, uri.URI) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	contents, foundAt, err := c.vm.ImportAST(from, path)
+	contents, foundAt, err := c.importer.Import(from, path)
 	if err != nil {
 		return nil, uri.URI("")
 	}
-	return contents, uri.File(foundAt)
+	if root, ok := c.asts[foundAt]; ok {
+		return root, uri.File(foundAt)
+	}
+	// the importer returns the same contents for the lifetime of the VM, so the shared
+	// AST only needs to be checked once
+	root, ok := c.shared.get(foundAt, contents)
+	if !ok {
+		p := c.startProgress("Parsing imports", path)
+		defer p.end("")
+		if root, _, err = c.vm.ImportAST(from, path); err != nil || root == nil {
+			// the VM caches the files that do not parse, their error is only returned once
+			if err != nil {
+				c.errs[foundAt] = err
+			}
+			return nil, uri.URI("")
+		}
+		c.shared.put(foundAt, contents, root)
+	}
+	c.asts[foundAt] = root
+	return root, uri.File(foundAt)
 }
 
+// vmPoolSize is the number of VMs kept for the most recently used files.
+const vmPoolSize = 4
+
 func (s *Server) getVM(uri uri.URI) *vmCache {
 	s.vmlock.Lock()
 	defer s.vmlock.Unlock()
 
-	// still on the same file, keep the vm cache
-	if s.vm != nil && uri == s.vm.from {
-		return s.vm
+	// already used for this file, keep the vm cache
+	for i, vm := range s.vms {
+		if vm.from == uri {
+			copy(s.vms[1:i+1], s.vms[:i])
+			s.vms[0] = vm
+			return vm
+		}
+	}
+
+	if len(s.vms) >= vmPoolSize {
+		tracef("flusing jsonnet vm cache of %s (changed file to %s)", s.vms[len(s.vms)-1].from, uri)
+		s.truncateVMs(vmPoolSize - 1)
+	}
+	vm := s.newVMCache(uri)
+	s.vms = append([]*vmCache{vm}, s.vms...)
+	return vm
+}
+
+// flushVMs drops every VM, f.ex when the files they have imported may have changed.
+func (s *Server) flushVMs() {
+	s.vmlock.Lock()
+	defer s.vmlock.Unlock()
+	s.vms = nil
+	s.evictedImports = nil
+	atomic.AddInt64(&s.vmGeneration, 1)
+}
+
+// truncateVMs keeps the `n` most recently used VMs, and remembers the files the others
+// imported. Expects the vmlock to be held.
+func (s *Server) truncateVMs(n int) {
+	for _, vm := range s.vms[n:] {
+		if s.evictedImports == nil {
+			s.evictedImports = map[string]bool{}
+		}
+		for _, file := range vm.importer.files() {
+			s.evictedImports[file] = true
+		}
+	}
+	s.vms = s.vms[:n]
+}
+
+// dropImporters drops the VMs that imported the file, as they keep its contents at the
+// time, and the shapes inferred from it in other files, f.ex when it is edited.
+func (s *Server) dropImporters(u uri.URI) {
+	file := filepath.Clean(u.Filename())
+	s.vmlock.Lock()
+	defer s.vmlock.Unlock()
+	vms := make([]*vmCache, 0, len(s.vms))
+	for _, vm := range s.vms {
+		if !vm.importer.imported(file) {
+			vms = append(vms, vm)
+		}
 	}
+	if len(vms) == len(s.vms) && !s.evictedImports[file] {
+		return
+	}
+	tracef("dropping the jsonnet vms importing %s", file)
+	s.vms = vms
+	// every shape is inferred again, with the VMs that are kept or new ones
+	s.evictedImports = nil
+	atomic.AddInt64(&s.vmGeneration, 1)
+}
+
+// traceWriter forwards the output of `std.trace` to the client as log messages. Each trace
+// is written at once, prefixed with the file and line of the call.
+type traceWriter struct {
+	notifier protocol.Client
+}
 
-	tracef("flusing jsonnet vm cache (changed file to %s)", uri)
-	vm := &vmCache{from: uri, vm: jsonnet.MakeVM()}
-	vm.vm.Importer(&cachedImporter{
-		notFound: map[[2]string]error{},
-		foundAt:  map[[2]string]string{},
-		cache:    map[string]jsonnet.Contents{},
-		real:     s.importer,
+func (w traceWriter) Write(p []byte) (int, error) {
+	_ = w.notifier.LogMessage(context.Background(), &protocol.LogMessageParams{
+		Type:    protocol.MessageTypeLog,
+		Message: strings.TrimSuffix(string(p), "\n"),
 	})
-	vm.vm.SetTraceOut(io.Discard)
-	s.vm = vm
+	return len(p), nil
+}
 
+// newVMCache creates a VM that is not tracked by the server. This is useful for
+// operations that span many files (like finding references) which would otherwise
+// thrash the active VM.
+func (s *Server) newVMCache(from uri.URI) *vmCache {
+	vm := &vmCache{
+		from: from,
+		vm:   jsonnet.MakeVM(),
+		importer: &cachedImporter{
+			notFound: map[[2]string]error{},
+			foundAt:  map[[2]string]string{},
+			cache:    map[string]jsonnet.Contents{},
+			real:     s.folderOf(from).importer,
+		},
+		shared:        s.asts,
+		asts:          map[string]ast.Node{},
+		errs:          map[string]error{},
+		startProgress: s.startProgress,
+		natives:       nativeFunctions(s.config),
+	}
+	vm.vm.Importer(vm.importer)
+	registerNatives(vm.vm, vm.natives)
+	vm.vm.SetTraceOut(traceWriter{notifier: s.notifier})
+	if cfg := s.config; cfg != nil {
+		for k, v := range cfg.ExtVars {
+			vm.vm.ExtVar(k, v)
+		}
+		for k, v := range cfg.ExtCode {
+			vm.vm.ExtCode(k, v)
+		}
+		for k, v := range cfg.TLAVars {
+			vm.vm.TLAVar(k, v)
+		}
+		for k, v := range cfg.TLACode {
+			vm.vm.TLACode(k, v)
+		}
+	}
 	return vm
 }
 
@@ -370,6 +737,26 @@ func convChangeEvents(events []protocol.TextDocumentContentChangeEvent) []gotext
 type ParseResult struct {
 	Root ast.Node
 	Err  error
+
+	// the shapes inferred for the version of the file, see typeCache
+	typesLock sync.Mutex
+	types     *analysis.TypeCache
+	typesGen  int64
+}
+
+// typeCache returns the cache of the shapes inferred in a parsed file. It is kept until the
+// file changes, or the VMs are flushed as the files it imports may have changed.
+func (s *Server) typeCache(pr *ParseResult) *analysis.TypeCache {
+	if pr == nil {
+		return nil
+	}
+	pr.typesLock.Lock()
+	defer pr.typesLock.Unlock()
+	if gen := atomic.LoadInt64(&s.vmGeneration); pr.types == nil || pr.typesGen != gen {
+		pr.types = analysis.NewTypeCache()
+		pr.typesGen = gen
+	}
+	return pr.types
 }
 
 func (p *ParseResult) StaticErr() staticError {
@@ -380,31 +767,12 @@ func (p *ParseResult) StaticErr() staticError {
 	return se
 }
 
-// AST recovery. Unfortunately jsonnet makes significant use of semicolons and colons for valid ASTs.
-// As a user is typing, the AST will often be invalid due to a missing semicolon or comma.
-// For example: `local x = std` -- when the user hits '.' autocomplete wont work because the
-// previous AST could not be parsed (`local x = std;` is valid, however).
-// The code below will try to add a semicolon and a comma to the text, the character after
-// where the user is typing.
-// We need still need to set the original AST error so it will be reported.
-func tryRecoverAST(uri uri.URI, contents string, lastEdit *gotextdiff.TextEdit) ast.Node {
-	// Eat panics from textedit
-	defer func() { _ = recover() }()
-	insertion := span.NewPoint(lastEdit.Span.End().Line(), lastEdit.Span.End().Column()+len(lastEdit.NewText), -1)
-	addSemicol := []gotextdiff.TextEdit{{NewText: ";", Span: span.New(span.URI(""), insertion, insertion)}}
-	addComma := []gotextdiff.TextEdit{{NewText: ",", Span: span.New(span.URI(""), insertion, insertion)}}
-
-	withSemicol := gotextdiff.ApplyEdits(contents, addSemicol)
-	if recovered, _ := jsonnet.SnippetToAST(uri.Filename(), withSemicol); recovered != nil {
-		return recovered
-	}
-
-	withComma := gotextdiff.ApplyEdits(contents, addComma)
-	if recovered, _ := jsonnet.SnippetToAST(uri.Filename(), withComma); recovered != nil {
-		return recovered
+// lastEditEnd returns the location after the text of an edit, unset if there is none.
+func lastEditEnd(edit *gotextdiff.TextEdit) ast.Location {
+	if edit == nil || strings.Contains(edit.NewText, "\n") {
+		return ast.Location{}
 	}
-
-	return nil
+	return ast.Location{Line: edit.Span.Start().Line(), Column: edit.Span.Start().Column() + len(edit.NewText)}
 }
 
 func parseJsonnetFn(uri uri.URI) overlay.ParseFunc {
@@ -413,16 +781,22 @@ func parseJsonnetFn(uri uri.URI) overlay.ParseFunc {
 		res := &ParseResult{}
 		res.Root, res.Err = jsonnet.SnippetToAST(uri.Filename(), contents)
 
-		if res.Root == nil && lastEdit != nil {
-			res.Root = tryRecoverAST(uri, contents, lastEdit)
+		if res.Root == nil {
+			// the AST is recovered for completion and hover while the user is typing, the
+			// error is still reported
+			res.Root = analysis.RecoverAST(uri.Filename(), contents, res.Err, lastEditEnd(lastEdit))
 		}
 
 		return res, res.Root != nil
 	}
 }
 
-func (s *Server) processFileUpdateFn(ctx context.Context, uri uri.URI) overlay.UpdateFunc {
+// processFileUpdateFn returns the callback that publishes the diagnostics of a file after
+// it is updated. The file is evaluated if `evaluate` is set, or evaluation is configured
+// for every change.
+func (s *Server) processFileUpdateFn(ctx context.Context, uri uri.URI, evaluate bool) overlay.UpdateFunc {
 	resv := &valueResolver{
+		ctx:        ctx,
 		rootURI:    uri,
 		rootAST:    nil,
 		roots:      map[string]ast.Node{},
@@ -436,73 +810,210 @@ func (s *Server) processFileUpdateFn(ctx context.Context, uri uri.URI) overlay.U
 		if ur.Current == nil {
 			return
 		}
+		// the file changed while this version was checked, the newer version will be published
+		stale := func() bool {
+			cur := s.overlay.Current(uri)
+			return cur != nil && cur.Version != ur.Current.Version
+		}
+		// the diagnostics are published before the linter runs, and once it is done. Clients
+		// that pull diagnostics only get the final ones.
+		publish := func(res []protocol.Diagnostic, final bool) {
+			if stale() || (s.pullDiagnostics && !final) {
+				return
+			}
+			items := s.positions().diagnosticsToClient(uri, s.config.Diag.applyRules(res))
+			if final && s.diagReports.put(uri, ur.Current.Version, items) {
+				s.refreshDiagnosticPulls(ctx)
+			}
+			if s.pullDiagnostics {
+				return
+			}
+			_ = s.notifier.PublishDiagnostics(ctx, &protocol.PublishDiagnosticsParams{
+				URI:         uri,
+				Version:     uint32(ur.Current.Version),
+				Diagnostics: items,
+			})
+		}
 
 		if pr, _ := ur.Current.Data.(*ParseResult); pr.StaticErr() != nil {
 			// AST failed to parse, do not run lints
-			se := pr.StaticErr()
-			diags = append(diags, protocol.Diagnostic{
-				Severity: protocol.DiagnosticSeverityError,
-				Range:    rangeToProto(se.Loc()),
-				Message:  se.Error(),
-				Source:   "jsonnet",
-			})
-		} else if ur.Parsed != nil && s.config.Diag.Linter && ur.Current.Version == ur.Parsed.Version {
-			// AST did parse, run linter
+			diags = append(diags, parseErrorDiagnostics(uri.Filename(), ur.Current.Contents, pr.StaticErr())...)
+		} else if ur.Parsed != nil && ur.Current.Version == ur.Parsed.Version {
+			// AST did parse, diagnostics that only need the AST are published before
+			// running the slower linter.
 			parseResult := ur.Parsed.Data.(*ParseResult)
-			resv.rootAST = parseResult.Root
-			resv.roots[resv.rootAST.Loc().FileName] = resv.rootAST
-			diags = append(diags, linter.LintAST(resv.rootAST, resv)...)
-
-			// If the linter has detected no fatal errors, then evaluate the file.
-			// This is to avoid evaluations of obviously bad files, which will just
-			// burn CPU as the user is typing.
-			if !linter.HasErrors(diags) && s.config.Diag.Evaluate {
-				resv.getvm().Use(func(vm *jsonnet.VM) {
-					defer func(t time.Time) { tracef("evaluation %s done diags in %s", uri, time.Since(t)) }(time.Now())
-					_, err := vm.Evaluate(resv.rootAST)
-					rterr, ok := err.(jsonnet.RuntimeError)
-					if !ok {
-						return
+			diags = append(diags, s.astDiagnostics(parseResult.Root)...)
+			lint := s.config.Diag.Linter && !s.isLargeFile(ctx, uri, ur.Current.Contents)
+			if lint {
+				diags = append(diags, s.config.Diag.applyRules(kube.UnknownFields(parseResult.Root, s.kubeCatalog(uri)))...)
+			}
+			if lint {
+				publish(diags, false)
+				if stale() {
+					// the user kept typing, do not lint a version that will not be published
+					return
+				}
+				resv.rootAST = parseResult.Root
+				resv.roots[resv.rootAST.Loc().FileName] = resv.rootAST
+				resv.types = s.typeCache(parseResult)
+				lintDiags := s.config.Diag.applyRules(linter.LintAST(resv.rootAST, resv))
+				diags = append(diags, lintDiags...)
+
+				// If the linter has detected no fatal errors, then evaluate the file.
+				// This is to avoid evaluations of obviously bad files, which will just
+				// burn CPU as the user is typing.
+				if !linter.HasErrors(lintDiags) && s.config.Diag.Evaluate && !evaluate {
+					// evaluating is slower than linting, wait longer for the user to stop typing
+					select {
+					case <-ctx.Done():
+					case <-time.After(s.diagSchedule.untilQuiet(uri, delay(s.config.Diag.EvaluateDelay))):
 					}
-
-					// Grab the stack trace from the error, and highlight
-					// each line.
-					fname := resv.rootAST.Loc().FileName
-					seenRootCause := false
-					for _, frame := range rterr.StackTrace {
-						if frame.Loc.FileName != fname {
-							continue
-						}
-						// Each implicated line of the stack trace is a diagnostic to be highlighted.
-						// The most specific stack frame in this file is highlighted as an error
-						// to draw user attention to the clostest known root cause.
-						sev := protocol.DiagnosticSeverityError
-						if seenRootCause {
-							sev = protocol.DiagnosticSeverityWarning
-						}
-						seenRootCause = true
-
-						diags = append(diags, protocol.Diagnostic{
-							Range:    rangeToProto(frame.Loc),
-							Severity: sev,
-							Code:     "RuntimeError",
-							Source:   "jsonnet",
-							Message:  rterr.Msg,
-						})
+				}
+				if !linter.HasErrors(lintDiags) && (s.config.Diag.Evaluate || evaluate) && !stale() {
+					p := s.startProgress("Evaluating", uri.Filename())
+					evalDiags, output := evaluationDiagnostics(resv)
+					diags = append(diags, evalDiags...)
+					if evaluate && output != "" {
+						diags = append(diags, s.schemaDiagnostics(resv, ur.Current.Contents, output)...)
 					}
-				})
+					p.end("")
+				}
 			}
 		}
 
-		_ = s.notifier.PublishDiagnostics(ctx, &protocol.PublishDiagnosticsParams{
-			URI:         uri,
-			Version:     uint32(ur.Current.Version),
-			Diagnostics: diags,
+		publish(diags, true)
+
+		// the files importing this one report if it does not parse
+		pr, _ := ur.Current.Data.(*ParseResult)
+		if s.brokenFiles.toggle(uri, pr.StaticErr() != nil) {
+			s.checkImporters(ctx, uri)
+		}
+
+		// keep the workspace index up to date with the editor
+		if ur.Parsed != nil && ur.Current.Version == ur.Parsed.Version {
+			s.reindexFiles(uri)
+		}
+	}
+}
+
+// parseErrorDiagnostics returns the diagnostics of a file that does not parse.
+func parseErrorDiagnostics(filename, contents string, se staticError) []protocol.Diagnostic {
+	if dups, ok := linter.ParseDuplicateFields(filename, contents, se); ok {
+		// the parser stops at the second definition of a field, report every definition
+		return dups
+	}
+	return []protocol.Diagnostic{{
+		Severity: protocol.DiagnosticSeverityError,
+		Range:    rangeToProto(se.Loc()),
+		Message:  se.Error(),
+		Source:   "jsonnet",
+	}}
+}
+
+// debouncedUpdateFn returns the callback that publishes the diagnostics of a file after it
+// is changed, once it has not changed for the configured delay.
+func (s *Server) debouncedUpdateFn(ctx context.Context, uri uri.URI) overlay.UpdateFunc {
+	process := s.processFileUpdateFn(ctx, uri, false)
+	return func(ur overlay.UpdateResult) {
+		s.diagSchedule.schedule(uri, delay(s.config.Diag.LintDelay), func() { process(ur) })
+	}
+}
+
+// evaluationDiagnostics evaluates the file, and highlights each frame of the stack trace
+// of a runtime error that is in the file. The output is returned if the evaluation succeeds.
+func evaluationDiagnostics(resv *valueResolver) (diags []protocol.Diagnostic, output string) {
+	diags = []protocol.Diagnostic{}
+	resv.getvm().Use(func(vm *jsonnet.VM) {
+		defer func(t time.Time) { tracef("evaluation %s done diags in %s", resv.rootURI, time.Since(t)) }(time.Now())
+		var err error
+		output, err = vm.Evaluate(resv.rootAST)
+		rterr, ok := err.(jsonnet.RuntimeError)
+		if !ok {
+			return
+		}
+
+		// Grab the stack trace from the error, and highlight
+		// each line.
+		fname := resv.rootAST.Loc().FileName
+		related := stackTraceInformation(rterr)
+		seenRootCause := false
+		for _, frame := range rterr.StackTrace {
+			if frame.Loc.FileName != fname {
+				continue
+			}
+			// Each implicated line of the stack trace is a diagnostic to be highlighted.
+			// The most specific stack frame in this file is highlighted as an error
+			// to draw user attention to the clostest known root cause.
+			sev := protocol.DiagnosticSeverityError
+			if seenRootCause {
+				sev = protocol.DiagnosticSeverityWarning
+			}
+			seenRootCause = true
+
+			diags = append(diags, protocol.Diagnostic{
+				Range:    rangeToProto(frame.Loc),
+				Severity: sev,
+				Code:     "RuntimeError",
+				Source:   "jsonnet",
+				Message:  rterr.Msg,
+				// every frame of the trace, to follow the error through other files
+				RelatedInformation: related,
+			})
+		}
+	})
+	return diags, output
+}
+
+// stackTraceInformation returns the frames of the stack trace of an error that are in a
+// file, in the order of the trace: from the outermost call to the error.
+func stackTraceInformation(rterr jsonnet.RuntimeError) []protocol.DiagnosticRelatedInformation {
+	res := []protocol.DiagnosticRelatedInformation{}
+	for i, frame := range rterr.StackTrace {
+		if !frame.Loc.IsSet() || frame.Loc.FileName == "" || strings.HasPrefix(frame.Loc.FileName, "<") {
+			// f.ex the frames of the standard library
+			continue
+		}
+		msg := frame.Name
+		if msg == "" {
+			msg = "called from here"
+		}
+		res = append(res, protocol.DiagnosticRelatedInformation{
+			Location: protocol.Location{URI: uri.File(frame.Loc.FileName), Range: rangeToProto(frame.Loc)},
+			Message:  fmt.Sprintf("#%d %s", i, msg),
 		})
 	}
+	return res
+}
+
+// astDiagnostics returns the diagnostics that only need the AST of a file: unused bindings,
+// shadowed variables, duplicate fields, and documented parameters that functions do not
+// have. Unused imports have their own severity, and are reported even when the linter is
+// off.
+func (s *Server) astDiagnostics(root ast.Node) []protocol.Diagnostic {
+	res := []protocol.Diagnostic{}
+	importSeverity, importsEnabled := linter.ParseSeverity(string(s.config.Diag.UnusedImports), protocol.DiagnosticSeverityWarning)
+	for _, d := range linter.UnusedBindings(root) {
+		switch {
+		case d.Code == linter.UnusedImport && !importsEnabled:
+			continue
+		case d.Code == linter.UnusedImport:
+			d.Severity = importSeverity
+		case !s.config.Diag.Linter:
+			continue
+		}
+		res = append(res, d)
+	}
+	if s.config.Diag.Linter {
+		res = append(res, linter.DuplicateFields(root)...)
+		res = append(res, linter.ShadowedBindings(root, s.config.Diag.AllowShadowing)...)
+		res = append(res, linter.DocParams(root)...)
+	}
+	return res
 }
 
 type valueResolver struct {
+	// imports are not made once the context is done, f.ex when the request is canceled
+	ctx     context.Context
 	rootURI uri.URI
 	rootAST ast.Node
 	// A map of filenames from node.Loc().Filename to the root AST node
@@ -511,21 +1022,41 @@ type valueResolver struct {
 	roots      map[string]ast.Node
 	getvm      func() *vmCache
 	vm         *vmCache
+	// the shapes inferred in the root file, nil if they are not cached
+	types *analysis.TypeCache
 }
 
-var _ = (analysis.Resolver)(new(valueResolver))
+var _ = (analysis.TypeCacheResolver)(new(valueResolver))
+var _ = (linter.ImportSearcher)(new(valueResolver))
+var _ = (analysis.NativeResolver)(new(valueResolver))
 
-func (s *Server) NewResolver(uri uri.URI) *valueResolver {
-	root := s.getCurrentAST(uri)
-	if root == nil {
+func (s *Server) NewResolver(ctx context.Context, uri uri.URI) *valueResolver {
+	pr := s.getParseResult(uri)
+	if pr == nil {
 		return nil
 	}
+	root := pr.Root
 	return &valueResolver{
+		ctx:        ctx,
 		rootURI:    uri,
 		rootAST:    root,
 		roots:      map[string]ast.Node{root.Loc().FileName: root},
 		stackCache: map[ast.Node][]ast.Node{},
 		getvm:      func() *vmCache { return s.getVM(uri) },
+		types:      s.typeCache(pr),
+	}
+}
+
+// newRootResolver creates a resolver for an AST that may not be open in the editor.
+// All imports are made through `vm`, which can be shared between resolvers.
+func newRootResolver(ctx context.Context, root ast.Node, vm *vmCache) *valueResolver {
+	return &valueResolver{
+		ctx:        ctx,
+		rootURI:    uri.File(root.Loc().FileName),
+		rootAST:    root,
+		roots:      map[string]ast.Node{root.Loc().FileName: root},
+		stackCache: map[ast.Node][]ast.Node{},
+		vm:         vm,
 	}
 }
 
@@ -554,7 +1085,17 @@ func (r *valueResolver) Vars(from ast.Node) analysis.VarMap {
 	return analysis.StackVars(stk)
 }
 
-func (r *valueResolver) Import(from, path string) ast.Node {
+// TypeCache returns the cache of the shapes of the root file, until the context is done as
+// imports are no longer made.
+func (r *valueResolver) TypeCache() *analysis.TypeCache {
+	if r.ctx != nil && r.ctx.Err() != nil {
+		return nil
+	}
+	return r.types
+}
+
+// importVM returns the VM imports are made with, nil if there is none.
+func (r *valueResolver) importVM() *vmCache {
 	// The reason for this dance is to only grab a VM and importer
 	// if we need to import something. This allows us to avoid thrashing the
 	// vm cache when we don't actually need a full VM to perform analysis
@@ -564,14 +1105,47 @@ func (r *valueResolver) Import(from, path string) ast.Node {
 		}
 		r.vm = r.getvm()
 	}
-	root, _ := r.vm.ImportAST(from, path)
+	return r.vm
+}
+
+func (r *valueResolver) Import(from, path string) ast.Node {
+	if r.ctx != nil && r.ctx.Err() != nil {
+		return nil
+	}
+	vm := r.importVM()
+	if vm == nil {
+		return nil
+	}
+	root, _ := vm.ImportAST(from, path)
 	if root != nil {
 		r.roots[root.Loc().FileName] = root
 	}
 	return root
 }
 
+// SearchedPaths returns the paths an import is searched at, which are listed in the
+// diagnostic of an import that is not found.
+func (r *valueResolver) SearchedPaths(from, path string) []string {
+	vm := r.importVM()
+	if vm == nil {
+		return nil
+	}
+	real, ok := vm.importer.real.(*OverlayImporter)
+	if !ok {
+		return nil
+	}
+	return real.SearchedPaths(from, path)
+}
+
 func (s *Server) getCurrentAST(uri uri.URI) ast.Node {
+	if res := s.getParseResult(uri); res != nil {
+		return res.Root
+	}
+	return nil
+}
+
+// getParseResult returns the last version of the file that parsed, nil if there is none.
+func (s *Server) getParseResult(uri uri.URI) *ParseResult {
 	parsed := s.overlay.Parsed(uri)
 	if parsed == nil {
 		return nil
@@ -580,5 +1154,5 @@ func (s *Server) getCurrentAST(uri uri.URI) ast.Node {
 	if res == nil || res.Root == nil {
 		return nil
 	}
-	return res.Root
+	return res
 }
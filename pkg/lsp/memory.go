@@ -0,0 +1,175 @@
+package lsp
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"go.lsp.dev/uri"
+)
+
+// methodMemoryUsage reports the memory used by the server and the size of its caches.
+const methodMemoryUsage = "jsonnet/memoryUsage"
+
+// memoryCheckInterval is how often the memory used is compared to the budget.
+const memoryCheckInterval = 5 * time.Second
+
+type MemoryUsageParams struct{}
+
+type MemoryUsage struct {
+	// Bytes of the heap in use, and of the configured budget, zero if there is none.
+	HeapBytes   uint64 `json:"heapBytes"`
+	BudgetBytes uint64 `json:"budgetBytes"`
+	// Files open in the editor, and the size of their contents.
+	OpenFiles int `json:"openFiles"`
+	OpenBytes int `json:"openBytes"`
+	// Imported files whose AST is cached, and the size of their contents.
+	ImportASTs     int `json:"importASTs"`
+	ImportASTBytes int `json:"importASTBytes"`
+	// Indexed workspace files, the ones whose AST is kept, and the size of their contents.
+	IndexedFiles int `json:"indexedFiles"`
+	IndexedASTs  int `json:"indexedASTs"`
+	IndexedBytes int `json:"indexedBytes"`
+	VMs          int `json:"vms"`
+	// Number of times the caches were evicted to stay under the budget.
+	Evictions int64 `json:"evictions"`
+}
+
+// memoryBudget returns the configured budget in bytes, zero if there is none.
+func (c *Configuration) memoryBudget() uint64 {
+	if c == nil || c.MemoryBudget <= 0 {
+		return 0
+	}
+	return uint64(c.MemoryBudget) << 20
+}
+
+// setMemoryLimit makes the garbage collector run more often as the heap gets close to the
+// budget, before the caches have to be evicted.
+func setMemoryLimit(budget uint64) {
+	if budget == 0 {
+		debug.SetMemoryLimit(math.MaxInt64)
+		return
+	}
+	debug.SetMemoryLimit(int64(budget))
+}
+
+func heapBytes() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// MemoryUsage reports the memory used by the server and the size of its caches.
+func (s *Server) MemoryUsage(ctx context.Context, params *MemoryUsageParams) (*MemoryUsage, error) {
+	res := &MemoryUsage{
+		HeapBytes:   heapBytes(),
+		BudgetBytes: s.config.memoryBudget(),
+		Evictions:   atomic.LoadInt64(&s.evictions),
+	}
+	for _, u := range s.overlay.Open() {
+		if cur := s.overlay.Current(u); cur != nil {
+			res.OpenFiles++
+			res.OpenBytes += len(cur.Contents)
+		}
+	}
+	res.ImportASTs, res.ImportASTBytes = s.asts.usage()
+
+	s.index.lock.Lock()
+	for _, f := range s.index.files {
+		res.IndexedFiles++
+		if f.root != nil {
+			res.IndexedASTs++
+			res.IndexedBytes += len(f.contents)
+		}
+	}
+	s.index.lock.Unlock()
+
+	s.vmlock.Lock()
+	res.VMs = len(s.vms)
+	s.vmlock.Unlock()
+	return res, nil
+}
+
+// watchMemory evicts the caches of the server whenever the heap is over the memory budget,
+// until the context is done.
+func (s *Server) watchMemory(ctx context.Context) {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.enforceMemoryBudget()
+	}
+}
+
+// enforceMemoryBudget evicts the least recently used half of the caches until the heap is
+// under the budget, or there is nothing left to evict. The files open in the editor are
+// never evicted, only what is derived from them.
+func (s *Server) enforceMemoryBudget() {
+	budget := s.config.memoryBudget()
+	if budget == 0 {
+		return
+	}
+	for heap := heapBytes(); heap > budget; heap = heapBytes() {
+		evicted := s.evictCaches()
+		logf("memory budget exceeded heap=%dMB budget=%dMB, evicted %d cached entries", heap>>20, budget>>20, evicted)
+		if evicted == 0 {
+			return
+		}
+		atomic.AddInt64(&s.evictions, 1)
+		runtime.GC()
+	}
+}
+
+// evictCaches drops the least recently used half of every cache, in the order they are the
+// cheapest to rebuild, and returns the number of entries dropped.
+func (s *Server) evictCaches() int {
+	evicted := 0
+
+	// the VMs of other files, and the shapes inferred for open files
+	s.vmlock.Lock()
+	if len(s.vms) > 1 {
+		evicted += len(s.vms) - 1
+		s.truncateVMs(1)
+	}
+	s.vmlock.Unlock()
+	for _, u := range s.overlay.Open() {
+		if pr := s.getParseResult(u); pr != nil {
+			pr.typesLock.Lock()
+			if pr.types != nil {
+				pr.types = nil
+				evicted++
+			}
+			pr.typesLock.Unlock()
+		}
+	}
+
+	evicted += s.asts.evict()
+
+	// the ASTs of workspace files on disk, their summaries are kept and they are parsed
+	// again when they are needed
+	s.index.lock.Lock()
+	defer s.index.lock.Unlock()
+	parsed := []uri.URI{}
+	for u, f := range s.index.files {
+		if f.root != nil && !f.modTime.IsZero() {
+			parsed = append(parsed, u)
+		}
+	}
+	sort.Slice(parsed, func(i, j int) bool { return s.index.used[parsed[i]].Before(s.index.used[parsed[j]]) })
+	for _, u := range parsed[:(len(parsed)+1)/2] {
+		// entries may be in use, they are replaced instead of changed
+		f := *s.index.files[u]
+		f.root, f.refs, f.contents = nil, nil, ""
+		s.index.files[u] = &f
+		evicted++
+	}
+	return evicted
+}
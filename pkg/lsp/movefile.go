@@ -0,0 +1,207 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+const commandMoveToFile = "jsonnet.lsp.moveToFile"
+
+// refactorMove is the kind of refactors that move code, it is not in LSP 3.16.
+const refactorMove protocol.CodeActionKind = "refactor.move"
+
+// maxMoveTargets limits the number of existing files a definition can be moved to.
+const maxMoveTargets = 5
+
+// MoveToFileParams are the arguments of the command that moves a definition to a file.
+// The position is in the encoding of the server, as the command is built by the server.
+type MoveToFileParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Position     protocol.Position               `json:"position"`
+	Target       protocol.DocumentURI            `json:"target"`
+}
+
+// The protocol package only has text edits in the document changes of a workspace edit,
+// edits that also create files are sent with these types.
+
+type createFileChange struct {
+	Kind string               `json:"kind"`
+	URI  protocol.DocumentURI `json:"uri"`
+}
+
+type optionalVersionedDocument struct {
+	URI protocol.DocumentURI `json:"uri"`
+	// null means the contents on disk, or the contents in the editor if it is open
+	Version *int32 `json:"version"`
+}
+
+type textDocumentChange struct {
+	TextDocument optionalVersionedDocument `json:"textDocument"`
+	Edits        []protocol.TextEdit       `json:"edits"`
+}
+
+type resourceWorkspaceEdit struct {
+	DocumentChanges []interface{} `json:"documentChanges"`
+}
+
+type applyResourceEditParams struct {
+	Label string                `json:"label,omitempty"`
+	Edit  resourceWorkspaceEdit `json:"edit"`
+}
+
+func moveToFileCommand(title string, params *MoveToFileParams) *protocol.Command {
+	data, _ := json.Marshal(params)
+	return &protocol.Command{Title: title, Command: commandMoveToFile, Arguments: []interface{}{string(data)}}
+}
+
+// moveToFileActions offers to move the top level local function or object at `pos` to
+// a new file named after it, or to the libsonnet files the file already imports. Moves
+// are commands, as creating a file needs a workspace edit the protocol package does not
+// support.
+func (s *Server) moveToFileActions(u uri.URI, root ast.Node, pos protocol.Position) []protocol.CodeAction {
+	res := []protocol.CodeAction{}
+	def, ok := analysis.MovableDefinitionAt(root, protoToPos(pos))
+	if !ok {
+		return res
+	}
+	from := u.Filename()
+	action := func(title, target string) protocol.CodeAction {
+		return protocol.CodeAction{
+			Title: title,
+			Kind:  refactorMove,
+			Command: moveToFileCommand(title, &MoveToFileParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: u},
+				Position:     pos,
+				Target:       protocol.DocumentURI(uri.File(target)),
+			}),
+		}
+	}
+
+	newFile := filepath.Join(filepath.Dir(from), def.Name+".libsonnet")
+	if _, exists := s.fileContents(uri.File(newFile)); !exists {
+		res = append(res, action(fmt.Sprintf("Move '%s' to a new file %s", def.Name, filepath.Base(newFile)), newFile))
+	}
+
+	folder := s.folderOf(u)
+	seen := map[string]bool{}
+	for _, imp := range analysis.BuildRefIndex(root).Imports {
+		file := analysis.ImportFile(imp)
+		if file == nil || len(res) >= maxMoveTargets {
+			continue
+		}
+		_, foundAt, err := folder.importer.Import(from, file.Value)
+		if err != nil || seen[foundAt] || filepath.Clean(foundAt) == filepath.Clean(from) || !strings.HasSuffix(foundAt, ".libsonnet") {
+			continue
+		}
+		seen[foundAt] = true
+		// the imports of the definition are relative to the directory of the file
+		if def.Imports && filepath.Dir(foundAt) != filepath.Dir(from) {
+			continue
+		}
+		if _, ok := s.exportFieldEdit(foundAt, def); ok {
+			res = append(res, action(fmt.Sprintf("Move '%s' to %s", def.Name, file.Value), foundAt))
+		}
+	}
+	return res
+}
+
+// exportFieldEdit returns the edit that adds the definition to the object exported by the
+// file at `path`.
+func (s *Server) exportFieldEdit(path string, def analysis.MovableDefinition) (protocol.TextEdit, bool) {
+	contents, ok := s.fileContents(uri.File(path))
+	if !ok {
+		return protocol.TextEdit{}, false
+	}
+	root, err := jsonnet.SnippetToAST(path, contents)
+	if err != nil {
+		return protocol.TextEdit{}, false
+	}
+	edit, ok := analysis.AddExportField(root, def)
+	if !ok {
+		return protocol.TextEdit{}, false
+	}
+	return protocol.TextEdit{Range: rangeToProto(edit.Range), NewText: edit.NewText}, true
+}
+
+// MoveToFile moves a top level local function or object into the object exported by
+// another file, creating the file if it does not exist. The local is replaced by the
+// field of the imported file, so the code using it does not change.
+func (s *Server) MoveToFile(ctx context.Context, params *MoveToFileParams) (interface{}, error) {
+	u := params.TextDocument.URI
+	from, target := u.Filename(), uri.URI(params.Target).Filename()
+	contents, ok := s.fileContents(u)
+	if !ok || s.conn == nil {
+		return nil, jsonrpc2.ErrInvalidParams
+	}
+	root, err := jsonnet.SnippetToAST(from, contents)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", jsonrpc2.ErrInvalidParams, err)
+	}
+	def, ok := analysis.MovableDefinitionAt(root, protoToPos(params.Position))
+	if !ok {
+		return nil, fmt.Errorf("%s: no definition to move", jsonrpc2.ErrInvalidParams)
+	}
+
+	positions := s.positions()
+	changes := []interface{}{}
+	var path string
+	if _, exists := s.fileContents(uri.File(target)); exists {
+		edit, ok := s.exportFieldEdit(target, def)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s does not export an object without '%s'", jsonrpc2.ErrInvalidParams, target, def.Name)
+		}
+		if path, ok = s.importPath(from, target, nil); !ok {
+			return nil, fmt.Errorf("%s: %s cannot be imported", jsonrpc2.ErrInvalidParams, target)
+		}
+		changes = append(changes, textDocumentChange{
+			TextDocument: optionalVersionedDocument{URI: params.Target},
+			Edits:        positions.editsToClient(uri.File(target), []protocol.TextEdit{edit}),
+		})
+	} else {
+		rel, err := filepath.Rel(filepath.Dir(from), target)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", jsonrpc2.ErrInvalidParams, err)
+		}
+		path = filepath.ToSlash(rel)
+		changes = append(changes,
+			createFileChange{Kind: "create", URI: params.Target},
+			textDocumentChange{
+				TextDocument: optionalVersionedDocument{URI: params.Target},
+				Edits:        []protocol.TextEdit{{NewText: analysis.NewExportFile(def)}},
+			},
+		)
+	}
+
+	_, quote := analysis.ImportInsertLine(contents)
+	replace := protocol.TextEdit{
+		Range:   rangeToProto(def.Range),
+		NewText: fmt.Sprintf("%s = (import %c%s%c).%s", def.Name, quote, path, quote, def.Name),
+	}
+	changes = append(changes, textDocumentChange{
+		TextDocument: optionalVersionedDocument{URI: u},
+		Edits:        positions.editsToClient(u, []protocol.TextEdit{replace}),
+	})
+
+	// requests to the client cannot be made while handling a message from it
+	go func() {
+		var res protocol.ApplyWorkspaceEditResponse
+		_, err := s.conn.Call(ctx, protocol.MethodWorkspaceApplyEdit, &applyResourceEditParams{
+			Label: fmt.Sprintf("Move '%s' to %s", def.Name, filepath.Base(target)),
+			Edit:  resourceWorkspaceEdit{DocumentChanges: changes},
+		}, &res)
+		if err != nil || !res.Applied {
+			logf("failed to move '%s' to %s: %v %s", def.Name, target, err, res.FailureReason)
+		}
+	}()
+	return nil, nil
+}
@@ -0,0 +1,57 @@
+package lsp
+
+import (
+	"fmt"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// nativeFunctions returns the native functions declared in the configuration, which the
+// tool that evaluates the files registers in its VM.
+func nativeFunctions(cfg *Configuration) map[string]*analysis.Function {
+	res := map[string]*analysis.Function{}
+	if cfg == nil {
+		return res
+	}
+	for name, params := range cfg.NativeFunctions {
+		fn := &analysis.Function{
+			Comment: []string{fmt.Sprintf("Native function `%s`, declared in the `nativeFunctions` setting.", name)},
+			Params:  make([]analysis.Param, 0, len(params)),
+		}
+		for _, p := range params {
+			fn.Params = append(fn.Params, analysis.Param{Name: p, Type: analysis.AnyType})
+		}
+		res[name] = fn
+	}
+	return res
+}
+
+// registerNatives registers a stub of each declared native function in the VM, so files
+// calling them can be evaluated. The implementations are not known, the stubs return null.
+func registerNatives(vm *jsonnet.VM, natives map[string]*analysis.Function) {
+	for name, fn := range natives {
+		params := make(ast.Identifiers, 0, len(fn.Params))
+		for _, p := range fn.Params {
+			params = append(params, ast.Identifier(p.Name))
+		}
+		vm.NativeFunction(&jsonnet.NativeFunction{
+			Name:   name,
+			Params: params,
+			Func:   func([]interface{}) (interface{}, error) { return nil, nil },
+		})
+	}
+}
+
+// NativeFunction returns the native function `name` declared in the configuration, which
+// a call to `std.native` returns.
+func (r *valueResolver) NativeFunction(name string) (*analysis.Function, bool) {
+	vm := r.importVM()
+	if vm == nil {
+		// unknown, not reported
+		return nil, true
+	}
+	fn, ok := vm.natives[name]
+	return fn, ok
+}
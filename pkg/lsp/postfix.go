@@ -0,0 +1,106 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// postfixTemplate is completed after the dot of an expression (`arr.map`), and replaces
+// the expression with a std call or a construct wrapping it.
+type postfixTemplate struct {
+	label  string
+	detail string
+	// the snippet with the text of the expression as its argument
+	format string
+	// the types of the expressions it is completed for, values whose type is not known
+	// complete every template
+	types []analysis.ValueType
+}
+
+var postfixTemplates = []postfixTemplate{
+	{label: "map", detail: "std.map(func, arr)", format: "std.map(function(${1:x}) ${0:x}, %s)", types: []analysis.ValueType{analysis.ArrayType}},
+	{label: "filter", detail: "std.filter(func, arr)", format: "std.filter(function(${1:x}) ${0:true}, %s)", types: []analysis.ValueType{analysis.ArrayType}},
+	{label: "join", detail: "std.join(sep, arr)", format: "std.join(${1:','}, %s)$0", types: []analysis.ValueType{analysis.ArrayType}},
+	{label: "prune", detail: "std.prune(a)", format: "std.prune(%s)$0", types: []analysis.ValueType{analysis.ArrayType, analysis.ObjectType}},
+	{label: "length", detail: "std.length(x)", format: "std.length(%s)$0", types: []analysis.ValueType{analysis.ArrayType, analysis.ObjectType, analysis.StringType, analysis.FunctionType}},
+	{label: "if", detail: "if cond then a else b", format: "if %s then ${1:a} else ${0:b}", types: []analysis.ValueType{analysis.BooleanType}},
+}
+
+func (t postfixTemplate) accepts(typ analysis.ValueType) bool {
+	if typ == analysis.AnyType {
+		return true
+	}
+	for _, accepted := range t.types {
+		if accepted == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeSnippet escapes the characters of text that have a meaning in snippets.
+func escapeSnippet(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `$`, `\$`, `}`, `\}`).Replace(text)
+}
+
+// postfixCandidates returns the postfix templates of the expression completed after its dot.
+// The edit of an item replaces the expression as well, so it must be on the line of the
+// cursor.
+func (s *Server) postfixCandidates(u uri.URI, contents string, node ast.Node, cursor ast.Location, resolver *valueResolver) []completionCandidate {
+	if node == nil || node.Loc() == nil || !node.Loc().IsSet() || node.Loc().Begin.Line != cursor.Line {
+		return nil
+	}
+	begin, end := locToOffset(contents, node.Loc().Begin), locToOffset(contents, node.Loc().End)
+	dot := locToOffset(contents, cursor) - len(completionPrefix(contents, cursor)) - 1
+	if end > dot || dot < 0 || contents[dot] != '.' {
+		return nil
+	}
+	// the parentheses around the expression are not part of its node, they are replaced
+	// as well but the templates are calls which do not need them
+	expr := contents[begin:end]
+	for _, c := range strings.TrimSpace(contents[end:dot]) {
+		if c != ')' {
+			return nil
+		}
+		before := strings.TrimRight(contents[:begin], " \t")
+		if !strings.HasSuffix(before, "(") {
+			return nil
+		}
+		begin = len(before) - 1
+	}
+	if strings.Contains(contents[begin:dot], "\n") {
+		return nil
+	}
+
+	typ := analysis.NodeToValue(node, resolver).Type
+	if typ == analysis.AnyType {
+		typ = analysis.InferShape(node, resolver).Type
+	}
+	lineStart := strings.LastIndexByte(contents[:begin], '\n') + 1
+	edit := s.positions().rangeToClient(u, protocol.Range{
+		Start: protocol.Position{Line: uint32(cursor.Line - 1), Character: uint32(begin - lineStart)},
+		End:   posToProto(cursor),
+	})
+	res := []completionCandidate{}
+	for _, t := range postfixTemplates {
+		if !t.accepts(typ) {
+			continue
+		}
+		text := fmt.Sprintf(t.format, escapeSnippet(expr))
+		res = append(res, completionCandidate{item: s.snippetItem(protocol.CompletionItem{
+			Label:            t.label,
+			Detail:           t.detail,
+			Kind:             protocol.CompletionItemKindSnippet,
+			InsertTextFormat: protocol.InsertTextFormatSnippet,
+			// clients filter the items by the text of the edit before the cursor
+			FilterText: contents[begin:dot] + "." + t.label,
+			TextEdit:   &protocol.TextEdit{Range: edit, NewText: text},
+		}), typ: analysis.AnyType})
+	}
+	return res
+}
@@ -0,0 +1,174 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Live previews of the output of a file. The client opens a preview with `jsonnet/preview`,
+// and the server pushes the new output with `jsonnet/previewDidChange` every time it
+// changes, until the preview or the file is closed.
+const (
+	methodPreview          = "jsonnet/preview"
+	methodClosePreview     = "jsonnet/closePreview"
+	methodPreviewDidChange = "jsonnet/previewDidChange"
+)
+
+// previewDelay is how long previews wait for the user to stop typing before they are
+// evaluated again.
+const previewDelay = 300 * time.Millisecond
+
+type PreviewParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	// Format of the output, `json` (the default) or `yaml`.
+	Format string `json:"format,omitempty"`
+	// Field is a top level field to preview instead of the whole file.
+	Field string `json:"field,omitempty"`
+}
+
+type PreviewDidChangeParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Format       string                          `json:"format"`
+	Field        string                          `json:"field,omitempty"`
+	Output       string                          `json:"output"`
+}
+
+type previewKey struct {
+	uri    uri.URI
+	format string
+	field  string
+}
+
+// previewSet is the open previews, with the last output sent for each of them.
+type previewSet struct {
+	lock  sync.Mutex
+	open  map[previewKey]string
+	timer *time.Timer
+}
+
+func previewFormat(format string) (string, error) {
+	switch format {
+	case "", "json":
+		return "json", nil
+	case "yaml":
+		return "yaml", nil
+	}
+	return "", fmt.Errorf("%w: unknown preview format '%s'", jsonrpc2.ErrInvalidParams, format)
+}
+
+// Preview opens a live preview of the file, and returns its current output.
+func (s *Server) Preview(ctx context.Context, params *PreviewParams) (*EvaluateResult, error) {
+	format, err := previewFormat(params.Format)
+	if err != nil {
+		return nil, err
+	}
+	key := previewKey{uri: params.TextDocument.URI, format: format, field: params.Field}
+	out, err := s.previewOutput(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.previews.lock.Lock()
+	defer s.previews.lock.Unlock()
+	if s.previews.open == nil {
+		s.previews.open = map[previewKey]string{}
+	}
+	s.previews.open[key] = out
+	return &EvaluateResult{Output: out}, nil
+}
+
+// ClosePreview stops updating a preview.
+func (s *Server) ClosePreview(_ context.Context, params *PreviewParams) (interface{}, error) {
+	format, err := previewFormat(params.Format)
+	if err != nil {
+		return nil, err
+	}
+	s.previews.lock.Lock()
+	defer s.previews.lock.Unlock()
+	delete(s.previews.open, previewKey{uri: params.TextDocument.URI, format: format, field: params.Field})
+	return nil, nil
+}
+
+// closePreviews stops updating the previews of a closed file.
+func (s *Server) closePreviews(u uri.URI) {
+	s.previews.lock.Lock()
+	defer s.previews.lock.Unlock()
+	for key := range s.previews.open {
+		if key.uri == u {
+			delete(s.previews.open, key)
+		}
+	}
+}
+
+func (s *Server) previewOutput(ctx context.Context, key previewKey) (string, error) {
+	params := &EvaluateParams{TextDocument: &protocol.TextDocumentIdentifier{URI: key.uri}, Field: key.field}
+	var res *EvaluateResult
+	var err error
+	if key.format == "yaml" {
+		res, err = s.EvaluateYaml(ctx, params)
+	} else {
+		res, err = s.Evaluate(ctx, params)
+	}
+	if err != nil {
+		return "", err
+	}
+	return res.Output, nil
+}
+
+// schedulePreviews evaluates the open previews again once the files stop changing. Every
+// preview is evaluated, as the file that changed can be imported by any of them.
+func (s *Server) schedulePreviews() {
+	s.previews.lock.Lock()
+	defer s.previews.lock.Unlock()
+	if len(s.previews.open) == 0 {
+		return
+	}
+	if s.previews.timer != nil {
+		s.previews.timer.Stop()
+	}
+	s.previews.timer = time.AfterFunc(previewDelay, s.refreshPreviews)
+}
+
+// refreshPreviews sends the output of the previews that changed to the client.
+func (s *Server) refreshPreviews() {
+	s.previews.lock.Lock()
+	keys := make([]previewKey, 0, len(s.previews.open))
+	for key := range s.previews.open {
+		keys = append(keys, key)
+	}
+	s.previews.lock.Unlock()
+
+	ctx := context.Background()
+	for _, key := range keys {
+		out, err := s.previewOutput(ctx, key)
+		if err != nil {
+			logf("failed to update preview of %s: %v", key.uri, err)
+			continue
+		}
+
+		s.previews.lock.Lock()
+		last, open := s.previews.open[key]
+		if open {
+			s.previews.open[key] = out
+		}
+		s.previews.lock.Unlock()
+		if !open || last == out || s.conn == nil {
+			continue
+		}
+		err = s.conn.Notify(ctx, methodPreviewDidChange, &PreviewDidChangeParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: key.uri},
+			Format:       key.format,
+			Field:        key.field,
+			Output:       out,
+		})
+		if err != nil {
+			logf("failed to send preview of %s: %v", key.uri, err)
+		}
+	}
+}
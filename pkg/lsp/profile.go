@@ -0,0 +1,147 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/uri"
+)
+
+type ProfileEntry struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type ProfileResult struct {
+	// Output is the report formatted as text.
+	Output  string         `json:"output"`
+	Seconds float64        `json:"seconds"`
+	Imports []ProfileEntry `json:"imports"`
+	Fields  []ProfileEntry `json:"fields"`
+}
+
+// Profile evaluates the file, and each of its imports and top level fields on their own,
+// to find where the evaluation time is spent. Every part is evaluated with a new VM, so
+// the time of a part includes the locals and imports it uses, even if other parts use
+// them too.
+func (s *Server) Profile(ctx context.Context, params *EvaluateParams) (*ProfileResult, error) {
+	u := params.TextDocument.URI
+	root := s.getCurrentAST(u)
+	if root == nil {
+		return nil, fmt.Errorf("cannot get jsonnet VM for file '%s'", u.Filename())
+	}
+	p := s.startProgress("Profiling", u.Filename())
+	defer p.end("")
+
+	res := &ProfileResult{Imports: []ProfileEntry{}, Fields: []ProfileEntry{}}
+	var err error
+	start := time.Now()
+	s.newVMCache(u).Use(func(vm *jsonnet.VM) {
+		_, err = vm.Evaluate(root)
+	})
+	res.Seconds = time.Since(start).Seconds()
+
+	// the file imports itself by its absolute path, which is found whatever the import
+	// order, unlike its name that could be a file at the root of the workspace
+	self, _ := json.Marshal(u.Filename())
+	for _, path := range codeImports(root) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lit, _ := json.Marshal(path)
+		res.Imports = append(res.Imports, s.profileSnippet(u, path, fmt.Sprintf("import %s", lit)))
+	}
+
+	// the fields of files that evaluate to objects, f.ex Tanka environments
+	var fields []string
+	if out, err := s.evaluateSnippet(u, fmt.Sprintf("local v = import %s; if std.isObject(v) then std.objectFields(v) else []", self)); err == nil {
+		_ = json.Unmarshal([]byte(out), &fields)
+	}
+	for _, name := range fields {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lit, _ := json.Marshal(name)
+		res.Fields = append(res.Fields, s.profileSnippet(u, name, fmt.Sprintf("(import %s)[%s]", self, lit)))
+	}
+
+	res.Output = res.format()
+	if err != nil {
+		// the parts that do not fail are still profiled
+		res.Output = formatRuntimeError(err) + "\n" + res.Output
+	}
+	return res, nil
+}
+
+// profileSnippet times the evaluation of an expression from the file.
+func (s *Server) profileSnippet(u uri.URI, name, expr string) ProfileEntry {
+	res := ProfileEntry{Name: name}
+	start := time.Now()
+	if _, err := s.evaluateSnippet(u, analysis.ForceSnippet(expr)); err != nil {
+		res.Error = err.Error()
+	}
+	res.Seconds = time.Since(start).Seconds()
+	return res
+}
+
+// evaluateSnippet evaluates code with a new VM, imports are relative to the file.
+func (s *Server) evaluateSnippet(u uri.URI, snippet string) (out string, err error) {
+	node, err := jsonnet.SnippetToAST(u.Filename(), snippet)
+	if err != nil {
+		return "", err
+	}
+	s.newVMCache(u).Use(func(vm *jsonnet.VM) {
+		out, err = vm.Evaluate(node)
+	})
+	return out, err
+}
+
+// codeImports returns the paths imported as code by the file, in order.
+func codeImports(root ast.Node) []string {
+	res := []string{}
+	seen := map[string]bool{}
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		if imp, ok := n.(*ast.Import); ok && !seen[imp.File.Value] {
+			seen[imp.File.Value] = true
+			res = append(res, imp.File.Value)
+		}
+		return true
+	})
+	return res
+}
+
+func (r *ProfileResult) format() string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "Total: %s\n", formatSeconds(r.Seconds))
+	sections := []struct {
+		title   string
+		entries []ProfileEntry
+	}{{"Imports", r.Imports}, {"Top level fields", r.Fields}}
+	for _, sec := range sections {
+		if len(sec.entries) == 0 {
+			continue
+		}
+		entries := append([]ProfileEntry{}, sec.entries...)
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Seconds > entries[j].Seconds })
+		fmt.Fprintf(&sb, "\n%s:\n", sec.title)
+		for _, e := range entries {
+			fmt.Fprintf(&sb, "  %10s  %s\n", formatSeconds(e.Seconds), e.Name)
+			if e.Error != "" {
+				fmt.Fprintf(&sb, "              error: %s\n", strings.SplitN(e.Error, "\n", 2)[0])
+			}
+		}
+	}
+	return sb.String()
+}
+
+func formatSeconds(sec float64) string {
+	return time.Duration(sec * float64(time.Second)).Round(time.Millisecond).String()
+}
@@ -0,0 +1,105 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.lsp.dev/protocol"
+)
+
+// progressDelay is how long an operation runs before its progress is shown, so that quick
+// operations do not flicker in the editor.
+const progressDelay = 500 * time.Millisecond
+
+// progressTokens numbers the progress tokens created by the server.
+var progressTokens int32
+
+// workProgress reports the progress of a long operation with `window/workDoneProgress`.
+// The progress is only created in the client once the operation has been running for
+// progressDelay, and does nothing if the client does not support it.
+type workProgress struct {
+	s     *Server
+	title string
+	timer *time.Timer
+
+	lock       sync.Mutex
+	message    string
+	percentage uint32
+	done       bool
+	// set once the progress has begun in the client
+	token *protocol.ProgressToken
+}
+
+// startProgress starts reporting the progress of an operation. The returned progress
+// must be ended.
+func (s *Server) startProgress(title, message string) *workProgress {
+	p := &workProgress{s: s, title: title, message: message}
+	if s.workDoneProgress {
+		p.timer = time.AfterFunc(progressDelay, p.begin)
+	}
+	return p
+}
+
+func (p *workProgress) begin() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.done {
+		return
+	}
+	// Progress messages are not tied to the context of the operation: the progress must
+	// still end if the request that started it is canceled.
+	ctx := context.Background()
+	token := protocol.NewProgressToken(fmt.Sprintf("jsonnet-lsp/%d", atomic.AddInt32(&progressTokens, 1)))
+	if err := p.s.notifier.WorkDoneProgressCreate(ctx, &protocol.WorkDoneProgressCreateParams{Token: *token}); err != nil {
+		logf("failed to create progress %q: %v", p.title, err)
+		return
+	}
+	p.token = token
+	_ = p.s.notifier.Progress(ctx, &protocol.ProgressParams{Token: *token, Value: &protocol.WorkDoneProgressBegin{
+		Kind:       protocol.WorkDoneProgressKindBegin,
+		Title:      p.title,
+		Message:    p.message,
+		Percentage: p.percentage,
+	}})
+}
+
+// report updates the message and percentage (from 0 to 100) of the progress.
+func (p *workProgress) report(message string, percentage uint32) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.message == message && p.percentage == percentage {
+		return
+	}
+	p.message, p.percentage = message, percentage
+	if p.token == nil || p.done {
+		return
+	}
+	_ = p.s.notifier.Progress(context.Background(), &protocol.ProgressParams{Token: *p.token, Value: &protocol.WorkDoneProgressReport{
+		Kind:       protocol.WorkDoneProgressKindReport,
+		Message:    message,
+		Percentage: percentage,
+	}})
+}
+
+// end ends the progress with a final message.
+func (p *workProgress) end(message string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.done {
+		return
+	}
+	p.done = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if p.token == nil {
+		return
+	}
+	_ = p.s.notifier.Progress(context.Background(), &protocol.ProgressParams{Token: *p.token, Value: &protocol.WorkDoneProgressEnd{
+		Kind:    protocol.WorkDoneProgressKindEnd,
+		Message: message,
+	}})
+}
@@ -0,0 +1,78 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/overlay"
+	"sigs.k8s.io/yaml"
+)
+
+// projectConfigFiles are the names of the project configuration file at the root of the
+// workspace, in order of preference. It has the same settings as the client.
+var projectConfigFiles = []string{".jsonnet-lsp.yaml", ".jsonnet-lsp.yml", ".jsonnet-lsp.json"}
+
+// projectConfigPollInterval is how often the project configuration file is checked for changes.
+const projectConfigPollInterval = 2 * time.Second
+
+// readProjectConfig returns the project configuration file as JSON, or nil if there is none.
+func readProjectConfig(root fs.FS) ([]byte, error) {
+	for _, name := range projectConfigFiles {
+		data, err := fs.ReadFile(root, name)
+		if err != nil {
+			continue
+		}
+		if filepath.Ext(name) == ".json" {
+			return data, nil
+		}
+		return yaml.YAMLToJSON(data)
+	}
+	return nil, nil
+}
+
+// watchProjectConfig loads the project configuration file, and polls it for changes until
+// the context is done. The configuration is re-applied whenever it changes.
+func (s *Server) watchProjectConfig(ctx context.Context) {
+	ticker := time.NewTicker(projectConfigPollInterval)
+	defer ticker.Stop()
+	for {
+		var data []byte
+		var err error
+		if main := s.mainFolder(); main != nil {
+			data, err = readProjectConfig(main.fs)
+		}
+		if err != nil {
+			logf("failed to read project configuration: %v", err)
+		}
+		s.configLock.Lock()
+		changed := err == nil && !bytes.Equal(data, s.projectConfig)
+		if changed {
+			s.projectConfig = data
+		}
+		s.configLock.Unlock()
+		if changed {
+			if s.applyConfiguration() {
+				go s.indexWorkspace(ctx)
+			}
+			s.refreshDiagnostics(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshDiagnostics publishes the diagnostics of every open file again, f.ex after the
+// configuration changed.
+func (s *Server) refreshDiagnostics(ctx context.Context) {
+	for _, u := range s.overlay.Open() {
+		update := overlay.UpdateResult{Current: s.overlay.Current(u), Parsed: s.overlay.Parsed(u)}
+		go s.processFileUpdateFn(ctx, u, false)(update)
+	}
+}
@@ -0,0 +1,220 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Pull diagnostics were added in LSP 3.17. Clients that support them request the
+// diagnostics of the files they show, instead of the server publishing them for every file
+// that changes.
+const (
+	methodDocumentDiagnostic = "textDocument/diagnostic"
+	methodDiagnosticRefresh  = "workspace/diagnostic/refresh"
+)
+
+type DocumentDiagnosticParams struct {
+	TextDocument     protocol.TextDocumentIdentifier `json:"textDocument"`
+	Identifier       string                          `json:"identifier,omitempty"`
+	PreviousResultID string                          `json:"previousResultId,omitempty"`
+}
+
+// The kinds of diagnostic reports, a full report has every diagnostic of the file, and an
+// unchanged report has none as they are the same as in the previous result.
+const (
+	diagnosticReportFull      = "full"
+	diagnosticReportUnchanged = "unchanged"
+)
+
+type FullDocumentDiagnosticReport struct {
+	Kind     string                `json:"kind"`
+	ResultID string                `json:"resultId,omitempty"`
+	Items    []protocol.Diagnostic `json:"items"`
+}
+
+type UnchangedDocumentDiagnosticReport struct {
+	Kind     string `json:"kind"`
+	ResultID string `json:"resultId"`
+}
+
+type diagnosticOptions struct {
+	InterFileDependencies bool `json:"interFileDependencies"`
+	WorkspaceDiagnostics  bool `json:"workspaceDiagnostics"`
+}
+
+// pullDiagnosticsSupport reads the `textDocument.diagnostic` client capability in the raw
+// `initialize` params, and whether the client can be asked to pull the diagnostics again.
+func pullDiagnosticsSupport(params json.RawMessage) (pull, refresh bool) {
+	caps := struct {
+		Capabilities struct {
+			TextDocument struct {
+				Diagnostic *struct{} `json:"diagnostic"`
+			} `json:"textDocument"`
+			Workspace struct {
+				Diagnostics struct {
+					RefreshSupport bool `json:"refreshSupport"`
+				} `json:"diagnostics"`
+			} `json:"workspace"`
+		} `json:"capabilities"`
+	}{}
+	if err := json.Unmarshal(params, &caps); err != nil {
+		return false, false
+	}
+	return caps.Capabilities.TextDocument.Diagnostic != nil, caps.Capabilities.Workspace.Diagnostics.RefreshSupport
+}
+
+// diagReport is the last diagnostics checked for a version of a file, in the encoding of
+// the client.
+type diagReport struct {
+	version  int64
+	resultID string
+	items    []protocol.Diagnostic
+}
+
+// diagReports are the diagnostics of the open files, for the clients that pull them.
+type diagReports struct {
+	lock    sync.Mutex
+	reports map[uri.URI]diagReport
+	// the result IDs last sent to the client
+	pulled map[uri.URI]string
+	// the last versions of the files sent by the client, they may not be parsed yet
+	versions map[uri.URI]int64
+	// closed when a report is stored, pulls of a file that is being checked wait on it
+	stored chan struct{}
+	nextID int64
+}
+
+// put stores the diagnostics of a version of a file. The result ID only changes when the
+// diagnostics change, so the client is told they are unchanged. Returns true if the client
+// pulled other diagnostics for the file, and should pull them again.
+func (d *diagReports) put(u uri.URI, version int64, items []protocol.Diagnostic) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.reports == nil {
+		d.reports = map[uri.URI]diagReport{}
+		d.pulled = map[uri.URI]string{}
+	}
+	prev, ok := d.reports[u]
+	if ok && prev.version > version {
+		return false
+	}
+	if items == nil {
+		items = []protocol.Diagnostic{}
+	}
+	rep := diagReport{version: version, resultID: prev.resultID, items: items}
+	if !ok || !sameDiagnostics(prev.items, items) {
+		d.nextID++
+		rep.resultID = strconv.FormatInt(d.nextID, 10)
+	}
+	d.reports[u] = rep
+	if d.stored != nil {
+		close(d.stored)
+		d.stored = nil
+	}
+	pulled, ok := d.pulled[u]
+	return ok && pulled != rep.resultID
+}
+
+// changed records the version of a file the client sent.
+func (d *diagReports) changed(u uri.URI, version int64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.versions == nil {
+		d.versions = map[uri.URI]int64{}
+	}
+	d.versions[u] = version
+}
+
+// get returns the report of a file, whether it is for the last version of the file, and a
+// channel closed when the next report is stored. Returns false if the file is not open.
+func (d *diagReports) get(u uri.URI) (rep diagReport, ok, current, open bool, stored <-chan struct{}) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.stored == nil {
+		d.stored = make(chan struct{})
+	}
+	version, open := d.versions[u]
+	rep, ok = d.reports[u]
+	return rep, ok, ok && rep.version >= version, open, d.stored
+}
+
+// sent records the result ID sent to the client for a file.
+func (d *diagReports) sent(u uri.URI, resultID string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.pulled == nil {
+		d.pulled = map[uri.URI]string{}
+	}
+	d.pulled[u] = resultID
+}
+
+// drop forgets the report of a file, f.ex when it is closed.
+func (d *diagReports) drop(u uri.URI) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.reports, u)
+	delete(d.pulled, u)
+	delete(d.versions, u)
+}
+
+func sameDiagnostics(a, b []protocol.Diagnostic) bool {
+	da, _ := json.Marshal(a)
+	db, _ := json.Marshal(b)
+	return string(da) == string(db)
+}
+
+// DocumentDiagnostic returns the diagnostics of an open file. Requests are handled in order,
+// so if the client can be asked to pull again, the last diagnostics are returned right
+// away while the file is being checked, and the client pulls again once it is done.
+// Otherwise the request waits for the version in the editor to be checked. Files that are
+// not open have no diagnostics.
+func (s *Server) DocumentDiagnostic(ctx context.Context, params *DocumentDiagnosticParams) (interface{}, error) {
+	u := params.TextDocument.URI
+	for {
+		rep, ok, current, open, stored := s.diagReports.get(u)
+		if !open {
+			return &FullDocumentDiagnosticReport{Kind: diagnosticReportFull, Items: []protocol.Diagnostic{}}, nil
+		}
+		if current || (ok && s.diagnosticRefresh) {
+			s.diagReports.sent(u, rep.resultID)
+			if rep.resultID == params.PreviousResultID {
+				return &UnchangedDocumentDiagnosticReport{Kind: diagnosticReportUnchanged, ResultID: rep.resultID}, nil
+			}
+			return &FullDocumentDiagnosticReport{Kind: diagnosticReportFull, ResultID: rep.resultID, Items: rep.items}, nil
+		}
+		if !ok && s.diagnosticRefresh {
+			s.diagReports.sent(u, "")
+			return &FullDocumentDiagnosticReport{Kind: diagnosticReportFull, Items: []protocol.Diagnostic{}}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-stored:
+		}
+	}
+}
+
+// diagnosticRefreshDelay is how long the server waits for the other files being checked
+// before asking the client to pull their diagnostics again.
+const diagnosticRefreshDelay = 100 * time.Millisecond
+
+// refreshDiagnosticPulls asks the client to pull the diagnostics of its files again, once
+// the files being checked are done.
+func (s *Server) refreshDiagnosticPulls(ctx context.Context) {
+	if !s.diagnosticRefresh || s.conn == nil || !atomic.CompareAndSwapInt32(&s.diagnosticRefreshing, 0, 1) {
+		return
+	}
+	time.AfterFunc(diagnosticRefreshDelay, func() {
+		atomic.StoreInt32(&s.diagnosticRefreshing, 0)
+		if _, err := s.conn.Call(ctx, methodDiagnosticRefresh, nil, nil); err != nil {
+			logf("failed to refresh diagnostics: %v", err)
+		}
+	})
+}
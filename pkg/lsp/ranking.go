@@ -0,0 +1,216 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+// maxCompletionItems is the number of items of a completion list. Longer lists, f.ex the
+// fields of the k8s libraries, only have the best matches of the text typed so far, and
+// the client asks for the list again as the user types.
+const maxCompletionItems = 200
+
+// commandCompletionAccepted is run by the client when the user picks a completion item,
+// the items picked recently are ranked higher.
+const commandCompletionAccepted = "jsonnet.lsp.completionAccepted"
+
+// maxRecentCompletions is the number of recently picked items that are ranked higher.
+const maxRecentCompletions = 50
+
+// Bonuses of the context of a completion item, added to its fuzzyScore for the text typed so
+// far. They are worth about as much as one or two characters matched better.
+const (
+	scopeBonus    = 3
+	maxScopeBonus = 60
+	recentBonus   = 150
+	typeBonus     = 100
+)
+
+type CompletionAcceptedParams struct {
+	Label string `json:"label"`
+}
+
+// recentCompletions is the labels of the items picked recently, from the oldest one.
+type recentCompletions struct {
+	lock   sync.Mutex
+	labels []string
+}
+
+func (r *recentCompletions) add(label string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, l := range r.labels {
+		if l == label {
+			r.labels = append(r.labels[:i], r.labels[i+1:]...)
+			break
+		}
+	}
+	r.labels = append(r.labels, label)
+	if len(r.labels) > maxRecentCompletions {
+		r.labels = r.labels[len(r.labels)-maxRecentCompletions:]
+	}
+}
+
+// bonuses returns the bonus of each recent label, the last one picked has the highest.
+func (r *recentCompletions) bonuses() map[string]int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	res := map[string]int{}
+	for i, l := range r.labels {
+		res[l] = recentBonus * (i + 1) / len(r.labels)
+	}
+	return res
+}
+
+// completionCandidate is a completion item along with what it is ranked by.
+type completionCandidate struct {
+	item protocol.CompletionItem
+	// the type of the value, AnyType if it is not known
+	typ analysis.ValueType
+	// the depth of the scope of a variable, nearer scopes are deeper
+	depth int
+}
+
+// completionRanker sorts completion items by how well they match the text typed before the
+// cursor, and by their context.
+type completionRanker struct {
+	prefix string
+	// the type of the value expected at the cursor, AnyType if it is not known
+	expected analysis.ValueType
+	recent   map[string]int
+}
+
+func (s *Server) completionRanker(contents string, cursor ast.Location, stack []ast.Node, resolver analysis.Resolver) completionRanker {
+	return completionRanker{
+		prefix:   completionPrefix(contents, cursor),
+		expected: expectedType(stack, resolver),
+		recent:   s.recentCompletions.bonuses(),
+	}
+}
+
+// rank returns the items from the best one. When there are more than maxCompletionItems,
+// only the best of the items matching the text typed so far are returned, and the list
+// is incomplete.
+func (r completionRanker) rank(candidates []completionCandidate) ([]protocol.CompletionItem, bool) {
+	type scored struct {
+		item    protocol.CompletionItem
+		score   int
+		matches bool
+	}
+	res := make([]scored, 0, len(candidates))
+	matching := 0
+	for _, c := range candidates {
+		score, matches := fuzzyScore(r.prefix, c.item.Label)
+		if matches {
+			matching++
+		}
+		if c.depth > 0 {
+			score += minInt(c.depth*scopeBonus, maxScopeBonus)
+		}
+		score += r.recent[c.item.Label]
+		if r.expected != analysis.AnyType && c.typ == r.expected {
+			score += typeBonus
+		}
+		res = append(res, scored{item: c.item, score: score, matches: matches})
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].matches != res[j].matches {
+			return res[i].matches
+		}
+		if res[i].score != res[j].score {
+			return res[i].score > res[j].score
+		}
+		return res[i].item.Label < res[j].item.Label
+	})
+
+	incomplete := len(res) > maxCompletionItems
+	if incomplete {
+		res = res[:minInt(matching, maxCompletionItems)]
+	}
+	items := make([]protocol.CompletionItem, len(res))
+	for i, sc := range res {
+		items[i] = sc.item
+		// clients sort the items they show by their sort text
+		items[i].SortText = fmt.Sprintf("%04d_%s", i, sc.item.Label)
+		if items[i].Command == nil {
+			items[i].Command = acceptedCommand(sc.item.Label)
+		}
+	}
+	return items, incomplete
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// expectedType returns the type of the value expected at the top of the stack: the type of
+// the other operand of a binary operator, of the parameter of an argument, or a boolean
+// for a condition. Returns AnyType if it is not known.
+func expectedType(stack []ast.Node, resolver analysis.Resolver) analysis.ValueType {
+	if len(stack) < 2 {
+		return analysis.AnyType
+	}
+	node := stack[len(stack)-1]
+	switch parent := stack[len(stack)-2].(type) {
+	case *ast.Binary:
+		other := parent.Left
+		if node == parent.Left {
+			other = parent.Right
+		}
+		switch parent.Op {
+		case ast.BopAnd, ast.BopOr:
+			return analysis.BooleanType
+		case ast.BopPlus, ast.BopMinus, ast.BopMult, ast.BopDiv, ast.BopLess, ast.BopLessEq,
+			ast.BopGreater, ast.BopGreaterEq, ast.BopManifestEqual, ast.BopManifestUnequal:
+			return analysis.NodeToValue(other, resolver).Type
+		}
+	case *ast.Conditional:
+		if node == parent.Cond {
+			return analysis.BooleanType
+		}
+	case *ast.Apply:
+		fn := analysis.NodeToValue(parent.Target, resolver).Function
+		if fn == nil {
+			return analysis.AnyType
+		}
+		for i, arg := range parent.Arguments.Positional {
+			if arg.Expr == node && i < len(fn.Params) {
+				return fn.Params[i].Type
+			}
+		}
+		for _, arg := range parent.Arguments.Named {
+			if arg.Arg != node {
+				continue
+			}
+			for _, p := range fn.Params {
+				if p.Name == string(arg.Name) {
+					return p.Type
+				}
+			}
+		}
+	}
+	return analysis.AnyType
+}
+
+// CompletionAccepted records the completion item the user picked.
+func (s *Server) CompletionAccepted(params *CompletionAcceptedParams) (interface{}, error) {
+	if params.Label != "" {
+		s.recentCompletions.add(params.Label)
+	}
+	return nil, nil
+}
+
+// acceptedCommand returns the command the client runs when an item is picked.
+func acceptedCommand(label string) *protocol.Command {
+	data, _ := json.Marshal(&CompletionAcceptedParams{Label: label})
+	return &protocol.Command{Command: commandCompletionAccepted, Arguments: []interface{}{string(data)}}
+}
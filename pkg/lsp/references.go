@@ -0,0 +1,164 @@
+package lsp
+
+import (
+	"context"
+	"time"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+// fieldDefAt finds the object field at `pos`, either from the field name where it is
+// defined in an object, or from an index expression accessing it. It returns the field
+// name and the range of the name where it was defined.
+func fieldDefAt(resolver *valueResolver, pos ast.Location) (string, ast.LocationRange, bool) {
+	_, stack := resolver.NodeAt(pos)
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch n := stack[i].(type) {
+		case *ast.Index:
+			name, rng, ok := analysis.IndexNameRange(n)
+			if !ok || !analysis.LocInRange(rng, pos) {
+				continue
+			}
+			if fld := analysis.IndexField(n, resolver); fld != nil && fld.NameRange.IsSet() {
+				return name, fld.NameRange, true
+			}
+			return "", ast.LocationRange{}, false
+		case *ast.DesugaredObject:
+			for j := range n.Fields {
+				name, rng, ok := analysis.FieldNameRange(&n.Fields[j])
+				if ok && analysis.LocInRange(rng, pos) {
+					return name, rng, true
+				}
+			}
+		}
+	}
+	return "", ast.LocationRange{}, false
+}
+
+// fieldAccesses returns the ranges of the names of index expressions that resolve to
+// the field defined at `def`.
+func fieldAccesses(resolver *valueResolver, accesses []*ast.Index, def ast.LocationRange) []ast.LocationRange {
+	res := []ast.LocationRange{}
+	for _, idx := range accesses {
+		fld := analysis.IndexField(idx, resolver)
+		if fld == nil || !analysis.SameRange(fld.NameRange, def) {
+			continue
+		}
+		if _, rng, ok := analysis.IndexNameRange(idx); ok {
+			res = append(res, rng)
+		}
+	}
+	return res
+}
+
+// fieldReferences searches the workspace for index expressions that resolve to the
+// field named `name` defined at `def`. Only the files that import the file of the
+// definition (directly or not) are searched.
+func (s *Server) fieldReferences(ctx context.Context, current *valueResolver, name string, def ast.LocationRange) []ast.LocationRange {
+	defer func(t time.Time) { tracef("field references for '%s' in %s", name, time.Since(t)) }(time.Now())
+	res := []ast.LocationRange{}
+
+	// Use a single VM for the whole search so imports are only parsed once
+	vm := s.newVMCache(current.rootURI)
+	search := func(resolver *valueResolver, accesses []*ast.Index) {
+		res = append(res, fieldAccesses(resolver, accesses, def)...)
+	}
+
+	seenCurrent := false
+	for _, f := range s.importingFiles(ctx, def.FileName, name) {
+		if ctx.Err() != nil {
+			return res
+		}
+		if f.root == current.rootAST {
+			seenCurrent = true
+			search(current, f.refs.Fields[name])
+			continue
+		}
+		if accesses := f.refs.Fields[name]; len(accesses) > 0 {
+			search(newRootResolver(ctx, f.root, vm), accesses)
+		}
+	}
+
+	// The current file may be outside of the workspace, or not yet indexed
+	if !seenCurrent {
+		search(current, analysis.BuildRefIndex(current.rootAST).Fields[name])
+	}
+	return res
+}
+
+func (s *Server) References(ctx context.Context, params *protocol.ReferenceParams) ([]protocol.Location, error) {
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
+	if resolver == nil {
+		return []protocol.Location{}, nil
+	}
+	positions := s.positions()
+	pos := protoToPos(positions.fromClient(params.TextDocument.URI, params.Position))
+	return positions.locationsToClient(s.references(ctx, resolver, pos, params.Context.IncludeDeclaration)), nil
+}
+
+// references returns the references of the variable or field at `pos`, and its
+// declaration if `includeDecl` is set.
+func (s *Server) references(ctx context.Context, resolver *valueResolver, pos ast.Location, includeDecl bool) []protocol.Location {
+	res := []protocol.Location{}
+
+	// Local variables can only be referenced in the file they are declared in
+	if bind := analysis.BindingAtLoc(resolver.rootAST, pos); bind != nil {
+		if decl := bind.Decl(); includeDecl && decl != nil && decl.Range.IsSet() {
+			res = append(res, rangeToLocation(decl.Range))
+		}
+		for _, v := range analysis.BindingReferences(bind) {
+			res = append(res, rangeToLocation(v.LocRange))
+		}
+		return res
+	}
+
+	// Object fields can be referenced from any file that imports them
+	name, def, ok := fieldDefAt(resolver, pos)
+	if !ok {
+		return res
+	}
+	if includeDecl {
+		res = append(res, rangeToLocation(def))
+	}
+	for _, rng := range s.fieldReferences(ctx, resolver, name, def) {
+		res = append(res, rangeToLocation(rng))
+	}
+	return res
+}
+
+// DocumentHighlight highlights the declaration and references of the variable or field
+// under the cursor in the current file.
+func (s *Server) DocumentHighlight(ctx context.Context, params *protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
+	res := []protocol.DocumentHighlight{}
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
+	if resolver == nil {
+		return res, nil
+	}
+	positions := s.positions()
+	pos := protoToPos(positions.fromClient(params.TextDocument.URI, params.Position))
+
+	if bind := analysis.BindingAtLoc(resolver.rootAST, pos); bind != nil {
+		if decl := bind.Decl(); decl != nil && decl.Range.IsSet() {
+			res = append(res, protocol.DocumentHighlight{Range: rangeToProto(decl.Range), Kind: protocol.DocumentHighlightKindWrite})
+		}
+		for _, v := range analysis.BindingReferences(bind) {
+			res = append(res, protocol.DocumentHighlight{Range: rangeToProto(v.LocRange), Kind: protocol.DocumentHighlightKindRead})
+		}
+		return positions.highlightsToClient(params.TextDocument.URI, res), nil
+	}
+
+	name, def, ok := fieldDefAt(resolver, pos)
+	if !ok {
+		return res, nil
+	}
+	// the field may be defined in another file
+	if def.FileName == resolver.rootAST.Loc().FileName {
+		res = append(res, protocol.DocumentHighlight{Range: rangeToProto(def), Kind: protocol.DocumentHighlightKindWrite})
+	}
+	for _, rng := range fieldAccesses(resolver, analysis.BuildRefIndex(resolver.rootAST).Fields[name], def) {
+		res = append(res, protocol.DocumentHighlight{Range: rangeToProto(rng), Kind: protocol.DocumentHighlightKindRead})
+	}
+	return positions.highlightsToClient(params.TextDocument.URI, res), nil
+}
@@ -0,0 +1,110 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// renameTarget is the symbol under the cursor for a rename. Exactly one of
+// `bind` or `field` is set.
+type renameTarget struct {
+	rng   ast.LocationRange
+	bind  *analysis.Binding
+	field string
+}
+
+func renameTargetAt(resolver *valueResolver, pos ast.Location) *renameTarget {
+	if bind := analysis.BindingAtLoc(resolver.rootAST, pos); bind != nil {
+		decl := bind.Decl()
+		// Desugared variables like `$` or `std` cannot be renamed
+		if decl == nil || !decl.Range.IsSet() {
+			return nil
+		}
+		// Return the range under the cursor, which may be a reference instead of the declaration
+		rng := decl.Range
+		if node, _ := resolver.NodeAt(pos); node != nil {
+			if v, ok := node.(*ast.Var); ok {
+				rng = v.LocRange
+			}
+		}
+		return &renameTarget{rng: rng, bind: bind}
+	}
+
+	if name, def, ok := fieldDefAt(resolver, pos); ok {
+		return &renameTarget{rng: def, field: name}
+	}
+	return nil
+}
+
+func (s *Server) PrepareRename(ctx context.Context, params *protocol.PrepareRenameParams) (*protocol.Range, error) {
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
+	if resolver == nil {
+		return nil, nil
+	}
+	positions := s.positions()
+	target := renameTargetAt(resolver, protoToPos(positions.fromClient(params.TextDocument.URI, params.Position)))
+	if target == nil {
+		return nil, nil
+	}
+	rng := positions.rangeToClient(params.TextDocument.URI, rangeToProto(target.rng))
+	return &rng, nil
+}
+
+// renameEdit builds the edit for a single occurrence of a renamed symbol. Field names
+// can be written as strings (`{'foo': 1}` or `x['foo']`), in which case the same
+// quotes are kept.
+func renameEdit(contents string, rng ast.LocationRange, newName string) protocol.TextEdit {
+	newText := newName
+	if old := textInRange(contents, rng); len(old) > 0 && (old[0] == '\'' || old[0] == '"') {
+		newText = string(old[0]) + newName + string(old[0])
+	}
+	return protocol.TextEdit{Range: rangeToProto(rng), NewText: newText}
+}
+
+func (s *Server) Rename(ctx context.Context, params *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
+	resolver := s.NewResolver(ctx, params.TextDocument.URI)
+	if resolver == nil {
+		return nil, fmt.Errorf("cannot rename in file '%s' which could not be parsed", params.TextDocument.URI.Filename())
+	}
+
+	if analysis.SafeIdent(params.NewName) != params.NewName {
+		return nil, fmt.Errorf("cannot rename to '%s' which is not a valid identifier", params.NewName)
+	}
+
+	positions := s.positions()
+	target := renameTargetAt(resolver, protoToPos(positions.fromClient(params.TextDocument.URI, params.Position)))
+	if target == nil {
+		return nil, fmt.Errorf("no symbol to rename at cursor")
+	}
+
+	ranges := []ast.LocationRange{}
+	if target.bind != nil {
+		ranges = append(ranges, target.bind.Decl().Range)
+		for _, v := range analysis.BindingReferences(target.bind) {
+			ranges = append(ranges, v.LocRange)
+		}
+	} else {
+		ranges = append(ranges, target.rng)
+		ranges = append(ranges, s.fieldReferences(ctx, resolver, target.field, target.rng)...)
+	}
+
+	res := &protocol.WorkspaceEdit{Changes: map[uri.URI][]protocol.TextEdit{}}
+	contents := map[uri.URI]string{}
+	for _, rng := range ranges {
+		u := uri.File(rng.FileName)
+		// keep the URI of the current document as the editor sent it
+		if rng.FileName == params.TextDocument.URI.Filename() {
+			u = params.TextDocument.URI
+		}
+		if _, ok := contents[u]; !ok {
+			contents[u], _ = s.fileContents(u)
+		}
+		res.Changes[u] = append(res.Changes[u], renameEdit(contents[u], rng, params.NewName))
+	}
+	return positions.workspaceEditToClient(res), nil
+}
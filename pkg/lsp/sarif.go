@@ -0,0 +1,201 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// SARIF 2.1.0 is the format CI systems read the results of static analysis from, f.ex
+// GitHub code scanning which annotates pull requests with them. Only the parts needed to
+// report diagnostics are written.
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	// the rule of the diagnostics without a code, which are the syntax errors
+	sarifParseErrorRule = "ParseError"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	RuleIndex        int             `json:"ruleIndex"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+}
+
+type sarifLocation struct {
+	ID               int                   `json:"id,omitempty"`
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// sarifRegion is 1-based, with columns in UTF-16 code units like LSP positions.
+type sarifRegion struct {
+	StartLine   uint32 `json:"startLine"`
+	StartColumn uint32 `json:"startColumn"`
+	EndLine     uint32 `json:"endLine"`
+	EndColumn   uint32 `json:"endColumn"`
+}
+
+// sarifLevels are the levels of results by the severity of diagnostics. SARIF has no hints,
+// they are notes like information.
+var sarifLevels = map[protocol.DiagnosticSeverity]string{
+	protocol.DiagnosticSeverityError:       "error",
+	protocol.DiagnosticSeverityWarning:     "warning",
+	protocol.DiagnosticSeverityInformation: "note",
+	protocol.DiagnosticSeverityHint:        "note",
+}
+
+// sarifURI returns the reference to a file in a result: relative paths are kept relative,
+// so they are resolved from the root of the repository, and others are file URIs.
+func sarifURI(path string) string {
+	if filepath.IsAbs(path) {
+		return string(uri.File(path))
+	}
+	return filepath.ToSlash(path)
+}
+
+// sarifPath returns the path of a file of a related location, the way the file of the
+// diagnostic was given when it is the same file.
+func sarifPath(file FileDiagnostics, u protocol.DocumentURI) string {
+	path := uri.URI(u).Filename()
+	if abs, err := filepath.Abs(file.Path); err == nil && abs == path {
+		return file.Path
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+func sarifRange(u string, r protocol.Range) sarifPhysicalLocation {
+	return sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifactLocation{URI: u},
+		Region: sarifRegion{
+			StartLine:   r.Start.Line + 1,
+			StartColumn: r.Start.Character + 1,
+			EndLine:     r.End.Line + 1,
+			EndColumn:   r.End.Character + 1,
+		},
+	}
+}
+
+// WriteSARIF writes the diagnostics of the files as a SARIF log, with a rule for each code
+// of the diagnostics.
+func WriteSARIF(w io.Writer, files []FileDiagnostics) error {
+	codes := map[string]bool{}
+	for _, f := range files {
+		for _, d := range f.Diagnostics {
+			codes[sarifRuleID(d)] = true
+		}
+	}
+	rules := make([]sarifRule, 0, len(codes))
+	for code := range codes {
+		rules = append(rules, sarifRule{ID: code, ShortDescription: sarifMessage{Text: code}})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	ruleIndex := map[string]int{}
+	for i, r := range rules {
+		ruleIndex[r.ID] = i
+	}
+
+	results := []sarifResult{}
+	for _, f := range files {
+		for _, d := range f.Diagnostics {
+			level, ok := sarifLevels[d.Severity]
+			if !ok {
+				level = "error"
+			}
+			id := sarifRuleID(d)
+			res := sarifResult{
+				RuleID:    id,
+				RuleIndex: ruleIndex[id],
+				Level:     level,
+				Message:   sarifMessage{Text: d.Message},
+				Locations: []sarifLocation{{PhysicalLocation: sarifRange(sarifURI(f.Path), d.Range)}},
+			}
+			for i, rel := range d.RelatedInformation {
+				res.RelatedLocations = append(res.RelatedLocations, sarifLocation{
+					ID:               i + 1,
+					PhysicalLocation: sarifRange(sarifURI(sarifPath(f, rel.Location.URI)), rel.Location.Range),
+					Message:          &sarifMessage{Text: rel.Message},
+				})
+			}
+			results = append(results, res)
+		}
+	}
+
+	log := sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "jsonnet-lsp",
+				InformationURI: "https://github.com/carlverge/jsonnet-lsp",
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+func sarifRuleID(d protocol.Diagnostic) string {
+	if d.Code == nil {
+		return sarifParseErrorRule
+	}
+	return fmt.Sprint(d.Code)
+}
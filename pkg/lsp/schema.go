@@ -0,0 +1,217 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/carlverge/jsonnet-lsp/pkg/jsonschema"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+	"sigs.k8s.io/yaml"
+)
+
+// schemaCommentRe matches the comment that associates a JSON Schema with a file, f.ex
+// `// @schema ./deployment.schema.json`. It must be in the comments at the top of the file.
+var schemaCommentRe = regexp.MustCompile(`^(?://|#)\s*@schema\s+(\S+)`)
+
+// schemaFor returns the path of the JSON Schema the output of a file is validated against,
+// or "" if there is none. A `@schema` comment is relative to the file, and the `schemas`
+// setting maps globs of files to schemas relative to the workspace folder.
+func (s *Server) schemaFor(u uri.URI, contents string) string {
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if m := schemaCommentRe.FindStringSubmatch(line); m != nil {
+			return absPath(filepath.Dir(u.Filename()), m[1])
+		}
+		if !strings.HasPrefix(line, "//") && !strings.HasPrefix(line, "#") {
+			break
+		}
+	}
+
+	cfg := s.config
+	if cfg == nil || len(cfg.Schemas) == 0 {
+		return ""
+	}
+	root := s.folderOf(u).uri.Filename()
+	rel, err := filepath.Rel(root, u.Filename())
+	if err != nil {
+		return ""
+	}
+	patterns := make([]string, 0, len(cfg.Schemas))
+	for pattern := range cfg.Schemas {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if matchGlob(pattern, filepath.ToSlash(rel)) {
+			return absPath(root, cfg.Schemas[pattern])
+		}
+	}
+	return ""
+}
+
+func absPath(dir, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, filepath.FromSlash(p))
+}
+
+// matchGlob matches a slash separated path with a glob, where `**` matches any number of
+// directories. A glob without a slash matches the name of the file in any directory.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// loadSchema reads a JSON Schema, in JSON or YAML.
+func loadSchema(path string) (*jsonschema.Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = yaml.YAMLToJSON(data); err != nil {
+		return nil, err
+	}
+	return jsonschema.Parse(data)
+}
+
+// schemaDiagnostics validates the output of a file against its JSON Schema. Each violation
+// is reported on the field of the file that produced the value, or the closest field
+// that is in the file when the value comes from an import.
+func (s *Server) schemaDiagnostics(resv *valueResolver, contents, output string) []protocol.Diagnostic {
+	schemaPath := s.schemaFor(resv.rootURI, contents)
+	if schemaPath == "" {
+		return nil
+	}
+	schema, err := loadSchema(schemaPath)
+	if err != nil {
+		return []protocol.Diagnostic{{
+			Severity: protocol.DiagnosticSeverityWarning,
+			Code:     "SchemaViolation",
+			Source:   "jsonschema",
+			Message:  fmt.Sprintf("cannot load JSON Schema '%s': %v", schemaPath, err),
+		}}
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(output), &doc); err != nil {
+		return nil
+	}
+
+	diags := []protocol.Diagnostic{}
+	for _, viol := range schema.Validate(doc) {
+		msg := viol.Message
+		if p := viol.PathString(); p != "" {
+			msg = p + ": " + msg
+		}
+		diag := protocol.Diagnostic{
+			Severity: protocol.DiagnosticSeverityError,
+			Code:     "SchemaViolation",
+			Source:   "jsonschema",
+			Message:  msg,
+		}
+		inFile, outside := outputLocation(resv, viol.Path)
+		if inFile.IsSet() {
+			diag.Range = rangeToProto(inFile)
+		}
+		if outside.IsSet() {
+			diag.RelatedInformation = []protocol.DiagnosticRelatedInformation{{
+				Location: rangeToLocation(outside),
+				Message:  "the value is defined here",
+			}}
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
+
+// outputLocation follows the path of a value of the output through the fields and array
+// elements of the file. It returns the location of the deepest one found in the file,
+// and the location of a deeper one in another file if there is one.
+func outputLocation(resv *valueResolver, valuePath []interface{}) (inFile, outside ast.LocationRange) {
+	fname := resv.rootAST.Loc().FileName
+	// values that are not found are reported at the start of the output expression
+	if _, body := analysis.UnwindLocals(resv.rootAST); body != nil && body.Loc() != nil {
+		inFile = ast.LocationRange{FileName: fname, File: body.Loc().File, Begin: body.Loc().Begin, End: body.Loc().Begin}
+	}
+	update := func(loc ast.LocationRange) {
+		if !loc.IsSet() {
+			return
+		}
+		if loc.FileName == fname {
+			inFile, outside = loc, ast.LocationRange{}
+		} else {
+			outside = loc
+		}
+	}
+
+	val := analysis.NodeToValue(resv.rootAST, resv)
+	for _, p := range valuePath {
+		var next ast.Node
+		switch p := p.(type) {
+		case string:
+			if val.Object == nil || val.Object.FieldMap == nil {
+				return inFile, outside
+			}
+			fld := val.Object.FieldMap[p]
+			if fld == nil {
+				return inFile, outside
+			}
+			if fld.NameRange.IsSet() {
+				update(fld.NameRange)
+			} else {
+				update(fld.Range)
+			}
+			next = fld.Node
+		case int:
+			arr, ok := val.Node.(*ast.Array)
+			if !ok || p >= len(arr.Elements) {
+				return inFile, outside
+			}
+			next = arr.Elements[p].Expr
+			if loc := next.Loc(); loc != nil {
+				update(*loc)
+			}
+		}
+		if next == nil {
+			return inFile, outside
+		}
+		val = analysis.NodeToValue(next, resv)
+	}
+	return inFile, outside
+}
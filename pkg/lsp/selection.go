@@ -0,0 +1,33 @@
+package lsp
+
+import (
+	"context"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"go.lsp.dev/protocol"
+)
+
+// The protocol package has the types for selection ranges, but does not dispatch the method.
+const methodSelectionRange = "textDocument/selectionRange"
+
+func (s *Server) SelectionRange(ctx context.Context, params *protocol.SelectionRangeParams) ([]protocol.SelectionRange, error) {
+	res := []protocol.SelectionRange{}
+	root := s.getCurrentAST(params.TextDocument.URI)
+	positions := s.positions()
+	for _, pos := range params.Positions {
+		var sel *protocol.SelectionRange
+		if root != nil {
+			ranges := analysis.SelectionRanges(root, protoToPos(positions.fromClient(params.TextDocument.URI, pos)))
+			for i := len(ranges) - 1; i >= 0; i-- {
+				rng := positions.rangeToClient(params.TextDocument.URI, rangeToProto(ranges[i]))
+				sel = &protocol.SelectionRange{Range: rng, Parent: sel}
+			}
+		}
+		// The result must have one entry per position, use an empty range if nothing is found.
+		if sel == nil {
+			sel = &protocol.SelectionRange{Range: protocol.Range{Start: pos, End: pos}}
+		}
+		res = append(res, *sel)
+	}
+	return res, nil
+}
@@ -0,0 +1,91 @@
+package lsp
+
+import (
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"go.lsp.dev/protocol"
+)
+
+// constructSnippet is a jsonnet construct completed like a keyword where an expression is
+// expected, with tab stops for its parts.
+type constructSnippet struct {
+	label  string
+	detail string
+	body   string
+}
+
+var constructSnippets = []constructSnippet{
+	{label: "function", detail: "function definition", body: "function(${1:x}) ${0:x}"},
+	{label: "if", detail: "if/then/else", body: "if ${1:cond} then ${2:a} else ${0:b}"},
+	{label: "for", detail: "array comprehension", body: "[${3:x} for ${1:x} in ${2:arr}]$0"},
+	{label: "for (object)", detail: "object comprehension", body: "{ [${3:k}]: ${4:obj[k]} for ${1:k} in std.objectFields(${2:obj}) }$0"},
+	{label: "assert", detail: "assertion", body: "assert ${1:cond} : ${2:'message'};\n$0"},
+	{label: "local import", detail: "import", body: "local ${1:name} = import '${2:file.libsonnet}';\n$0"},
+}
+
+// snippetPlaceholders replaces the tab stops of a snippet by their default text, to show
+// what is inserted.
+func snippetPlaceholders(body string) string {
+	var sb strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] == '\\' && i+1 < len(body) {
+			// \$, \} and \\ are escaped characters
+			sb.WriteByte(body[i+1])
+			i++
+			continue
+		}
+		if body[i] != '$' || i+1 >= len(body) {
+			sb.WriteByte(body[i])
+			continue
+		}
+		switch {
+		case body[i+1] == '{':
+			// ${1:text}
+			end := strings.IndexByte(body[i:], '}')
+			colon := strings.IndexByte(body[i:], ':')
+			if end < 0 || colon < 0 || colon > end {
+				sb.WriteByte(body[i])
+				continue
+			}
+			sb.WriteString(body[i+colon+1 : i+end])
+			i += end
+		case '0' <= body[i+1] && body[i+1] <= '9':
+			// $0
+			i++
+		default:
+			sb.WriteByte(body[i])
+		}
+	}
+	return sb.String()
+}
+
+// snippetItem returns the completion item whose text is a snippet as plain text if the
+// client does not support snippets. The tab stops are replaced by their default text.
+func (s *Server) snippetItem(item protocol.CompletionItem) protocol.CompletionItem {
+	if s.snippetSupport || item.InsertTextFormat != protocol.InsertTextFormatSnippet {
+		return item
+	}
+	item.InsertTextFormat = protocol.InsertTextFormatPlainText
+	item.InsertText = snippetPlaceholders(item.InsertText)
+	if item.TextEdit != nil {
+		item.TextEdit = &protocol.TextEdit{Range: item.TextEdit.Range, NewText: snippetPlaceholders(item.TextEdit.NewText)}
+	}
+	return item
+}
+
+// snippetCandidates returns the completion items of the construct snippets.
+func (s *Server) snippetCandidates() []completionCandidate {
+	res := make([]completionCandidate, 0, len(constructSnippets))
+	for _, snip := range constructSnippets {
+		res = append(res, completionCandidate{item: s.snippetItem(protocol.CompletionItem{
+			Label:            snip.label,
+			Detail:           snip.detail,
+			Documentation:    &protocol.MarkupContent{Kind: protocol.Markdown, Value: "```jsonnet\n" + snippetPlaceholders(snip.body) + "\n```"},
+			Kind:             protocol.CompletionItemKindSnippet,
+			InsertText:       snip.body,
+			InsertTextFormat: protocol.InsertTextFormatSnippet,
+		}), typ: analysis.AnyType})
+	}
+	return res
+}
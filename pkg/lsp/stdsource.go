@@ -0,0 +1,72 @@
+package lsp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/astgen"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// stdSource is the source of the part of the stdlib written in jsonnet, which go-jsonnet
+// embeds along with its AST. It is written to a file the first time it is needed, so the
+// editor can open it. The other functions of the stdlib are builtins written in Go.
+var stdSource struct {
+	once sync.Once
+	path string
+	// the range of the name of each function, in the file
+	names map[string]ast.LocationRange
+}
+
+// stdSourcePath returns the file the source of the stdlib is written to, in the user cache
+// directory (f.ex `~/.cache/jsonnet-lsp/std-v0.20.0.jsonnet`).
+func stdSourcePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "jsonnet-lsp", "std-"+jsonnet.Version()+".jsonnet")
+}
+
+func loadStdSource() {
+	std := astgen.StdAst
+	if std == nil || std.LocRange.File == nil {
+		return
+	}
+	contents := strings.Join(std.LocRange.File.Lines, "")
+	path := stdSourcePath()
+	if data, err := os.ReadFile(path); err != nil || string(data) != contents {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			logf("failed to write the stdlib source: %v", err)
+			return
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			logf("failed to write the stdlib source: %v", err)
+			return
+		}
+	}
+	stdSource.path = path
+	stdSource.names = map[string]ast.LocationRange{}
+	for i := range std.Fields {
+		if name, rng, ok := analysis.FieldNameRange(&std.Fields[i]); ok && rng.IsSet() {
+			stdSource.names[name] = rng
+		}
+	}
+}
+
+// stdDefinition returns where a function of the stdlib is defined in its source. Returns
+// false for builtins, which have no jsonnet source.
+func stdDefinition(name string) (protocol.Location, bool) {
+	stdSource.once.Do(loadStdSource)
+	rng, ok := stdSource.names[name]
+	if !ok {
+		return protocol.Location{}, false
+	}
+	return protocol.Location{URI: uri.File(stdSource.path), Range: rangeToProto(rng)}, true
+}
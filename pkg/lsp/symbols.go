@@ -0,0 +1,92 @@
+package lsp
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"go.lsp.dev/protocol"
+)
+
+// maxWorkspaceSymbols limits the number of results for a workspace symbol query, since
+// an empty or short query can match every definition in a large workspace.
+const maxWorkspaceSymbols = 256
+
+// fuzzyScore matches `query` as a case-insensitive subsequence of `name`. Higher scores
+// are better matches: consecutive characters, matches at word boundaries, and matches
+// at the start of the name are preferred. Returns false if the name does not match.
+func fuzzyScore(query, name string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+	q := []rune(strings.ToLower(query))
+	n := []rune(name)
+	score, qi, prev := 0, 0, -2
+	for i := 0; i < len(n) && qi < len(q); i++ {
+		if unicode.ToLower(n[i]) != q[qi] {
+			continue
+		}
+		switch {
+		case i == 0:
+			score += 8
+		case prev == i-1:
+			score += 5
+		case n[i-1] == '_' || (unicode.IsUpper(n[i]) && unicode.IsLower(n[i-1])):
+			score += 4
+		default:
+			score += 1
+		}
+		prev = i
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	// Prefer shorter names when the query matches equally well
+	return score*64 - len(n), true
+}
+
+type scoredSymbol struct {
+	score int
+	info  protocol.SymbolInformation
+}
+
+func collectWorkspaceSymbols(f *indexedFile, query, container string, syms []analysis.Symbol, out *[]scoredSymbol) {
+	for i := range syms {
+		sym := &syms[i]
+		if score, ok := fuzzyScore(query, sym.Name); ok {
+			*out = append(*out, scoredSymbol{score: score, info: protocol.SymbolInformation{
+				Name:          sym.Name,
+				Kind:          symbolKindToProto(sym),
+				Location:      protocol.Location{URI: f.uri, Range: rangeToProto(sym.NameRange)},
+				ContainerName: container,
+			}})
+		}
+		child := sym.Name
+		if container != "" {
+			child = container + "." + sym.Name
+		}
+		collectWorkspaceSymbols(f, query, child, sym.Children, out)
+	}
+}
+
+func (s *Server) Symbols(ctx context.Context, params *protocol.WorkspaceSymbolParams) ([]protocol.SymbolInformation, error) {
+	found := []scoredSymbol{}
+	for _, f := range s.indexedFiles(ctx, "") {
+		collectWorkspaceSymbols(f, params.Query, "", f.symbols, &found)
+	}
+
+	sort.SliceStable(found, func(i, j int) bool { return found[i].score > found[j].score })
+	if len(found) > maxWorkspaceSymbols {
+		found = found[:maxWorkspaceSymbols]
+	}
+	positions := s.positions()
+	res := make([]protocol.SymbolInformation, len(found))
+	for i := range found {
+		res[i] = found[i].info
+		res[i].Location.Range = positions.rangeToClient(res[i].Location.URI, res[i].Location.Range)
+	}
+	return res, nil
+}
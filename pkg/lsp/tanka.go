@@ -0,0 +1,34 @@
+package lsp
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+)
+
+// isTankaProject returns true if the workspace is a Tanka project: it has a `tkrc.yaml`,
+// or a `jsonnetfile.json` and an `environments` directory.
+func isTankaProject(root fs.FS) bool {
+	if _, err := fs.Stat(root, "tkrc.yaml"); err == nil {
+		return true
+	}
+	if _, err := fs.Stat(root, "jsonnetfile.json"); err != nil {
+		return false
+	}
+	info, err := fs.Stat(root, "environments")
+	return err == nil && info.IsDir()
+}
+
+// tankaBaseDir returns the environment directory of a file in a Tanka project, relative to
+// the root: the closest directory to `dir` containing a `main.jsonnet`. Returns false for
+// directories outside of an environment, f.ex `lib`.
+func tankaBaseDir(root fs.FS, dir string) (string, bool) {
+	dir = path.Clean(filepath.ToSlash(dir))
+	for dir != "." && dir != "/" && dir != ".." {
+		if _, err := fs.Stat(root, path.Join(dir, "main.jsonnet")); err == nil {
+			return dir, true
+		}
+		dir = path.Dir(dir)
+	}
+	return "", false
+}
@@ -0,0 +1,115 @@
+package lsp
+
+import (
+	"os"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// locToOffset converts a jsonnet location (1-indexed line, 1-indexed byte column)
+// into a byte offset into the contents. Locations past the end are clamped.
+func locToOffset(contents string, loc ast.Location) int {
+	offset := 0
+	for line := 1; line < loc.Line; line++ {
+		idx := strings.IndexByte(contents[offset:], '\n')
+		if idx < 0 {
+			return len(contents)
+		}
+		offset += idx + 1
+	}
+	offset += loc.Column - 1
+	if offset > len(contents) {
+		return len(contents)
+	}
+	if offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// textInRange returns the text of the contents covered by the range.
+func textInRange(contents string, r ast.LocationRange) string {
+	begin, end := locToOffset(contents, r.Begin), locToOffset(contents, r.End)
+	if end < begin {
+		return ""
+	}
+	return contents[begin:end]
+}
+
+// fileContents returns the contents of a file, preferring the version in the editor
+// if it is open.
+func (s *Server) fileContents(u uri.URI) (string, bool) {
+	if ent := s.overlay.Current(u); ent != nil {
+		return ent.Contents, true
+	}
+	data, err := os.ReadFile(u.Filename())
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// diffEdits returns the edits that turn `before` into `after`. Only the lines that
+// differ are replaced, so the editor keeps the cursor, folds, and markers elsewhere.
+func diffEdits(before, after string) []protocol.TextEdit {
+	res := []protocol.TextEdit{}
+	for _, edit := range myers.ComputeEdits(span.URI(""), before, after) {
+		rng := protocol.Range{
+			Start: protocol.Position{Line: uint32(edit.Span.Start().Line() - 1)},
+			End:   protocol.Position{Line: uint32(edit.Span.End().Line() - 1)},
+		}
+		// lines that were deleted and then inserted in the same place are a replacement
+		if last := len(res) - 1; last >= 0 && rng.Start == rng.End && res[last].Range.End == rng.Start {
+			res[last].NewText += edit.NewText
+			continue
+		}
+		res = append(res, protocol.TextEdit{Range: rng, NewText: edit.NewText})
+	}
+	return res
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// completionPrefix returns the part of an identifier typed before the cursor.
+func completionPrefix(contents string, cursor ast.Location) string {
+	end := locToOffset(contents, cursor)
+	begin := end
+	for begin > 0 && isIdentByte(contents[begin-1]) {
+		begin--
+	}
+	return contents[begin:end]
+}
+
+// accessKeyword returns `self` or `super` if the field access being typed at the cursor
+// is on one of them (`self.fo|`), or an empty string. The text is used as `super.` alone
+// does not parse.
+func accessKeyword(contents string, cursor ast.Location) string {
+	end := locToOffset(contents, cursor)
+	// the partially typed field name
+	for end > 0 && isIdentByte(contents[end-1]) {
+		end--
+	}
+	if end == 0 || contents[end-1] != '.' {
+		return ""
+	}
+	end--
+	begin := end
+	for begin > 0 && isIdentByte(contents[begin-1]) {
+		begin--
+	}
+	// not a field of another expression (`x.self`)
+	if begin > 0 && contents[begin-1] == '.' {
+		return ""
+	}
+	if kw := contents[begin:end]; kw == "self" || kw == "super" {
+		return kw
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package lsp
+
+import (
+	"context"
+
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// watchedFilesPattern matches the files the client is asked to watch for changes.
+const watchedFilesPattern = "**/*.{jsonnet,libsonnet}"
+
+// registerFileWatcher asks the client to notify the server when jsonnet files are changed
+// outside of the editor, f.ex by a `git checkout` or `jb install`.
+func (s *Server) registerFileWatcher(ctx context.Context) {
+	err := s.notifier.RegisterCapability(ctx, &protocol.RegistrationParams{
+		Registrations: []protocol.Registration{{
+			ID:     "jsonnet-files",
+			Method: protocol.MethodWorkspaceDidChangeWatchedFiles,
+			RegisterOptions: protocol.DidChangeWatchedFilesRegistrationOptions{
+				Watchers: []protocol.FileSystemWatcher{{GlobPattern: watchedFilesPattern}},
+			},
+		}},
+	})
+	if err != nil {
+		logf("failed to register file watcher: %v", err)
+	}
+}
+
+// DidChangeWatchedFiles indexes the changed files again and drops them from the VMs, and
+// publishes the diagnostics of the open files again as they may import them. Files that
+// are excluded from the index are only dropped, they may still be imported, f.ex from an
+// ignored `vendor/`.
+func (s *Server) DidChangeWatchedFiles(ctx context.Context, params *protocol.DidChangeWatchedFilesParams) error {
+	changed := []uri.URI{}
+	s.index.lock.Lock()
+	for _, ev := range params.Changes {
+		if ev == nil || !isJsonnetFile(ev.URI.Filename()) {
+			continue
+		}
+		delete(s.index.files, ev.URI)
+		delete(s.index.used, ev.URI)
+		changed = append(changed, ev.URI)
+	}
+	s.index.lock.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+
+	// the VMs cache the contents of imported files
+	s.flushVMs()
+	go s.refreshDiagnostics(ctx)
+	go s.reindexFiles(changed...)
+	return nil
+}
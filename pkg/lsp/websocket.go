@@ -0,0 +1,106 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"go.lsp.dev/jsonrpc2"
+)
+
+// wsStream is a stream of jsonrpc messages over a WebSocket, with one message per text
+// frame and no headers. This is the framing used by Monaco and Theia based editors.
+type wsStream struct {
+	conn *websocket.Conn
+	// only one writer is allowed at a time
+	writeLock sync.Mutex
+}
+
+var _ jsonrpc2.Stream = (*wsStream)(nil)
+
+func (s *wsStream) Read(ctx context.Context) (jsonrpc2.Message, int64, error) {
+	_, data, err := s.conn.ReadMessage()
+	if err != nil {
+		return nil, 0, err
+	}
+	msg, err := jsonrpc2.DecodeMessage(data)
+	return msg, int64(len(data)), err
+}
+
+func (s *wsStream) Write(ctx context.Context, msg jsonrpc2.Message) (int64, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	return int64(len(data)), s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (s *wsStream) Close() error {
+	return s.conn.Close()
+}
+
+// checkOrigin returns the origin check of the WebSocket upgrades. Browsers send the origin
+// of the page, any web site could otherwise connect to a server on localhost and read the
+// workspace. Connections from the origin of the server, from the `allowed` origins (f.ex
+// `http://localhost:3000`) and from clients that are not browsers are accepted.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, o := range allowed {
+			if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+				return true
+			}
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// WebSocketServer accepts WebSocket connections on `addr`, f.ex `localhost:7777`. Every
+// connection is a separate session of the language server, so browser sessions can share
+// one process. Pages served from another origin than the server must be in
+// `allowedOrigins`. Runs until the context is done.
+func WebSocketServer(ctx context.Context, addr string, allowedOrigins []string) error {
+	upgrader := websocket.Upgrader{CheckOrigin: checkOrigin(allowedOrigins)}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logf("failed to upgrade connection from %s: %v", r.RemoteAddr, err)
+			return
+		}
+		logf("accepted websocket connection from %s", r.RemoteAddr)
+		stream := &wsStream{conn: conn}
+		defer stream.Close()
+		// the session is canceled when the client exits
+		if err := serveStream(ctx, stream); err != nil && !errors.Is(err, context.Canceled) {
+			logf("websocket connection from %s failed: %v", r.RemoteAddr, err)
+		}
+	})
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logf("listening for websocket connections on %s", lis.Addr())
+	srv := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	err = srv.Serve(lis)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,414 @@
+package lsp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/uri"
+)
+
+func isJsonnetFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".jsonnet" || ext == ".libsonnet"
+}
+
+// indexedFile is a parsed workspace file along with the summaries built from it. Files
+// loaded from the index cache only have the summaries, without the contents and AST.
+type indexedFile struct {
+	uri      uri.URI
+	contents string
+	root     ast.Node
+	refs     *analysis.RefIndex
+	symbols  []analysis.Symbol
+	imported map[string][]string
+	// the files the file imports, resolved by the importer of its folder
+	imports []string
+	// the names of fields, functions, and imports used in the file
+	names map[string]bool
+	// the names of the external variables read in the file
+	extVars []string
+	// the names of the fields of the object the file exports
+	exports []string
+
+	// Used to know when the file needs to be re-parsed. Files open in the editor
+	// are tracked by overlay version, otherwise by modification time on disk, and
+	// by the hash of the contents if the file was touched without changing.
+	version int64
+	modTime time.Time
+	hash    string
+}
+
+func (f *indexedFile) summarize() {
+	f.refs = analysis.BuildRefIndex(f.root)
+	f.symbols = analysis.DocumentSymbols(f.root)
+	f.imported = analysis.ImportedNames(f.root)
+	f.names = map[string]bool{}
+	for name := range f.refs.Fields {
+		f.names[name] = true
+	}
+	for name := range f.refs.Calls {
+		f.names[name] = true
+	}
+	for name := range f.imported {
+		f.names[name] = true
+	}
+	f.extVars = []string{}
+	for name := range f.refs.ExtVars {
+		f.extVars = append(f.extVars, name)
+	}
+	f.exports = []string{}
+	for _, d := range analysis.ExportedFields(f.root) {
+		f.exports = append(f.exports, d.Name)
+	}
+}
+
+// resolveImports resolves the imports of the file with the importer of its folder.
+// Imports that are not found are left out.
+func (f *indexedFile) resolveImports(folder *workspaceFolder) {
+	f.imports = []string{}
+	seen := map[string]bool{}
+	for _, imp := range f.refs.Imports {
+		file := analysis.ImportFile(imp)
+		if file == nil {
+			continue
+		}
+		_, foundAt, err := folder.importer.Import(f.uri.Filename(), file.Value)
+		if err == nil && !seen[foundAt] {
+			seen[foundAt] = true
+			f.imports = append(f.imports, filepath.Clean(foundAt))
+		}
+	}
+}
+
+// mayContain returns false if the file cannot reference `name`.
+func (f *indexedFile) mayContain(name string) bool {
+	if f.root == nil {
+		return f.names[name]
+	}
+	return strings.Contains(f.contents, name)
+}
+
+// contentHash is the hash of the contents of a file, to know if it changed.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// workspaceIndex keeps a parsed AST and reference index for every jsonnet file in the
+// workspace. Files are only re-parsed when their contents change, which keeps repeated
+// cross-file queries (like finding references) fast.
+type workspaceIndex struct {
+	lock  sync.Mutex
+	files map[uri.URI]*indexedFile
+	// when the entries were last used, the least recently used ASTs are dropped first when
+	// the memory budget is exceeded
+	used map[uri.URI]time.Time
+	// held while the whole workspace is being indexed
+	scanLock sync.Mutex
+	// set when files on disk were indexed since the index cache was saved
+	dirty bool
+}
+
+func newWorkspaceIndex() *workspaceIndex {
+	return &workspaceIndex{files: map[uri.URI]*indexedFile{}, used: map[uri.URI]time.Time{}}
+}
+
+// workspaceFile is a jsonnet file in a workspace folder.
+type workspaceFile struct {
+	folder *workspaceFolder
+	// path relative to the root of the folder
+	path string
+}
+
+func (f workspaceFile) uri() uri.URI {
+	return uri.File(filepath.Join(f.folder.uri.Filename(), f.path))
+}
+
+// workspaceFiles returns all jsonnet files under the workspace folders. Hidden
+// directories, the paths excluded by the configuration and ignored by .gitignore files are
+// skipped, and symlinks are not followed. Files in nested folders are only returned once.
+func (s *Server) workspaceFiles(ctx context.Context) []workspaceFile {
+	res := []workspaceFile{}
+	seen := map[uri.URI]bool{}
+	for _, folder := range s.folders() {
+		filter := s.pathFilter(folder)
+		_ = fs.WalkDir(folder.fs, ".", func(path string, d fs.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				// only the entry that failed is skipped, not the rest of its directory
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				if path != "." && (strings.HasPrefix(d.Name(), ".") || filter.excluded(path, true)) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			f := workspaceFile{folder: folder, path: path}
+			if isJsonnetFile(path) && !seen[f.uri()] && !filter.excluded(path, false) {
+				seen[f.uri()] = true
+				res = append(res, f)
+			}
+			return nil
+		})
+	}
+	return res
+}
+
+// workspaceFileOf returns the workspace file of a URI. Returns false for files that are
+// not in a workspace folder.
+func (s *Server) workspaceFileOf(u uri.URI) (workspaceFile, bool) {
+	folder := s.folderOf(u)
+	if !inDirectory(folder.uri, u) {
+		return workspaceFile{}, false
+	}
+	path, err := filepath.Rel(folder.uri.Filename(), u.Filename())
+	if err != nil {
+		return workspaceFile{}, false
+	}
+	return workspaceFile{folder: folder, path: filepath.ToSlash(path)}, true
+}
+
+// indexWorkers is the number of files parsed at once when indexing the workspace.
+const indexWorkers = 4
+
+// indexWorkspace parses every jsonnet file in the workspace folders in the background, so
+// cross-file features (workspace symbols, references, imports) do not have to parse the
+// whole workspace the first time they are used. Files that are already indexed and have
+// not changed are skipped.
+func (s *Server) indexWorkspace(ctx context.Context) {
+	s.index.scanLock.Lock()
+	defer s.index.scanLock.Unlock()
+
+	start := time.Now()
+	for _, folder := range s.folders() {
+		folder.loadCache.Do(func() { s.loadIndexCache(folder) })
+	}
+	p := s.startProgress("Indexing workspace", "")
+	defer p.end("")
+	files := s.workspaceFiles(ctx)
+	work := make(chan workspaceFile)
+	wg := sync.WaitGroup{}
+	for i := 0; i < indexWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range work {
+				s.indexFile(f, false)
+			}
+		}()
+	}
+	for i, f := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- f
+		// only report whole percents, the client does not need a message for every file
+		if pct := uint32(i * 100 / len(files)); i == 0 || pct != uint32((i-1)*100/len(files)) {
+			p.report(fmt.Sprintf("%d/%d files", i+1, len(files)), pct)
+		}
+	}
+	close(work)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	// forget deleted files, so they are not kept in the index cache
+	found := map[uri.URI]bool{}
+	for _, f := range files {
+		found[f.uri()] = true
+	}
+	s.index.lock.Lock()
+	for u, f := range s.index.files {
+		if !found[u] && !f.modTime.IsZero() {
+			delete(s.index.files, u)
+			delete(s.index.used, u)
+			s.index.dirty = true
+		}
+	}
+	s.index.lock.Unlock()
+	logf("indexed %d workspace files in %s", len(files), time.Since(start))
+	s.saveIndexCache()
+}
+
+// reindexFiles updates the index entries of files that changed. Files that are excluded
+// from the index are skipped.
+func (s *Server) reindexFiles(uris ...uri.URI) {
+	filters := map[*workspaceFolder]*pathFilter{}
+	for _, u := range uris {
+		f, ok := s.workspaceFileOf(u)
+		if !ok || !isJsonnetFile(f.path) {
+			continue
+		}
+		if filters[f.folder] == nil {
+			filters[f.folder] = s.pathFilter(f.folder)
+		}
+		if !filters[f.folder].excludedPath(f.path, false) {
+			s.indexFile(f, false)
+		}
+	}
+}
+
+// indexFile returns the up to date index entry for a file, parsing it if it has
+// changed since it was last indexed. Entries loaded from the index cache are only parsed
+// if `parse` is set. Returns nil if the file could not be read or parsed.
+func (s *Server) indexFile(file workspaceFile, parse bool) *indexedFile {
+	f := s.loadIndexFile(file, parse)
+	if f != nil {
+		s.index.lock.Lock()
+		s.index.used[f.uri] = time.Now()
+		s.index.lock.Unlock()
+	}
+	return f
+}
+
+func (s *Server) loadIndexFile(file workspaceFile, parse bool) *indexedFile {
+	u := file.uri()
+
+	s.index.lock.Lock()
+	prev := s.index.files[u]
+	s.index.lock.Unlock()
+
+	// Open files use the last parsed contents in the editor
+	if ent := s.overlay.Parsed(u); ent != nil {
+		if prev != nil && prev.version == ent.Version && prev.modTime.IsZero() {
+			return prev
+		}
+		pr, _ := ent.Data.(*ParseResult)
+		if pr == nil || pr.Root == nil {
+			return nil
+		}
+		res := &indexedFile{uri: u, contents: ent.Contents, root: pr.Root, version: ent.Version}
+		res.summarize()
+		res.resolveImports(file.folder)
+		s.index.lock.Lock()
+		s.index.files[u] = res
+		s.index.lock.Unlock()
+		return res
+	}
+
+	finfo, err := fs.Stat(file.folder.fs, file.path)
+	if err != nil {
+		return nil
+	}
+	if prev != nil && prev.modTime.Equal(finfo.ModTime()) && (prev.root != nil || !parse) {
+		return prev
+	}
+
+	data, err := fs.ReadFile(file.folder.fs, file.path)
+	if err != nil {
+		return nil
+	}
+	hash := contentHash(data)
+	if prev != nil && prev.root == nil && !parse && prev.hash == hash {
+		// touched without changing, f.ex by switching branches, the summaries still hold
+		res := *prev
+		res.modTime = finfo.ModTime()
+		s.index.lock.Lock()
+		s.index.files[u] = &res
+		s.index.dirty = true
+		s.index.lock.Unlock()
+		return &res
+	}
+	root, err := jsonnet.SnippetToAST(u.Filename(), string(data))
+	if err != nil || root == nil {
+		return nil
+	}
+	res := &indexedFile{uri: u, contents: string(data), root: root, modTime: finfo.ModTime(), hash: hash}
+	res.summarize()
+	res.resolveImports(file.folder)
+	s.index.lock.Lock()
+	s.index.files[u] = res
+	s.index.dirty = true
+	s.index.lock.Unlock()
+	return res
+}
+
+// indexedFiles returns the index entries for every parsable file in the workspace.
+// If `contains` is set, only files that may reference that name are returned, which is a
+// cheap way to skip files that could never reference a symbol. Those files are parsed if
+// they were loaded from the index cache, otherwise only the summaries are up to date.
+func (s *Server) indexedFiles(ctx context.Context, contains string) []*indexedFile {
+	defer func(t time.Time) { tracef("indexed workspace files in %s", time.Since(t)) }(time.Now())
+	res := []*indexedFile{}
+	for _, file := range s.workspaceFiles(ctx) {
+		if ctx.Err() != nil {
+			break
+		}
+		f := s.indexFile(file, false)
+		if f == nil || (contains != "" && !f.mayContain(contains)) {
+			continue
+		}
+		if contains != "" && f.root == nil {
+			if f = s.indexFile(file, true); f == nil {
+				continue
+			}
+		}
+		res = append(res, f)
+	}
+	return res
+}
+
+// importingFiles is like indexedFiles, but only returns the file at `path` and the files
+// that import it, directly or through other files. Values defined in a file can only be
+// accessed from the files that import it, which bounds cross-file searches.
+func (s *Server) importingFiles(ctx context.Context, path, contains string) []*indexedFile {
+	defer func(t time.Time) { tracef("indexed files importing %s in %s", path, time.Since(t)) }(time.Now())
+	files := s.workspaceFiles(ctx)
+	importers := map[string][]string{}
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if f := s.indexFile(file, false); f != nil {
+			for _, imp := range f.imports {
+				importers[imp] = append(importers[imp], filepath.Clean(f.uri.Filename()))
+			}
+		}
+	}
+
+	path = filepath.Clean(path)
+	keep := map[string]bool{path: true}
+	for queue := []string{path}; len(queue) > 0; queue = queue[1:] {
+		for _, importer := range importers[queue[0]] {
+			if !keep[importer] {
+				keep[importer] = true
+				queue = append(queue, importer)
+			}
+		}
+	}
+
+	res := []*indexedFile{}
+	for _, file := range files {
+		if ctx.Err() != nil || !keep[filepath.Clean(file.uri().Filename())] {
+			continue
+		}
+		f := s.indexFile(file, false)
+		if f == nil || (contains != "" && !f.mayContain(contains)) {
+			continue
+		}
+		if f.root == nil {
+			if f = s.indexFile(file, true); f == nil {
+				continue
+			}
+		}
+		res = append(res, f)
+	}
+	return res
+}
@@ -0,0 +1,229 @@
+package lsp
+
+import (
+	"context"
+	"io/fs"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/kube"
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
+	"github.com/google/go-jsonnet"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Workspace diagnostics were added in LSP 3.17, they are the diagnostics of the files of the
+// workspace that are not open, pulled by the client for a project wide problems view.
+const methodWorkspaceDiagnostic = "workspace/diagnostic"
+
+type PreviousResultID struct {
+	URI   protocol.DocumentURI `json:"uri"`
+	Value string               `json:"value"`
+}
+
+type WorkspaceDiagnosticParams struct {
+	Identifier        string             `json:"identifier,omitempty"`
+	PreviousResultIDs []PreviousResultID `json:"previousResultIds"`
+}
+
+type WorkspaceDiagnosticReport struct {
+	Items []interface{} `json:"items"`
+}
+
+// The reports of files that are not open have no version.
+
+type WorkspaceFullDocumentDiagnosticReport struct {
+	FullDocumentDiagnosticReport
+	URI     protocol.DocumentURI `json:"uri"`
+	Version *int32               `json:"version"`
+}
+
+type WorkspaceUnchangedDocumentDiagnosticReport struct {
+	UnchangedDocumentDiagnosticReport
+	URI     protocol.DocumentURI `json:"uri"`
+	Version *int32               `json:"version"`
+}
+
+// workspaceReport is the diagnostics of a file on disk, they are checked again when the
+// file or the files it may import change.
+type workspaceReport struct {
+	modTime    time.Time
+	generation int64
+	resultID   string
+	items      []protocol.Diagnostic
+}
+
+// workspaceReports are the diagnostics of the workspace files that are not open.
+type workspaceReports struct {
+	lock    sync.Mutex
+	reports map[uri.URI]workspaceReport
+	// set while the workspace is being checked
+	checking bool
+}
+
+// checkFile returns the diagnostics of a workspace file that is not open. They are the
+// diagnostics of an open file, without evaluating it.
+func (s *Server) checkFile(ctx context.Context, u uri.URI, contents string, vm *vmCache) []protocol.Diagnostic {
+	root, err := jsonnet.SnippetToAST(u.Filename(), contents)
+	if se, ok := err.(staticError); ok {
+		return parseErrorDiagnostics(u.Filename(), contents, se)
+	}
+	if root == nil {
+		return nil
+	}
+	diags := s.astDiagnostics(root)
+	if _, large := s.config.LargeFile.exceeded(contents); s.config.Diag.Linter && !large {
+		diags = append(diags, s.config.Diag.applyRules(kube.UnknownFields(root, s.kubeCatalog(u)))...)
+		diags = append(diags, s.config.Diag.applyRules(linter.LintAST(root, newRootResolver(ctx, root, vm)))...)
+	}
+	return diags
+}
+
+// checkWorkspace checks the workspace files that are not open and changed since they were
+// last checked, and returns true if the diagnostics of any of them changed.
+func (s *Server) checkWorkspace(ctx context.Context) bool {
+	p := s.startProgress("Checking workspace", "")
+	defer p.end("")
+
+	generation := atomic.LoadInt64(&s.vmGeneration)
+	files := s.workspaceFiles(ctx)
+	vms := map[*workspaceFolder]*vmCache{}
+	changed := false
+	seen := map[uri.URI]bool{}
+	for i, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		u := file.uri()
+		seen[u] = true
+		if s.overlay.Current(u) != nil {
+			continue
+		}
+		finfo, err := fs.Stat(file.folder.fs, file.path)
+		if err != nil {
+			continue
+		}
+		s.workspaceReports.lock.Lock()
+		prev, ok := s.workspaceReports.reports[u]
+		s.workspaceReports.lock.Unlock()
+		if ok && prev.modTime.Equal(finfo.ModTime()) && prev.generation == generation {
+			continue
+		}
+
+		p.report(file.path, uint32(i*100/len(files)))
+		data, err := fs.ReadFile(file.folder.fs, file.path)
+		if err != nil {
+			continue
+		}
+		if vms[file.folder] == nil {
+			// one VM for each folder, so imports shared by the files are only parsed once
+			vms[file.folder] = s.newVMCache(u)
+		}
+		items := s.positions().diagnosticsToClient(u, s.config.Diag.applyRules(s.checkFile(ctx, u, string(data), vms[file.folder])))
+		if items == nil {
+			items = []protocol.Diagnostic{}
+		}
+
+		rep := workspaceReport{modTime: finfo.ModTime(), generation: generation, resultID: prev.resultID, items: items}
+		if !ok || !sameDiagnostics(prev.items, items) {
+			rep.resultID = strconv.FormatInt(atomic.AddInt64(&s.workspaceResultIDs, 1), 10)
+			changed = true
+		}
+		s.workspaceReports.lock.Lock()
+		s.workspaceReports.reports[u] = rep
+		s.workspaceReports.lock.Unlock()
+	}
+	if ctx.Err() != nil {
+		return changed
+	}
+
+	// the problems of deleted files are cleared
+	s.workspaceReports.lock.Lock()
+	defer s.workspaceReports.lock.Unlock()
+	for u, rep := range s.workspaceReports.reports {
+		if !seen[u] && len(rep.items) > 0 {
+			rep.items = []protocol.Diagnostic{}
+			rep.resultID = strconv.FormatInt(atomic.AddInt64(&s.workspaceResultIDs, 1), 10)
+			s.workspaceReports.reports[u] = rep
+			changed = true
+		}
+	}
+	return changed
+}
+
+// WorkspaceDiagnostic returns the diagnostics of the workspace files that are not open.
+// Requests are handled in order, so if the client can be asked to pull again, the files
+// are checked in the background: the last diagnostics are returned right away, and the
+// client pulls again once the files that changed are checked. Otherwise the request waits
+// for the files to be checked.
+func (s *Server) WorkspaceDiagnostic(ctx context.Context, params *WorkspaceDiagnosticParams) (*WorkspaceDiagnosticReport, error) {
+	s.workspaceReports.lock.Lock()
+	if s.workspaceReports.reports == nil {
+		s.workspaceReports.reports = map[uri.URI]workspaceReport{}
+	}
+	checking := s.workspaceReports.checking
+	s.workspaceReports.checking = true
+	s.workspaceReports.lock.Unlock()
+
+	check := func(ctx context.Context) bool {
+		defer func() {
+			s.workspaceReports.lock.Lock()
+			s.workspaceReports.checking = false
+			s.workspaceReports.lock.Unlock()
+		}()
+		return s.checkWorkspace(ctx)
+	}
+	switch {
+	case checking:
+		// the files are already being checked, the client is asked to pull again after
+	case s.diagnosticRefresh && s.conn != nil:
+		go func() {
+			// not tied to the request, which is answered right away
+			if check(context.Background()) {
+				s.refreshDiagnosticPulls(context.Background())
+			}
+		}()
+	default:
+		check(ctx)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	previous := map[uri.URI]string{}
+	for _, prev := range params.PreviousResultIDs {
+		previous[uri.URI(prev.URI)] = prev.Value
+	}
+	res := &WorkspaceDiagnosticReport{Items: []interface{}{}}
+	s.workspaceReports.lock.Lock()
+	defer s.workspaceReports.lock.Unlock()
+	files := make([]uri.URI, 0, len(s.workspaceReports.reports))
+	for u := range s.workspaceReports.reports {
+		files = append(files, u)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i] < files[j] })
+	for _, u := range files {
+		rep := s.workspaceReports.reports[u]
+		if s.overlay.Current(u) != nil {
+			// open files are reported by document pulls
+			continue
+		}
+		if previous[u] == rep.resultID {
+			res.Items = append(res.Items, &WorkspaceUnchangedDocumentDiagnosticReport{
+				UnchangedDocumentDiagnosticReport: UnchangedDocumentDiagnosticReport{Kind: diagnosticReportUnchanged, ResultID: rep.resultID},
+				URI:                               protocol.DocumentURI(u),
+			})
+			continue
+		}
+		res.Items = append(res.Items, &WorkspaceFullDocumentDiagnosticReport{
+			FullDocumentDiagnosticReport: FullDocumentDiagnosticReport{Kind: diagnosticReportFull, ResultID: rep.resultID, Items: rep.items},
+			URI:                          protocol.DocumentURI(u),
+		})
+	}
+	tracef("workspace diagnostics: %d files", len(res.Items))
+	return res, nil
+}
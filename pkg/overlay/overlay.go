@@ -40,7 +40,7 @@ type fileUpdate struct {
 	Close   bool
 	Version int64
 	Replace *string
-	Edits   []gotextdiff.TextEdit
+	Edits   EditsFunc
 }
 
 func NewOverlay() *Overlay {
@@ -64,6 +64,10 @@ type UpdateFunc func(UpdateResult)
 // every item of a batch update. `lastEdit` may be set as the last edit made in the update.
 type ParseFunc func(contents string, lastEdit *gotextdiff.TextEdit) (data interface{}, ok bool)
 
+// EditsFunc returns the edits of a delta update from the contents they are applied to,
+// f.ex to convert their positions with the text of the lines they are on.
+type EditsFunc func(contents string) []gotextdiff.TextEdit
+
 func EmptyParseFunc(string, *gotextdiff.TextEdit) (interface{}, bool) { return nil, true }
 
 // applyFileUpdates run with ent.updateLock already locked
@@ -106,7 +110,8 @@ func applyFileUpdates(ent *overlayFile, updates []fileUpdate, parse ParseFunc) {
 			panic(fmt.Errorf("invariant: %s: out of order delta-update for file with version current=%d new=%d", up.URI.Filename(), ent.current.Version, up.Version))
 		}
 
-		if len(up.Edits) == 0 {
+		edits := up.Edits(ent.current.Contents)
+		if len(edits) == 0 {
 			// in case of no updates, just change version and continue
 			// make a new struct so we don't clobber in-use Entries
 			parsedIsCurrent := ent.parsed != nil && ent.current.Version == ent.parsed.Version
@@ -125,8 +130,8 @@ func applyFileUpdates(ent *overlayFile, updates []fileUpdate, parse ParseFunc) {
 			continue
 		}
 
-		updated := gotextdiff.ApplyEdits(ent.current.Contents, up.Edits)
-		lastEdit := up.Edits[len(up.Edits)-1]
+		updated := gotextdiff.ApplyEdits(ent.current.Contents, edits)
+		lastEdit := edits[len(edits)-1]
 		data, parsed := parse(updated, &lastEdit)
 
 		res := &Entry{
@@ -153,6 +158,11 @@ func (o *Overlay) Close(u uri.URI) {
 }
 
 func (o *Overlay) Update(u uri.URI, version int64, edits []gotextdiff.TextEdit, parse ParseFunc, done UpdateFunc) {
+	o.UpdateWith(u, version, func(string) []gotextdiff.TextEdit { return edits }, parse, done)
+}
+
+// UpdateWith is Update with edits that depend on the contents they are applied to.
+func (o *Overlay) UpdateWith(u uri.URI, version int64, edits EditsFunc, parse ParseFunc, done UpdateFunc) {
 	o.update(fileUpdate{URI: u, Version: version, Edits: edits}, parse, done)
 }
 
@@ -180,6 +190,27 @@ func (o *Overlay) Parsed(u uri.URI) *Entry {
 	return ent.parsed
 }
 
+// Open returns the files that are currently open.
+func (o *Overlay) Open() []uri.URI {
+	o.fileLock.Lock()
+	files := make(map[uri.URI]*overlayFile, len(o.files))
+	for u, f := range o.files {
+		files[u] = f
+	}
+	o.fileLock.Unlock()
+
+	res := []uri.URI{}
+	for u, f := range files {
+		f.entryLock.Lock()
+		if f.current != nil {
+			res = append(res, u)
+		}
+		f.entryLock.Unlock()
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
 // getFile always returns non nil -- it will create an entry if it doesnt exist
 func (o *Overlay) getFile(u uri.URI) *overlayFile {
 	o.fileLock.Lock()